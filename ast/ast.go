@@ -158,18 +158,25 @@ func (ps *PrintState) Print(str ...string) *PrintState {
 // Node represents everything in the tree.
 type Node interface {
 	Value() *token.Token
+	Position() token.Position
 	PrettyPrint(ps *PrintState) *PrintState
 }
 
 // Base contains fields common to all nodes that have a token and avoids repeating the same TokenLiteral() methods.
+// Pos is where the token was found in the source (tokens themselves are interned so can't carry it).
 type Base struct {
 	*token.Token
+	Pos token.Position
 }
 
 func (b Base) Value() *token.Token {
 	return b.Token
 }
 
+func (b Base) Position() token.Position {
+	return b.Pos
+}
+
 func (b Base) PrettyPrint(ps *PrintState) *PrintState {
 	// In theory should only be called for literals.
 	// log.Debugf("PrettyPrint on base called for %T", b.Value())
@@ -251,6 +258,21 @@ func startsWithMinus(node Node) bool {
 	}
 }
 
+// startsWithArray checks if a node, when pretty-printed, starts with a `[` (array literal or
+// destructuring pattern) which would otherwise be re-parsed as indexing the previous expression.
+func startsWithArray(node Node) bool {
+	switch n := node.(type) {
+	case *ArrayLiteral:
+		return true
+	case *InfixExpression:
+		return startsWithArray(n.Left)
+	case *IndexExpression:
+		return startsWithArray(n.Left)
+	default:
+		return false
+	}
+}
+
 // Compact mode: Skip comments and decide if we need a space separator or not.
 func prettyPrintCompact(ps *PrintState, s Node, i int) bool {
 	if isComment(s) {
@@ -268,7 +290,7 @@ func prettyPrintCompact(ps *PrintState, s Node, i int) bool {
 		}
 	}
 	_, prevIsExpr := ps.prev.(*InfixExpression)
-	_, curIsArray := s.(*ArrayLiteral)
+	curIsArray := startsWithArray(s)
 	if curIsArray || (prevIsExpr && ps.last != "}" && ps.last != "]") {
 		if i > 0 {
 			_, _ = ps.Out.Write([]byte{' '})
@@ -373,7 +395,38 @@ type StringLiteral struct {
 }
 
 func (s StringLiteral) PrettyPrint(ps *PrintState) *PrintState {
-	ps.Print(strconv.Quote(s.Literal()))
+	ps.Print(quote(s.Literal()))
+	return ps
+}
+
+// quote is strconv.Quote with `${` escaped so it isn't read back as an interpolation.
+func quote(s string) string {
+	return strings.ReplaceAll(strconv.Quote(s), "${", `\${`)
+}
+
+// InterpolatedString is a "text ${expr} text" string literal. Parts are the
+// StringLiteral text pieces and the expressions, in order.
+type InterpolatedString struct {
+	Base
+	Parts []Node
+}
+
+func (is InterpolatedString) PrettyPrint(ps *PrintState) *PrintState {
+	ps.Print(`"`)
+	for _, p := range is.Parts {
+		if str, ok := p.(*StringLiteral); ok {
+			q := quote(str.Literal())
+			ps.Print(q[1 : len(q)-1])
+			continue
+		}
+		// expressions are printed compact, on a single line.
+		sub := NewPrintState()
+		sub.Compact = true
+		sub.AllParens = ps.AllParens
+		p.PrettyPrint(sub)
+		ps.Print("${", sub.String(), "}")
+	}
+	ps.Print(`"`)
 	return ps
 }
 
@@ -460,7 +513,11 @@ func (i InfixExpression) PrettyPrint(out *PrintState) *PrintState {
 	// Print left child - mark as not right child
 	oldIsRightChild := out.IsRightChild
 	out.IsRightChild = false
-	i.Left.PrettyPrint(out)
+	if i.Token.Type() == token.ASSIGN || i.Token.Type() == token.DEFINE {
+		printPattern(out, i.Left) // destructuring `[a, b] = ...` or `{x, y} := ...`
+	} else {
+		i.Left.PrettyPrint(out)
+	}
 	if out.Compact {
 		out.Print(i.Literal())
 	} else {
@@ -539,6 +596,128 @@ func (ie IfExpression) PrettyPrint(out *PrintState) *PrintState {
 	return out
 }
 
+// TryExpression is `try { body } catch name { handler } finally { cleanup }`, with either or both
+// of catch (name being optional) and finally: an error from the body runs the handler, with name
+// set to the error's catch() map, and the cleanup always runs last.
+type TryExpression struct {
+	Base    // the try token
+	Body    *Statements
+	Name    *Identifier // the catch variable, if any.
+	Catch   *Statements // nil without catch.
+	Finally *Statements // nil without finally.
+}
+
+func (te TryExpression) PrettyPrint(out *PrintState) *PrintState {
+	sep := " "
+	if out.Compact {
+		sep = ""
+	}
+	out.Print("try", sep)
+	te.Body.PrettyPrint(out)
+	if te.Catch != nil {
+		out.Print(sep, "catch ")
+		if te.Name != nil {
+			out.Print(te.Name.Literal(), sep)
+		}
+		te.Catch.PrettyPrint(out)
+	}
+	if te.Finally != nil {
+		out.Print(sep, "finally", sep)
+		te.Finally.PrettyPrint(out)
+	}
+	return out
+}
+
+// MatchExpression is `match subject { pattern, pattern if guard { body } ... }`: the body of the
+// first arm with a pattern matching the subject (and a true guard, if any) is evaluated.
+type MatchExpression struct {
+	Base    // the match token
+	Subject Node
+	Arms    []*MatchArm
+}
+
+// MatchArm is one `patterns [if guard] { body }` arm of a match expression. Patterns are
+// literals, constants, type names (int, string, map,...), `_`, identifiers (bindings)
+// or array and map literals of patterns, arrays ending with an optional `..tail`.
+type MatchArm struct {
+	Base     // the first pattern token
+	Patterns []Node
+	Guard    Node
+	Body     *Statements
+}
+
+func (me MatchExpression) PrettyPrint(out *PrintState) *PrintState {
+	out.Print("match ")
+	me.Subject.PrettyPrint(out)
+	if !out.Compact {
+		out.Print(" ")
+	}
+	out.Print("{")
+	out.IndentLevel++
+	for _, arm := range me.Arms {
+		out.Println()
+		arm.PrettyPrint(out)
+	}
+	out.IndentLevel--
+	out.Println()
+	out.Print("}")
+	return out
+}
+
+func (ma MatchArm) PrettyPrint(out *PrintState) *PrintState {
+	oldPrecedence := out.ExpressionPrecedence
+	out.ExpressionPrecedence = LOWEST
+	out.PatternList(ma.Patterns)
+	if ma.Guard != nil {
+		out.Print(" if ")
+		ma.Guard.PrettyPrint(out)
+	}
+	if !out.Compact {
+		out.Print(" ")
+	}
+	out.ExpressionPrecedence = oldPrecedence
+	ma.Body.PrettyPrint(out)
+	return out
+}
+
+// printPattern prints match and destructuring patterns, using the `{x, y}` short form for maps binding keys to same name variables.
+func printPattern(out *PrintState, p Node) {
+	switch p := p.(type) {
+	case *ArrayLiteral:
+		out.Print("[")
+		out.PatternList(p.Elements)
+		out.Print("]")
+	case *MapLiteral:
+		out.Print("{")
+		for i, key := range p.Order {
+			if i > 0 {
+				out.Print(out.listSep())
+			}
+			value := p.Pairs[key]
+			if id, ok := value.(*Identifier); ok && key.Value().Type() == token.STRING && key.Value().Literal() == id.Literal() {
+				out.Print(id.Literal())
+				continue
+			}
+			key.PrettyPrint(out)
+			out.Print(":")
+			printPattern(out, value)
+		}
+		out.Print("}")
+	default:
+		p.PrettyPrint(out)
+	}
+}
+
+// PatternList prints a coma separated list of patterns (match arms, function parameters).
+func (ps *PrintState) PatternList(list []Node) {
+	for i, p := range list {
+		if i > 0 {
+			ps.Print(ps.listSep())
+		}
+		printPattern(ps, p)
+	}
+}
+
 func PrintList(out *PrintState, list []Node, sep string) {
 	for i, p := range list {
 		if i > 0 {
@@ -599,7 +778,7 @@ func (fl FunctionLiteral) PrettyPrint(out *PrintState) *PrintState {
 		out.Print(fl.Name.Literal())
 	}
 	out.Print("(")
-	out.ComaList(fl.Parameters)
+	out.PatternList(fl.Parameters)
 	if out.Compact {
 		out.Print(")")
 	} else {
@@ -613,6 +792,7 @@ type CallExpression struct {
 	Base           // The '(' token
 	Function  Node // Identifier or FunctionLiteral
 	Arguments []Node
+	Tail      bool // in tail position of the enclosing function's body, see MarkTailCalls.
 }
 
 func (ce CallExpression) PrettyPrint(out *PrintState) *PrintState {
@@ -708,9 +888,12 @@ func (ml MacroLiteral) PrettyPrint(out *PrintState) *PrintState {
 }
 
 func (ps *PrintState) ComaList(list []Node) {
-	sep := ", "
+	PrintList(ps, list, ps.listSep())
+}
+
+func (ps *PrintState) listSep() string {
 	if ps.Compact {
-		sep = ","
+		return ","
 	}
-	PrintList(ps, list, sep)
+	return ", "
 }
@@ -76,6 +76,44 @@ func Modify(node Node, f func(Node) (Node, bool)) (Node, bool) { //nolint:funlen
 			newNode.Alternative = nc.(*Statements)
 		}
 		return f(newNode)
+	case *TryExpression:
+		newNode := &TryExpression{Base: node.Base, Name: node.Name} // the catch variable is left as is.
+		blocks := []*Statements{node.Body, node.Catch, node.Finally}
+		for i, b := range blocks {
+			if b == nil {
+				continue
+			}
+			nb, ok := Modify(b, f)
+			if !ok {
+				return nil, false
+			}
+			blocks[i] = nb.(*Statements)
+		}
+		newNode.Body, newNode.Catch, newNode.Finally = blocks[0], blocks[1], blocks[2]
+		return f(newNode)
+	case *MatchExpression:
+		newNode := &MatchExpression{Base: node.Base, Arms: make([]*MatchArm, len(node.Arms))}
+		newNode.Subject, cont = Modify(node.Subject, f)
+		if !cont {
+			return nil, false
+		}
+		for i, arm := range node.Arms {
+			// patterns are literals and bindings, left as is.
+			newArm := &MatchArm{Base: arm.Base, Patterns: arm.Patterns}
+			if arm.Guard != nil {
+				newArm.Guard, cont = Modify(arm.Guard, f)
+				if !cont {
+					return nil, false
+				}
+			}
+			nb, ok := Modify(arm.Body, f)
+			if !ok {
+				return nil, false
+			}
+			newArm.Body = nb.(*Statements)
+			newNode.Arms[i] = newArm
+		}
+		return f(newNode)
 	case *ForExpression:
 		newNode := &ForExpression{Base: node.Base}
 		newNode.Condition, cont = Modify(node.Condition, f)
@@ -105,7 +143,7 @@ func Modify(node Node, f func(Node) (Node, bool)) (Node, bool) { //nolint:funlen
 			if !ok {
 				return nil, false
 			}
-			newNode.Parameters[i] = id.(*Identifier)
+			newNode.Parameters[i] = id
 		}
 		nb, ok := Modify(node.Body, f)
 		if !ok {
@@ -152,6 +190,15 @@ func Modify(node Node, f func(Node) (Node, bool)) (Node, bool) { //nolint:funlen
 	case *StringLiteral:
 		n := *node
 		return f(&n)
+	case *InterpolatedString:
+		newNode := &InterpolatedString{Base: node.Base, Parts: make([]Node, len(node.Parts))}
+		for i := range node.Parts {
+			newNode.Parts[i], cont = Modify(node.Parts[i], f)
+			if !cont {
+				return nil, false
+			}
+		}
+		return f(newNode)
 	case *Boolean:
 		n := *node
 		return f(&n)
@@ -192,7 +239,7 @@ func Modify(node Node, f func(Node) (Node, bool)) (Node, bool) { //nolint:funlen
 			if !ok {
 				return nil, false
 			}
-			newNode.Parameters[i] = id.(*Identifier)
+			newNode.Parameters[i] = id
 		}
 		nb, ok := Modify(node.Body, f)
 		if !ok {
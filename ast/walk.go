@@ -0,0 +1,141 @@
+package ast
+
+// Walk traverses the AST depth first, calling f for each node before its children.
+// Children of a node are skipped when f returns false. Unlike Modify it doesn't copy
+// anything and also visits the function part of calls.
+func Walk(node Node, f func(Node) bool) { //nolint:gocyclo // lots of types.
+	if isNil(node) || !f(node) {
+		return
+	}
+	switch node := node.(type) {
+	case *Statements:
+		for _, statement := range node.Statements {
+			Walk(statement, f)
+		}
+	case *InfixExpression:
+		Walk(node.Left, f)
+		Walk(node.Right, f)
+	case *PrefixExpression:
+		Walk(node.Right, f)
+	case *IndexExpression:
+		Walk(node.Left, f)
+		Walk(node.Index, f)
+	case *IfExpression:
+		Walk(node.Condition, f)
+		Walk(node.Consequence, f)
+		Walk(node.Alternative, f)
+	case *ForExpression:
+		Walk(node.Condition, f)
+		Walk(node.Body, f)
+	case *TryExpression:
+		Walk(node.Body, f)
+		if node.Name != nil {
+			Walk(node.Name, f)
+		}
+		Walk(node.Catch, f)
+		Walk(node.Finally, f)
+	case *MatchExpression:
+		Walk(node.Subject, f)
+		for _, arm := range node.Arms {
+			walkList(arm.Patterns, f)
+			Walk(arm.Guard, f)
+			Walk(arm.Body, f)
+		}
+	case *ReturnStatement:
+		Walk(node.ReturnValue, f)
+	case *FunctionLiteral:
+		if node.Name != nil {
+			Walk(node.Name, f)
+		}
+		walkList(node.Parameters, f)
+		Walk(node.Body, f)
+	case *MacroLiteral:
+		walkList(node.Parameters, f)
+		Walk(node.Body, f)
+	case *ArrayLiteral:
+		walkList(node.Elements, f)
+	case *InterpolatedString:
+		walkList(node.Parts, f)
+	case *MapLiteral:
+		for _, key := range node.Order {
+			Walk(key, f)
+			Walk(node.Pairs[key], f)
+		}
+	case *Builtin:
+		walkList(node.Parameters, f)
+	case *CallExpression:
+		Walk(node.Function, f)
+		walkList(node.Arguments, f)
+	}
+}
+
+func walkList(nodes []Node, f func(Node) bool) {
+	for _, n := range nodes {
+		Walk(n, f)
+	}
+}
+
+// isNil checks for both nil interface and typed nil Statements (e.g. missing else).
+func isNil(node Node) bool {
+	if node == nil {
+		return true
+	}
+	s, ok := node.(*Statements)
+	return ok && s == nil
+}
+
+// MarkTailCalls sets Tail on the calls in tail position of a function body: its last expression,
+// through blocks, if/else branches and match arms, and the values of return statements. Calls in
+// loops, try blocks and nested functions (marked when they are parsed) are left alone. Nothing is
+// marked when the body contains a function literal: a tail call reuses the frame, which closures
+// made in earlier iterations may have captured.
+func MarkTailCalls(body *Statements) {
+	if HasFunctionLiteral(body) {
+		return
+	}
+	markTail(body, true)
+}
+
+// HasFunctionLiteral tells whether a function literal (closure) appears anywhere in body.
+func HasFunctionLiteral(body *Statements) bool {
+	found := false
+	Walk(body, func(n Node) bool {
+		if _, ok := n.(*FunctionLiteral); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+func markTail(node Node, tail bool) {
+	switch node := node.(type) {
+	case *Statements:
+		if node == nil {
+			return
+		}
+		last := len(node.Statements) - 1
+		for last >= 0 {
+			if _, isComment := node.Statements[last].(*Comment); !isComment {
+				break
+			}
+			last--
+		}
+		for i, st := range node.Statements {
+			markTail(st, tail && i == last)
+		}
+	case *ReturnStatement:
+		if node.ReturnValue != nil {
+			markTail(node.ReturnValue, true)
+		}
+	case *IfExpression:
+		markTail(node.Consequence, tail)
+		markTail(node.Alternative, tail)
+	case *MatchExpression:
+		for _, arm := range node.Arms {
+			markTail(arm.Body, tail)
+		}
+	case *CallExpression:
+		node.Tail = tail
+	}
+}
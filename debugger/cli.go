@@ -0,0 +1,184 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"grol.io/grol/eval"
+)
+
+const cliHelp = `Commands (first letter is enough for most):
+  break <line>|<func>   add a breakpoint (on a line of the script or entering a function)
+  delete [line|func]    remove a breakpoint (all if no argument)
+  breakpoints           list the breakpoints
+  continue              run until the next breakpoint
+  step                  step to the next statement, into function calls
+  next                  step to the next statement, over function calls
+  out                   run until the current function returns
+  print <expr>          evaluate expression in the current frame
+  vars                  show the variables of the current frame
+  bt                    show the stack (backtrace)
+  list                  show the source around the current line
+  quit                  abort the script
+An empty line repeats the previous command.
+`
+
+// CLI is the interactive (command line) front end of the debugger.
+type CLI struct {
+	*Debugger
+	in      *bufio.Scanner
+	out     io.Writer
+	prompt  string
+	lastCmd string
+}
+
+// NewCLI creates a command line debugger reading commands from in and writing to out.
+// It stops before the first statement so breakpoints can be set.
+func NewCLI(in io.Reader, out io.Writer) *CLI {
+	c := &CLI{in: bufio.NewScanner(in), out: out, prompt: "(debug) "}
+	c.Debugger = New(c.onStop, true)
+	return c
+}
+
+func (c *CLI) onStop(s *eval.State, stop Stop) Action {
+	c.where(s, stop)
+	for {
+		fmt.Fprint(c.out, c.prompt)
+		if !c.in.Scan() {
+			fmt.Fprintln(c.out)
+			return c.quit(s)
+		}
+		line := strings.TrimSpace(c.in.Text())
+		if line == "" {
+			line = c.lastCmd
+		}
+		c.lastCmd = line
+		if action, resume := c.command(s, stop, line); resume {
+			return action
+		}
+	}
+}
+
+// where prints the stop position and source line.
+func (c *CLI) where(s *eval.State, stop Stop) {
+	fmt.Fprintf(c.out, "Stopped (%s) at %s:%s", stop.Reason, s.CurrentFile, stop.Pos)
+	if frame := s.Frames()[0]; frame.Name != "" {
+		fmt.Fprintf(c.out, " in %s()", frame.Name)
+	}
+	fmt.Fprintln(c.out)
+	if src := s.SourceLine(stop.Pos.Line); src != "" {
+		fmt.Fprintf(c.out, "%d\t%s\n", stop.Pos.Line, src)
+	}
+}
+
+// command executes one command, returning true and the action if execution should resume.
+func (c *CLI) command(s *eval.State, stop Stop, line string) (Action, bool) { //nolint:gocyclo // one case per command.
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case "":
+	case "c", "continue":
+		return Continue, true
+	case "s", "step":
+		return StepIn, true
+	case "n", "next":
+		return StepOver, true
+	case "o", "out", "finish":
+		return StepOut, true
+	case "q", "quit":
+		return c.quit(s), true
+	case "b", "break":
+		c.setBreakpoint(arg)
+	case "d", "delete":
+		c.deleteBreakpoint(arg)
+	case "breakpoints":
+		lines, funcs := c.Breakpoints()
+		for _, l := range lines {
+			fmt.Fprintf(c.out, "line %d\n", l)
+		}
+		for _, f := range funcs {
+			fmt.Fprintf(c.out, "func %s\n", f)
+		}
+	case "p", "print":
+		res, err := s.EvalInFrame(s.Frames()[0], arg)
+		if err != nil {
+			fmt.Fprintln(c.out, err)
+		} else {
+			fmt.Fprintln(c.out, res.Inspect())
+		}
+	case "v", "vars":
+		env := s.Frames()[0].Env
+		for _, name := range env.Names() {
+			v, _ := env.GetLocal(name)
+			fmt.Fprintf(c.out, "%s = %s\n", name, v.Inspect())
+		}
+	case "bt", "backtrace", "where":
+		for i, f := range s.Frames() {
+			name := f.Name
+			if name == "" {
+				name = "<top level>"
+			}
+			fmt.Fprintf(c.out, "#%d %s at %s:%s\n", i, name, s.CurrentFile, f.Pos)
+		}
+	case "l", "list":
+		for l := max(1, stop.Pos.Line-5); l <= stop.Pos.Line+5; l++ {
+			marker := " "
+			if l == stop.Pos.Line {
+				marker = ">"
+			}
+			if src := s.SourceLine(l); src != "" || l == stop.Pos.Line {
+				fmt.Fprintf(c.out, "%s%d\t%s\n", marker, l, src)
+			}
+		}
+	case "h", "help":
+		fmt.Fprint(c.out, cliHelp)
+	default:
+		fmt.Fprintf(c.out, "Unknown command %q, type help for the list of commands\n", cmd)
+	}
+	return Continue, false
+}
+
+func (c *CLI) setBreakpoint(arg string) {
+	if arg == "" {
+		fmt.Fprintln(c.out, "break needs a line number or a function name")
+		return
+	}
+	if line, err := strconv.Atoi(arg); err == nil {
+		c.AddLineBreakpoint(line)
+		fmt.Fprintf(c.out, "Breakpoint set at line %d\n", line)
+		return
+	}
+	c.AddFunctionBreakpoint(arg)
+	fmt.Fprintf(c.out, "Breakpoint set on func %s\n", arg)
+}
+
+func (c *CLI) deleteBreakpoint(arg string) {
+	if arg == "" {
+		c.SetLineBreakpoints(nil)
+		c.SetFunctionBreakpoints(nil)
+		fmt.Fprintln(c.out, "All breakpoints deleted")
+		return
+	}
+	var found bool
+	if line, err := strconv.Atoi(arg); err == nil {
+		found = c.RemoveLineBreakpoint(line)
+	} else {
+		found = c.RemoveFunctionBreakpoint(arg)
+	}
+	if !found {
+		fmt.Fprintf(c.out, "No breakpoint on %s\n", arg)
+	}
+}
+
+// quit aborts the script by canceling its context (and removing the breakpoints so it ends promptly).
+func (c *CLI) quit(s *eval.State) Action {
+	c.SetLineBreakpoints(nil)
+	c.SetFunctionBreakpoints(nil)
+	if s.Cancel != nil {
+		s.Cancel()
+	}
+	return Continue
+}
@@ -0,0 +1,420 @@
+package debugger
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"fortio.org/log"
+	"grol.io/grol/eval"
+	"grol.io/grol/extensions"
+	"grol.io/grol/lsp"
+	"grol.io/grol/object"
+	"grol.io/grol/repl"
+)
+
+const threadID = 1 // grol scripts are single threaded.
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+// DAPServer is a Debug Adapter Protocol server, for a single (launched) script.
+type DAPServer struct {
+	*Debugger
+	in          *bufio.Reader
+	out         io.Writer
+	mu          sync.Mutex // protects the fields below and the writes to out.
+	seq         int
+	state       *eval.State
+	program     string
+	stopOnEntry bool
+	running     bool
+	cancel      context.CancelFunc    // aborts the script.
+	frames      []eval.Frame          // while stopped.
+	scopes      []*object.Environment // variablesReference - 1 while stopped.
+	resume      chan Action
+	next        *Action // how to resume once the current request is answered.
+	done        chan struct{}
+}
+
+// NewDAPServer creates a debug adapter reading requests from in and writing responses
+// and events to out. Extensions must have been initialized (extensions.Init) first.
+func NewDAPServer(in io.Reader, out io.Writer) *DAPServer {
+	d := &DAPServer{
+		in:     bufio.NewReader(in),
+		out:    out,
+		resume: make(chan Action),
+		done:   make(chan struct{}),
+	}
+	d.Debugger = New(d.onStop, false)
+	return d
+}
+
+// Run processes requests until disconnect (or end of input), returns the exit code.
+func (d *DAPServer) Run() int {
+	for {
+		msg, err := lsp.ReadMessage(d.in)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Errf("dap: error reading message: %v", err)
+				return 1
+			}
+			return 0
+		}
+		var req dapRequest
+		if err = json.Unmarshal(msg, &req); err != nil {
+			log.Errf("dap: invalid message: %v", err)
+			continue
+		}
+		log.LogVf("dap: %s", req.Command)
+		body, err := d.handle(req)
+		resp := dapResponse{Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+		d.send(&resp)
+		if d.next != nil { // resume after the response so it's sent before the next stopped event.
+			action := *d.next
+			d.next = nil
+			d.resume <- action
+		}
+		if req.Command == "initialize" {
+			d.event("initialized", nil)
+		}
+		if req.Command == "disconnect" || req.Command == "terminate" {
+			d.stopScript()
+			return 0
+		}
+	}
+}
+
+// send writes a response or event, setting its sequence number.
+func (d *DAPServer) send(msg any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seq++
+	switch m := msg.(type) {
+	case *dapResponse:
+		m.Seq = d.seq
+	case *dapEvent:
+		m.Seq = d.seq
+	}
+	if err := lsp.WriteMessage(d.out, msg); err != nil {
+		log.Errf("dap: error writing message: %v", err)
+	}
+}
+
+func (d *DAPServer) event(name string, body any) {
+	d.send(&dapEvent{Type: "event", Event: name, Body: body})
+}
+
+func (d *DAPServer) handle(req dapRequest) (any, error) { //nolint:gocyclo,funlen // one case per command.
+	switch req.Command {
+	case "initialize":
+		return map[string]any{
+			"supportsConfigurationDoneRequest": true,
+			"supportsFunctionBreakpoints":      true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "launch":
+		var args struct {
+			Program     string `json:"program"`
+			StopOnEntry bool   `json:"stopOnEntry"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if args.Program == "" {
+			return nil, errors.New("missing program to launch")
+		}
+		d.program, d.stopOnEntry = args.Program, args.StopOnEntry
+		return nil, nil
+	case "setBreakpoints":
+		var args struct {
+			Source struct {
+				Path string `json:"path"`
+			} `json:"source"`
+			Breakpoints []struct {
+				Line int `json:"line"`
+			} `json:"breakpoints"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		lines := make([]int, 0, len(args.Breakpoints))
+		result := make([]map[string]any, 0, len(args.Breakpoints))
+		verified := d.program == "" || sameFile(args.Source.Path, d.program)
+		for _, b := range args.Breakpoints {
+			lines = append(lines, b.Line)
+			result = append(result, map[string]any{"verified": verified, "line": b.Line})
+		}
+		if verified {
+			d.SetLineBreakpoints(lines)
+		}
+		return map[string]any{"breakpoints": result}, nil
+	case "setFunctionBreakpoints":
+		var args struct {
+			Breakpoints []struct {
+				Name string `json:"name"`
+			} `json:"breakpoints"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(args.Breakpoints))
+		result := make([]map[string]any, 0, len(args.Breakpoints))
+		for _, b := range args.Breakpoints {
+			names = append(names, b.Name)
+			result = append(result, map[string]any{"verified": true})
+		}
+		d.SetFunctionBreakpoints(names)
+		return map[string]any{"breakpoints": result}, nil
+	case "setExceptionBreakpoints":
+		return map[string]any{"breakpoints": []any{}}, nil
+	case "configurationDone":
+		return nil, d.start()
+	case "threads":
+		return map[string]any{"threads": []map[string]any{{"id": threadID, "name": "main"}}}, nil
+	case "stackTrace":
+		return d.stackTrace(), nil
+	case "scopes":
+		var args struct {
+			FrameID int `json:"frameId"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return d.scopesOf(args.FrameID)
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return d.variables(args.VariablesReference)
+	case "evaluate":
+		var args struct {
+			Expression string `json:"expression"`
+			FrameID    int    `json:"frameId"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return d.evaluate(args.Expression, args.FrameID)
+	case "continue":
+		return map[string]any{"allThreadsContinued": true}, d.resumeWith(Continue)
+	case "next":
+		return nil, d.resumeWith(StepOver)
+	case "stepIn":
+		return nil, d.resumeWith(StepIn)
+	case "stepOut":
+		return nil, d.resumeWith(StepOut)
+	case "pause":
+		d.Pause()
+		return nil, nil
+	case "disconnect", "terminate":
+		return nil, nil
+	}
+	return nil, errors.New("unsupported command " + req.Command)
+}
+
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// outputWriter sends what the script prints as output events.
+type outputWriter struct {
+	d        *DAPServer
+	category string
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.d.event("output", map[string]any{"category": w.category, "output": string(p)})
+	return len(p), nil
+}
+
+// start runs the launched program in the background.
+func (d *DAPServer) start() error {
+	if d.program == "" {
+		return errors.New("no program launched")
+	}
+	code, err := os.ReadFile(d.program)
+	if err != nil {
+		return err
+	}
+	s := eval.NewState()
+	s.Out = outputWriter{d, "stdout"}
+	s.LogOut = outputWriter{d, "console"}
+	s.CurrentFile = d.program
+	d.entry = d.stopOnEntry
+	d.Attach(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	d.mu.Lock()
+	d.state, d.running, d.cancel = s, true, cancel
+	d.mu.Unlock()
+	go func() {
+		defer cancel()
+		// Same as repl.EvalAll but with our context so the script can be aborted.
+		what := extensions.DropStartingShebang(string(code))
+		s.SetSource(d.program, what)
+		_, _, errs, _ := repl.EvalOne(ctx, s, what, s.Out, repl.Options{All: true}) //nolint:dogsled // same as EvalAll.
+		exitCode := 0
+		if len(errs) > 0 {
+			exitCode = 1
+			d.event("output", map[string]any{"category": "stderr", "output": strings.Join(errs, "\n") + "\n"})
+		}
+		d.mu.Lock()
+		d.running = false
+		d.mu.Unlock()
+		d.event("exited", map[string]any{"exitCode": exitCode})
+		d.event("terminated", nil)
+		close(d.done)
+	}()
+	return nil
+}
+
+// onStop is called on the script's goroutine, it waits for the client to resume.
+func (d *DAPServer) onStop(s *eval.State, stop Stop) Action {
+	d.mu.Lock()
+	d.frames = s.Frames()
+	d.scopes = nil
+	d.mu.Unlock()
+	d.event("stopped", map[string]any{"reason": stop.Reason, "threadId": threadID, "allThreadsStopped": true})
+	action := <-d.resume
+	d.mu.Lock()
+	d.frames, d.scopes = nil, nil
+	d.mu.Unlock()
+	return action
+}
+
+func (d *DAPServer) resumeWith(action Action) error {
+	d.mu.Lock()
+	stopped := d.frames != nil
+	d.mu.Unlock()
+	if !stopped {
+		return errors.New("not stopped")
+	}
+	d.next = &action
+	return nil
+}
+
+// stopScript cancels the script, if running, and waits for it to end.
+func (d *DAPServer) stopScript() {
+	d.mu.Lock()
+	running, stopped := d.running, d.frames != nil
+	d.mu.Unlock()
+	if !running {
+		return
+	}
+	d.SetLineBreakpoints(nil)
+	d.SetFunctionBreakpoints(nil)
+	d.cancel()
+	if stopped {
+		d.resume <- Continue
+	}
+	<-d.done
+}
+
+func (d *DAPServer) frame(id int) (eval.Frame, error) {
+	if id < 1 || id > len(d.frames) {
+		return eval.Frame{}, errors.New("invalid frame (not stopped?)")
+	}
+	return d.frames[id-1], nil
+}
+
+func (d *DAPServer) stackTrace() any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	frames := make([]map[string]any, 0, len(d.frames))
+	source := map[string]any{"name": filepath.Base(d.program), "path": d.program}
+	for i, f := range d.frames {
+		name := f.Name
+		if name == "" {
+			name = "<top level>"
+		}
+		frames = append(frames, map[string]any{
+			"id": i + 1, "name": name, "source": source, "line": f.Pos.Line, "column": f.Pos.Column,
+		})
+	}
+	return map[string]any{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+// scopesOf returns the local scope of a frame and, for functions, the globals.
+func (d *DAPServer) scopesOf(frameID int) (any, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := d.frame(frameID)
+	if err != nil {
+		return nil, err
+	}
+	root := d.frames[len(d.frames)-1].Env
+	d.scopes = append(d.scopes, f.Env)
+	scopes := []map[string]any{{"name": "Locals", "variablesReference": len(d.scopes), "expensive": false}}
+	if f.Env != root {
+		d.scopes = append(d.scopes, root)
+		scopes = append(scopes, map[string]any{"name": "Globals", "variablesReference": len(d.scopes), "expensive": false})
+	}
+	return map[string]any{"scopes": scopes}, nil
+}
+
+func (d *DAPServer) variables(ref int) (any, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ref < 1 || ref > len(d.scopes) {
+		return nil, errors.New("invalid variables reference")
+	}
+	env := d.scopes[ref-1]
+	vars := make([]map[string]any, 0)
+	for _, name := range env.Names() {
+		v, _ := env.GetLocal(name)
+		v = object.Value(v)
+		vars = append(vars, map[string]any{"name": name, "value": v.Inspect(), "type": v.Type().String(), "variablesReference": 0})
+	}
+	return map[string]any{"variables": vars}, nil
+}
+
+// evaluate runs on the protocol goroutine while the script's one is blocked in onStop.
+func (d *DAPServer) evaluate(expr string, frameID int) (any, error) {
+	d.mu.Lock()
+	f, err := d.frame(max(frameID, 1))
+	s := d.state
+	d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.EvalInFrame(f, expr)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"result": res.Inspect(), "variablesReference": 0}, nil
+}
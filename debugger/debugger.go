@@ -0,0 +1,190 @@
+// Package debugger implements breakpoints and stepping on top of the eval.Debugger hook,
+// with an interactive command line front end (`grol -debug`) and a Debug Adapter Protocol
+// server (`grol dap`) for editors.
+package debugger // import "grol.io/grol/debugger"
+
+import (
+	"slices"
+	"sort"
+	"sync"
+
+	"grol.io/grol/ast"
+	"grol.io/grol/eval"
+	"grol.io/grol/token"
+)
+
+// Action is how to resume after a stop.
+type Action int
+
+const (
+	Continue Action = iota // until the next breakpoint.
+	StepIn                 // stop at the next statement, including in called functions.
+	StepOver               // stop at the next statement of the current function (or its callers).
+	StepOut                // stop once the current function returns.
+)
+
+// Stop describes where and why the execution stopped.
+type Stop struct {
+	Reason string // "entry", "breakpoint", "function breakpoint", "step" or "pause".
+	Node   ast.Node
+	Pos    token.Position
+}
+
+// Debugger decides, before each statement, whether to stop. When it does, it calls OnStop
+// which blocks until the user decides how to resume.
+type Debugger struct {
+	OnStop func(s *eval.State, stop Stop) Action
+
+	mu        sync.Mutex
+	lines     map[int]bool
+	functions map[string]bool
+	action    Action
+	entry     bool // stop at the first statement.
+	pause     bool // stop at the next statement.
+	stepLine  int  // line and depth where the last step started.
+	stepDepth int
+	lastLine  int // line and depth of the previous statement.
+	lastDepth int
+}
+
+// New creates a debugger that stops at the first statement when stopOnEntry is true.
+func New(onStop func(s *eval.State, stop Stop) Action, stopOnEntry bool) *Debugger {
+	return &Debugger{
+		OnStop:    onStop,
+		lines:     make(map[int]bool),
+		functions: make(map[string]bool),
+		entry:     stopOnEntry,
+	}
+}
+
+// Attach sets the debugger on the state.
+func (d *Debugger) Attach(s *eval.State) {
+	s.SetDebugger(d)
+}
+
+// SetLineBreakpoints replaces the line breakpoints.
+func (d *Debugger) SetLineBreakpoints(lines []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lines = make(map[int]bool, len(lines))
+	for _, l := range lines {
+		d.lines[l] = true
+	}
+}
+
+// SetFunctionBreakpoints replaces the function breakpoints.
+func (d *Debugger) SetFunctionBreakpoints(names []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.functions = make(map[string]bool, len(names))
+	for _, n := range names {
+		d.functions[n] = true
+	}
+}
+
+// AddLineBreakpoint adds a breakpoint on the given line.
+func (d *Debugger) AddLineBreakpoint(line int) {
+	d.mu.Lock()
+	d.lines[line] = true
+	d.mu.Unlock()
+}
+
+// AddFunctionBreakpoint adds a breakpoint on entering the named function.
+func (d *Debugger) AddFunctionBreakpoint(name string) {
+	d.mu.Lock()
+	d.functions[name] = true
+	d.mu.Unlock()
+}
+
+// RemoveLineBreakpoint removes a line breakpoint, returns false if there wasn't one.
+func (d *Debugger) RemoveLineBreakpoint(line int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	found := d.lines[line]
+	delete(d.lines, line)
+	return found
+}
+
+// RemoveFunctionBreakpoint removes a function breakpoint, returns false if there wasn't one.
+func (d *Debugger) RemoveFunctionBreakpoint(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	found := d.functions[name]
+	delete(d.functions, name)
+	return found
+}
+
+// Breakpoints returns the sorted line and function breakpoints.
+func (d *Debugger) Breakpoints() ([]int, []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lines := make([]int, 0, len(d.lines))
+	for l := range d.lines {
+		lines = append(lines, l)
+	}
+	sort.Ints(lines)
+	names := make([]string, 0, len(d.functions))
+	for n := range d.functions {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	return lines, names
+}
+
+// Pause requests a stop at the next statement.
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	d.pause = true
+	d.mu.Unlock()
+}
+
+// Statement implements eval.Debugger.
+func (d *Debugger) Statement(s *eval.State, node ast.Node) {
+	pos := eval.StatementStart(node)
+	depth := s.FrameDepth()
+	d.mu.Lock()
+	reason := d.reason(s, pos.Line, depth)
+	d.lastLine, d.lastDepth = pos.Line, depth
+	d.mu.Unlock()
+	if reason == "" {
+		return
+	}
+	action := d.OnStop(s, Stop{Reason: reason, Node: node, Pos: pos})
+	d.mu.Lock()
+	d.action, d.stepLine, d.stepDepth = action, pos.Line, depth
+	d.mu.Unlock()
+}
+
+// reason returns why to stop at that statement, "" to keep going. Called with the lock held.
+func (d *Debugger) reason(s *eval.State, line, depth int) string {
+	moved := line != d.lastLine || depth != d.lastDepth // don't stop twice on `a=1; b=2`.
+	stepped := line != d.stepLine || depth != d.stepDepth
+	switch {
+	case d.entry:
+		d.entry = false
+		return "entry"
+	case d.pause:
+		d.pause = false
+		return "pause"
+	case moved && d.lines[line]:
+		return "breakpoint"
+	case depth > d.lastDepth && len(d.functions) > 0 && d.functions[s.Frames()[0].Name]:
+		return "function breakpoint"
+	}
+	switch d.action {
+	case StepIn:
+		if stepped {
+			return "step"
+		}
+	case StepOver:
+		if stepped && depth <= d.stepDepth {
+			return "step"
+		}
+	case StepOut:
+		if depth < d.stepDepth {
+			return "step"
+		}
+	case Continue:
+	}
+	return ""
+}
@@ -0,0 +1,231 @@
+package debugger_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"grol.io/grol/debugger"
+	"grol.io/grol/eval"
+	"grol.io/grol/extensions"
+	"grol.io/grol/lsp"
+)
+
+const script = `func fact(n) {
+	if n <= 1 {
+		return 1
+	}
+	r := n * fact(n - 1)
+	r
+}
+x := 3
+y := fact(x)
+println("y is", y)
+`
+
+func TestCLI(t *testing.T) {
+	s := eval.NewState()
+	out := strings.Builder{}
+	s.Out = &out
+	s.CurrentFile = "test.gr"
+	s.SetSource("test.gr", script)
+	cmds := "b fact\nc\nbt\nvars\nc\np n*10\nd fact\nn\n\nout\nvars\nb 10\nc\nprint y\nc\n"
+	debugger.NewCLI(strings.NewReader(cmds), &out).Attach(s)
+	if _, err := eval.EvalString(s, script, false); err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	expected := []string{
+		"Stopped (entry) at test.gr:1:1",
+		"Breakpoint set on func fact",
+		"Stopped (function breakpoint) at test.gr:2:2 in fact()\n2\t\tif n <= 1 {\n",
+		"#0 fact at test.gr:2:2\n#1 <top level> at test.gr:9:1\n",
+		"(debug) n = 3\n",
+		"(debug) 20\n",
+		"(debug) (debug) Stopped (step) at test.gr:5:2 in fact()", // next over fact(1).
+		"(debug) Stopped (step) at test.gr:6:2 in fact()\n6\t\tr\n(debug) Stopped (step) at test.gr:6:2 in fact()\n",
+		"(debug) n = 3\nr = 6\n", // back in fact(3) after out.
+		"Breakpoint set at line 10",
+		"Stopped (breakpoint) at test.gr:10:1\n10\tprintln(\"y is\", y)\n(debug) 6\n",
+		"y is 6\n",
+	}
+	got := out.String()
+	for _, e := range expected {
+		if !strings.Contains(got, e) {
+			t.Errorf("missing %q in output:\n%s", e, got)
+		}
+	}
+}
+
+// dapClient drives a DAPServer through pipes.
+type dapClient struct {
+	t        *testing.T
+	w        io.Writer
+	seq      int
+	messages chan map[string]any
+}
+
+func newDAPClient(t *testing.T) *dapClient {
+	t.Helper()
+	if err := extensions.Init(nil); err != nil {
+		t.Fatalf("extensions.Init: %v", err)
+	}
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	srv := debugger.NewDAPServer(inR, outW)
+	go func() {
+		srv.Run()
+		outW.Close()
+	}()
+	c := &dapClient{t: t, w: inW, messages: make(chan map[string]any, 100)}
+	go func() {
+		r := bufio.NewReader(outR)
+		for {
+			msg, err := lsp.ReadMessage(r)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			var m map[string]any
+			_ = json.Unmarshal(msg, &m)
+			c.messages <- m
+		}
+	}()
+	return c
+}
+
+// request sends the command and returns its response body, failing if it wasn't successful.
+func (c *dapClient) request(command string, args any) map[string]any {
+	c.t.Helper()
+	c.seq++
+	err := lsp.WriteMessage(c.w, map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	if err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+	m := c.waitFor(func(m map[string]any) bool {
+		return m["type"] == "response" && m["request_seq"] == float64(c.seq)
+	})
+	if m["success"] != true {
+		c.t.Fatalf("%s failed: %v", command, m)
+	}
+	body, _ := m["body"].(map[string]any)
+	return body
+}
+
+// event waits for the named event and returns its body.
+func (c *dapClient) event(name string) map[string]any {
+	c.t.Helper()
+	m := c.waitFor(func(m map[string]any) bool { return m["type"] == "event" && m["event"] == name })
+	body, _ := m["body"].(map[string]any)
+	return body
+}
+
+func (c *dapClient) waitFor(match func(map[string]any) bool) map[string]any {
+	c.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m, ok := <-c.messages:
+			if !ok {
+				c.t.Fatalf("server closed")
+			}
+			if match(m) {
+				return m
+			}
+		case <-timeout:
+			c.t.Fatalf("timeout waiting for message")
+		}
+	}
+}
+
+func TestDAP(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "test.gr")
+	if err := os.WriteFile(program, []byte(script), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := newDAPClient(t)
+	c.request("initialize", map[string]any{"adapterID": "grol"})
+	c.event("initialized")
+	c.request("launch", map[string]any{"program": program})
+	c.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": program},
+		"breakpoints": []map[string]any{{"line": 3}},
+	})
+	c.request("configurationDone", nil)
+	if reason := c.event("stopped")["reason"]; reason != "breakpoint" {
+		t.Errorf("stopped reason %v", reason)
+	}
+	frames := c.request("stackTrace", map[string]any{"threadId": 1})["stackFrames"].([]any)
+	if len(frames) != 4 {
+		t.Fatalf("expected 4 frames, got %v", frames)
+	}
+	top := frames[0].(map[string]any)
+	if top["name"] != "fact" || top["line"] != float64(3) {
+		t.Errorf("unexpected top frame %v", top)
+	}
+	scopes := c.request("scopes", map[string]any{"frameId": 1})["scopes"].([]any)
+	ref := scopes[0].(map[string]any)["variablesReference"]
+	vars := c.request("variables", map[string]any{"variablesReference": ref})["variables"].([]any)
+	if len(vars) != 1 || vars[0].(map[string]any)["name"] != "n" || vars[0].(map[string]any)["value"] != "1" {
+		t.Errorf("unexpected variables %v", vars)
+	}
+	if res := c.request("evaluate", map[string]any{"expression": "n + 41", "frameId": 2})["result"]; res != "43" {
+		t.Errorf("evaluate in caller frame: %v", res)
+	}
+	c.request("stepOut", map[string]any{"threadId": 1})
+	c.event("stopped")
+	frames = c.request("stackTrace", map[string]any{"threadId": 1})["stackFrames"].([]any)
+	if top = frames[0].(map[string]any); top["line"] != float64(6) || len(frames) != 3 {
+		t.Errorf("unexpected frames after step out %v", frames)
+	}
+	c.request("setBreakpoints", map[string]any{"source": map[string]any{"path": program}, "breakpoints": []any{}})
+	c.request("continue", map[string]any{"threadId": 1})
+	if out := c.event("output")["output"]; out != "y is 6\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+	if code := c.event("exited")["exitCode"]; code != float64(0) {
+		t.Errorf("exit code %v", code)
+	}
+	c.event("terminated")
+	c.request("disconnect", nil)
+}
+
+func TestCLIMatch(t *testing.T) {
+	code := `func f(v) {
+	a := 1
+	match v {
+		[x, y] {
+			b := x + y
+			b * a
+		}
+	}
+}
+f([2, 3])
+`
+	s := eval.NewState()
+	out := strings.Builder{}
+	s.Out = &out
+	s.SetSource("test.gr", code)
+	debugger.NewCLI(strings.NewReader("b 5\nc\nbt\nvars\nn\nvars\nc\n"), &out).Attach(s)
+	if _, err := eval.EvalString(s, code, false); err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	// the arm's bindings are part of f's frame.
+	expected := []string{
+		"Stopped (breakpoint) at test.gr:5:4 in f()",
+		"#0 f at test.gr:5:4\n#1 <top level> at test.gr:10:1\n(debug)",
+		"(debug) a = 1\nv = [2,3]\nx = 2\ny = 3\n",
+		"(debug) Stopped (step) at test.gr:6:4 in f()",
+		"(debug) a = 1\nb = 5\nv = [2,3]\nx = 2\ny = 3\n",
+	}
+	got := out.String()
+	for _, e := range expected {
+		if !strings.Contains(got, e) {
+			t.Errorf("missing %q in output:\n%s", e, got)
+		}
+	}
+}
@@ -0,0 +1,851 @@
+package eval
+
+// Compiler from function bodies to the bytecode run by the VM (see vm.go).
+// Parameters and := variables get resolved to local slots, integer for loops get their
+// counter in native int64s, and anything the compiler doesn't handle natively but that
+// doesn't touch local slots (calls to closures, macros, pipes,...) is delegated back to
+// the tree walking evaluator. Functions using constructs that can't be expressed either
+// way (like eval() or info, which need to see all variables in the environment) are
+// not compiled and keep running through the evaluator.
+
+import (
+	"fortio.org/log"
+	"grol.io/grol/ast"
+	"grol.io/grol/object"
+	"grol.io/grol/token"
+)
+
+type opcode uint8
+
+const (
+	opConst        opcode = iota // push consts[a]
+	opPop                        // drop top of stack
+	opDeref                      // replace top of stack reference by its value
+	opSlot                       // push slot a, through the environment (identifier node) while not set, c: raw
+	opDefineSlot                 // slot a := top of stack
+	opSetSlot                    // slot a = top of stack
+	opCompoundSlot               // slot a op= top of stack, c: op
+	opPostfixSlot                // slot a++ or a--
+	opSetIndexSlot               // slot a[index] (op)= value, stack: value, index. c: op (or 0 for plain =)
+	opIdent                      // push identifier node, c: raw
+	opEval                       // push tree walking evaluation of node, c: raw
+	opAssign                     // assignment node of (popped) value to non local target
+	opPrefix                     // prefix operator c on top of stack
+	opInfix                      // infix operator c on top 2 values
+	opJump                       // jump to a
+	opJumpIf                     // jump to a if top of stack is consts[b] (short circuit), keeping it either way
+	opIf                         // pop condition: continue if true, jump to a if false or nil, error otherwise
+	opTry                        // errors until opEndTry jump to a with the error pushed instead of returning
+	opEndTry                     // end of try region
+	opReturn                     // return top of stack
+	opCall                       // call function below a arguments, b: name in strs, c: 1 when followed by return
+	opBuiltin                    // len/first/rest/catch of top of stack, c: token
+	opPrint                      // print/println/log/error of the top a values
+	opLogCheck                   // replace top of stack by nil and jump to a when log() is disabled
+	opArray                      // array of the top a values
+	opInterp                     // string interpolation of the top a values
+	opCheckKey                   // error if top of stack isn't usable as a map key
+	opMap                        // map of the top a key, value pairs
+	opDotExt                     // push extension named strs[b] and jump to a if it exists
+	opIndex                      // index expression: c: 0 value[index], 1 dot or range (evaluated from node)
+	opCheckInt                   // error with message strs[a] if top of stack isn't an integer
+	opLoopInit                   // start of condition loop a
+	opLoopStart                  // start of loop a with `var = value` (int, list or condition)
+	opLoopRange                  // start of loop a with `var = start:end[:incr]` with c values
+	opLoopCond                   // pop condition of loop a: run body, exit or switch to counting
+	opLoopBody                   // end of loop a's body: save the value and move on to next iteration
+	opLoopNext                   // next iteration of loop a
+	opLoopVar                    // push loop a's integer variable (or slot b when not counting), c: raw
+	opLoopEnd                    // push value of loop a
+)
+
+type instr struct {
+	op   opcode
+	c    uint8 // small operand (raw flag, operator,...)
+	a, b int32
+	node int32 // index in code.nodes, used for error locations, or -1.
+}
+
+// Loop modes.
+const (
+	modeCond  = iota // condition evaluated for each iteration.
+	modeInt          // counting with the variable set to the count.
+	modeCount        // counting without variable (condition evaluated to an integer).
+	modeList         // iterating over elements of an array, map or string.
+)
+
+// Per loop int64 state, at loopInfo.ints + offset.
+const (
+	loopMode = iota
+	loopCur
+	loopEnd
+	loopIncr
+	loopStarted
+	loopInts // number of int64s per loop.
+)
+
+type loopInfo struct {
+	ints                   int // base index in the frame's int64s.
+	list, last             int // slots for remaining elements and last body value.
+	varSlot                int // slot of the `var = ...` variable, -1 when none.
+	cond, body, next, exit int // entry points.
+}
+
+type slotInfo struct {
+	name   string
+	mirror bool // kept in the environment too, so recursive calls and closures can see it.
+}
+
+type code struct {
+	instrs  []instr
+	consts  []object.Object
+	strs    []string // function and extension names, error messages.
+	nodes   []ast.Node
+	slots   []slotInfo // named slots first, then hidden ones for loops.
+	params  []int      // slot of each (non variadic) parameter.
+	loops   []loopInfo
+	numInts int
+	noReg   bool
+}
+
+// notCompilable is the panic value used to abandon compilation of a function.
+type notCompilable string
+
+type compileCtx struct {
+	canReturn bool
+	loop      int // innermost loop break and continue refer to, -1 if none.
+}
+
+type compiler struct {
+	s        *State
+	c        *code
+	slots    map[string]int
+	loopVars []loopVar // integer loop variables in scope.
+	ctx      compileCtx
+}
+
+type loopVar struct {
+	name string
+	loop int
+}
+
+// maxCompiled is the maximum number of function bodies whose bytecode a state keeps, the
+// compiled code of the ones no longer used (e.g. from previous REPL inputs) is otherwise never
+// released. Reaching it starts over, the functions still used get compiled again.
+const maxCompiled = 1024
+
+// compiledCode returns the bytecode for the function, compiling it on first use,
+// or nil when the VM is off or the function can't be compiled.
+func (s *State) compiledCode(fn object.Function) *code {
+	if !s.VM {
+		return nil
+	}
+	c, ok := s.compiled[fn.Body]
+	if !ok {
+		c = s.compile(fn)
+		if s.compiled == nil || len(s.compiled) >= maxCompiled {
+			s.compiled = make(map[*ast.Statements]*code)
+		}
+		s.compiled[fn.Body] = c
+	}
+	return c
+}
+
+func (s *State) compile(fn object.Function) (res *code) {
+	cp := &compiler{
+		s:     s,
+		c:     &code{noReg: s.NoReg},
+		slots: make(map[string]int),
+		ctx:   compileCtx{canReturn: true, loop: -1},
+	}
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		reason, ok := r.(notCompilable)
+		if !ok {
+			panic(r)
+		}
+		if log.LogVerbose() {
+			log.LogVf("vm: not compiling %s: %s", fn.Inspect(), reason)
+		}
+		res = nil
+	}()
+	params := fn.Parameters
+	if fn.Variadic {
+		params = params[:len(params)-1]
+	}
+	for _, p := range params {
+		if isPattern(p) {
+			cp.fail("destructuring parameter")
+		}
+		cp.c.params = append(cp.c.params, cp.addSlot(p.Value().Literal(), false))
+	}
+	cp.findLocals(fn.Body)
+	for name := range cp.slots {
+		if object.Constant(name) || object.IsExtraFunction(name) || name == "self" || name == "info" || name == ".." {
+			cp.fail("reserved name " + name)
+		}
+		if _, isExt := s.Extensions[name]; isExt {
+			cp.fail("extension name " + name)
+		}
+		if fn.Name != nil && name == fn.Name.Literal() {
+			cp.fail("function name " + name)
+		}
+	}
+	cp.block(fn.Body)
+	cp.emit(opReturn, 0, 0, 0, nil)
+	if !ast.HasFunctionLiteral(fn.Body) {
+		cp.markTailCalls() // closures may have captured the frame the loop would rebind.
+	}
+	if log.LogDebug() {
+		log.Debugf("vm: compiled %s to %d instructions, %d slots", fn.Inspect(), len(cp.c.instrs), len(cp.c.slots))
+	}
+	return cp.c
+}
+
+// markTailCalls flags the calls followed by a return, possibly through jumps, which the VM runs
+// as loops when they call the function itself (see tailCall).
+func (cp *compiler) markTailCalls() {
+	instrs := cp.c.instrs
+	for pc := range instrs {
+		if instrs[pc].op != opCall {
+			continue
+		}
+		next := pc + 1
+		for instrs[next].op == opJump {
+			next = int(instrs[next].a)
+		}
+		if instrs[next].op == opReturn {
+			instrs[pc].c = 1
+		}
+	}
+}
+
+func (cp *compiler) fail(reason string) {
+	panic(notCompilable(reason))
+}
+
+// findLocals assigns slots to := variables and `for var = ...` variables.
+func (cp *compiler) findLocals(body *ast.Statements) {
+	ast.Walk(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral, *ast.MacroLiteral:
+			return false // their own scope, variables they share with us are mirrored (see isLocal).
+		case *ast.Identifier:
+			switch n.Literal() {
+			case "eval", "info", "load":
+				cp.fail("needs the whole environment: " + n.Literal())
+			}
+		case *ast.InfixExpression:
+			if (n.Type() == token.ASSIGN || n.Type() == token.DEFINE) && isPattern(n.Left) {
+				cp.fail("destructuring assignment")
+			}
+			if n.Type() == token.DEFINE {
+				if id, ok := n.Left.(*ast.Identifier); ok {
+					cp.addSlot(id.Literal(), true)
+				}
+			}
+		case *ast.ForExpression:
+			if name, ok := forVar(n); ok {
+				cp.addSlot(name, true)
+			}
+		case *ast.MatchExpression:
+			cp.fail("match expression")
+		case *ast.TryExpression:
+			cp.fail("try expression")
+		}
+		return true
+	})
+}
+
+// forVar returns the variable name of the `for var = ...` special forms.
+func forVar(fe *ast.ForExpression) (string, bool) {
+	ie, ok := fe.Condition.(*ast.InfixExpression)
+	if !ok || (ie.Type() != token.ASSIGN && ie.Type() != token.DEFINE) {
+		return "", false
+	}
+	if ie.Left.Value().Type() != token.IDENT {
+		return "", false
+	}
+	return ie.Left.Value().Literal(), true
+}
+
+func (cp *compiler) addSlot(name string, mirror bool) int {
+	if idx, ok := cp.slots[name]; ok {
+		return idx
+	}
+	idx := len(cp.c.slots)
+	cp.c.slots = append(cp.c.slots, slotInfo{name: name, mirror: mirror})
+	cp.slots[name] = idx
+	return idx
+}
+
+func (cp *compiler) hiddenSlot() int {
+	cp.c.slots = append(cp.c.slots, slotInfo{})
+	return len(cp.c.slots) - 1
+}
+
+func (cp *compiler) emit(op opcode, c uint8, a, b int, node ast.Node) int {
+	in := instr{op: op, c: c, a: int32(a), b: int32(b), node: -1} //nolint:gosec // code is never that big.
+	if node != nil {
+		in.node = int32(len(cp.c.nodes)) //nolint:gosec // same.
+		cp.c.nodes = append(cp.c.nodes, node)
+	}
+	cp.c.instrs = append(cp.c.instrs, in)
+	return len(cp.c.instrs) - 1
+}
+
+// patch sets the jump target of instruction at pc to be the next instruction emitted.
+func (cp *compiler) patch(pc int) {
+	cp.c.instrs[pc].a = int32(len(cp.c.instrs)) //nolint:gosec // code is never that big.
+}
+
+func (cp *compiler) constant(o object.Object) int {
+	cp.c.consts = append(cp.c.consts, o)
+	return len(cp.c.consts) - 1
+}
+
+func (cp *compiler) str(str string) int {
+	cp.c.strs = append(cp.c.strs, str)
+	return len(cp.c.strs) - 1
+}
+
+func (cp *compiler) emitConst(o object.Object) {
+	cp.emit(opConst, 0, cp.constant(o), 0, nil)
+}
+
+func rawFlag(raw bool) uint8 {
+	if raw {
+		return 1
+	}
+	return 0
+}
+
+// isLocal returns true if the node refers to local slots or has control flow (return, break, continue)
+// outside of nested functions, ie. if it can't be handed over as is to the tree walking evaluator.
+func (cp *compiler) isLocal(node ast.Node) bool {
+	local := false
+	ast.Walk(node, func(n ast.Node) bool {
+		if local {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.Identifier:
+			_, local = cp.slots[n.Literal()]
+		case *ast.PostfixExpression:
+			_, local = cp.slots[n.Prev.Literal()]
+		case *ast.ReturnStatement, *ast.ControlExpression:
+			local = true
+		case *ast.FunctionLiteral:
+			// Control flow inside nested functions is their own, and they see our variables
+			// through the environment they capture.
+			cp.mirrorSlots(n)
+			return false
+		}
+		return !local
+	})
+	return local
+}
+
+// mirrorSlots marks the slots a closure refers to as needing to be kept in the environment.
+func (cp *compiler) mirrorSlots(fn *ast.FunctionLiteral) {
+	ast.Walk(fn.Body, func(n ast.Node) bool {
+		name := ""
+		switch n := n.(type) {
+		case *ast.Identifier:
+			name = n.Literal()
+		case *ast.PostfixExpression:
+			name = n.Prev.Literal()
+		}
+		if k, ok := cp.slots[name]; ok {
+			cp.c.slots[k].mirror = true
+		}
+		return true
+	})
+}
+
+// delegate hands over the node to the tree walking evaluator, when possible.
+func (cp *compiler) delegate(node ast.Node, raw bool) {
+	if cp.isLocal(node) {
+		cp.fail("can't delegate " + node.Value().DebugString())
+	}
+	cp.emit(opEval, rawFlag(raw), 0, 0, node)
+}
+
+// block compiles statements, leaving the value of the last one on the stack.
+func (cp *compiler) block(stmts *ast.Statements) {
+	n := 0
+	if stmts != nil {
+		for _, st := range stmts.Statements {
+			if isComment(st) {
+				continue
+			}
+			if n > 0 {
+				cp.emit(opPop, 0, 0, 0, nil)
+			}
+			cp.statement(st)
+			n++
+		}
+	}
+	if n == 0 {
+		cp.emitConst(object.NULL)
+	}
+}
+
+func (cp *compiler) statement(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.ReturnStatement:
+		if !cp.ctx.canReturn {
+			cp.fail("return inside expression")
+		}
+		if node.ReturnValue == nil {
+			cp.emitConst(object.NULL)
+		} else {
+			cp.expr(node.ReturnValue, true)
+		}
+		cp.emit(opReturn, 0, 0, 0, node)
+	case *ast.ControlExpression:
+		if cp.ctx.loop < 0 {
+			cp.fail(node.Literal() + " outside of loop body")
+		}
+		loop := &cp.c.loops[cp.ctx.loop]
+		target := loop.exit
+		if node.Type() == token.CONTINUE {
+			target = loop.next
+		}
+		// targets aren't known yet, store the loop and fix them up at the end of the loop.
+		cp.emit(opJump, uint8(node.Type()), target, cp.ctx.loop, node)
+	case *ast.IfExpression:
+		cp.ifExpr(node)
+	case *ast.ForExpression:
+		cp.forExpr(node)
+	default:
+		cp.expr(node, true)
+	}
+}
+
+// expr compiles an expression, where return, break and continue aren't allowed
+// (they would leave partial values on the stack).
+func (cp *compiler) expr(node ast.Node, raw bool) {
+	saved := cp.ctx
+	cp.ctx = compileCtx{loop: -1}
+	cp.expression(node, raw)
+	cp.ctx = saved
+}
+
+func (cp *compiler) expression(node ast.Node, raw bool) { //nolint:gocyclo,funlen // lots of node types.
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		cp.emitConst(object.Integer{Value: node.Val})
+	case *ast.FloatLiteral:
+		cp.emitConst(object.Float{Value: node.Val})
+	case *ast.Boolean:
+		cp.emitConst(object.NativeBoolToBooleanObject(node.Val))
+	case *ast.StringLiteral:
+		cp.emitConst(object.String{Value: node.Literal()})
+	case *ast.Comment:
+		cp.emitConst(object.NULL)
+	case *ast.Identifier:
+		cp.identifier(node, raw)
+	case *extensionRef:
+		cp.emitConst(node.ext)
+	case *ast.Statements:
+		cp.block(node)
+		cp.deref(raw)
+	case *ast.IfExpression:
+		cp.ifExpr(node)
+		cp.deref(raw)
+	case *ast.ForExpression:
+		cp.forExpr(node)
+		cp.deref(raw)
+	case *ast.PrefixExpression:
+		if node.Type() == token.INCR || node.Type() == token.DECR {
+			cp.delegate(node, raw)
+			return
+		}
+		cp.expr(node.Right, false)
+		cp.emit(opPrefix, uint8(node.Type()), 0, 0, node)
+	case *ast.PostfixExpression:
+		cp.postfix(node, raw)
+	case *ast.InfixExpression:
+		cp.infix(node, raw)
+	case *ast.CallExpression:
+		cp.expr(node.Function, false)
+		for _, arg := range node.Arguments {
+			cp.expr(arg, true)
+		}
+		name := cp.str(node.Function.Value().Literal())
+		cp.emit(opCall, 0, len(node.Arguments), name, node)
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			cp.expr(e, true)
+		}
+		cp.emit(opArray, 0, len(node.Elements), 0, node)
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			cp.expr(part, true)
+		}
+		cp.emit(opInterp, 0, len(node.Parts), 0, node)
+	case *ast.MapLiteral:
+		cp.mapLiteral(node)
+	case *ast.IndexExpression:
+		cp.index(node)
+	case *ast.Builtin:
+		cp.builtin(node, raw)
+	default:
+		// function literals (closures), macros, bigint literals,...
+		cp.delegate(node, raw)
+	}
+}
+
+func (cp *compiler) deref(raw bool) {
+	if !raw {
+		cp.emit(opDeref, 0, 0, 0, nil)
+	}
+}
+
+func (cp *compiler) loopVar(name string) (int, bool) {
+	for i := len(cp.loopVars) - 1; i >= 0; i-- {
+		if cp.loopVars[i].name == name {
+			return cp.loopVars[i].loop, true
+		}
+	}
+	return -1, false
+}
+
+func (cp *compiler) identifier(node *ast.Identifier, raw bool) {
+	name := node.Literal()
+	slot, isSlot := cp.slots[name]
+	if loop, ok := cp.loopVar(name); ok {
+		cp.emit(opLoopVar, rawFlag(raw), loop, slot, node)
+		return
+	}
+	if isSlot {
+		cp.emit(opSlot, rawFlag(raw), slot, 0, node)
+		return
+	}
+	cp.emit(opIdent, rawFlag(raw), 0, 0, node)
+}
+
+func (cp *compiler) postfix(node *ast.PostfixExpression, raw bool) {
+	name := node.Prev.Literal()
+	if _, ok := cp.loopVar(name); ok {
+		cp.fail("loop variable modified " + name)
+	}
+	slot, isSlot := cp.slots[name]
+	if !isSlot {
+		cp.delegate(node, raw)
+		return
+	}
+	cp.emit(opPostfixSlot, 0, slot, 0, node)
+}
+
+func (cp *compiler) infix(node *ast.InfixExpression, raw bool) {
+	op := node.Type()
+	if isAssignment(op) {
+		cp.assignment(node)
+		return
+	}
+	if op == token.BITOR && node.Right.Value().Type() == token.LPAREN {
+		// pipe (string | call) or bitwise or of a call, leave it to the evaluator.
+		cp.delegate(node, raw)
+		return
+	}
+	cp.expr(node.Left, false)
+	var shortCircuit int
+	switch op { //nolint:exhaustive // only these 2 short circuit.
+	case token.AND:
+		shortCircuit = cp.emit(opJumpIf, 0, 0, cp.constant(object.FALSE), nil)
+	case token.OR:
+		shortCircuit = cp.emit(opJumpIf, 0, 0, cp.constant(object.TRUE), nil)
+	}
+	cp.expr(node.Right, false)
+	cp.emit(opInfix, uint8(op), 0, 0, node)
+	if shortCircuit != 0 {
+		cp.patch(shortCircuit)
+	}
+}
+
+func (cp *compiler) assignment(node *ast.InfixExpression) {
+	op := node.Type()
+	switch target := node.Left.(type) {
+	case *ast.Identifier:
+		name := target.Literal()
+		if _, ok := cp.loopVar(name); ok {
+			cp.fail("loop variable modified " + name)
+		}
+		slot, isSlot := cp.slots[name]
+		cp.expr(node.Right, false)
+		switch {
+		case !isSlot:
+			cp.emit(opAssign, 0, 0, 0, node)
+		case op == token.DEFINE:
+			cp.emit(opDefineSlot, 0, slot, 0, node)
+		case op == token.ASSIGN:
+			cp.emit(opSetSlot, 0, slot, 0, node)
+		default:
+			compound, _ := isCompound(op)
+			cp.emit(opCompoundSlot, uint8(compound), slot, 0, node)
+		}
+		return
+	case *ast.IndexExpression:
+		if !cp.isLocal(target) {
+			cp.expr(node.Right, false)
+			cp.emit(opAssign, 0, 0, 0, node)
+			return
+		}
+		cp.indexAssignment(node, target)
+		return
+	}
+	cp.delegate(node, true)
+}
+
+// indexAssignment handles local[index] = value and local.key = value (and their compound forms).
+func (cp *compiler) indexAssignment(node *ast.InfixExpression, target *ast.IndexExpression) {
+	id, ok := target.Left.(*ast.Identifier)
+	if !ok {
+		cp.fail("nested index assignment")
+	}
+	name := id.Literal()
+	if _, ok := cp.loopVar(name); ok {
+		cp.fail("loop variable modified " + name)
+	}
+	slot, isSlot := cp.slots[name]
+	if !isSlot {
+		cp.fail("index assignment with local index")
+	}
+	var op uint8
+	if compound, ok := isCompound(node.Type()); ok {
+		op = uint8(compound)
+		if target.Type() != token.DOT && !pure(target.Index) {
+			// the evaluator evaluates the index twice in this case.
+			cp.fail("compound index assignment with side effects")
+		}
+	}
+	cp.expr(node.Right, false)
+	switch {
+	case target.Type() == token.DOT:
+		key := target.Index.Value()
+		if key.Type() != token.STRING && key.Type() != token.IDENT {
+			cp.fail("invalid dot key")
+		}
+		cp.emitConst(object.String{Value: key.Literal()})
+	case target.Index.Value().Type() == token.COLON:
+		cp.fail("range index assignment")
+	default:
+		cp.expr(target.Index, false)
+	}
+	cp.emit(opSetIndexSlot, op, slot, 0, node)
+}
+
+// pure returns true for expressions without side effects.
+func pure(node ast.Node) bool {
+	ok := true
+	ast.Walk(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Identifier, *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.Boolean, *ast.IndexExpression:
+		case *ast.PrefixExpression:
+			ok = ok && n.Type() != token.INCR && n.Type() != token.DECR
+		case *ast.InfixExpression:
+			ok = ok && !isAssignment(n.Type())
+		default:
+			ok = false
+		}
+		return ok
+	})
+	return ok
+}
+
+func (cp *compiler) index(node *ast.IndexExpression) {
+	if node.Type() == token.DOT {
+		ext := cp.str(node.Left.Value().Literal() + "." + node.Index.Value().Literal())
+		skip := cp.emit(opDotExt, 0, 0, ext, nil)
+		cp.expr(node.Left, false)
+		cp.emit(opIndex, 1, 0, 0, node)
+		cp.patch(skip)
+		return
+	}
+	if node.Index.Value().Type() == token.COLON {
+		if cp.isLocal(node.Index) {
+			cp.fail("range index using locals")
+		}
+		cp.expr(node.Left, false)
+		cp.emit(opIndex, 1, 0, 0, node)
+		return
+	}
+	cp.expr(node.Left, false)
+	cp.expr(node.Index, false)
+	cp.emit(opIndex, 0, 0, 0, node)
+}
+
+// try compiles node such as errors are values instead of returning from the function.
+func (cp *compiler) try(node ast.Node, raw bool) {
+	handler := cp.emit(opTry, 0, 0, 0, nil)
+	cp.expr(node, raw)
+	cp.emit(opEndTry, 0, 0, 0, nil)
+	cp.patch(handler)
+}
+
+func (cp *compiler) mapLiteral(node *ast.MapLiteral) {
+	for _, key := range node.Order {
+		cp.try(key, false)
+		cp.emit(opCheckKey, 0, 0, 0, key)
+		cp.try(node.Pairs[key], false)
+	}
+	cp.emit(opMap, 0, len(node.Order), 0, node)
+}
+
+func (cp *compiler) builtin(node *ast.Builtin, raw bool) {
+	t := node.Type()
+	n := len(node.Parameters)
+	valid := n == 1
+	switch t { //nolint:exhaustive // others take exactly 1 argument.
+	case token.PRINT, token.LOG, token.ERROR:
+		valid = n >= 1
+	case token.PRINTLN:
+		valid = true
+	}
+	if !valid {
+		cp.delegate(node, raw) // for the error message.
+		return
+	}
+	switch t { //nolint:exhaustive // others are delegated.
+	case token.LEN, token.FIRST, token.REST:
+		cp.expr(node.Parameters[0], true)
+		cp.emit(opBuiltin, uint8(t), 0, 0, node)
+	case token.CATCH:
+		cp.try(node.Parameters[0], true)
+		cp.emit(opBuiltin, uint8(t), 0, 0, node)
+	case token.PRINT, token.PRINTLN, token.ERROR:
+		for _, p := range node.Parameters {
+			cp.expr(p, true)
+		}
+		cp.emit(opPrint, 0, n, 0, node)
+	case token.LOG:
+		// like the evaluator, the first argument is evaluated even when logging is off.
+		cp.try(node.Parameters[0], true)
+		skip := cp.emit(opLogCheck, 0, 0, 0, nil)
+		for _, p := range node.Parameters[1:] {
+			cp.try(p, true)
+		}
+		cp.emit(opPrint, 0, n, 0, node)
+		cp.patch(skip)
+	default: // quote, del,...
+		cp.delegate(node, raw)
+	}
+}
+
+func (cp *compiler) ifExpr(node *ast.IfExpression) {
+	cp.try(node.Condition, true)
+	elseJump := cp.emit(opIf, 0, 0, 0, node)
+	cp.block(node.Consequence)
+	endJump := cp.emit(opJump, 0, 0, 0, nil)
+	cp.patch(elseJump)
+	cp.block(node.Alternative)
+	cp.patch(endJump)
+}
+
+// checkLoopBody rejects loop bodies the evaluator would treat specially through registers (errors
+// for function literals and modifications of the variable), or nested loops reusing the variable.
+func (cp *compiler) checkLoopBody(fe *ast.ForExpression, name string) {
+	ast.Walk(fe.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral, *ast.MacroLiteral:
+			cp.fail("function inside integer loop body")
+		case *ast.PostfixExpression:
+			if n.Prev.Literal() == name {
+				cp.fail("loop variable modified " + name)
+			}
+		case *ast.ForExpression:
+			if inner, ok := forVar(n); ok && inner == name {
+				cp.fail("nested loop reusing variable " + name)
+			}
+		}
+		return true
+	})
+}
+
+func (cp *compiler) forExpr(fe *ast.ForExpression) {
+	loop := len(cp.c.loops)
+	info := loopInfo{ints: cp.c.numInts, list: cp.hiddenSlot(), last: cp.hiddenSlot(), varSlot: -1}
+	cp.c.numInts += loopInts
+	cp.c.loops = append(cp.c.loops, info)
+	name, special := forVar(fe)
+	hasCond := true
+	switch {
+	case special:
+		info.varSlot = cp.slots[name]
+		cp.checkLoopBody(fe, name)
+		rhs := fe.Condition.(*ast.InfixExpression).Right
+		if rhs.Value().Type() == token.COLON {
+			cp.forRange(loop, rhs.(*ast.InfixExpression))
+			hasCond = false
+		} else {
+			cp.expr(rhs, true)
+			cp.emit(opLoopStart, 0, loop, 0, fe)
+		}
+	case fe.Condition.Value().Type() == token.ASSIGN || fe.Condition.Value().Type() == token.DEFINE:
+		// `for a.b = ...` error from the evaluator.
+		cp.delegate(fe, true)
+		return
+	default:
+		cp.emit(opLoopInit, 0, loop, 0, fe)
+	}
+	cp.c.loops[loop] = info
+	if hasCond {
+		info.cond = len(cp.c.instrs)
+		cp.expr(fe.Condition, true)
+		cp.emit(opLoopCond, 0, loop, 0, fe)
+	}
+	info.body = len(cp.c.instrs)
+	saved := cp.ctx
+	cp.ctx.loop = loop
+	if special && !cp.c.noReg {
+		cp.loopVars = append(cp.loopVars, loopVar{name: name, loop: loop})
+	}
+	cp.block(fe.Body)
+	if special && !cp.c.noReg {
+		cp.loopVars = cp.loopVars[:len(cp.loopVars)-1]
+	}
+	cp.ctx = saved
+	cp.emit(opLoopBody, 0, loop, 0, fe)
+	info.next = len(cp.c.instrs)
+	cp.emit(opLoopNext, 0, loop, 0, fe)
+	info.exit = len(cp.c.instrs)
+	cp.emit(opLoopEnd, 0, loop, 0, nil)
+	cp.c.loops[loop] = info
+	// fix up break and continue jumps.
+	for pc := info.body; pc < info.next; pc++ {
+		in := &cp.c.instrs[pc]
+		if in.op != opJump || in.c == 0 || int(in.b) != loop {
+			continue
+		}
+		if token.Type(in.c) == token.CONTINUE {
+			in.a = int32(info.next) //nolint:gosec // code is never that big.
+		} else {
+			in.a = int32(info.exit) //nolint:gosec // same.
+		}
+	}
+}
+
+var (
+	forRange3Msgs = []string{"for var = n:m:i n not an integer: ", "for var = n:m:i m not an integer: ", "for var = n:m:i i not an integer: "}
+	forRange2Msgs = []string{"for var = n:m n not an integer: ", "for var = n:m m not an integer: "}
+)
+
+func (cp *compiler) forRange(loop int, rangeExpr *ast.InfixExpression) {
+	parts := []ast.Node{rangeExpr.Left, rangeExpr.Right}
+	msgs := forRange2Msgs
+	if rangeExpr.Left.Value().Type() == token.COLON {
+		inner := rangeExpr.Left.(*ast.InfixExpression)
+		parts = []ast.Node{inner.Left, inner.Right, rangeExpr.Right}
+		msgs = forRange3Msgs
+	}
+	for i, p := range parts {
+		cp.expr(p, true)
+		cp.emit(opCheckInt, 0, cp.str(msgs[i]), 0, p)
+	}
+	cp.emit(opLoopRange, uint8(len(parts)), loop, 0, rangeExpr) //nolint:gosec // 2 or 3.
+}
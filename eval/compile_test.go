@@ -0,0 +1,21 @@
+package eval
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCompiledBounded(t *testing.T) {
+	s := NewState()
+	s.VM = true
+	for i := range maxCompiled + 10 {
+		// e.g. functions from successive REPL inputs.
+		res, err := EvalString(s, "func(x) {x + "+strconv.Itoa(i)+"}(1)", false)
+		if err != nil || res.Inspect() != strconv.Itoa(i+1) {
+			t.Fatalf("unexpected %s, %v", res.Inspect(), err)
+		}
+	}
+	if n := len(s.compiled); n == 0 || n > maxCompiled {
+		t.Errorf("expected between 1 and %d compiled functions, got %d", maxCompiled, n)
+	}
+}
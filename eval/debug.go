@@ -0,0 +1,122 @@
+package eval
+
+import (
+	"grol.io/grol/ast"
+	"grol.io/grol/object"
+	"grol.io/grol/token"
+)
+
+// Debugger is notified before each statement is evaluated, when set on the State.
+// It can block to pause the execution and use Frames(), FrameDepth() and EvalInFrame()
+// to inspect the state meanwhile (statements evaluated from within Statement() don't call
+// the debugger again).
+// While a debugger is set the VM, registers and the functions cache aren't used so every
+// statement is visited and every variable is visible.
+type Debugger interface {
+	Statement(s *State, node ast.Node)
+}
+
+// Frame is a function call (or the top level) in the stack.
+type Frame struct {
+	Name string              // function name, "" for the top level and "func" for anonymous functions.
+	Pos  token.Position      // position of the statement being evaluated in that frame.
+	Env  *object.Environment // variables of that frame.
+}
+
+// SetDebugger sets (or, with nil, removes) the debugger. Removing it restores the VM and
+// NoReg settings the state had before.
+func (s *State) SetDebugger(d Debugger) {
+	switch {
+	case d != nil && s.debugger == nil:
+		s.savedVM, s.savedNoReg = s.VM, s.NoReg
+		s.VM = false
+		s.NoReg = true
+		s.debugPos = make(map[*object.Environment]token.Position)
+	case d == nil && s.debugger != nil:
+		s.VM, s.NoReg = s.savedVM, s.savedNoReg
+		s.debugPos = nil
+	}
+	s.debugger = d
+}
+
+// StatementStart returns the position of the first token of a statement; infix, call and index
+// expressions (e.g. `x = 1`) have the position of their operator instead.
+func StatementStart(node ast.Node) token.Position {
+	for {
+		var left ast.Node
+		switch n := node.(type) {
+		case *ast.InfixExpression:
+			left = n.Left
+		case *ast.IndexExpression:
+			left = n.Left
+		case *ast.CallExpression:
+			left = n.Function
+		}
+		if left == nil || !left.Position().IsValid() {
+			return node.Position()
+		}
+		node = left
+	}
+}
+
+func (s *State) debugStatement(node ast.Node) {
+	s.debugPos[s.env.Frame()] = StatementStart(node)
+	s.inDebugger = true
+	defer func() { s.inDebugger = false }()
+	s.debugger.Statement(s, node)
+}
+
+// debugReturn forgets the position of a frame that is returning.
+func (s *State) debugReturn(env *object.Environment) {
+	delete(s.debugPos, env)
+}
+
+// FrameDepth returns the number of function calls in the stack (0 at the top level).
+func (s *State) FrameDepth() int {
+	n := 0
+	for e := s.env.Frame().StackParent(); e != nil; e = e.StackParent() {
+		if !e.IsBlock() {
+			n++
+		}
+	}
+	return n
+}
+
+// Frames returns the stack, innermost frame first (same order as Stack()).
+func (s *State) Frames() []Frame {
+	var frames []Frame
+	var block *object.Environment
+	for e := s.env; e != nil; e = e.StackParent() {
+		if e.IsBlock() { // e.g. a match arm's bindings, evaluated in the frame they're part of.
+			if block == nil {
+				block = e
+			}
+			continue
+		}
+		env := e
+		if block != nil {
+			env, block = block, nil
+		}
+		frames = append(frames, Frame{Name: e.FunctionName(), Pos: s.debugPos[e], Env: env})
+	}
+	return frames
+}
+
+// EvalInFrame evaluates code (e.g. an expression typed in the debugger) in the environment of the frame.
+func (s *State) EvalInFrame(frame Frame, code string) (object.Object, error) {
+	prevEnv, prevDepth := s.env, s.depth
+	s.env = frame.Env
+	defer func() {
+		s.env, s.depth = prevEnv, prevDepth
+	}()
+	res, err := EvalString(s, code, false)
+	return object.Value(res), err
+}
+
+// SourceLine returns the given (1 based) line of the current file (see SetSource), "" if unknown.
+func (s *State) SourceLine(line int) string {
+	if line < 1 || line > len(s.sourceLines) {
+		return ""
+	}
+	return s.sourceLines[line-1]
+}
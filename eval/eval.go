@@ -2,10 +2,12 @@ package eval
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"math"
 	"math/big"
 	"math/bits"
+	"slices"
 	"strings"
 
 	"fortio.org/log"
@@ -128,6 +130,12 @@ func (s *State) evalAssignment(right object.Object, node *ast.InfixExpression) o
 		log.Warnf("Not assigning %q", right.Inspect())
 		return right
 	}
+	if isPattern(node.Left) {
+		if _, ok := isCompound(node.Type()); ok {
+			return s.NewError("compound assignment to destructuring pattern: " + node.Type().String())
+		}
+		return s.destructure(s.env, node.Left, right, node.Type() == token.DEFINE)
+	}
 	switch node.Left.Value().Type() {
 	case token.DOT, token.LBRACKET:
 		nodeType := node.Type()
@@ -181,18 +189,51 @@ func (s *State) evalAssignment(right object.Object, node *ast.InfixExpression) o
 	}
 }
 
+// isPattern returns true for the array and map destructuring patterns.
+func isPattern(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.ArrayLiteral, *ast.MapLiteral:
+		return true
+	default:
+		return false
+	}
+}
+
+// destructure sets (or creates when define is true) the variables of the array or map pattern
+// to the matching parts of value, e.g. `[a, b, ..tail] = arr` or `{x, y} := point`.
+func (s *State) destructure(env *object.Environment, pattern ast.Node, value object.Object, define bool) object.Object {
+	value = object.Value(value)
+	var bindings []binding
+	ok, oerr := s.matchPattern(pattern, value, &bindings)
+	if oerr != nil {
+		return *oerr
+	}
+	if !ok {
+		ps := ast.NewPrintState()
+		ps.Compact = true
+		ps.PatternList([]ast.Node{pattern})
+		return s.Errorf("%s doesn't match destructuring pattern %s", value.Inspect(), ps.String())
+	}
+	for _, b := range bindings {
+		if r := env.CreateOrSet(b.name, b.value, define); r.Type() == object.ERROR {
+			return r
+		}
+	}
+	return value
+}
+
 func (s *State) evalIndexAssignmentValue(base, index, value object.Object, identifier string) object.Object {
 	switch base.Type() {
 	case object.ARRAY:
 		idx, ok := Int64Value(index)
 		if !ok {
-			return s.NewError("index assignment to array with non integer index: " + index.Inspect())
+			return s.KindErrorf(object.IndexError, "index assignment to array with non integer index: %s", index.Inspect())
 		}
 		if idx < 0 {
 			idx = int64(object.Len(base)) + idx
 		}
 		if idx < 0 || idx >= int64(object.Len(base)) {
-			return s.NewError("index assignment out of bounds: " + index.Inspect())
+			return s.KindErrorf(object.IndexError, "index assignment out of bounds: %s", index.Inspect())
 		}
 		elements := object.Elements(base)
 		elements[idx] = value
@@ -202,22 +243,22 @@ func (s *State) evalIndexAssignmentValue(base, index, value object.Object, ident
 		return m.Set(object.Value(index), value)
 	default:
 		if identifier != "" {
-			return s.Errorf("index assignment to %s of unexpected type %s (%s)", identifier, base.Type().String(), base.Inspect())
+			return s.KindErrorf(object.IndexError, "index assignment to %s of unexpected type %s (%s)", identifier, base.Type().String(), base.Inspect())
 		}
-		return s.Errorf("index assignment to object of unexpected type %s (%s)", base.Type().String(), base.Inspect())
+		return s.KindErrorf(object.IndexError, "index assignment to object of unexpected type %s (%s)", base.Type().String(), base.Inspect())
 	}
 }
 
 func argCheck[T any](s *State, msg string, n int, vararg bool, args []T) *object.Error {
 	if vararg {
 		if len(args) < n {
-			e := s.Errorf("%s: wrong number of arguments. got=%d, want at least %d", msg, len(args), n)
+			e := s.KindErrorf(object.TypeError, "%s: wrong number of arguments. got=%d, want at least %d", msg, len(args), n)
 			return &e
 		}
 		return nil
 	}
 	if len(args) != n {
-		e := s.Errorf("%s: wrong number of arguments. got=%d, want=%d", msg, len(args), n)
+		e := s.KindErrorf(object.TypeError, "%s: wrong number of arguments. got=%d, want=%d", msg, len(args), n)
 		return &e
 	}
 	return nil
@@ -264,31 +305,38 @@ func (s *State) evalPostfixExpression(node *ast.PostfixExpression) object.Object
 		return s.NewError("identifier not found: " + id)
 	}
 	val = object.Value(val) // deref.
+	nv := s.postfixValue(node.Type(), val)
+	if nv.Type() == object.ERROR {
+		return nv
+	}
+	if oerr := s.env.Set(id, nv); oerr.Type() == object.ERROR { // So PI++ fails not silently.
+		return oerr
+	}
+	return val
+}
+
+// postfixValue returns val incremented (token.INCR) or decremented (token.DECR).
+func (s *State) postfixValue(t token.Type, val object.Object) object.Object {
 	var toAdd int64
-	switch node.Type() {
+	switch t {
 	case token.INCR:
 		toAdd = 1
 	case token.DECR:
 		toAdd = -1
 	default:
-		return s.NewError("unknown postfix operator: " + node.Type().String())
+		return s.NewError("unknown postfix operator: " + t.String())
 	}
-	var oerr object.Object
 	switch val := val.(type) {
 	case object.Integer:
-		oerr = s.env.Set(id, object.Integer{Value: val.Value + toAdd})
+		return object.Integer{Value: val.Value + toAdd}
 	case object.Float:
-		oerr = s.env.Set(id, object.Float{Value: val.Value + float64(toAdd)}) // So PI++ fails not silently.
+		return object.Float{Value: val.Value + float64(toAdd)}
 	case object.BigInt:
 		result := new(big.Int).Add(val.Value, big.NewInt(toAdd))
-		oerr = s.env.Set(id, object.BigInt{Value: result}.Normalize())
+		return object.BigInt{Value: result}.Normalize()
 	default:
 		return s.NewError("can't postfix increment/decrement " + val.Type().String())
 	}
-	if oerr.Type() == object.ERROR {
-		return oerr
-	}
-	return val
 }
 
 // Doesn't unwrap return - return bubbles up.
@@ -298,6 +346,10 @@ func (s *State) evalInternal(node any) object.Object { //nolint:funlen,gocognit,
 	if s.Context != nil && s.Context.Err() != nil {
 		return s.Error(s.Context.Err())
 	}
+	s.usage.steps.Add(1)
+	if oerr := s.checkSteps(); oerr != nil {
+		return errorObject(oerr)
+	}
 	switch node := node.(type) {
 	case *object.Register:
 		// somehow returning unwrapped node as is for Eval to unwrap is more expensive (escape analysis issue?)
@@ -311,6 +363,10 @@ func (s *State) evalInternal(node any) object.Object { //nolint:funlen,gocognit,
 		return s.evalStatements(node.Statements)
 	case *ast.IfExpression:
 		return s.evalIfExpression(node)
+	case *ast.MatchExpression:
+		return s.evalMatchExpression(node)
+	case *ast.TryExpression:
+		return s.evalTryExpression(node)
 	case *ast.ForExpression:
 		return s.evalForExpression(node)
 		// Expressions
@@ -372,6 +428,12 @@ func (s *State) evalInternal(node any) object.Object { //nolint:funlen,gocognit,
 		return object.NativeBoolToBooleanObject(node.Val)
 	case *ast.StringLiteral:
 		return object.String{Value: node.Literal()}
+	case *ast.InterpolatedString:
+		values, oerr := s.evalExpressions(node.Parts)
+		if oerr != nil {
+			return *oerr
+		}
+		return s.allocated(interpolate(values))
 
 	case *ast.ControlExpression:
 		return object.ReturnValue{Value: object.NULL, ControlType: node.Type()}
@@ -418,15 +480,18 @@ func (s *State) evalInternal(node any) object.Object { //nolint:funlen,gocognit,
 			return s.applyExtension(f.(object.Extension), args)
 		}
 		name := node.Function.Value().Literal()
+		if node.Tail && s.selfCall(f) {
+			return tailCall{name: name, args: args, node: node}
+		}
 		return s.applyFunction(name, f, args)
 	case *ast.ArrayLiteral:
 		elements, oerr := s.evalExpressions(node.Elements)
 		if oerr != nil {
 			return *oerr
 		}
-		return object.NewArray(elements)
+		return s.allocated(object.NewArray(elements))
 	case *ast.MapLiteral:
-		return s.evalMapLiteral(node)
+		return s.allocated(s.evalMapLiteral(node))
 	case *ast.IndexExpression:
 		if node.Value().Type() == token.DOT {
 			// See commits in PR#217 for a version using double map lookup, trading off the string concat (alloc)
@@ -440,6 +505,8 @@ func (s *State) evalInternal(node any) object.Object { //nolint:funlen,gocognit,
 		return s.evalIndexExpression(s.Eval(node.Left), node)
 	case *ast.Comment:
 		return object.NULL
+	case *extensionRef:
+		return node.ext
 	}
 	return s.Errorf("unknown node type: %T", node)
 }
@@ -460,7 +527,7 @@ func (s *State) evalIndexExpression(left object.Object, node *ast.IndexExpressio
 		// index is the string value and not an identifier to resolve.
 		key := node.Index.Value()
 		if key.Type() != token.STRING && key.Type() != token.IDENT {
-			return s.Errorf("index expression with . not string: %s", key.Literal())
+			return s.KindErrorf(object.IndexError, "index expression with . not string: %s", key.Literal())
 		}
 		return s.evalIndexExpressionIdx(left, object.String{Value: key.Literal()})
 	}
@@ -496,11 +563,8 @@ func (s *State) evalPrintLogError(node *ast.Builtin, firstArg object.Object) obj
 	if doLog && (log.GetLogLevel() >= log.Error) {
 		return object.NULL
 	}
-	buf := strings.Builder{}
+	values := make([]object.Object, 0, len(node.Parameters))
 	for i, v := range node.Parameters {
-		if i > 0 {
-			buf.WriteString(" ")
-		}
 		var r object.Object
 		if i == 0 && firstArg != nil { // println doesn't come in with firstArg already evaluated, print does.
 			r = firstArg
@@ -511,18 +575,43 @@ func (s *State) evalPrintLogError(node *ast.Builtin, firstArg object.Object) obj
 		if r.Type() == object.ERROR && !doLog {
 			return r
 		}
+		values = append(values, r)
+	}
+	return s.printLogError(node.Type(), values)
+}
+
+// printLogError does the output part of print, println, log and error() once the values are evaluated.
+func (s *State) printLogError(t token.Type, values []object.Object) object.Object {
+	doLog := (t == token.LOG)
+	buf := strings.Builder{}
+	for i, r := range values {
+		if i > 0 {
+			buf.WriteString(" ")
+		}
 		if isString := r.Type() == object.STRING; isString {
 			buf.WriteString(r.(object.String).Value)
 		} else {
 			buf.WriteString(r.Inspect())
 		}
 	}
-	if node.Type() == token.ERROR {
-		return s.NewError(buf.String())
+	if t == token.ERROR {
+		if len(values) == 1 {
+			if e, ok := s.errorFromMap(values[0]); ok {
+				return e // re-raise of a caught error, e.g. catch e { ...; error(e) }
+			}
+		}
+		e := s.KindErrorf(object.UserError, "%s", buf.String())
+		if len(values) == 1 && values[0].Type() != object.STRING {
+			e.Data = values[0] // e.g. error({"code": 42}) for catch(...).data.code
+		}
+		return e
 	}
-	if (s.NoLog && doLog) || node.Type() == token.PRINTLN {
+	if (s.NoLog && doLog) || t == token.PRINTLN {
 		buf.WriteRune('\n') // log() has a implicit newline when using log.Xxx, print() doesn't, println() does.
 	}
+	if oerr := s.output(buf.Len()); oerr != nil {
+		return *oerr
+	}
 	if doLog && !s.NoLog {
 		// Consider passing the arguments to log instead of making a string concatenation.
 		log.Printf("%s", buf.String())
@@ -539,8 +628,126 @@ func (s *State) evalPrintLogError(node *ast.Builtin, firstArg object.Object) obj
 	return object.NULL
 }
 
+// interpolate concatenates the values of an interpolated string's parts, like print() does
+// but without spaces: strings as is and other values using Inspect().
+func interpolate(values []object.Object) object.Object {
+	buf := strings.Builder{}
+	for _, v := range values {
+		v = object.Value(v)
+		if v.Type() == object.STRING {
+			buf.WriteString(v.(object.String).Value)
+		} else {
+			buf.WriteString(v.Inspect())
+		}
+	}
+	return object.String{Value: buf.String()}
+}
+
 var ErrorKey = object.String{Value: "err"} // can't use error as that's a builtin.
 
+// Keys of the catch() result maps for errors, besides ErrorKey and object.ValueKey.
+var (
+	KindKey   = object.String{Value: "kind"}
+	DataKey   = object.String{Value: "data"}
+	CauseKey  = object.String{Value: "cause"}
+	FileKey   = object.String{Value: "file"}
+	LineKey   = object.String{Value: "line"}
+	ColumnKey = object.String{Value: "column"}
+	StackKey  = object.String{Value: "stack"}
+)
+
+// catchResult is the {"err": bool, "value": v} map catch(node) returns, see errorMap for errors.
+func (s *State) catchResult(val object.Object, node ast.Node) object.Object {
+	if val.Type() != object.ERROR {
+		return object.MakeQuad(ErrorKey, object.FALSE, object.ValueKey, val)
+	}
+	// Errors aren't memoized, as they can be transient (timeouts, quotas,...), neither are
+	// results computed from a caught one.
+	s.env.TriggerNoCache()
+	return errorMap(s.locateError(val.(object.Error), node))
+}
+
+// errorMap is the catch() result for the error: "err" is true, "value" is the message and the
+// other keys are only present when known: "kind", "data", "cause" (the wrapped error's map),
+// "file", "line", "column" and "stack".
+func errorMap(e object.Error) object.Map {
+	res := object.MakeQuad(ErrorKey, object.TRUE, object.ValueKey, object.String{Value: e.Value})
+	if e.Kind != "" {
+		res = res.Set(KindKey, object.String{Value: e.Kind})
+	}
+	if e.Data != nil {
+		res = res.Set(DataKey, e.Data)
+	}
+	if e.Cause != nil {
+		res = res.Set(CauseKey, errorMap(*e.Cause))
+	}
+	if e.Pos.IsValid() {
+		if e.File != "" {
+			res = res.Set(FileKey, object.String{Value: e.File})
+		}
+		res = res.Set(LineKey, object.Integer{Value: int64(e.Pos.Line)})
+		res = res.Set(ColumnKey, object.Integer{Value: int64(e.Pos.Column)})
+	}
+	if len(e.Stack) > 0 {
+		stack := object.MakeObjectSlice(len(e.Stack))
+		for _, f := range e.Stack {
+			stack = append(stack, object.String{Value: f})
+		}
+		res = res.Set(StackKey, object.NewArray(stack))
+	}
+	return res
+}
+
+// errorFromMap is the reverse of errorMap: the error for a caught error's map, with its original
+// kind, location and stack, so error(e) re-raises it as is. ok is false for other values.
+func (s *State) errorFromMap(o object.Object) (object.Error, bool) {
+	m, ok := o.(object.Map)
+	if !ok {
+		return object.Error{}, false
+	}
+	if isErr, _ := m.Get(ErrorKey); isErr != object.TRUE {
+		return object.Error{}, false
+	}
+	value, _ := m.Get(object.ValueKey)
+	msg, ok := value.(object.String)
+	if !ok {
+		return object.Error{}, false
+	}
+	e := object.Error{Value: msg.Value}
+	if kind, found := m.Get(KindKey); found && kind.Type() == object.STRING {
+		e.Kind = kind.(object.String).Value
+	}
+	if data, found := m.Get(DataKey); found {
+		e.Data = data
+	}
+	if cause, found := m.Get(CauseKey); found {
+		if c, ok := s.errorFromMap(cause); ok {
+			e.Cause = &c
+		}
+	}
+	if file, found := m.Get(FileKey); found && file.Type() == object.STRING {
+		e.File = file.(object.String).Value
+	}
+	line, _ := m.Get(LineKey)
+	column, _ := m.Get(ColumnKey)
+	if l, ok := line.(object.Integer); ok {
+		if c, ok := column.(object.Integer); ok {
+			e.Pos = token.Position{Line: int(l.Value), Column: int(c.Value)}
+		}
+	}
+	if e.Pos.IsValid() && e.File == s.CurrentFile && e.Pos.Line <= len(s.sourceLines) {
+		e.Source = s.sourceLines[e.Pos.Line-1]
+	}
+	if stack, found := m.Get(StackKey); found && stack.Type() == object.ARRAY {
+		for _, f := range object.Elements(stack) {
+			if f.Type() == object.STRING {
+				e.Stack = append(e.Stack, f.(object.String).Value)
+			}
+		}
+	}
+	return e, true
+}
+
 func (s *State) evalDelete(node ast.Node) object.Object {
 	s.env.TriggerNoCache()
 	switch node.Value().Type() {
@@ -586,7 +793,7 @@ func (s *State) deleteMapEntry(idxE *ast.IndexExpression, index object.Object) o
 	obj = object.Value(obj)
 	// TODO: handle arrays too? though delete arr[idx] == arr[0:idx]+arr[idx+1:] so... no point
 	if obj.Type() != object.MAP {
-		return s.NewError("delete index on non map: " + id + " " + obj.Type().String())
+		return s.KindErrorf(object.IndexError, "delete index on non map: %s %s", id, obj.Type())
 	}
 	log.LogVf("remove map: %s from %s", index.Inspect(), id)
 	m := obj.(object.Map)
@@ -632,11 +839,7 @@ func (s *State) evalBuiltin(node *ast.Builtin) object.Object {
 	}
 	switch t {
 	case token.CATCH:
-		isError := rt == object.ERROR
-		if isError {
-			val = object.String{Value: val.(object.Error).Value}
-		}
-		return object.MakeQuad(ErrorKey, object.NativeBoolToBooleanObject(isError), object.ValueKey, val)
+		return s.catchResult(val, node.Parameters[0])
 	case token.ERROR, token.PRINT, token.PRINTLN, token.LOG:
 		return s.evalPrintLogError(node, val)
 	case token.FIRST:
@@ -646,7 +849,7 @@ func (s *State) evalBuiltin(node *ast.Builtin) object.Object {
 	case token.LEN:
 		l := object.Len(val)
 		if l == -1 {
-			return s.NewError("len: not supported on " + val.Type().String())
+			return s.KindErrorf(object.TypeError, "len: not supported on %s", val.Type())
 		}
 		return object.Integer{Value: int64(l)}
 	default:
@@ -669,7 +872,7 @@ func (s *State) evalIndexRangeExpression(left object.Object, leftIdx, rightIdx a
 		}
 	}
 	if !object.IsIntType(leftIndex.Type()) || (!nilRight && !object.IsIntType(rightIndex.Type())) {
-		return s.NewError("range index not integer")
+		return s.KindErrorf(object.IndexError, "range index not integer")
 	}
 	num := object.Len(left)
 	l, _ := Int64Value(leftIndex)
@@ -686,7 +889,7 @@ func (s *State) evalIndexRangeExpression(left object.Object, leftIdx, rightIdx a
 		}
 	}
 	if l > r {
-		return s.NewError("range index invalid: left greater then right")
+		return s.KindErrorf(object.IndexError, "range index invalid: left greater then right")
 	}
 	l = min(l, int64(num))
 	r = min(r, int64(num))
@@ -701,7 +904,7 @@ func (s *State) evalIndexRangeExpression(left object.Object, leftIdx, rightIdx a
 	case object.NIL:
 		return object.NULL
 	default:
-		return s.NewError("range index operator not supported: " + left.Type().String())
+		return s.KindErrorf(object.IndexError, "range index operator not supported: %s", left.Type())
 	}
 }
 
@@ -732,7 +935,7 @@ func (s *State) evalIndexExpressionIdx(left, index object.Object) object.Object
 	case left.Type() == object.NIL:
 		return object.NULL
 	default:
-		return s.NewError("index operator not supported: " + left.Type().String() + "[" + index.Type().String() + "]")
+		return s.KindErrorf(object.IndexError, "index operator not supported: %s[%s]", left.Type(), index.Type())
 	}
 }
 
@@ -762,6 +965,9 @@ func (s *State) applyExtension(fn object.Extension, args []object.Object) object
 	if log.LogDebug() {
 		log.Debugf("apply extension %s variadic %t : %d args %v", fn.Inspect(), fn.Variadic, l, args)
 	}
+	if missing := fn.Capabilities &^ s.Capabilities; missing != 0 {
+		return s.KindErrorf(object.CapabilityError, "%s: %s capability not granted", fn.Name, missing)
+	}
 	if fn.MaxArgs == -1 {
 		// Only do this for true variadic functions (maxargs == -1)
 		if l > 0 && args[l-1].Type() == object.ARRAY {
@@ -771,11 +977,11 @@ func (s *State) applyExtension(fn object.Extension, args []object.Object) object
 		}
 	}
 	if l < fn.MinArgs {
-		return s.Errorf("wrong number of arguments got=%d, want %s",
+		return s.KindErrorf(object.TypeError, "wrong number of arguments got=%d, want %s",
 			l, fn.Inspect()) // shows usage
 	}
 	if fn.MaxArgs != -1 && l > fn.MaxArgs {
-		return s.Errorf("wrong number of arguments got=%d, want %s",
+		return s.KindErrorf(object.TypeError, "wrong number of arguments got=%d, want %s",
 			l, fn.Inspect()) // shows usage
 	}
 	for i, arg := range args {
@@ -801,7 +1007,7 @@ func (s *State) applyExtension(fn object.Extension, args []object.Object) object
 			continue
 		}
 		if fn.ArgTypes[i] != arg.Type() {
-			return s.Errorf("wrong type of argument got=%s, want %s",
+			return s.KindErrorf(object.TypeError, "wrong type of argument got=%s, want %s",
 				arg.Type(), fn.Inspect())
 		}
 	}
@@ -814,19 +1020,25 @@ func (s *State) applyExtension(fn object.Extension, args []object.Object) object
 			// Add the stack trace to the error.
 			return s.ErrorAddStack(res.(object.Error))
 		}
-		return res
+		return s.allocated(res)
 	}
-	return fn.Callback(s, fn.Name, args)
+	return s.allocated(fn.Callback(s, fn.Name, args))
 }
 
 func (s *State) applyFunction(name string, fn object.Object, args []object.Object) object.Object {
 	function, ok := fn.(object.Function)
 	if !ok {
-		return s.NewError("not a function: " + fn.Type().String() + ":" + fn.Inspect())
+		return s.KindErrorf(object.TypeError, "not a function: %s:%s", fn.Type(), fn.Inspect())
 	}
-	if v, output, ok := s.cache.Get(function.CacheKey, args); ok {
+	memoize := s.debugger == nil && s.cache.Memoized(function.CacheKey)
+	if !memoize {
+		log.Debugf("Not memoizing %s", function.CacheKey)
+	} else if v, output, ok := s.cache.Get(function.CacheKey, args); ok {
 		log.Debugf("Cache hit for %s %v -> %#v", function.CacheKey, args, v)
 		if len(output) > 0 {
+			if oerr := s.output(len(output)); oerr != nil {
+				return *oerr
+			}
 			_, err := s.Out.Write(output)
 			if err != nil {
 				log.Warnf("output: %v", err)
@@ -834,29 +1046,70 @@ func (s *State) applyFunction(name string, fn object.Object, args []object.Objec
 		}
 		return v
 	}
-	nenv, newBody, oerr := s.extendFunctionEnv(s.env, name, function, args)
+	var nenv *object.Environment
+	var newBody ast.Node
+	var fr *frame
+	var oerr *object.Error
+	if c := s.compiledCode(function); c != nil {
+		fr, oerr = s.newFrame(name, function, c, args)
+		if fr != nil {
+			nenv = fr.env
+		}
+	} else {
+		nenv, newBody, oerr = s.extendFunctionEnv(s.env, name, function, args)
+	}
 	if oerr != nil {
 		return *oerr
 	}
 	curState := s.env
 	s.env = nenv
-	oldOut := s.startOutputBuffering()
+	s.startOutputBuffering()
 	// This is 0 as the env is new, but... we just want to make sure there is
 	// no get() up stack to confirm the function might be cacheable.
 	before := s.env.GetMisses()
-	res := s.Eval(newBody) // Need to have the return value unwrapped. Fixes bug #46, also need to count recursion.
+	var res object.Object
+	for {
+		if fr != nil {
+			res = s.run(fr)
+		} else {
+			res = s.Eval(newBody) // Need to have the return value unwrapped. Fixes bug #46, also need to count recursion.
+		}
+		tc, ok := res.(tailCall)
+		if !ok {
+			break
+		}
+		// Loop, reusing the environment, instead of recursing for self calls in tail position.
+		if fr != nil {
+			oerr = s.bindFrame(fr, tc.name, function, tc.args)
+		} else {
+			nenv.ReleaseRegisters()
+			newBody, oerr = s.bindParameters(nenv, tc.name, function, tc.args)
+		}
+		if oerr != nil {
+			res = s.locateError(*oerr, tc.node)
+			break
+		}
+	}
 	after := s.env.GetMisses()
 	cantCache := s.env.CantCache()
 	// gather output
 	output := s.stopOutputBuffering()
+	if s.debugger != nil {
+		s.debugReturn(nenv)
+	}
 	// restore the previous env/state.
 	s.env = curState
 	if len(output) > 0 {
-		_, err := oldOut.Write(output)
+		_, err := s.Out.Write(output)
 		if err != nil {
 			log.Warnf("output: %v", err)
 		}
 	}
+	if !memoize && s.debugger == nil {
+		// like calling a DontCache extension.
+		s.env.TriggerNoCache()
+		return res
+	}
 	if after != before {
 		log.Debugf("Cache miss for %s %v, %d get misses", function.CacheKey, args, after-before)
 		// Propagate the can't cache
@@ -881,6 +1134,31 @@ func (s *State) applyFunction(name string, fn object.Object, args []object.Objec
 	return res
 }
 
+// tailCall is the result of self calls in tail position, for applyFunction to loop with the new
+// arguments instead of recursing, so such recursions aren't limited by MaxDepth (nor the Go stack).
+// Like return values it bubbles up through statements and if and match expressions.
+type tailCall struct {
+	name string
+	args []object.Object
+	node ast.Node // the call, for errors.
+}
+
+func (tc tailCall) Type() object.Type      { return object.RETURN }
+func (tc tailCall) Inspect() string        { return "<tail call to " + tc.name + ">" }
+func (tc tailCall) Unwrap(_ bool) any      { return nil }
+func (tc tailCall) JSON(_ io.Writer) error { return errors.New("tail call not serializable") }
+
+// selfCall returns true when f is the function being applied (same code and captured environment),
+// whose calls in tail position can be looped on.
+func (s *State) selfCall(f object.Object) bool {
+	fn, ok := f.(object.Function)
+	if !ok || s.debugger != nil {
+		return false
+	}
+	cur := s.env.Function()
+	return cur != nil && cur.Body == fn.Body && cur.Env == fn.Env
+}
+
 func (s *State) extendFunctionEnv(
 	currrentEnv *object.Environment,
 	name string, fn object.Function,
@@ -891,33 +1169,44 @@ func (s *State) extendFunctionEnv(
 	//     func test(n) {if (n==2) {x=1}; if (n==1) {return x}; test(n-1)}; test(3)
 	// return 1 (state set by recursion with n==2)
 	env, _ := object.NewFunctionEnvironment(fn, currrentEnv)
-	params := fn.Parameters
-	atLeast := ""
-	var extra []object.Object
-	if fn.Variadic {
-		n := len(params) - 1
-		params = params[:n]
-		// Expending the last argument expecting it to be "..", but any other array will do too.
-		if len(args) > 0 && args[len(args)-1].Type() == object.ARRAY {
-			args = append(args[:len(args)-1], object.Elements(args[len(args)-1])...)
-		}
-		if len(args) >= n {
-			extra = args[n:]
-			args = args[:n]
-		}
-		atLeast = " at least"
+	newBody, oerr := s.bindParameters(env, name, fn, args)
+	if oerr != nil {
+		return nil, nil, oerr
 	}
-	n := len(params)
-	if len(args) != n {
-		oerr := s.Errorf("wrong number of arguments for %s. got=%d, want%s=%d",
-			name, len(args), atLeast, n)
-		return nil, nil, &oerr
+	// Recursion is handle specially in Get (defining "self" and the function name in the env)
+	// For recursion in named functions, set it here so we don't need to go up a stack of 50k envs to find it
+	/*
+		if sameFunction && name != "" {
+			env.SetNoChecks(name, fn, true)
+		}
+	*/
+	return env, newBody, nil
+}
+
+// bindParameters sets the function's parameters to the arguments in env, returning the body to
+// evaluate (with integer parameters replaced by registers). It's also used for tail calls, which
+// reuse the environment: the other local variables are kept, like they'd be visible to a recursive call.
+func (s *State) bindParameters(
+	env *object.Environment,
+	name string, fn object.Function,
+	args []object.Object,
+) (ast.Node, *object.Error) {
+	params, args, extra, oerr := s.functionArgs(name, fn, args)
+	if oerr != nil {
+		return nil, oerr
 	}
 	var newBody ast.Node
 	newBody = fn.Body
 	for paramIdx, param := range params {
 		// By definition function parameters are local copies, deref argument values:
 		pval := object.Value(args[paramIdx])
+		if isPattern(param) {
+			if r := s.destructure(env, param, pval, true); r.Type() == object.ERROR {
+				oe, _ := r.(object.Error)
+				return nil, &oe
+			}
+			continue
+		}
 		needVariable := true
 		if !s.NoReg && pval.Type() == object.INTEGER {
 			// We will release all these registers just by returning/dropping the env.
@@ -934,21 +1223,45 @@ func (s *State) extendFunctionEnv(
 			}
 			if oerr.Type() == object.ERROR {
 				oe, _ := oerr.(object.Error)
-				return nil, nil, &oe
+				return nil, &oe
 			}
 		}
 	}
 	if fn.Variadic {
 		env.SetNoChecks("..", object.NewArray(extra), true)
 	}
-	// Recursion is handle specially in Get (defining "self" and the function name in the env)
-	// For recursion in named functions, set it here so we don't need to go up a stack of 50k envs to find it
-	/*
-		if sameFunction && name != "" {
-			env.SetNoChecks(name, fn, true)
+	return newBody, nil
+}
+
+// functionArgs checks the number of arguments against the function's parameters, returning the
+// parameters to bind the arguments to (all but `..`) and the extra arguments for variadic functions.
+func (s *State) functionArgs(
+	name string, fn object.Function,
+	args []object.Object,
+) ([]ast.Node, []object.Object, []object.Object, *object.Error) {
+	params := fn.Parameters
+	atLeast := ""
+	var extra []object.Object
+	if fn.Variadic {
+		n := len(params) - 1
+		params = params[:n]
+		// Expending the last argument expecting it to be "..", but any other array will do too.
+		if len(args) > 0 && args[len(args)-1].Type() == object.ARRAY {
+			args = append(args[:len(args)-1], object.Elements(args[len(args)-1])...)
 		}
-	*/
-	return env, newBody, nil
+		if len(args) >= n {
+			extra = args[n:]
+			args = args[:n]
+		}
+		atLeast = " at least"
+	}
+	n := len(params)
+	if len(args) != n {
+		oerr := s.KindErrorf(object.TypeError, "wrong number of arguments for %s. got=%d, want%s=%d",
+			name, len(args), atLeast, n)
+		return nil, nil, nil, &oerr
+	}
+	return params, args, extra, nil
 }
 
 func (s *State) evalExpressions(exps []ast.Node) ([]object.Object, *object.Error) {
@@ -978,9 +1291,38 @@ func (s *State) evalIdentifier(node *ast.Identifier) object.Object {
 	if !ok {
 		return s.NewError("identifier not found: " + node.Literal())
 	}
+	if name == "info" {
+		return s.stateInfo(val.(object.Map))
+	}
 	return val
 }
 
+// stateInfo adds to info this state's extensions, which may differ from the global ones, its
+// capabilities: the granted ones and the extensions disabled for lack of them, and its cache statistics.
+func (s *State) stateInfo(info object.Map) object.Map {
+	var names, disabled []string
+	for n, ext := range s.Extensions {
+		names = append(names, n)
+		if ext.Capabilities&^s.Capabilities != 0 {
+			disabled = append(disabled, n)
+		}
+	}
+	info = info.Set(object.String{Value: "gofuncs"}, stringsArray(names))
+	info = info.Set(object.String{Value: "capabilities"}, stringsArray(s.Capabilities.Names()))
+	info = info.Set(object.String{Value: "cache"}, s.cache.Stats().Map())
+	return info.Set(object.String{Value: "disabled"}, stringsArray(disabled))
+}
+
+// stringsArray returns the sorted array of the strings.
+func stringsArray(strs []string) object.Object {
+	slices.Sort(strs)
+	arr := object.MakeObjectSlice(len(strs))
+	for _, str := range strs {
+		arr = append(arr, object.String{Value: str})
+	}
+	return object.NewArray(arr)
+}
+
 func (s *State) evalIfExpression(ie *ast.IfExpression) object.Object {
 	condition := object.Value(s.evalInternal(ie.Condition))
 	switch condition {
@@ -995,10 +1337,186 @@ func (s *State) evalIfExpression(ie *ast.IfExpression) object.Object {
 		}
 		return s.evalInternal(ie.Alternative)
 	default:
+		if condition.Type() == object.ERROR { // e.g. max depth reached while evaluating it, keep its kind.
+			return condition
+		}
 		return s.NewError("condition is not a boolean: " + condition.Inspect())
 	}
 }
 
+// evalTryExpression evaluates the body, then the catch block if it errored and finally the
+// finally block, whose errors and return/break/continue take precedence over the others'.
+func (s *State) evalTryExpression(te *ast.TryExpression) object.Object {
+	res := s.evalInternal(te.Body)
+	if res.Type() == object.ERROR && te.Catch != nil {
+		caught := s.catchResult(res, te)
+		if te.Name != nil {
+			if r := s.env.CreateOrSet(te.Name.Literal(), caught, true); r.Type() == object.ERROR {
+				return r
+			}
+		}
+		res = s.evalInternal(te.Catch)
+	}
+	if te.Finally != nil {
+		if r := s.evalInternal(te.Finally); r.Type() == object.ERROR || r.Type() == object.RETURN {
+			return r
+		}
+	}
+	return res
+}
+
+// matchTypes are the type names usable as match patterns.
+var matchTypes = map[string]object.Type{
+	"int":       object.INTEGER,
+	"float":     object.FLOAT,
+	"bigint":    object.BIGINT,
+	"bool":      object.BOOLEAN,
+	"nil":       object.NIL,
+	"string":    object.STRING,
+	"array":     object.ARRAY,
+	"map":       object.MAP,
+	"func":      object.FUNC,
+	"extension": object.EXTENSION,
+}
+
+// binding is a variable set by a matching pattern.
+type binding struct {
+	name  string
+	value object.Object
+}
+
+func (s *State) evalMatchExpression(me *ast.MatchExpression) object.Object {
+	subject := s.evalInternal(me.Subject)
+	if subject.Type() == object.ERROR {
+		return subject
+	}
+	subject = object.Value(subject)
+	var bindings []binding
+	for _, arm := range me.Arms {
+		for _, pattern := range arm.Patterns {
+			bindings = bindings[:0]
+			ok, oerr := s.matchPattern(pattern, subject, &bindings)
+			if oerr != nil {
+				return *oerr
+			}
+			if !ok {
+				continue
+			}
+			env := s.env
+			s.env = object.NewBlockEnvironment(env)
+			res, matched := s.evalMatchArm(arm, bindings)
+			s.env = env
+			if matched {
+				return res
+			}
+		}
+	}
+	return object.NULL
+}
+
+// evalMatchArm evaluates the guard and then the body of the arm whose pattern matched, in the
+// block environment set by evalMatchExpression for the pattern's bindings, so they don't change
+// (nor leak to) the enclosing one. Returns false when the guard doesn't pass.
+func (s *State) evalMatchArm(arm *ast.MatchArm, bindings []binding) (object.Object, bool) {
+	for _, b := range bindings {
+		if r := s.env.CreateOrSet(b.name, b.value, true); r.Type() == object.ERROR {
+			return r, true
+		}
+	}
+	if arm.Guard != nil {
+		guard := object.Value(s.evalInternal(arm.Guard))
+		switch guard {
+		case object.TRUE:
+		case object.FALSE, object.NULL:
+			return nil, false
+		default:
+			if guard.Type() == object.ERROR {
+				return guard, true
+			}
+			return s.NewError("match guard is not a boolean: " + guard.Inspect()), true
+		}
+	}
+	return s.evalInternal(arm.Body), true
+}
+
+// matchPattern checks if value matches the pattern, appending the variables it binds.
+func (s *State) matchPattern(pattern ast.Node, value object.Object, bindings *[]binding) (bool, *object.Error) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		name := pattern.Literal()
+		if name == "_" || name == ".." {
+			return true, nil
+		}
+		if t, isType := matchTypes[name]; isType {
+			return value.Type() == t || (t == object.FUNC && value.Type() == object.EXTENSION), nil
+		}
+		if !object.Constant(name) {
+			*bindings = append(*bindings, binding{name, value})
+			return true, nil
+		}
+	case *ast.ArrayLiteral:
+		return s.matchArray(pattern, value, bindings)
+	case *ast.MapLiteral:
+		return s.matchMap(pattern, value, bindings)
+	}
+	// literals and constants.
+	expected := s.evalInternal(pattern)
+	if expected.Type() == object.ERROR {
+		oerr := expected.(object.Error)
+		return false, &oerr
+	}
+	return object.Equals(object.Value(expected), value), nil
+}
+
+func (s *State) matchArray(pattern *ast.ArrayLiteral, value object.Object, bindings *[]binding) (bool, *object.Error) {
+	if value.Type() != object.ARRAY {
+		return false, nil
+	}
+	elements := object.Elements(value)
+	patterns := pattern.Elements
+	var rest ast.Node
+	if n := len(patterns); n > 0 && patterns[n-1].Value().Type() == token.DOTDOT {
+		rest = patterns[n-1]
+		patterns = patterns[:n-1]
+	}
+	if len(elements) < len(patterns) || (rest == nil && len(elements) != len(patterns)) {
+		return false, nil
+	}
+	for i, p := range patterns {
+		if ok, oerr := s.matchPattern(p, object.Value(elements[i]), bindings); !ok || oerr != nil {
+			return false, oerr
+		}
+	}
+	if prefix, ok := rest.(*ast.PrefixExpression); ok {
+		remaining := object.MakeObjectSlice(len(elements) - len(patterns))
+		remaining = append(remaining, elements[len(patterns):]...)
+		*bindings = append(*bindings, binding{prefix.Right.Value().Literal(), object.NewArray(remaining)})
+	}
+	return true, nil
+}
+
+func (s *State) matchMap(pattern *ast.MapLiteral, value object.Object, bindings *[]binding) (bool, *object.Error) {
+	m, isMap := value.(object.Map)
+	if !isMap {
+		return false, nil
+	}
+	for _, keyNode := range pattern.Order {
+		key := s.evalInternal(keyNode)
+		if key.Type() == object.ERROR {
+			oerr := key.(object.Error)
+			return false, &oerr
+		}
+		v, found := m.Get(object.Value(key))
+		if !found {
+			return false, nil
+		}
+		if ok, oerr := s.matchPattern(pattern.Pairs[keyNode], object.Value(v), bindings); !ok || oerr != nil {
+			return false, oerr
+		}
+	}
+	return true, nil
+}
+
 func ModifyRegister(register *object.Register, in ast.Node) (ast.Node, bool) {
 	switch in := in.(type) {
 	case *ast.Identifier:
@@ -1014,10 +1532,41 @@ func ModifyRegister(register *object.Register, in ast.Node) (ast.Node, bool) {
 	case *ast.FunctionLiteral:
 		// skip lambda/functions in functions.
 		return nil, false
+	case *ast.TryExpression:
+		// nor would the catch variable.
+		if in.Name != nil && in.Name.Literal() == register.Literal() {
+			return nil, false
+		}
+	case *ast.MatchExpression:
+		// patterns binding the same name would shadow the register, not handled currently.
+		for _, arm := range in.Arms {
+			if patternBinds(register.Literal(), arm.Patterns...) {
+				return nil, false
+			}
+		}
+	case *ast.InfixExpression:
+		// nor is destructuring into the register.
+		if (in.Type() == token.ASSIGN || in.Type() == token.DEFINE) && isPattern(in.Left) &&
+			patternBinds(register.Literal(), in.Left) {
+			return nil, false
+		}
 	}
 	return in, true
 }
 
+// patternBinds returns true if one of the patterns uses name (already replaced by a register or not).
+func patternBinds(name string, patterns ...ast.Node) bool {
+	found := false
+	for _, p := range patterns {
+		ast.Walk(p, func(n ast.Node) bool {
+			t := n.Value().Type()
+			found = found || ((t == token.IDENT || t == token.REGISTER) && n.Value().Literal() == name)
+			return !found
+		})
+	}
+	return found
+}
+
 func setupRegister(env *object.Environment, name string, value int64, body ast.Node) (object.Register, ast.Node, bool) {
 	register := env.MakeRegister(name, value)
 	newBody, ok := ast.Modify(body, func(in ast.Node) (ast.Node, bool) {
@@ -1165,29 +1714,37 @@ func (s *State) evalForSpecialForms(fe *ast.ForExpression) (object.Object, bool)
 	if ie.Token.Type() != token.ASSIGN && ie.Token.Type() != token.DEFINE {
 		return object.NULL, false
 	}
-	if ie.Left.Value().Type() != token.IDENT {
+	pattern := isPattern(ie.Left)
+	if !pattern && ie.Left.Value().Type() != token.IDENT {
 		return s.Errorf("for var = ... not a var %s", ie.Left.Value().DebugString()), true
 	}
 	name := ie.Left.Value().Literal()
 	if ie.Right.Value().Type() == token.COLON {
+		if pattern {
+			return s.NewError("for destructuring pattern used with a range"), true
+		}
 		rangeExpr := ie.Right.(*ast.InfixExpression)
 		return s.evalForRangeExpr(fe, rangeExpr, name)
 	}
 	// Evaluate:
 	v := object.Value(s.evalInternal(ie.Right))
+	if pattern && v.Type() != object.ERROR && v.Type() != object.ARRAY && v.Type() != object.MAP && v.Type() != object.STRING {
+		return s.NewError("for destructuring pattern needs an array, map or string, got: " + v.Inspect()), true
+	}
 	switch v.Type() {
 	case object.INTEGER:
 		return s.evalForInteger(fe, nil, v.(object.Integer).Value, nil, name), true
 	case object.ERROR:
 		return v, true
 	case object.ARRAY, object.MAP, object.STRING:
-		return s.evalForList(fe, v, name), true
+		return s.evalForList(fe, v, ie.Left), true
 	default:
 		return object.NULL, false
 	}
 }
 
-func (s *State) evalForList(fe *ast.ForExpression, list object.Object, name string) object.Object {
+// evalForList iterates over the elements of list, setting target (a variable or a destructuring pattern) to each.
+func (s *State) evalForList(fe *ast.ForExpression, list object.Object, target ast.Node) object.Object {
 	var lastEval object.Object
 	lastEval = object.NULL
 	for object.Len(list) > 0 {
@@ -1196,7 +1753,12 @@ func (s *State) evalForList(fe *ast.ForExpression, list object.Object, name stri
 		if v == nil {
 			return s.NewError("for list element is nil")
 		}
-		oerr := s.env.CreateOrSet(name, v, true) // Create new local scope for loop variable
+		var oerr object.Object
+		if isPattern(target) {
+			oerr = s.destructure(s.env, target, v, true)
+		} else {
+			oerr = s.env.CreateOrSet(target.Value().Literal(), v, true) // Create new local scope for loop variable
+		}
 		if oerr.Type() == object.ERROR {
 			return oerr
 		}
@@ -1294,12 +1856,17 @@ func (s *State) evalStatements(stmts []ast.Node) object.Object {
 			log.Debugf("skipping comment")
 			continue
 		}
+		if s.debugger != nil && !s.inDebugger {
+			s.debugStatement(statement)
+		}
 		result = s.evalInternal(statement)
 		if log.LogVerbose() {
 			log.LogVf("result statement %s: %s", result.Type(), result.Inspect())
 		}
-		if rt := result.Type(); rt == object.RETURN || rt == object.ERROR {
+		if rt := result.Type(); rt == object.RETURN {
 			return result
+		} else if rt == object.ERROR {
+			return s.located(result, statement)
 		}
 	}
 	return result
@@ -1328,7 +1895,7 @@ func (s *State) evalPrefixExpression(operator token.Type, right object.Object) o
 		// nothing do with unary plus, just return the value.
 		return right
 	default:
-		return s.NewError("unknown operator: " + operator.String())
+		return s.KindErrorf(object.TypeError, "unknown operator: %s", operator)
 	}
 }
 
@@ -1396,15 +1963,15 @@ func (s *State) evalInfixExpression(operator token.Type, left, right object.Obje
 	case left.Type() == object.BIGINT || right.Type() == object.BIGINT:
 		return s.evalBigIntInfixExpression(operator, left, right)
 	case left.Type() == object.ARRAY:
-		return s.evalArrayInfixExpression(operator, left, right)
+		return s.allocated(s.evalArrayInfixExpression(operator, left, right))
 	case left.Type() == object.FLOAT || right.Type() == object.FLOAT:
 		return s.evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.STRING:
-		return s.evalStringInfixExpression(operator, left, right)
+		return s.allocated(s.evalStringInfixExpression(operator, left, right))
 	case left.Type() == object.MAP && right.Type() == object.MAP:
-		return s.evalMapInfixExpression(operator, left, right)
+		return s.allocated(s.evalMapInfixExpression(operator, left, right))
 	default:
-		return s.NewError("no " + operator.String() + " on left=" + left.Inspect() + " right=" + right.Inspect())
+		return s.KindErrorf(object.TypeError, "no %s on left=%s right=%s", operator, left.Inspect(), right.Inspect())
 	}
 }
 
@@ -1414,16 +1981,22 @@ func (s *State) evalStringInfixExpression(operator token.Type, left, right objec
 	switch {
 	case operator == token.PLUS && right.Type() == object.STRING:
 		rightVal := right.(object.String).Value
+		if err := s.allocating(int64(len(leftVal) + len(rightVal))); err != nil {
+			return *err
+		}
 		return object.String{Value: leftVal + rightVal}
 	case operator == token.ASTERISK && rightIsInt:
-		n := len(leftVal) * int(rightVal)
 		if rightVal < 0 {
-			return s.Errorf("right operand of * on strings must be a positive integer, got %d", rightVal)
+			return s.KindErrorf(object.TypeError, "right operand of * on strings must be a positive integer, got %d", rightVal)
 		}
+		if err := s.allocating(repeatSize(int64(len(leftVal)), rightVal)); err != nil {
+			return *err
+		}
+		n := len(leftVal) * int(rightVal)
 		object.MustBeOk(n / object.ObjectSize)
 		return object.String{Value: strings.Repeat(leftVal, int(rightVal))}
 	default:
-		return s.Errorf("unknown operator: %s %s %s",
+		return s.KindErrorf(object.TypeError, "unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
 }
@@ -1434,11 +2007,14 @@ func (s *State) evalArrayInfixExpression(operator token.Type, left, right object
 	case token.ASTERISK: // repeat
 		rightVal, ok := Int64Value(right)
 		if !ok {
-			return s.NewError("right operand of * on arrays must be an integer")
+			return s.KindErrorf(object.TypeError, "right operand of * on arrays must be an integer")
 		}
 		// TODO: go1.23 use	slices.Repeat
 		if rightVal < 0 {
-			return s.NewError("right operand of * on arrays must be a positive integer")
+			return s.KindErrorf(object.TypeError, "right operand of * on arrays must be a positive integer")
+		}
+		if err := s.allocating(repeatSize(int64(len(leftVal))*object.ObjectSize, rightVal)); err != nil {
+			return *err
 		}
 		result := object.MakeObjectSlice(len(leftVal) * int(rightVal))
 		for range rightVal {
@@ -1447,13 +2023,19 @@ func (s *State) evalArrayInfixExpression(operator token.Type, left, right object
 		return object.NewArray(result)
 	case token.PLUS: // concat / append
 		if right.Type() != object.ARRAY {
+			if err := s.allocating(int64(len(leftVal)+1) * object.ObjectSize); err != nil {
+				return *err
+			}
 			return object.NewArray(append(leftVal, object.Value(right)))
 		}
 		rightArr := object.Elements(right)
+		if err := s.allocating(int64(len(leftVal)+len(rightArr)) * object.ObjectSize); err != nil {
+			return *err
+		}
 		object.MustBeOk(len(leftVal) + len(rightArr))
 		return object.NewArray(append(leftVal, rightArr...))
 	default:
-		return s.Errorf("unknown operator: %s %s %s",
+		return s.KindErrorf(object.TypeError, "unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
 }
@@ -1463,9 +2045,12 @@ func (s *State) evalMapInfixExpression(operator token.Type, left, right object.O
 	rightMap := right.(object.Map)
 	switch operator {
 	case token.PLUS: // concat / append
+		if err := s.allocating(2 * int64(leftMap.Len()+rightMap.Len()) * object.ObjectSize); err != nil {
+			return *err
+		}
 		return leftMap.Append(rightMap)
 	default:
-		return s.Errorf("unknown operator: %s %s %s",
+		return s.KindErrorf(object.TypeError, "unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
 }
@@ -1587,7 +2172,7 @@ func (s *State) evalIntegerInfixExpression(operator token.Type, leftVal, rightVa
 	case token.COLON:
 		lg := rightVal - leftVal
 		if lg < 0 {
-			return s.NewError("range index invalid: left greater then right")
+			return s.KindErrorf(object.IndexError, "range index invalid: left greater then right")
 		}
 		arr := object.MakeObjectSlice(int(lg))
 		for i := leftVal; i < rightVal; i++ {
@@ -1595,7 +2180,7 @@ func (s *State) evalIntegerInfixExpression(operator token.Type, leftVal, rightVa
 		}
 		return object.NewArray(arr)
 	default:
-		return s.NewError("unknown operator: " + operator.String())
+		return s.KindErrorf(object.TypeError, "unknown operator: %s", operator)
 	}
 }
 
@@ -1651,7 +2236,7 @@ func (s *State) evalBigIntInfixExpression(operator token.Type, left, right objec
 	case token.BITXOR:
 		result = new(big.Int).Xor(leftVal, rightVal)
 	default:
-		return s.NewError("unknown operator: " + operator.String())
+		return s.KindErrorf(object.TypeError, "unknown operator: %s", operator)
 	}
 	return object.BigInt{Value: result}.Normalize()
 }
@@ -1699,21 +2284,19 @@ func (s *State) evalFloatInfixExpression(operator token.Type, left, right object
 	case token.PERCENT:
 		return object.Float{Value: math.Mod(leftVal, rightVal)}
 	default:
-		return s.NewError("unknown operator: " + operator.String())
+		return s.KindErrorf(object.TypeError, "unknown operator: %s", operator)
 	}
 }
 
 // startOutputBuffering starts capturing output in a buffer.
-// Returns the previous output writer.
-func (s *State) startOutputBuffering() io.Writer {
+func (s *State) startOutputBuffering() {
 	s.env.OutputBuffer = &bytes.Buffer{}
 	s.env.PrevOut = s.Out
 	s.Out = s.env.OutputBuffer
-	return s.env.PrevOut
 }
 
-// stopOutputBuffering stops capturing output and restores the previous output writer.
-// Returns the buffered output.
+// stopOutputBuffering stops capturing output and restores the previous output writer (which
+// may have been replaced meanwhile, see sharedOutputs). Returns the buffered output.
 func (s *State) stopOutputBuffering() []byte {
 	output := s.env.OutputBuffer.Bytes()
 	s.Out = s.env.PrevOut
@@ -2,9 +2,12 @@ package eval
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"fortio.org/log"
@@ -35,7 +38,7 @@ type State struct {
 	macroState *object.Environment
 	env        *object.Environment
 	rootEnv    *object.Environment // same as ancestor of env but used for reset in panic recovery.
-	cache      Cache
+	cache      *Cache
 	Extensions object.ExtensionMap
 	NoLog      bool // turn log() into println() (for EvalString)
 	// Max depth / recursion level - default DefaultMaxDepth,
@@ -49,20 +52,44 @@ type State struct {
 	Cancel  context.CancelFunc
 	PipeVal []byte // value to return from pipe() function
 	NoReg   bool   // don't use registers.
-	// Current file being processed (TODO: use it to have parsing errors showing as filename:line...)
+	VM      bool   // run functions through the bytecode compiler and VM instead of walking the tree.
+	// Compiled functions bodies (nil entries for the ones that can't be compiled), up to maxCompiled.
+	compiled map[*ast.Statements]*code
+	// Current file being processed, used to show runtime errors as filename:line:col.
 	CurrentFile string
+	sourceLines []string // lines of the current file's content, for errors (see SetSource).
+	// Debugger (see SetDebugger) and the position of the current statement of each frame.
+	debugger   Debugger
+	inDebugger bool
+	debugPos   map[*object.Environment]token.Position
+	// VM and NoReg before the debugger was set, restored when it's removed.
+	savedVM, savedNoReg bool
+	// Namespaces of the import()ed files by path and the imports in progress (for cycle detection).
+	modules   map[string]object.Object
+	importing []string
+	// Quotas (0 for unlimited) for running untrusted code, see quota.go. The usage is shared with
+	// the goroutines started by the state.
+	MaxSteps  int64 // evaluation steps (nodes evaluated or VM instructions).
+	MaxAlloc  int64 // approximate bytes of strings, arrays and maps created.
+	MaxOutput int64 // bytes printed to Out/LogOut.
+	usage     *usageCounters
+	// Capabilities granted to the extensions (object.CapAll by default), calling one requiring
+	// others is an error.
+	Capabilities object.Capability
 }
 
 func NewState() *State {
 	st := &State{
-		env:        object.NewRootEnvironment(),
-		Out:        os.Stdout,
-		LogOut:     os.Stdout,
-		cache:      NewCache(),
-		Extensions: object.ExtraFunctions(),
-		macroState: object.NewMacroEnvironment(),
-		MaxDepth:   DefaultMaxDepth,
-		depth:      0,
+		env:          object.NewRootEnvironment(),
+		Out:          os.Stdout,
+		LogOut:       os.Stdout,
+		cache:        NewCache(),
+		Extensions:   object.ExtraFunctions(),
+		macroState:   object.NewMacroEnvironment(),
+		MaxDepth:     DefaultMaxDepth,
+		depth:        0,
+		usage:        &usageCounters{},
+		Capabilities: object.CapAll,
 	}
 	st.rootEnv = st.env
 	return st
@@ -70,18 +97,26 @@ func NewState() *State {
 
 func NewBlankState() *State {
 	st := &State{
-		env:        object.NewMacroEnvironment(), // to get empty store
-		Out:        io.Discard,
-		LogOut:     io.Discard,
-		cache:      NewCache(),
-		Extensions: make(map[string]object.Extension),
-		macroState: object.NewMacroEnvironment(),
-		MaxDepth:   DefaultMaxDepth,
+		env:          object.NewMacroEnvironment(), // to get empty store
+		Out:          io.Discard,
+		LogOut:       io.Discard,
+		cache:        NewCache(),
+		Extensions:   make(map[string]object.Extension),
+		macroState:   object.NewMacroEnvironment(),
+		MaxDepth:     DefaultMaxDepth,
+		usage:        &usageCounters{},
+		Capabilities: object.CapAll,
 	}
 	st.rootEnv = st.env
 	return st
 }
 
+// depthError is the error returned, by both the interpreter and the VM, when the recursion exceeds MaxDepth.
+func (s *State) depthError() object.Error {
+	log.LogVf("max depth %d reached", s.MaxDepth)
+	return s.KindErrorf(object.DepthError, "max depth %d reached", s.MaxDepth)
+}
+
 // Reset post panic recovery.
 func (s *State) Reset() {
 	s.env = s.rootEnv
@@ -94,8 +129,14 @@ func (s *State) RegisterTrie(t *trie.Trie) {
 	s.env.RegisterTrie(t)
 }
 
+// ResetCache empties the functions results cache, see Cache.Reset.
 func (s *State) ResetCache() {
-	s.cache = NewCache()
+	s.cache.Reset()
+}
+
+// Cache returns the functions results cache, e.g. to set its limits or get its statistics.
+func (s *State) Cache() *Cache {
+	return s.cache
 }
 
 // Len forwards to env to count the number of bindings. Used mostly to know if there are any macros.
@@ -118,6 +159,18 @@ func (s *State) UpdateNumSet() (oldvalue, newvalue int64) {
 	return oldvalue, newvalue
 }
 
+// SetSource sets the current file name and its content, used to annotate runtime errors
+// with file:line:col and the offending source line. It returns a function restoring
+// the previous ones (e.g. for nested load()s).
+func (s *State) SetSource(file, code string) func() {
+	prevFile, prevLines := s.CurrentFile, s.sourceLines
+	s.CurrentFile = file
+	s.sourceLines = strings.Split(code, "\n")
+	return func() {
+		s.CurrentFile, s.sourceLines = prevFile, prevLines
+	}
+}
+
 // SetContext sets the context for the evaluator, with a maximum duration.
 // The returned cancel function can be used to cancel the context sooner and must be
 // called (in a defer typically) to release resources (to avoid issue #204).
@@ -143,13 +196,14 @@ func (s *State) EvalToplevel(node any) object.Object {
 // Eval does unwrap (so stop bubbling up) return values.
 func (s *State) Eval(node any) object.Object {
 	if s.depth > s.MaxDepth {
-		log.LogVf("max depth %d reached", s.MaxDepth) // will be logged by the panic handler.
-		// State must be reset using s.Reset() to reuse the evaluator post panic (not just for depth but for s.env)
-		panic(fmt.Sprintf("max depth %d reached", s.MaxDepth))
+		return s.depthError()
 	}
 	s.depth++
 	result := s.evalInternal(node)
 	s.depth--
+	if result.Type() == object.ERROR {
+		return s.located(result, node)
+	}
 	// unwrap return values only at the top.
 	if returnValue, ok := result.(object.ReturnValue); ok {
 		if returnValue.ControlType != token.RETURN {
@@ -202,7 +256,7 @@ func EvalString(this any, code string, emptyEnv bool) (object.Object, error) {
 	var program ast.Node
 	program = p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		return object.NULL, fmt.Errorf("parsing error: %v", p.Errors())
+		return object.NULL, &object.Error{Value: fmt.Sprintf("parsing error: %v", p.Errors()), Kind: object.ParseError}
 	}
 	evalState, ok := this.(*State)
 	if emptyEnv {
@@ -221,11 +275,108 @@ func EvalString(this any, code string, emptyEnv bool) (object.Object, error) {
 	}
 	res := evalState.Eval(program)
 	if res.Type() == object.ERROR {
-		return res, fmt.Errorf("eval error: %v", res.Inspect())
+		return res, evalError{"eval error: ", res.(object.Error)}
+	}
+	return res, nil
+}
+
+// Call calls the grol function or extension fn with the arguments, e.g. a callback value given to
+// an extension, with the same arguments checks, memoization, MaxDepth and context cancellation as
+// calls from grol code. It can be used from within extension callbacks (re-entrantly). When the call
+// fails, the error object is returned along with a Go error.
+func (s *State) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	var res object.Object
+	args = slices.Clone(args) // variadic functions expand their last argument in place.
+	switch f := object.Value(fn).(type) {
+	case object.Extension:
+		res = s.applyExtension(f, args)
+	case object.Function:
+		name := "func"
+		if f.Name != nil {
+			name = f.Name.Literal()
+		}
+		if s.Context != nil && s.Context.Err() != nil {
+			res = s.Error(s.Context.Err())
+			break
+		}
+		res = s.applyFunction(name, f, args)
+	default:
+		res = s.KindErrorf(object.TypeError, "not a function: %s:%s", fn.Type(), fn.Inspect())
+	}
+	res = object.Value(res)
+	if res.Type() == object.ERROR {
+		return res, evalError{"call error: ", res.(object.Error)}
 	}
 	return res, nil
 }
 
+// CallWithTimeout is Call under a child context of the state's one timing out after d: the
+// error is then of kind object.TimeoutError but, unlike for the state's own timeout, evaluation
+// can continue afterwards (e.g. to give each item of a batch its own time limit).
+func (s *State) CallWithTimeout(d time.Duration, fn object.Object, args ...object.Object) (object.Object, error) {
+	prevCtx, prevCancel := s.Context, s.Cancel
+	parent := prevCtx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, d)
+	s.Context, s.Cancel = ctx, cancel
+	defer func() {
+		cancel()
+		s.Context, s.Cancel = prevCtx, prevCancel
+	}()
+	res, err := s.Call(fn, args...)
+	if err != nil && parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		e := s.KindErrorf(object.TimeoutError, "timeout of %v exceeded", d)
+		return e, evalError{"call error: ", e}
+	}
+	return res, err
+}
+
+// Import evaluates the source of the file at path, as returned by load, in its own top level
+// environment and returns a map of its top level definitions (so `lib.fn(x)` calls the file's fn).
+// Each path is only evaluated once per state, later imports return the same map.
+// Macros defined by the file are added to the (shared) macros of the state.
+func (s *State) Import(path string, load func(path string) (string, error)) object.Object {
+	if ns, ok := s.modules[path]; ok {
+		return ns
+	}
+	if slices.Contains(s.importing, path) {
+		return s.Errorf("import cycle: %s -> %s", strings.Join(s.importing, " -> "), path)
+	}
+	code, err := load(path)
+	if err != nil {
+		return s.Error(err)
+	}
+	s.importing = append(s.importing, path)
+	defer func() {
+		s.importing = s.importing[:len(s.importing)-1]
+	}()
+	defer s.SetSource(path, code)()
+	env := s.env
+	moduleEnv := object.NewRootEnvironment()
+	s.env = moduleEnv
+	res, err := EvalString(s, code, false)
+	s.env = env
+	if err != nil {
+		if res.Type() == object.ERROR {
+			return res
+		}
+		return withCause(s.Errorf("import %s: %v", path, err), err)
+	}
+	names := moduleEnv.Names()
+	ns := object.NewMapSize(len(names))
+	for _, name := range names {
+		v, _ := moduleEnv.GetLocal(name)
+		ns = ns.Set(object.String{Value: name}, v)
+	}
+	if s.modules == nil {
+		s.modules = make(map[string]object.Object)
+	}
+	s.modules[path] = ns
+	return ns
+}
+
 func (s *State) NumMacros() int {
 	return s.macroState.Len()
 }
@@ -251,3 +402,74 @@ func (s *State) FlushOutput() {
 	// Clear the buffer but keep it for future writes
 	s.env.OutputBuffer.Reset()
 }
+
+// Primitives on already evaluated values, for code generated by grol2go (and other
+// hosts wanting to operate on grol values the same way the interpreter does).
+
+// Infix returns left op right, op being one of the infix operators (token.PLUS, token.LT,...).
+// Note that it can't short circuit for token.AND and token.OR as both sides are already evaluated.
+func (s *State) Infix(op token.Type, left, right object.Object) object.Object {
+	return s.evalInfixExpression(op, object.Value(left), object.Value(right))
+}
+
+// Prefix returns op right, op being token.MINUS, token.BANG, token.BITNOT,...
+func (s *State) Prefix(op token.Type, right object.Object) object.Object {
+	return s.evalPrefixExpression(op, object.Value(right))
+}
+
+// Postfix returns the incremented (token.INCR) or decremented (token.DECR) value.
+func (s *State) Postfix(op token.Type, val object.Object) object.Object {
+	return s.postfixValue(op, object.Value(val))
+}
+
+// Index returns left[index].
+func (s *State) Index(left, index object.Object) object.Object {
+	return s.evalIndexExpressionIdx(object.Value(left), object.Value(index))
+}
+
+// SetIndex returns a copy of base with base[index] set to value.
+func (s *State) SetIndex(base, index, value object.Object) object.Object {
+	return s.evalIndexAssignmentValue(object.Value(base), object.Value(index), object.Value(value), "")
+}
+
+// CallExtension calls the named extension (e.g. "math.sqrt") with the arguments checked and
+// converted the same way as when called from grol code.
+func (s *State) CallExtension(name string, args ...object.Object) object.Object {
+	ext, ok := s.Extensions[name]
+	if !ok {
+		return s.NewError("identifier not found: " + name)
+	}
+	return s.applyExtension(ext, args)
+}
+
+// EnterCall is for functions running outside of the evaluator (e.g. transpiled to Go) to be
+// limited like interpreted ones: it returns the max depth or context (timeout, cancellation)
+// error, if any, or counts one more level of recursion, which LeaveCall undoes on return.
+func (s *State) EnterCall() object.Object {
+	if s.depth > s.MaxDepth {
+		return s.depthError()
+	}
+	if s.Context != nil && s.Context.Err() != nil {
+		return s.Error(s.Context.Err())
+	}
+	s.depth++
+	return nil
+}
+
+// LeaveCall is called when returning from a function for which EnterCall succeeded.
+func (s *State) LeaveCall() {
+	s.depth--
+}
+
+// Print does what the print, println, log and error builtins do with the given values.
+func (s *State) Print(t token.Type, values ...object.Object) object.Object {
+	if t == token.LOG && log.GetLogLevel() >= log.Error {
+		return object.NULL
+	}
+	return s.printLogError(t, values)
+}
+
+// Interpolate returns the string of a "${...}" interpolated string with the given parts values.
+func (s *State) Interpolate(values ...object.Object) object.Object {
+	return interpolate(values)
+}
@@ -1,8 +1,14 @@
 package eval_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"grol.io/grol/ast"
 	"grol.io/grol/eval"
@@ -94,15 +100,26 @@ func(n) {
 	}
 }
 
+// testEval evaluates the input with the tree walking interpreter and with the VM, reports any
+// difference between the two and returns the result (the same for both when the test passes).
 func testEval(t *testing.T, input string) object.Object {
-	l := lexer.New(input)
-	p := parser.New(l)
-	program := p.ParseProgram()
-	if len(p.Errors()) > 0 {
-		t.Fatalf("parser has %d error(s) for %q: %v", len(p.Errors()), input, p.Errors())
-	}
-	s := eval.NewState() // each test starts anew.
-	return s.EvalToplevel(program)
+	t.Helper()
+	var results [2]object.Object
+	for i, vm := range []bool{false, true} {
+		l := lexer.New(input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("parser has %d error(s) for %q: %v", len(p.Errors()), input, p.Errors())
+		}
+		s := eval.NewState() // each test starts anew.
+		s.VM = vm
+		results[i] = s.EvalToplevel(program)
+	}
+	if tw, vm := results[0].Inspect(), results[1].Inspect(); tw != vm {
+		t.Errorf("%s:\ntree walker: %s\nvm:          %s", input, tw, vm)
+	}
+	return results[1]
 }
 
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
@@ -458,6 +475,107 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestStringInterpolation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`a=1; b=2; "total: ${a+b}"`, "total: 3"},
+		{`x="s"; "${x}${[1, x]} ${ {"k": 1.5}.k }"`, `s[1,"s"] 1.5`},
+		{`a=3; "${"in ${a}"}!"`, "in 3!"},
+		{`"\${a} $ {} $"`, "${a} $ {} $"},
+		{`f=func(n) {"n=${n} g=${g}"}; g=1; r1=f(1); g=2; r1 + " " + f(1)`, "n=1 g=1 n=1 g=2"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		str, ok := evaluated.(object.String)
+		if !ok {
+			t.Fatalf("%s: object is not String. got=%T (%+v)", tt.input, evaluated, evaluated)
+		}
+		if str.Value != tt.expected {
+			t.Errorf("%s: got %q, expected %q", tt.input, str.Value, tt.expected)
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	input := `func m(v) {
+	match v {
+		0, -1 { "zero or minus one" }
+		"a" { "letter a" }
+		[] { "empty" }
+		[x, ..tail] if x > 10 { "big head " + str(tail) }
+		[x, y] { x + y }
+		{"k": [k]} { "k is " + str(k) }
+		{x, y} { x * y }
+		int if v > 100 { "large" }
+		int, float { "number" }
+		map { "other map" }
+		_ { "other" }
+	}
+}
+[m(0), m(-1), m("a"), m([]), m([11, 1, 2]), m([1, 2]), m([1, 2, 3]), m({"k": [5]}), m({"x": 3, "y": 4}),
+	m(101), m(5), m(1.5), m({"x": 1}), m("b")]`
+	expected := `["zero or minus one","zero or minus one","letter a","empty","big head [1 2]",3,"other",` +
+		`"k is 5",12,"large","number","number","other map","other"]`
+	evaluated := testEval(t, input)
+	if evaluated.Inspect() != expected {
+		t.Errorf("got %s, expected %s", evaluated.Inspect(), expected)
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match 3 { 4 { 1 } }`, "nil"},
+		{`match 3 { x if x > 2 { x * 2 } }; x`, "<err: identifier not found: x>"}, // bindings are the arm's.
+		// not leaking from arms whose guard fails, nor changing the variables they shadow.
+		{`x = 5; r = match [1, 2] {[x, y] if x > 10 {"big"} _ {x}}; [r, x]`, "[5,5]"},
+		{`match [1, 2] {[x, y] if x > 10 {"big"} _ {y}}`, "<err: identifier not found: y>"},
+		{`func f() {x := 5; match [1] {[x] {x}}; x}; f()`, "5"},
+		{`func f() {x := 5; [match [1] {[x] {x = 7; x}}, x]}; f()`, "[7,5]"},
+		{`y = 1; match 2 {x {y = x; z := 3}}; [y, info.globals.z]`, "[2,nil]"},          // other names are the enclosing ones.
+		{`g = 1; func h(n) {match n {v {g + v}}}; a = h(1); g = 2; [a, h(1)]`, "[2,3]"}, // not cached.
+		{`match 3 { x if x { 1 } }`, "<err: match guard is not a boolean: 3>"},
+		{`func f(n) { match n { 0 { 0 } n { n + f(n-1) } } }; f(4)`, "10"},
+		{`func g(n) { match [n * 2] { [n] { n } } }; g(21)`, "42"},
+	}
+	for _, tt := range tests {
+		if actual := testEval(t, tt.input).Inspect(); actual != tt.expected {
+			t.Errorf("%s: got %s, expected %s", tt.input, actual, tt.expected)
+		}
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[a, b, ..tail] = [1, 2, 3, 4]; [a, b, tail]`, "[1,2,[3,4]]"},
+		{`[a, [b, c]] := [1, [2, 3]]; a + b + c`, "6"},
+		{`{x, y} = {"x": 3, "y": 4, "z": 5}; x * y`, "12"},
+		{`{"a": [p, _]} := {"a": [7, 8]}; p`, "7"},
+		{`x := 1; f := func() { [x] := [2]; x }; [f(), x]`, "[2,1]"},
+		{`x := 1; f := func() { [x] = [2]; x }; [f(), x]`, "[2,2]"},
+		{`x := 3; y := 4; {x, y}`, `{"x":3,"y":4}`},
+		{`func f([a, b], {c}) { a * b + c }; f([2, 3], {"c": 4})`, "10"},
+		{`func g(n, [m]) { n * m }; g(6, [7])`, "42"},
+		{`s := 0; for [a, b] = [[1, 2], [3, 4]] { s = s + a * b }; s`, "14"},
+		{`s := ""; for {key, value} = {"a": 1, "b": 2} { s = s + key + str(value) }; s`, `"a1b2"`},
+		{`func h(n) { [n, m] := [n + 1, n + 2]; n * m }; h(3)`, "20"},
+		{`[a, b] = [1]`, "<err: [1] doesn't match destructuring pattern [a,b]>"},
+		{`{x} = 1`, `<err: 1 doesn't match destructuring pattern {x}>`},
+		{`[a] += [1]`, "<err: compound assignment to destructuring pattern: SUMASSIGN>"},
+		{`for [a] = 1:3 { a }`, "<err: for destructuring pattern used with a range>"},
+		{`func f([a]) { a }; f(1)`, "<err: 1 doesn't match destructuring pattern [a]>"},
+	}
+	for _, tt := range tests {
+		if actual := testEval(t, tt.input).Inspect(); actual != tt.expected {
+			t.Errorf("%s: got %s, expected %s", tt.input, actual, tt.expected)
+		}
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -1052,39 +1170,62 @@ func TestExtension(t *testing.T) {
 	}
 }
 
-func TestNotCachingErrors(t *testing.T) {
-	s := eval.NewState()
-	_, err := eval.EvalString(s, `func x(n) {aa+n};x(3)`, false)
-	if err == nil {
-		t.Fatalf("should have errored out, got nil")
-	}
-	_, err = eval.EvalString(s, `aa=1;x(4)`, false)
-	if err != nil {
-		t.Errorf("should have not errored out after defining aa, got %v", err)
-	}
-	_, err = eval.EvalString(s, `x(3)`, false)
-	if err != nil {
-		t.Errorf("should have not cached the error, got %v", err)
+// forBoth runs the test with a new state using the tree walking interpreter and with one using the VM.
+func forBoth(t *testing.T, test func(t *testing.T, s *eval.State)) {
+	t.Helper()
+	for _, vm := range []bool{false, true} {
+		t.Run(fmt.Sprintf("vm=%t", vm), func(t *testing.T) {
+			s := eval.NewState()
+			s.VM = vm
+			test(t, s)
+		})
 	}
 }
 
+func TestNotCachingErrors(t *testing.T) {
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		_, err := eval.EvalString(s, `func x(n) {aa+n};x(3)`, false)
+		if err == nil {
+			t.Fatalf("should have errored out, got nil")
+		}
+		_, err = eval.EvalString(s, `aa=1;x(4)`, false)
+		if err != nil {
+			t.Errorf("should have not errored out after defining aa, got %v", err)
+		}
+		_, err = eval.EvalString(s, `x(3)`, false)
+		if err != nil {
+			t.Errorf("should have not cached the error, got %v", err)
+		}
+		// nor the result computed from a caught one.
+		res, _ := eval.EvalString(s, `func y(n) {try {bb+n} catch {0}}; y(3)`, false)
+		if res.Inspect() != "0" {
+			t.Errorf("expected the error to be caught, got %s", res.Inspect())
+		}
+		res, _ = eval.EvalString(s, `bb=1;y(3)`, false)
+		if res.Inspect() != "4" {
+			t.Errorf("should have not cached the caught error result, got %s", res.Inspect())
+		}
+	})
+}
+
 func TestNaNMapKey(t *testing.T) {
 	// Also tests sorting order with ints mixed
-	s := eval.NewState()
-	_, err := eval.EvalString(s, `nan=0./0.`, false)
-	if err != nil {
-		t.Errorf("should have not errored out just defining NaN, got %v", err)
-	}
-	res, errs, _ := repl.EvalString(`nan=0./0; minf=-1/0.; pinf=1/0.
-	m={-42.3: "about -42",42:"int 42", minf:"minf", pinf:"pinf", 42.1:"42.1", nan: "this is NaN"}
-	println(m)`)
-	if len(errs) != 0 {
-		t.Errorf("should have no error trying to put a nan in map, got %v", errs)
-	}
-	expected := `{NaN:"this is NaN",-Inf:"minf",-42.3:"about -42",42:"int 42",42.1:"42.1",+Inf:"pinf"}` + "\n"
-	if res != expected {
-		t.Errorf("wrong result, got %s expected %s", res, expected)
-	}
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		_, err := eval.EvalString(s, `nan=0./0.`, false)
+		if err != nil {
+			t.Errorf("should have not errored out just defining NaN, got %v", err)
+		}
+		res, errs, _ := repl.EvalString(`nan=0./0; minf=-1/0.; pinf=1/0.
+		m={-42.3: "about -42",42:"int 42", minf:"minf", pinf:"pinf", 42.1:"42.1", nan: "this is NaN"}
+		println(m)`)
+		if len(errs) != 0 {
+			t.Errorf("should have no error trying to put a nan in map, got %v", errs)
+		}
+		expected := `{NaN:"this is NaN",-Inf:"minf",-42.3:"about -42",42:"int 42",42.1:"42.1",+Inf:"pinf"}` + "\n"
+		if res != expected {
+			t.Errorf("wrong result, got %s expected %s", res, expected)
+		}
+	})
 }
 
 func TestBlankSlateEval(t *testing.T) {
@@ -1102,136 +1243,512 @@ func TestBlankSlateEval(t *testing.T) {
 	}
 	s := eval.NewState()
 	s.MaxDepth = 10
-	inp = `func f(a){if a==0 {return 0} f(a-1)}; f(20)`
-	defer func() {
-		if r := recover(); r != nil {
-			t.Logf("recovered from panic as expected: %v", r)
-			if r.(string) != "max depth 10 reached" {
-				t.Fatalf("wrong panic message: %v", r)
+	inp = `func f(a){if a==0 {return 0} 1+f(a-1)}; f(20)` // not a tail call, those don't recurse.
+	obj, err = eval.EvalString(s, inp, true)
+	if oerr, ok := obj.(object.Error); err == nil || !ok || oerr.Kind != object.DepthError || oerr.Value != "max depth 10 reached" {
+		t.Fatalf("expected max depth error, got %s", obj.Inspect())
+	}
+}
+
+func TestMaxDepthCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`r := catch(f(100)); [r.kind, r.value]`, `["depth","max depth 50 reached"]`},
+		{`try { f(100) } catch e { e.kind }`, `"depth"`},
+		{`try { f(100) } catch e { f(10) }`, "10"},
+	}
+	for _, vm := range []bool{false, true} {
+		for _, tt := range tests {
+			s := eval.NewState()
+			s.VM = vm
+			s.MaxDepth = 50
+			_, _ = eval.EvalString(s, `func f(n) {if n == 0 {return 0}; 1 + f(n-1)}`, false)
+			res, err := eval.EvalString(s, tt.input, false)
+			if err != nil || res.Inspect() != tt.expected {
+				t.Errorf("vm %t %s: got %s, %v, expected %s", vm, tt.input, res.Inspect(), err, tt.expected)
 			}
 		}
-	}()
-	_, _ = eval.EvalString(s, inp, true)
-	t.Fatalf("should have panicked and not reach")
+	}
 }
 
 func TestMapAccidentalMutation(t *testing.T) {
 	inp := `m={1:1, nil:"foo"}; m+{nil:"bar"}; m`
-	s := eval.NewState()
-	res, err := eval.EvalString(s, inp, false)
-	if err != nil {
-		t.Fatalf("should not have errored: %v", err)
-	}
-	resStr := res.Inspect()
-	expected := `{1:1,nil:"foo"}`
-	if resStr != expected {
-		t.Errorf("wrong result, got %q expected %q", resStr, expected)
-	}
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		res, err := eval.EvalString(s, inp, false)
+		if err != nil {
+			t.Fatalf("should not have errored: %v", err)
+		}
+		resStr := res.Inspect()
+		expected := `{1:1,nil:"foo"}`
+		if resStr != expected {
+			t.Errorf("wrong result, got %q expected %q", resStr, expected)
+		}
+	})
 }
 
 func TestSmallMapSorting(t *testing.T) {
 	inp := `m={2:"b"};n={1:"a"};m+n`
 	expected := `{1:"a",2:"b"}`
-	s := eval.NewState()
-	res, err := eval.EvalString(s, inp, false)
-	if err != nil {
-		t.Fatalf("should not have errored: %v", err)
-	}
-	resStr := res.Inspect()
-	if resStr != expected {
-		t.Errorf("wrong result, got %q expected %q", resStr, expected)
-	}
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		res, err := eval.EvalString(s, inp, false)
+		if err != nil {
+			t.Fatalf("should not have errored: %v", err)
+		}
+		resStr := res.Inspect()
+		if resStr != expected {
+			t.Errorf("wrong result, got %q expected %q", resStr, expected)
+		}
+	})
 }
 
 func TestCrashKeys(t *testing.T) {
 	inp := `keys({1:1,2:2,3:3,4:4,5:5,6:6,7:7,8:8,9:9})` // Big map and big array (>8 elements).
-	s := eval.NewState()
-	_, err := eval.EvalString(s, inp, false)
-	if err != nil {
-		t.Errorf("should not have errored: %v", err)
-	}
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		_, err := eval.EvalString(s, inp, false)
+		if err != nil {
+			t.Errorf("should not have errored: %v", err)
+		}
+	})
 }
 
 func TestParenInIf(t *testing.T) {
 	inp := `if (1+2)==3 {42}`
-	s := eval.NewState()
-	res, err := eval.EvalString(s, inp, false)
-	if err != nil {
-		t.Fatalf("should not have errored: %v", err)
-	}
-	if res.Type() != object.INTEGER {
-		t.Fatalf("should have returned an integer, got %#v", res)
-	}
-	if res.Inspect() != "42" {
-		t.Errorf("wrong result, got %q", res.Inspect())
-	}
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		res, err := eval.EvalString(s, inp, false)
+		if err != nil {
+			t.Fatalf("should not have errored: %v", err)
+		}
+		if res.Type() != object.INTEGER {
+			t.Fatalf("should have returned an integer, got %#v", res)
+		}
+		if res.Inspect() != "42" {
+			t.Errorf("wrong result, got %q", res.Inspect())
+		}
+	})
 }
 
 func TestSelfRef(t *testing.T) {
 	inp := `a=1 ()=>{a=a}()`
-	s := eval.NewState()
-	res, err := eval.EvalString(s, inp, false)
-	if err != nil {
-		t.Errorf("should not have errored: %v", err)
-	}
-	expected := "1"
-	if res.Inspect() != expected {
-		t.Errorf("wrong result, got %q", res.Inspect())
-	}
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		res, err := eval.EvalString(s, inp, false)
+		if err != nil {
+			t.Errorf("should not have errored: %v", err)
+		}
+		expected := "1"
+		if res.Inspect() != expected {
+			t.Errorf("wrong result, got %q", res.Inspect())
+		}
+	})
 }
 
 func TestAliasTwice(t *testing.T) {
 	inp := `a=1; b=2;()=>{a=b}();b=5;()=>{a=b}()` // should not crash
-	s := eval.NewState()
-	res, err := eval.EvalString(s, inp, false)
-	if err != nil {
-		t.Errorf("should not have errored: %v", err)
-	}
-	expected := "5"
-	if res.Inspect() != expected {
-		t.Errorf("wrong result, got %q", res.Inspect())
-	}
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		res, err := eval.EvalString(s, inp, false)
+		if err != nil {
+			t.Errorf("should not have errored: %v", err)
+		}
+		expected := "5"
+		if res.Inspect() != expected {
+			t.Errorf("wrong result, got %q", res.Inspect())
+		}
+	})
 }
 
 func TestIncrMatrix(t *testing.T) {
 	inp := `m={"v":3};()=>{m.v++}();m.v`
-	s := eval.NewState()
-	res, err := eval.EvalString(s, inp, false)
-	if err == nil { // TODO fix https://github.com/grol-io/grol/issues/189
-		// t.Errorf("should not have errored: %v", err)
-		t.Fatalf("should have errored, got %v", res)
-	}
-	// once implement res should be 4.
-	expected := "eval error: <err: index expression with . not string: ++ in ()=>m.v++>"
-	actual := err.Error()
-	if actual != expected {
-		t.Errorf("wrong error, got %q instead of %q", actual, expected)
-	}
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		res, err := eval.EvalString(s, inp, false)
+		if err == nil { // TODO fix https://github.com/grol-io/grol/issues/189
+			// t.Errorf("should not have errored: %v", err)
+			t.Fatalf("should have errored, got %v", res)
+		}
+		// once implement res should be 4.
+		expected := "eval error: <err: index expression with . not string: ++ in ()=>m.v++>"
+		actual := err.Error()
+		if actual != expected {
+			t.Errorf("wrong error, got %q instead of %q", actual, expected)
+		}
+	})
 }
 
 func TestDecrRegister(t *testing.T) {
 	inp := `x=>{x-- return x}(3)`
-	s := eval.NewState()
-	res, err := eval.EvalString(s, inp, false)
-	if err != nil {
-		t.Errorf("should not have errored: %v", err)
-	}
-	expected := "2"
-	if res.Inspect() != expected {
-		t.Errorf("wrong result, got %q", res.Inspect())
-	}
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		res, err := eval.EvalString(s, inp, false)
+		if err != nil {
+			t.Errorf("should not have errored: %v", err)
+		}
+		expected := "2"
+		if res.Inspect() != expected {
+			t.Errorf("wrong result, got %q", res.Inspect())
+		}
+	})
 }
 
 // Test for https://github.com/grol-io/grol/issues/276
 func TestArrayLeftNoPanic(t *testing.T) {
 	inp := `[]=0`
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		res, err := eval.EvalString(s, inp, false)
+		if err == nil {
+			t.Errorf("should have errored: %v", res)
+		}
+		expected := "<err: 0 doesn't match destructuring pattern []>"
+		if res.Inspect() != expected {
+			t.Errorf("wrong result, got %q", res.Inspect())
+		}
+	})
+}
+
+func TestErrorLocation(t *testing.T) {
+	inp := "f = func(x) {\n\tx + 1\n}\nf(\"a\")"
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		s.SetSource("test.gr", inp)
+		res, err := eval.EvalString(s, inp, false)
+		if err == nil {
+			t.Fatalf("should have errored: %v", res)
+		}
+		errObj, ok := res.(object.Error)
+		if !ok {
+			t.Fatalf("expected error object, got %T (%+v)", res, res)
+		}
+		if errObj.Location() != "test.gr:2:4" {
+			t.Errorf("wrong location, got %q", errObj.Location())
+		}
+		if errObj.Source != "\tx + 1" {
+			t.Errorf("wrong source line, got %q", errObj.Source)
+		}
+		expected := "<err: test.gr:2:4: unknown operator: STRING PLUS INTEGER in x=>x+1>\n\tx + 1\n\t  ^"
+		if errObj.Inspect() != expected {
+			t.Errorf("wrong error, got %q expected %q", errObj.Inspect(), expected)
+		}
+	})
+}
+
+func TestImport(t *testing.T) {
+	files := map[string]string{
+		"lib.gr": "func double(x) { 2 * x }\nfunc quad(x) { double(double(x)) }\nsecret := 7",
+		"a.gr":   `import("b.gr")`,
+		"b.gr":   `import("a.gr")`,
+	}
+	reads := 0
+	load := func(path string) (string, error) {
+		reads++
+		code, ok := files[path]
+		if !ok {
+			return "", fmt.Errorf("no such file %s", path)
+		}
+		return code, nil
+	}
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		reads = 0
+		s.Extensions["import"] = object.Extension{
+			Name: "import", MinArgs: 1, MaxArgs: 1, ArgTypes: []object.Type{object.STRING},
+			Callback: func(env any, _ string, args []object.Object) object.Object {
+				return env.(*eval.State).Import(args[0].(object.String).Value, load)
+			},
+		}
+		res, err := eval.EvalString(s, `l := import("lib.gr"); l2 := import("lib.gr"); [l.quad(3), l.secret, l == l2]`, false)
+		if err != nil {
+			t.Fatalf("import error: %v", err)
+		}
+		if res.Inspect() != "[12,7,true]" || reads != 1 {
+			t.Errorf("wrong result %s or reads %d", res.Inspect(), reads)
+		}
+		if res, _ = eval.EvalString(s, `secret`, false); res.Inspect() != "<err: identifier not found: secret>" {
+			t.Errorf("import leaked into the caller environment: %s", res.Inspect())
+		}
+		res, _ = eval.EvalString(s, `import("a.gr")`, false)
+		if !strings.Contains(res.Inspect(), "import cycle: a.gr -> b.gr -> a.gr") {
+			t.Errorf("expected import cycle error, got %s", res.Inspect())
+		}
+		res, _ = eval.EvalString(s, `import("c.gr")`, false)
+		if res.Inspect() != "<err: no such file c.gr>" {
+			t.Errorf("expected missing file error, got %s", res.Inspect())
+		}
+	})
+}
+
+func TestCall(t *testing.T) {
+	forBoth(t, func(t *testing.T, s *eval.State) {
+		calls := 0
+		s.Extensions["apply_each"] = object.Extension{
+			Name: "apply_each", MinArgs: 2, MaxArgs: 2, ArgTypes: []object.Type{object.FUNC, object.ARRAY},
+			Callback: func(env any, _ string, args []object.Object) object.Object {
+				st := env.(*eval.State)
+				elements := object.Elements(args[1])
+				res := object.MakeObjectSlice(len(elements))
+				for _, e := range elements {
+					calls++
+					v, err := st.Call(args[0], e)
+					if err != nil {
+						return v
+					}
+					res = append(res, v)
+				}
+				return object.NewArray(res)
+			},
+		}
+		res, err := eval.EvalString(s,
+			`func sq(x) { x * x }; func nested(x) { apply_each(sq, [x, x+1]) }; apply_each(nested, [1, 2])`, false)
+		if err != nil {
+			t.Fatalf("eval error: %v", err)
+		}
+		if res.Inspect() != "[[1,4],[4,9]]" || calls != 6 {
+			t.Errorf("wrong result %s or calls %d", res.Inspect(), calls)
+		}
+		sq, _ := eval.EvalString(s, `sq`, false)
+		if res, err = s.Call(sq, object.Integer{Value: 12}); err != nil || res.Inspect() != "144" {
+			t.Errorf("Call(sq, 12): %v, %v", res.Inspect(), err)
+		}
+		if res, err = s.Call(sq); err == nil || res.Inspect() != "<err: wrong number of arguments for sq. got=0, want=1>" {
+			t.Errorf("expected argument count error, got %s", res.Inspect())
+		}
+		if res, err = s.Call(object.Integer{Value: 1}); err == nil || res.Inspect() != "<err: not a function: INTEGER:1>" {
+			t.Errorf("expected not a function error, got %s", res.Inspect())
+		}
+		res, _ = eval.EvalString(s, `apply_each(func(x) { x / 0 }, [1])`, false)
+		if !strings.Contains(res.Inspect(), "division by zero") {
+			t.Errorf("expected error from the callback, got %s", res.Inspect())
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s.Context = ctx
+		if res, err = s.Call(sq, object.Integer{Value: 2}); err == nil || res.Inspect() != "<err: context canceled>" {
+			t.Errorf("expected context canceled error, got %s", res.Inspect())
+		}
+	})
+}
+
+func TestCallWithTimeout(t *testing.T) {
+	for _, vm := range []bool{false, true} {
+		s := eval.NewState()
+		s.VM = vm
+		loop, _ := eval.EvalString(s, `func loop(n) { for true { n++ } }`, false)
+		res, err := s.CallWithTimeout(20*time.Millisecond, loop, object.Integer{Value: 1})
+		var oerr *object.Error
+		if !errors.As(err, &oerr) || oerr.Kind != object.TimeoutError || oerr.Value != "timeout of 20ms exceeded" {
+			t.Errorf("vm %t: expected timeout error, got %s", vm, res.Inspect())
+		}
+		// the state's context is restored and still usable.
+		if res, err = eval.EvalString(s, `1+1`, false); err != nil || res.Inspect() != "2" {
+			t.Errorf("vm %t: state should still be usable, got %s %v", vm, res.Inspect(), err)
+		}
+		sq, _ := eval.EvalString(s, `func(x) { x * x }`, false)
+		if res, err = s.CallWithTimeout(time.Second, sq, object.Integer{Value: 3}); err != nil || res.Inspect() != "9" {
+			t.Errorf("vm %t: expected 9, got %s %v", vm, res.Inspect(), err)
+		}
+	}
+}
+
+// TestParallelStates checks (with -race) that independent states can run concurrently.
+func TestParallelStates(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 { // registering new extensions while other states run.
+				// (error ignored as it is already defined when running with -count > 1)
+				_ = object.CreateFunction(object.Extension{
+					Name: fmt.Sprintf("parallel%d", i), MinArgs: 0, MaxArgs: 0,
+					Callback: func(_ any, _ string, _ []object.Object) object.Object { return object.NULL },
+				})
+			}
+			s := eval.NewState()
+			out := &strings.Builder{}
+			s.Out = out
+			extensions.SetImages(s, make(extensions.ImageMap))
+			code := fmt.Sprintf(`func fact%d(n) { if n <= 1 { return 1 } n * fact%d(n-1) }
+m := {"a%d": [1, 2.5, "x"]}; v := fact%d(10); println(json(m), sprintf("%%d", v), round(PI), str(m))
+image.new("img", 10, 10); image.text("img", 1, 9, 8., "%d"); s := image.text_size("%d", 8.)
+[a, ..r] := [1, 2, 3]; g := len(info.globals); match v { 3628800 { "ok" } _ { "bad" } }`, i, i, i, i, i, i)
+			res, err := eval.EvalString(s, code, false)
+			if err != nil || res.Inspect() != `"ok"` {
+				t.Errorf("goroutine %d: %v %v", i, res.Inspect(), err)
+			}
+			expected := fmt.Sprintf(`{"a%d":[1,2.500000,"x"]} 3628800 3 map[a%d:[1 2.5 x]]`+"\n", i, i)
+			if out.String() != expected {
+				t.Errorf("goroutine %d: output %q, expected %q", i, out.String(), expected)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestQuotas(t *testing.T) {
+	tests := []struct {
+		input     string
+		configure func(s *eval.State)
+		kind      string
+		after     string // caught, the error happens again at the next step, allocation or print.
+	}{
+		{
+			`func f(n) { for i = n { x := i } }; f(100_000)`, func(s *eval.State) { s.MaxSteps = 10_000 },
+			object.StepsQuotaError, `r := catch(f(100)); r.kind`,
+		},
+		{
+			`func f(n) { if n == 0 { 0 } else { f(n-1) } }; f(5000)`, func(s *eval.State) { s.MaxSteps = 10_000 },
+			object.StepsQuotaError, `r := catch(f(100)); r.kind`,
+		},
+		{
+			`s := "abc"; for i = 20 { s = s + s }`, func(s *eval.State) { s.MaxAlloc = 100_000 },
+			object.AllocQuotaError, `r := catch(s + s); [r.kind]`,
+		},
+		{
+			`func f(n) { for i = n { x := [i, i, i] } }; f(1000)`, func(s *eval.State) { s.MaxAlloc = 10_000 },
+			object.AllocQuotaError, `r := catch(f(1)); {"kind": r.kind}`,
+		},
+		{
+			`for i = 100 { print("0123456789") }`, func(s *eval.State) { s.MaxOutput = 500 },
+			object.OutputQuotaError, `r := catch(print("x")); println(r.kind)`,
+		},
+	}
+	for _, vm := range []bool{false, true} {
+		for _, tt := range tests {
+			s := eval.NewState()
+			s.VM = vm
+			out := &strings.Builder{}
+			s.Out = out
+			tt.configure(s)
+			res, err := eval.EvalString(s, tt.input, false)
+			oerr, ok := res.(object.Error)
+			if err == nil || !ok || oerr.Kind != tt.kind {
+				t.Errorf("vm %t %s: expected %s error, got %s", vm, tt.input, tt.kind, res.Inspect())
+				continue
+			}
+			res, _ = eval.EvalString(s, tt.after, false)
+			if oerr, ok = res.(object.Error); !ok || oerr.Kind != tt.kind {
+				t.Errorf("vm %t %s: expected %s error after catch, got %s", vm, tt.input, tt.kind, res.Inspect())
+			}
+			if u := s.Usage(); tt.kind == object.OutputQuotaError && (u.Output != 500 || out.Len() != 500) {
+				t.Errorf("vm %t: expected 500 bytes of output, got %d/%d", vm, u.Output, out.Len())
+			}
+			s.ResetUsage()
+			s.MaxSteps, s.MaxAlloc, s.MaxOutput = 0, 0, 0
+			res, _ = eval.EvalString(s, `catch(error("x")).kind`, false)
+			if res.Inspect() != `"user"` {
+				t.Errorf("errors after the reset shouldn't be quota ones, got %s", res.Inspect())
+			}
+		}
+	}
+	s := eval.NewState()
+	s.MaxSteps = 1000
+	res, _ := eval.EvalString(s, `r := catch(for i = 10_000 { i }); [r.err, r.kind]`, false)
+	if res.Inspect() != `<err: steps quota of 1000 exceeded>` {
+		t.Errorf("unexpected %s", res.Inspect())
+	}
+}
+
+func TestQuotasBeforeAllocating(t *testing.T) {
+	tests := []string{
+		`"x" * 1_000_000_000_000`,
+		`[0] * 1_000_000_000_000`,
+		`[1, 2, 3] * 9_223_372_036_854_775_807`,
+		`s := "0123456789" * 50_000; s + s`,
+		`a := [0] * 40_000; a + a`,
+		`a := [0] * 60_000; a + 1`,
+	}
+	for _, vm := range []bool{false, true} {
+		for _, input := range tests {
+			s := eval.NewState()
+			s.VM = vm
+			s.MaxAlloc = 1_000_000
+			res, _ := eval.EvalString(s, input, false)
+			if oerr, ok := res.(object.Error); !ok || oerr.Kind != object.AllocQuotaError {
+				t.Errorf("vm %t %s: expected allocation error, got %s", vm, input, res.Inspect())
+			}
+			res, _ = eval.EvalString(s, `[1]`, false)
+			if oerr, ok := res.(object.Error); !ok || oerr.Kind != object.AllocQuotaError {
+				t.Errorf("vm %t %s: expected the quota to stay exceeded, got %s", vm, input, res.Inspect())
+			}
+		}
+	}
+	s := eval.NewState()
+	s.NoLog = false
+	s.MaxOutput = 15
+	res, _ := eval.EvalString(s, `log("0123456789"); log("0123456789")`, false)
+	if oerr, ok := res.(object.Error); !ok || oerr.Kind != object.OutputQuotaError || s.Usage().Output != 10 {
+		t.Errorf("log output should count towards the quota, got %s (%d bytes)", res.Inspect(), s.Usage().Output)
+	}
+}
+
+func TestStructuredErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`catch(error({"code": 42})).data.code`, "42"},
+		{`r := catch(error("bad", 1)); [r.kind, r.value, r.data]`, `["user","bad 1",nil]`},
+		{`catch(1 + "a").kind`, `"type"`},
+		{`catch(if 1 + "a" {1}).kind`, `"type"`},
+		{`catch(len(1)).kind`, `"type"`},
+		{`catch(round("a")).kind`, `"type"`},
+		{`catch([1, 2][1:0]).kind`, `"index"`},
+		{`catch(eval("1 +")).kind`, `"parse"`},
+		{`r := catch(eval("error({\"x\": 1})")); [r.kind, r.cause.kind, r.cause.data]`, `["user","user",{"x":1}]`},
+		{"x := 1\nr := catch(error(\"at\"))\n[r.line, r.column]", "[2,12]"},
+		{`catch(1).kind`, "nil"},
+		{`try {error({"x": 1})} catch e {[e.kind, e.data.x]}`, `["user",1]`},
+		{`try {1} catch e {2} finally {3}`, "1"},
+		{`try {return 1} finally {return 2}`, "2"},
+		{`n = 0; for i = 3 {try {continue} finally {n++}}; n`, "3"},
+		{`r := catch(try {1 + "a"} catch e {error(e)}); [r.kind, r.column]`, `["type",29]`},
+		{`catch(try {error("a")} finally {0}).value`, `"a"`},
+	}
+	for _, vm := range []bool{false, true} {
+		for _, tt := range tests {
+			s := eval.NewState()
+			s.VM = vm
+			// through a function so the VM runs the code too.
+			res, err := eval.EvalString(s, "func f() {"+tt.input+"}; f()", false)
+			if err != nil {
+				t.Errorf("vm %t %s: unexpected error %v", vm, tt.input, err)
+				continue
+			}
+			if actual := res.Inspect(); actual != tt.expected {
+				t.Errorf("vm %t %s: got %s, expected %s", vm, tt.input, actual, tt.expected)
+			}
+		}
+	}
 	s := eval.NewState()
-	res, err := eval.EvalString(s, inp, false)
-	if err == nil {
-		t.Errorf("should have errored: %v", res)
+	_, err := eval.EvalString(s, `x := 1; error("failed", x)`, false)
+	var oerr *object.Error
+	if !errors.As(err, &oerr) || oerr.Kind != object.UserError || oerr.Value != "failed 1" {
+		t.Errorf("expected errors.As to find the user error, got %v (%#v)", err, oerr)
+	}
+	_, err = eval.EvalString(s, `1 +`, false)
+	if !errors.As(err, &oerr) || oerr.Kind != object.ParseError {
+		t.Errorf("expected a parse error, got %v", err)
 	}
-	expected := "<err: assignment to non identifier: LBRACKET:\"[\">"
-	if res.Inspect() != expected {
-		t.Errorf("wrong result, got %q", res.Inspect())
+	_, err = s.Call(object.Integer{Value: 1})
+	if !errors.As(err, &oerr) || oerr.Kind != object.TypeError {
+		t.Errorf("expected a type error, got %v", err)
+	}
+}
+
+type countingDebugger struct{ statements int }
+
+func (d *countingDebugger) Statement(_ *eval.State, _ ast.Node) { d.statements++ }
+
+func TestSetDebuggerRestores(t *testing.T) {
+	s := eval.NewState()
+	s.VM = true
+	d := &countingDebugger{}
+	s.SetDebugger(d)
+	if s.VM || !s.NoReg {
+		t.Errorf("debugger should use the tree walker without registers, got VM %t NoReg %t", s.VM, s.NoReg)
+	}
+	s.SetDebugger(&countingDebugger{}) // replacing it keeps the saved settings.
+	s.SetDebugger(d)
+	if _, err := eval.EvalString(s, `func f(x) {x + 1}; f(1)`, false); err != nil || d.statements == 0 {
+		t.Errorf("debugger not called (%d statements): %v", d.statements, err)
+	}
+	s.SetDebugger(nil)
+	if !s.VM || s.NoReg {
+		t.Errorf("removing the debugger should restore VM and NoReg, got VM %t NoReg %t", s.VM, s.NoReg)
 	}
 }
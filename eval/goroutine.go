@@ -0,0 +1,118 @@
+package eval
+
+import (
+	"context"
+	"io"
+	"maps"
+	"sync"
+
+	"grol.io/grol/object"
+)
+
+// Go starts the call of the function (or extension) with the arguments in a new goroutine and
+// returns the object.Task to wait for its result. The call runs in its own State, with a copy of
+// the function's environment (so the globals as they are now, values the goroutine changes are
+// its own), the same extensions, capabilities and limits, its own cache and a context canceled
+// along with s's one (e.g. when it times out). The quotas of steps, allocations and output are
+// shared: what the goroutines use counts for s too. Outputs to s.Out and s.LogOut are serialized.
+func (s *State) Go(fn object.Object, args ...object.Object) object.Object {
+	fn = object.Value(fn)
+	if t := fn.Type(); t != object.FUNC && t != object.EXTENSION {
+		return s.KindErrorf(object.TypeError, "not a function: %s:%s", fn.Type(), fn.Inspect())
+	}
+	env := s.env
+	if f, ok := fn.(object.Function); ok {
+		env = f.Env
+	}
+	snapshot, copyValue := env.Snapshot()
+	root := snapshot
+	for root.Outer() != nil {
+		root = root.Outer()
+	}
+	child := s.spawn(root)
+	fn = copyValue(fn)
+	for i, arg := range args {
+		args[i] = copyValue(arg)
+	}
+	task, done := object.NewTask()
+	go func() {
+		var res object.Object
+		defer func() {
+			if r := recover(); r != nil { // e.g. would exceed memory.
+				child.Reset()
+				res = child.Errorf("panic in %s: %v", task.Inspect(), r)
+			}
+			child.Cancel()
+			done(object.Isolate(res))
+		}()
+		res, _ = child.Call(fn, args...)
+	}()
+	return task
+}
+
+// spawn returns the State for a goroutine started by s (see Go), with env as its top level.
+func (s *State) spawn(env *object.Environment) *State {
+	out, logOut := s.sharedOutputs()
+	child := &State{
+		Out:          out,
+		LogOut:       logOut,
+		env:          env,
+		rootEnv:      env,
+		cache:        NewCache(),
+		Extensions:   maps.Clone(s.Extensions), // with the same ClientData (e.g. images), which they synchronize.
+		macroState:   object.NewMacroEnvironment(),
+		NoLog:        s.NoLog,
+		MaxDepth:     s.MaxDepth,
+		MaxValueLen:  s.MaxValueLen,
+		NoReg:        s.NoReg,
+		VM:           s.VM,
+		CurrentFile:  s.CurrentFile,
+		sourceLines:  s.sourceLines,
+		MaxSteps:     s.MaxSteps,
+		MaxAlloc:     s.MaxAlloc,
+		MaxOutput:    s.MaxOutput,
+		usage:        s.usage,
+		Capabilities: s.Capabilities,
+	}
+	child.cache.MaxEntries, child.cache.MaxBytes = s.cache.MaxEntries, s.cache.MaxBytes
+	child.cache.noMemo = maps.Clone(s.cache.noMemo)
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	child.SetContext(ctx, 0)
+	return child
+}
+
+// lockedWriter serializes the writes of goroutines to the same writer.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (lw lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
+
+// sharedOutputs makes s write its outputs through lockedWriters, if it doesn't already, and
+// returns them for a goroutine to use. Within function calls s.Out buffers the call's output (see
+// startOutputBuffering), the actual writer is then the one saved by the outermost call.
+func (s *State) sharedOutputs() (io.Writer, io.Writer) {
+	out := &s.Out
+	for e := s.env; e != nil; e = e.StackParent() {
+		if e.PrevOut != nil {
+			out = &e.PrevOut
+		}
+	}
+	lw, ok := (*out).(lockedWriter)
+	if !ok {
+		lw = lockedWriter{mu: &sync.Mutex{}, w: *out}
+		*out = lw
+	}
+	if _, ok := s.LogOut.(lockedWriter); !ok {
+		s.LogOut = lockedWriter{mu: lw.mu, w: s.LogOut}
+	}
+	return lw, s.LogOut
+}
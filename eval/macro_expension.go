@@ -130,5 +130,5 @@ func extendMacroEnv(macro *object.Macro, args []object.Quote) *State {
 		extended.Set(param.Value().Literal(), args[paramIdx])
 	}
 
-	return &State{env: extended}
+	return &State{env: extended, usage: &usageCounters{}}
 }
@@ -1,14 +1,20 @@
 package eval
 
 import (
+	"container/list"
+
 	"grol.io/grol/object"
 )
 
+// MaxArgs is the number of arguments kept as is in CacheKey, calls with more are keyed by Encoded.
 const MaxArgs = 4
 
 type CacheKey struct {
 	Fn   string
 	Args [MaxArgs]object.Object
+	// Encoded arguments (see object.AppendKey) when there are more than MaxArgs or some aren't
+	// object.Hashable (e.g. big arrays and maps, functions), Args are then unused.
+	Encoded string
 }
 
 type CacheValue struct {
@@ -16,39 +22,160 @@ type CacheValue struct {
 	Output []byte
 }
 
-type Cache map[CacheKey]CacheValue
+// CacheStats are the counters and current size of a Cache.
+type CacheStats struct {
+	Hits      int64
+	Misses    int64 // lookups of memoizable calls not found.
+	Evictions int64 // entries removed to stay within the limits.
+	Entries   int
+	Bytes     int64 // approximate size of the entries.
+}
 
-func NewCache() Cache {
-	return make(Cache)
+// Map returns the statistics as a grol map, e.g. for info.cache.
+func (cs CacheStats) Map() object.Map {
+	m := object.NewMapSize(5)
+	m = m.Set(object.String{Value: "hits"}, object.Integer{Value: cs.Hits})
+	m = m.Set(object.String{Value: "misses"}, object.Integer{Value: cs.Misses})
+	m = m.Set(object.String{Value: "evictions"}, object.Integer{Value: cs.Evictions})
+	m = m.Set(object.String{Value: "entries"}, object.Integer{Value: int64(cs.Entries)})
+	return m.Set(object.String{Value: "bytes"}, object.Integer{Value: cs.Bytes})
 }
 
-func (c Cache) Get(fn string, args []object.Object) (object.Object, []byte, bool) {
-	if len(args) > MaxArgs {
-		return nil, nil, false
-	}
+// Cache is the memoization cache of functions results: least recently used entries are evicted
+// once there are more than MaxEntries or their approximate size is over MaxBytes (0 for unlimited,
+// the default). It isn't safe for concurrent use (each State has its own).
+type Cache struct {
+	MaxEntries int
+	MaxBytes   int64
+	entries    map[CacheKey]*list.Element
+	lru        list.List // of *cacheEntry, most recently used first.
+	noMemo     map[string]bool
+	stats      CacheStats
+}
+
+type cacheEntry struct {
+	key   CacheKey
+	value CacheValue
+	size  int64
+}
+
+func NewCache() *Cache {
+	return &Cache{entries: make(map[CacheKey]*list.Element), noMemo: make(map[string]bool)}
+}
+
+// cacheKey returns the key for the call, ok is false when it can't be memoized.
+func cacheKey(fn string, args []object.Object) (CacheKey, bool) {
 	key := CacheKey{Fn: fn}
-	for i, v := range args {
-		// Can't hash functions, arrays, maps arguments (yet).
-		if !object.Hashable(v) {
-			return nil, nil, false
+	if len(args) <= MaxArgs {
+		i := 0
+		for ; i < len(args) && object.Hashable(args[i]); i++ {
+			key.Args[i] = args[i]
 		}
-		key.Args[i] = v
+		if i == len(args) {
+			return key, true
+		}
+		key.Args = [MaxArgs]object.Object{}
+	}
+	var buf []byte
+	var ok bool
+	for _, v := range args {
+		if buf, ok = object.AppendKey(buf, v); !ok {
+			return key, false
+		}
+	}
+	key.Encoded = string(buf)
+	return key, true
+}
+
+func (c *Cache) Get(fn string, args []object.Object) (object.Object, []byte, bool) {
+	key, ok := cacheKey(fn, args)
+	if !ok {
+		return nil, nil, false
 	}
-	result, ok := c[key]
-	return result.Result, result.Output, ok
+	e, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(e)
+	result := e.Value.(*cacheEntry).value
+	return result.Result, result.Output, true
 }
 
-func (c Cache) Set(fn string, args []object.Object, result object.Object, output []byte) {
-	if len(args) > MaxArgs {
+func (c *Cache) Set(fn string, args []object.Object, result object.Object, output []byte) {
+	key, ok := cacheKey(fn, args)
+	if !ok {
 		return
 	}
-	key := CacheKey{Fn: fn}
-	for i, v := range args {
-		// Can't hash functions arguments (yet).
-		if !object.Hashable(v) {
-			return
+	c.remove(c.entries[key]) // nil if not already there.
+	entry := &cacheEntry{key: key, value: CacheValue{Result: result, Output: output}}
+	entry.size = int64(MaxArgs+1)*object.ObjectSize + int64(len(key.Encoded)) + approxSize(result) + int64(len(output))
+	c.entries[key] = c.lru.PushFront(entry)
+	c.stats.Entries++
+	c.stats.Bytes += entry.size
+	for c.stats.Entries > 1 && ((c.MaxEntries > 0 && c.stats.Entries > c.MaxEntries) ||
+		(c.MaxBytes > 0 && c.stats.Bytes > c.MaxBytes)) {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache) remove(e *list.Element) {
+	if e == nil {
+		return
+	}
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.stats.Entries--
+	c.stats.Bytes -= entry.size
+}
+
+// Stats returns the cache's counters and size.
+func (c *Cache) Stats() CacheStats {
+	return c.stats
+}
+
+// Reset removes all the entries and resets the counters, keeping the limits and functions
+// marked with SetMemoize.
+func (c *Cache) Reset() {
+	c.removeAll()
+	c.stats = CacheStats{}
+}
+
+func (c *Cache) removeAll() {
+	clear(c.entries)
+	c.lru.Init()
+	c.stats.Entries, c.stats.Bytes = 0, 0
+}
+
+// Clear removes the entries of the function (by its object.Function CacheKey) and returns how many.
+func (c *Cache) Clear(fn string) int {
+	n := 0
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*cacheEntry).key.Fn == fn {
+			c.remove(e)
+			n++
 		}
-		key.Args[i] = v
+		e = next
 	}
-	c[key] = CacheValue{Result: result, Output: output}
+	return n
+}
+
+// SetMemoize sets whether the function's results are memoized (the default). Functions calling
+// non memoized ones aren't memoized either, like for extensions with DontCache, so turning it
+// off clears all the entries (not just the function's).
+func (c *Cache) SetMemoize(fn string, memoize bool) {
+	if memoize {
+		delete(c.noMemo, fn)
+		return
+	}
+	c.noMemo[fn] = true
+	c.removeAll()
+}
+
+// Memoized returns false for the functions on which SetMemoize(fn, false) was called.
+func (c *Cache) Memoized(fn string) bool {
+	return !c.noMemo[fn]
 }
@@ -1,6 +1,7 @@
 package eval_test
 
 import (
+	"strings"
 	"testing"
 
 	"grol.io/grol/eval"
@@ -28,3 +29,72 @@ func TestBigArrayNotHashable(t *testing.T) {
 	c := eval.NewCache()
 	c.Get("func(){}", []object.Object{a})
 }
+
+func TestCacheEviction(t *testing.T) {
+	c := eval.NewCache()
+	c.MaxEntries = 2
+	one := []object.Object{object.Integer{Value: 1}}
+	two := []object.Object{object.Integer{Value: 2}}
+	c.Set("f", one, object.Integer{Value: 10}, nil)
+	c.Set("f", two, object.Integer{Value: 20}, nil)
+	if _, _, ok := c.Get("f", one); !ok { // now the most recently used.
+		t.Fatalf("expected f(1) to be cached")
+	}
+	c.Set("g", one, object.Integer{Value: 30}, nil)
+	if _, _, ok := c.Get("f", two); ok {
+		t.Errorf("expected least recently used f(2) to be evicted")
+	}
+	if v, _, ok := c.Get("f", one); !ok || v.Inspect() != "10" {
+		t.Errorf("expected f(1) to still be cached, got %v %v", v, ok)
+	}
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Evictions != 1 || stats.Entries != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if n := c.Clear("f"); n != 1 || c.Stats().Entries != 1 {
+		t.Errorf("expected to clear 1 entry of f, got %d, %+v", n, c.Stats())
+	}
+	c.MaxEntries = 0
+	c.MaxBytes = 1000
+	big := object.String{Value: strings.Repeat("x", 800)}
+	c.Set("h", one, big, nil)
+	c.Set("h", two, big, nil)
+	if stats = c.Stats(); stats.Entries != 1 || stats.Bytes > 1000 {
+		t.Errorf("expected the size limit to keep 1 entry, got %+v", stats)
+	}
+	c.SetMemoize("h", false)
+	if c.Memoized("h") || c.Stats().Entries != 0 {
+		t.Errorf("expected h to not be memoized and the entries cleared, got %+v", c.Stats())
+	}
+}
+
+func TestMemoFunctions(t *testing.T) {
+	s := eval.NewState()
+	s.Cache().MaxEntries = 10
+	res, err := eval.EvalString(s, `func fib(n) { if n <= 1 { return n } fib(n-1) + fib(n-2) }
+fib(50); [info.cache.entries, info.cache.evictions > 0]`, false)
+	if err != nil || res.Inspect() != "[10,true]" {
+		t.Errorf("unexpected result %s %v", res.Inspect(), err)
+	}
+	res, err = eval.EvalString(s, `func sq(x) { x * x }; func sum(x) { sq(x) + 1 }
+memo.clear(); sq(2); sum(3); a := [memo.clear(sq), info.cache.entries]
+memo.off(sq); a = a + [info.cache.entries, sq(2), sum(3), info.cache.entries]
+memo.on(sq); sq(2); a + [info.cache.entries]`, false)
+	if err != nil || res.Inspect() != "[2,1,0,4,10,0,1]" {
+		t.Errorf("unexpected result %s %v", res.Inspect(), err)
+	}
+}
+
+func TestMemoizeStructuralArgs(t *testing.T) {
+	s := eval.NewState()
+	res, err := eval.EvalString(s, `func f(a) { len(a) }; func g(a, b, c, d, e) { a + b + c + d + e }
+func apply(fn, x) { fn(x) }; func sq(x) { x * x }; func adder(n) { x => x + n }
+a := [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]; m := {"a": a, "b": 2, "c": 3, "d": 4, "e": 5}
+f(a); f([1, 2, 3, 4, 5, 6, 7, 8, 9, 10]); f(m); f(m); g(1, 2, 3, 4, 5); g(1, 2, 3, 4, 5); apply(sq, 3); apply(sq, 3)
+r := [apply(adder(1), 3), apply(adder(2), 3)]
+[info.cache.hits, info.cache.entries, r]`, false)
+	// closures aren't memoized (adder's result depends on n).
+	if err != nil || res.Inspect() != "[4,5,[4,5]]" {
+		t.Errorf("unexpected result %s %v", res.Inspect(), err)
+	}
+}
@@ -0,0 +1,259 @@
+package eval
+
+// AST optimization pass run on programs before their evaluation (see Optimize).
+
+import (
+	"math"
+	"strconv"
+
+	"grol.io/grol/ast"
+	"grol.io/grol/object"
+	"grol.io/grol/token"
+)
+
+// extensionRef is an identifier (or namespaced ns.name) in call position resolved by Optimize to
+// the extension it refers to, extensions taking precedence over variables in evalIdentifier.
+type extensionRef struct {
+	ast.Node // the original identifier or dot expression, for printing and errors.
+	ext      object.Extension
+}
+
+// foldable are the operators evaluated at optimization time when both operands are constants.
+var foldable = map[token.Type]bool{
+	token.PLUS: true, token.MINUS: true, token.ASTERISK: true, token.SLASH: true, token.PERCENT: true,
+	token.BITAND: true, token.BITOR: true, token.BITXOR: true, token.LEFTSHIFT: true, token.RIGHTSHIFT: true,
+	token.LT: true, token.GT: true, token.LTEQ: true, token.GTEQ: true, token.EQ: true, token.NOTEQ: true,
+	token.AND: true, token.OR: true,
+}
+
+type optimizer struct {
+	s      *State
+	folder *State                // blank state to evaluate constant expressions without affecting s's quotas.
+	asIs   map[ast.Base]ast.Node // quote() and del() calls, which use their argument's node, are kept as written.
+}
+
+// Optimize returns the program with constant arithmetic, comparisons and string concatenations
+// folded, `if` on literal booleans replaced by the taken branch, nested blocks flattened and
+// extensions called by name resolved ahead of time. Expressions whose evaluation errors are left
+// for the evaluator to report. The results of the optimized program are the same, only the printed
+// form of its functions (and the evaluation steps) may differ.
+func (s *State) Optimize(program ast.Node) ast.Node {
+	o := &optimizer{s: s, folder: NewBlankState()}
+	ast.Walk(program, func(n ast.Node) bool {
+		if b, ok := n.(*ast.Builtin); ok && (b.Type() == token.QUOTE || b.Type() == token.DEL) {
+			if o.asIs == nil {
+				o.asIs = make(map[ast.Base]ast.Node)
+			}
+			o.asIs[b.Base] = b
+			return false
+		}
+		return true
+	})
+	return ast.ModifyNoOk(program, o.optimize)
+}
+
+func (o *optimizer) optimize(node ast.Node) ast.Node {
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		if !foldable[node.Type()] {
+			return node
+		}
+		left, lok := constant(node.Left)
+		right, rok := constant(node.Right)
+		if !lok || !rok {
+			return node
+		}
+		res := o.folder.evalInfixExpression(node.Type(), left, right)
+		if str, ok := res.(object.String); ok && len(str.Value) > object.Len(left)+object.Len(right) {
+			return node // only concatenations, not repetitions which could be huge.
+		}
+		return literal(node, res)
+	case *ast.PrefixExpression:
+		right, ok := constant(node.Right)
+		if !ok {
+			return node
+		}
+		switch node.Type() {
+		case token.BANG, token.BITNOT, token.BITXOR:
+			return literal(node, o.folder.evalPrefixExpression(node.Type(), right))
+		case token.MINUS:
+			if _, isPrefix := node.Right.(*ast.PrefixExpression); isPrefix { // - -x
+				return literal(node, o.folder.evalPrefixExpression(node.Type(), right))
+			}
+		}
+		return node
+	case *ast.IfExpression:
+		// A single expression taken branch replaces the if, blocks are inlined by the enclosing statements.
+		block, ok := takenBranch(node)
+		if !ok || block == nil || len(block.Statements) != 1 {
+			return node
+		}
+		switch block.Statements[0].(type) {
+		case *ast.ReturnStatement, *ast.ControlExpression, *ast.Comment:
+			return node
+		}
+		return block.Statements[0]
+	case *ast.Statements:
+		return flatten(node)
+	case *ast.CallExpression:
+		if ext, ok := o.extension(node.Function); ok {
+			node.Function = &extensionRef{Node: node.Function, ext: ext}
+		}
+		return node
+	case *ast.Builtin:
+		if b, ok := o.asIs[node.Base]; ok {
+			return b
+		}
+	}
+	return node
+}
+
+// extension returns the extension a call's function refers to, if any.
+func (o *optimizer) extension(fn ast.Node) (object.Extension, bool) {
+	name := ""
+	switch fn := fn.(type) {
+	case *ast.Identifier:
+		name = fn.Literal()
+	case *ast.IndexExpression:
+		if fn.Value().Type() != token.DOT {
+			return object.Extension{}, false
+		}
+		name = fn.Left.Value().Literal() + "." + fn.Index.Value().Literal()
+	default:
+		return object.Extension{}, false
+	}
+	ext, ok := o.s.Extensions[name]
+	return ext, ok
+}
+
+// takenBranch returns the block an if on a literal boolean evaluates (nil for a false one without else).
+func takenBranch(node *ast.IfExpression) (*ast.Statements, bool) {
+	b, ok := node.Condition.(*ast.Boolean)
+	if !ok {
+		return nil, false
+	}
+	if b.Val {
+		return node.Consequence, true
+	}
+	return node.Alternative, true
+}
+
+// flatten inlines the nested blocks and the taken branch of ifs on literal booleans. The last
+// statement is only inlined when that doesn't change the value of the block: when it ends with
+// a statement that isn't a comment (comments don't change the value, so an empty block or one of
+// comments evaluates to nil while the inlined version would be the previous statement's value).
+func flatten(node *ast.Statements) *ast.Statements {
+	last := len(node.Statements) - 1
+	for last >= 0 && isComment(node.Statements[last]) {
+		last--
+	}
+	inlined := false
+	statements := make([]ast.Node, 0, len(node.Statements))
+	for i, st := range node.Statements {
+		block, ok := st.(*ast.Statements)
+		if ifExpr, isIf := st.(*ast.IfExpression); isIf {
+			block, ok = takenBranch(ifExpr)
+		}
+		if ok && i == last && (block == nil || len(block.Statements) == 0 || isComment(block.Statements[len(block.Statements)-1])) {
+			ok = false
+		}
+		if !ok {
+			statements = append(statements, st)
+			continue
+		}
+		inlined = true
+		if block != nil {
+			statements = append(statements, block.Statements...)
+		}
+	}
+	if !inlined {
+		return node
+	}
+	node.Statements = statements
+	return node
+}
+
+// constant returns the value of literal nodes (including negative numbers).
+func constant(node ast.Node) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return object.Integer{Value: node.Val}, true
+	case *ast.BigIntLiteral:
+		return object.BigInt{Value: node.Val}, true
+	case *ast.FloatLiteral:
+		return object.Float{Value: node.Val}, true
+	case *ast.StringLiteral:
+		return object.String{Value: node.Literal()}, true
+	case *ast.Boolean:
+		return object.NativeBoolToBooleanObject(node.Val), true
+	case *ast.PrefixExpression:
+		if node.Type() != token.MINUS {
+			return nil, false
+		}
+		switch v := node.Right.(type) {
+		case *ast.IntegerLiteral:
+			return object.Integer{Value: -v.Val}, true
+		case *ast.FloatLiteral:
+			return object.Float{Value: -v.Val}, true
+		}
+	}
+	return nil, false
+}
+
+// literal returns the node for the folded value res of the expression node, or node itself
+// when the value can't be written as a literal (errors, infinities,...). Negative numbers are
+// the minus prefix on the absolute value, which is how they are parsed.
+func literal(node ast.Node, res object.Object) ast.Node {
+	base := ast.Base{Pos: node.Position()}
+	negative := false
+	var lit ast.Node
+	switch v := res.(type) {
+	case object.Integer:
+		if v.Value == math.MinInt64 {
+			return node
+		}
+		negative = v.Value < 0
+		base.Token = token.Intern(token.INT, strconv.FormatInt(abs(v.Value), 10))
+		lit = &ast.IntegerLiteral{Base: base, Val: abs(v.Value)}
+	case object.Float:
+		if math.IsInf(v.Value, 0) || math.IsNaN(v.Value) {
+			return node
+		}
+		negative = math.Signbit(v.Value)
+		f := math.Abs(v.Value)
+		str := strconv.FormatFloat(f, 'f', -1, 64)
+		if _, err := strconv.ParseInt(str, 10, 64); err == nil {
+			str += ".0" // so it's read back as a float.
+		}
+		base.Token = token.Intern(token.FLOAT, str)
+		lit = &ast.FloatLiteral{Base: base, Val: f}
+	case object.BigInt:
+		if v.Value.Sign() < 0 {
+			return node
+		}
+		base.Token = token.Intern(token.INT, v.Value.String())
+		lit = &ast.BigIntLiteral{Base: base, Val: v.Value}
+	case object.String:
+		base.Token = token.Intern(token.STRING, v.Value)
+		lit = &ast.StringLiteral{Base: base}
+	case object.Boolean:
+		base.Token = token.FALSET
+		if v.Value {
+			base.Token = token.TRUET
+		}
+		lit = &ast.Boolean{Base: base, Val: v.Value}
+	default:
+		return node
+	}
+	if !negative {
+		return lit
+	}
+	return &ast.PrefixExpression{Base: ast.Base{Token: token.ByType(token.MINUS), Pos: base.Pos}, Right: lit}
+}
+
+func abs(i int64) int64 {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
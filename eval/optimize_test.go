@@ -0,0 +1,89 @@
+package eval
+
+import (
+	"testing"
+
+	"grol.io/grol/ast"
+	"grol.io/grol/object"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // compact form of the optimized program.
+	}{
+		{`1+2*3-10`, `-3`},
+		{`x+2*3`, `x+6`},
+		{`-(-5)`, `5`},
+		{`2.5*2`, `5.0`},
+		{`1-2.5`, `-1.5`},
+		{`"a"+"b"+"c"`, `"abc"`},
+		{`"ab"*3`, `"ab"*3`}, // repetitions aren't folded.
+		{`1/0`, `1/0`},       // errors are left for the evaluation.
+		{`9223372036854775807+1`, `9223372036854775808`},
+		{`1<2 && !false`, `true`},
+		{`x==1 || 2>=3`, `x==1||false`},
+		{`^5 & 0xf`, `10`},
+		{`if true {println(1+1)} else {2}`, `println(2)`},
+		{`if false {1} else {x=3;x}`, `x=3 x`},
+		{`if false {1}; 4`, `4`},
+		{`f=func(){if true {a:=1;a+1}}`, `f=func(){a:=1 a+1}`},
+		{`f=func(){if 1<2 {return 3}}`, `f=func(){return 3}`},
+		{`f=func(){if false {1}}`, `f=func(){if false{1}}`}, // would otherwise be the previous statement's value.
+		{`quote(1+2)`, `quote(1+2)`},
+		{`del(1+2)`, `del(1+2)`},
+		{`double(1+1)`, `double(2)`},
+	}
+	for _, tt := range tests {
+		s := NewState()
+		s.Extensions["double"] = object.Extension{
+			Name: "double", MinArgs: 1, MaxArgs: 1, ArgTypes: []object.Type{object.INTEGER},
+			Callback: func(_ any, _ string, args []object.Object) object.Object {
+				return object.Integer{Value: 2 * args[0].(object.Integer).Value}
+			},
+		}
+		program := testParseProgram(t, tt.input)
+		optimized := s.Optimize(program)
+		ps := ast.NewPrintState()
+		ps.Compact = true
+		if got := optimized.PrettyPrint(ps).String(); got != tt.expected {
+			t.Errorf("Optimize(%s) got %s, expected %s", tt.input, got, tt.expected)
+		}
+		if got := ast.DebugString(testParseProgram(t, tt.input)); got != ast.DebugString(program) {
+			t.Errorf("Optimize(%s) changed its input to %s", tt.input, got)
+		}
+	}
+}
+
+func TestOptimizeSameResults(t *testing.T) {
+	inputs := []string{
+		`x=1; if true {x=2}; x`,
+		`f=func(n){if true {n+1-1} else {0}}; f(41)`,
+		`f=func(){if false {1}}; f()`,
+		`f=func(){a:=1; if true {}}; f()`,
+		`[1+2, 3.0*2, "a"+"b", 7%4, 1<<3, -(-1), !true]`,
+		`double(21)`,
+		`y=5; if (1>2) {y=6}; y`,
+		`f=func(n){if n<=1 {return 1}; n*f(n-1)}; f(20)`,
+	}
+	for _, input := range inputs {
+		var results [2]string
+		for i := range results {
+			s := NewState()
+			s.Extensions["double"] = object.Extension{
+				Name: "double", MinArgs: 1, MaxArgs: 1, ArgTypes: []object.Type{object.INTEGER},
+				Callback: func(_ any, _ string, args []object.Object) object.Object {
+					return object.Integer{Value: 2 * args[0].(object.Integer).Value}
+				},
+			}
+			var program ast.Node = testParseProgram(t, input)
+			if i == 1 {
+				program = s.Optimize(program)
+			}
+			results[i] = s.Eval(program).Inspect()
+		}
+		if results[0] != results[1] {
+			t.Errorf("%s: optimized got %s, expected %s", input, results[1], results[0])
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package eval
+
+import (
+	"math"
+	"sync/atomic"
+
+	"grol.io/grol/object"
+)
+
+// Usage is the resources used by a state, checked against its MaxSteps, MaxAlloc and MaxOutput quotas.
+type Usage struct {
+	Steps  int64 // evaluation steps: nodes evaluated by the interpreter and instructions run by the VM.
+	Alloc  int64 // approximate bytes of strings, arrays and maps created, only counted when MaxAlloc is set.
+	Output int64 // bytes printed (print, println, log and replays of memoized output).
+}
+
+// usageCounters are the resources used by a state and the goroutines it started (see Go), which
+// share them so the quotas apply to all of them together.
+type usageCounters struct {
+	steps, alloc, output atomic.Int64
+}
+
+// Usage returns the resources used since the state was created or ResetUsage was called,
+// including by the goroutines it started.
+func (s *State) Usage() Usage {
+	return Usage{Steps: s.usage.steps.Load(), Alloc: s.usage.alloc.Load(), Output: s.usage.output.Load()}
+}
+
+// ResetUsage resets the resources used, e.g. to reuse the state for another script with the same quotas.
+func (s *State) ResetUsage() {
+	s.usage.steps.Store(0)
+	s.usage.alloc.Store(0)
+	s.usage.output.Store(0)
+}
+
+// quotaError is the error of the kind for an exceeded quota. It can be caught like other errors
+// but the quota stays exceeded so the evaluation stops at the next step, allocation or print.
+func (s *State) quotaError(kind, what string, limit int64) object.Error {
+	return s.KindErrorf(kind, "%s quota of %d exceeded", what, limit)
+}
+
+// checkSteps returns the steps quota error if it's exceeded.
+func (s *State) checkSteps() *object.Error {
+	if s.MaxSteps <= 0 || s.usage.steps.Load() <= s.MaxSteps {
+		return nil
+	}
+	e := s.quotaError(object.StepsQuotaError, "steps", s.MaxSteps)
+	return &e
+}
+
+// allocated accounts for the newly created string, array or map o, returning o or the error if
+// the allocation quota is exceeded (or o itself is an error).
+func (s *State) allocated(o object.Object) object.Object {
+	if s.MaxAlloc <= 0 {
+		return o
+	}
+	size := approxSize(o)
+	if size == 0 {
+		return o
+	}
+	if s.charge(size) > s.MaxAlloc {
+		return s.quotaError(object.AllocQuotaError, "allocation", s.MaxAlloc)
+	}
+	return o
+}
+
+// allocating returns the allocation quota error if creating a string, array or map of about size
+// bytes would exceed it. It's checked before building repetitions and concatenations so that
+// e.g. "x" * 1e12 fails without first allocating; allocated then accounts for the actual result.
+func (s *State) allocating(size int64) *object.Error {
+	if s.MaxAlloc <= 0 || size <= s.MaxAlloc-s.usage.alloc.Load() {
+		return nil
+	}
+	s.charge(size) // like allocated does, so the quota stays exceeded.
+	e := s.quotaError(object.AllocQuotaError, "allocation", s.MaxAlloc)
+	return &e
+}
+
+// charge adds size to the allocations, saturating at math.MaxInt64, and returns the new total.
+func (s *State) charge(size int64) int64 {
+	for {
+		cur := s.usage.alloc.Load()
+		total := cur + size
+		if size > math.MaxInt64-cur {
+			total = math.MaxInt64
+		}
+		if s.usage.alloc.CompareAndSwap(cur, total) {
+			return total
+		}
+	}
+}
+
+// repeatSize is the size of n repetitions of size bytes, saturated at math.MaxInt64 on overflow.
+func repeatSize(size, n int64) int64 {
+	if n != 0 && size > math.MaxInt64/n {
+		return math.MaxInt64
+	}
+	return size * n
+}
+
+// approxSize is the approximate size in bytes of the string, array or map itself (not counting
+// the values it contains, accounted for when they were created) and 0 for other objects.
+func approxSize(o object.Object) int64 {
+	switch v := o.(type) {
+	case object.String:
+		return int64(len(v.Value))
+	case object.Map:
+		return 2 * int64(v.Len()) * object.ObjectSize // keys and values.
+	}
+	if o.Type() == object.ARRAY {
+		return int64(object.Len(o)) * object.ObjectSize
+	}
+	return 0
+}
+
+// output accounts for n bytes about to be printed, returning the error if it would exceed the quota.
+func (s *State) output(n int) *object.Error {
+	for {
+		cur := s.usage.output.Load()
+		if s.MaxOutput > 0 && cur+int64(n) > s.MaxOutput {
+			e := s.quotaError(object.OutputQuotaError, "output", s.MaxOutput)
+			return &e
+		}
+		if s.usage.output.CompareAndSwap(cur, cur+int64(n)) {
+			return nil
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package eval
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 
 	"fortio.org/log"
+	"grol.io/grol/ast"
 	"grol.io/grol/object"
 )
 
@@ -47,7 +51,44 @@ func (s *State) NewError(msg string) object.Error {
 }
 
 func (s *State) ErrorAddStack(e object.Error) object.Error {
-	return object.Error{Value: e.Value, Stack: s.Stack()}
+	e.Stack = s.Stack()
+	return e
+}
+
+// locateError records, unless already known, where in the source the error occurred;
+// the first node (through Eval or statements) that sees the error bubbling up is the innermost
+// one that produced it.
+func (s *State) locateError(e object.Error, node any) object.Error {
+	if e.Pos.IsValid() {
+		return e
+	}
+	n, ok := node.(ast.Node)
+	if !ok {
+		return e
+	}
+	e.Pos = n.Position()
+	if !e.Pos.IsValid() {
+		return e
+	}
+	e.File = s.CurrentFile
+	if e.Pos.Line <= len(s.sourceLines) {
+		e.Source = s.sourceLines[e.Pos.Line-1]
+	}
+	return e
+}
+
+// located is locateError for an object known to be an error and errorObject returns *e as an
+// Object. They are kept out of line so the stack frames of the recursive evaluation functions
+// don't hold copies of the (large) object.Error, which would lower the reachable depth.
+//
+//go:noinline
+func (s *State) located(o object.Object, node any) object.Object {
+	return s.locateError(o.(object.Error), node)
+}
+
+//go:noinline
+func errorObject(e *object.Error) object.Object {
+	return *e
 }
 
 // Errorf formats and create an object.Error using given format and args.
@@ -55,17 +96,54 @@ func (s *State) Errorf(format string, args ...any) object.Error {
 	return s.NewError(fmt.Sprintf(format, args...))
 }
 
+// KindErrorf is Errorf for an error of the kind (e.g. object.TypeError).
+func (s *State) KindErrorf(kind, format string, args ...any) object.Error {
+	e := s.Errorf(format, args...)
+	e.Kind = kind
+	return e
+}
+
 // Errorfp formats and create an *object.Error using given format and args.
 func (s *State) Errorfp(format string, args ...any) *object.Error {
 	e := s.Errorf(format, args...)
 	return &e
 }
 
-// Error converts from a go error to an object.Error.
+// Error converts from a go error to an object.Error, with the kind of error when it can be told
+// (e.g. object.IOError for file errors) and the object.Error it wraps, if any, as Cause.
 // If the error is nil, it returns object.NULL instead (no error).
 func (s *State) Error(err error) object.Object {
 	if err == nil {
 		return object.NULL
 	}
-	return s.NewError(err.Error())
+	if e, ok := err.(*object.Error); ok { // already one, e.g. parsing error from EvalString.
+		return s.ErrorAddStack(*e)
+	}
+	return withCause(s.NewError(err.Error()), err)
+}
+
+// withCause sets the kind and cause of e from the go error err it was created from.
+func withCause(e object.Error, err error) object.Error {
+	var cause *object.Error
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &cause):
+		c := *cause
+		e.Kind, e.Cause = c.Kind, &c
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		e.Kind = object.TimeoutError
+	case errors.As(err, &pathErr), errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrPermission):
+		e.Kind = object.IOError
+	}
+	return e
 }
+
+// evalError is the go error returned along with error objects (e.g. by EvalString), errors.As
+// retrieves the *object.Error from it.
+type evalError struct {
+	prefix string
+	err    object.Error
+}
+
+func (e evalError) Error() string { return e.prefix + e.err.Inspect() }
+func (e evalError) Unwrap() error { return &e.err }
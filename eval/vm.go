@@ -0,0 +1,531 @@
+package eval
+
+// Virtual machine running the bytecode produced by compile.go.
+
+import (
+	"fortio.org/log"
+	"grol.io/grol/ast"
+	"grol.io/grol/object"
+	"grol.io/grol/token"
+)
+
+// frame is the state of one call of a compiled function.
+type frame struct {
+	code    *code
+	env     *object.Environment
+	slots   []object.Object // nil for not (yet) set.
+	ints    []int64         // loops state.
+	version int64           // env.Updates() slots are in sync with.
+}
+
+type handler struct {
+	pc, sp int
+}
+
+// newFrame is extendFunctionEnv's equivalent for compiled functions.
+func (s *State) newFrame(name string, fn object.Function, c *code, args []object.Object) (*frame, *object.Error) {
+	env, _ := object.NewFunctionEnvironment(fn, s.env)
+	fr := &frame{code: c, env: env, slots: make([]object.Object, len(c.slots))}
+	if c.numInts > 0 {
+		fr.ints = make([]int64, c.numInts)
+	}
+	if oerr := s.bindFrame(fr, name, fn, args); oerr != nil {
+		return nil, oerr
+	}
+	return fr, nil
+}
+
+// bindFrame sets the parameters of the frame to the arguments, for a new call or for a tail call
+// reusing the frame (the other slots then keep their values, like the environment does).
+func (s *State) bindFrame(fr *frame, name string, fn object.Function, args []object.Object) *object.Error {
+	_, args, extra, oerr := s.functionArgs(name, fn, args)
+	if oerr != nil {
+		return oerr
+	}
+	for i, slot := range fr.code.params {
+		// By definition function parameters are local copies, deref argument values:
+		fr.defineSlot(slot, args[i])
+	}
+	if fn.Variadic {
+		fr.env.SetNoChecks("..", object.NewArray(extra), true)
+	}
+	return nil
+}
+
+// run is Eval's equivalent for compiled functions.
+func (s *State) run(fr *frame) object.Object {
+	if s.depth > s.MaxDepth {
+		return s.depthError()
+	}
+	if s.Context != nil && s.Context.Err() != nil {
+		return s.Error(s.Context.Err())
+	}
+	if oerr := s.checkSteps(); oerr != nil {
+		return *oerr
+	}
+	s.depth++
+	res := s.exec(fr)
+	s.depth--
+	return res
+}
+
+// reload resyncs the slots with the environment when another environment (a recursive call)
+// changed or deleted some of our variables.
+func (fr *frame) reload() {
+	v := fr.env.Updates()
+	if v == fr.version {
+		return
+	}
+	fr.version = v
+	for k, si := range fr.code.slots {
+		if !si.mirror || fr.slots[k] == nil {
+			continue
+		}
+		if val, ok := fr.env.GetLocal(si.name); ok {
+			fr.slots[k] = object.Value(val)
+		} else {
+			fr.slots[k] = nil
+		}
+	}
+}
+
+// defineSlot is := for slots.
+func (fr *frame) defineSlot(k int, v object.Object) object.Object {
+	v = object.Value(v)
+	fr.slots[k] = v
+	if si := fr.code.slots[k]; si.mirror {
+		fr.env.SetNoChecks(si.name, v, true)
+	}
+	return v
+}
+
+// setSlot is = for slots, going through the environment while not yet defined (outer variable).
+func (s *State) setSlot(fr *frame, k int, v object.Object) object.Object {
+	if fr.slots[k] == nil {
+		return s.env.CreateOrSet(fr.code.slots[k].name, v, false)
+	}
+	return fr.defineSlot(k, v)
+}
+
+// getSlot returns the slot's value, or looks it up while not yet set.
+func (s *State) getSlot(fr *frame, k int, node int32, raw bool) object.Object {
+	if v := fr.slots[k]; v != nil {
+		return v
+	}
+	v := s.evalIdentifier(fr.code.nodes[node].(*ast.Identifier))
+	if ref, ok := v.(object.Reference); ok && !raw {
+		return ref.ObjValue()
+	}
+	return v
+}
+
+// startCounting sets up the integer loop state, same checks as evalForInteger.
+func (s *State) startCounting(fr *frame, lp *loopInfo, mode, start, end, incr int64) object.Object {
+	if incr == 0 {
+		return s.Errorf("for loop with zero increment")
+	}
+	if incr > 0 && end < start {
+		return s.Errorf("for loop with negative count [%d,%d[ incr %d", start, end, incr)
+	}
+	if incr < 0 && end > start {
+		return s.Errorf("for loop with positive range but negative increment [%d,%d[ incr %d", start, end, incr)
+	}
+	st := fr.ints[lp.ints : lp.ints+loopInts]
+	st[loopMode] = mode
+	st[loopCur] = start
+	st[loopEnd] = end
+	st[loopIncr] = incr
+	st[loopStarted] = 0
+	fr.slots[lp.last] = object.NULL
+	return nil
+}
+
+func (s *State) postfixSlot(fr *frame, k int, node *ast.PostfixExpression) object.Object {
+	val := fr.slots[k]
+	if val == nil {
+		return s.evalPostfixExpression(node)
+	}
+	nv := s.postfixValue(node.Type(), val)
+	if nv.Type() == object.ERROR {
+		return nv
+	}
+	fr.defineSlot(k, nv)
+	return val
+}
+
+// setIndexSlot does slot[index] = value and slot[index] op= value.
+func (s *State) setIndexSlot(fr *frame, k int, op token.Type, index, value object.Object) object.Object {
+	name := fr.code.slots[k].name
+	base := fr.slots[k]
+	if base == nil {
+		b, ok := s.env.Get(name)
+		if !ok {
+			return s.Errorf("identifier not found: %s", name)
+		}
+		base = object.Value(b)
+	}
+	if op != 0 {
+		value = s.evalInfixExpression(op, s.evalIndexExpressionIdx(base, index), value)
+	}
+	newBase := s.evalIndexAssignmentValue(base, index, value, name)
+	if newBase.Type() == object.ERROR {
+		return newBase
+	}
+	if res := s.setSlot(fr, k, newBase); res.Type() == object.ERROR {
+		return res
+	}
+	return value
+}
+
+func (s *State) builtinValue(t token.Type, val object.Object, node ast.Node) object.Object {
+	switch t { //nolint:exhaustive // only the ones the compiler emits.
+	case token.CATCH:
+		return s.catchResult(val, node.(*ast.Builtin).Parameters[0])
+	case token.FIRST:
+		return object.First(val)
+	case token.REST:
+		return object.Rest(val)
+	default: // token.LEN
+		l := object.Len(val)
+		if l == -1 {
+			return s.KindErrorf(object.TypeError, "len: not supported on %s", val.Type())
+		}
+		return object.Integer{Value: int64(l)}
+	}
+}
+
+// exec runs the frame's code until return or error.
+func (s *State) exec(fr *frame) object.Object { //nolint:funlen,gocognit,gocyclo,maintidx // it's the VM main loop.
+	c := fr.code
+	instrs := c.instrs
+	slots := fr.slots
+	stack := make([]object.Object, 0, 16)
+	var handlers []handler
+	pc := 0
+	for {
+		in := &instrs[pc]
+		pc++
+		s.usage.steps.Add(1) // checked on calls and loop iterations.
+		var r object.Object
+		top := len(stack) - 1
+		switch in.op {
+		case opConst:
+			stack = append(stack, c.consts[in.a])
+			continue
+		case opPop:
+			stack = stack[:top]
+			continue
+		case opDeref:
+			if ref, ok := stack[top].(object.Reference); ok {
+				stack[top] = ref.ObjValue()
+			}
+			continue
+		case opSlot:
+			r = s.getSlot(fr, int(in.a), in.node, in.c != 0)
+		case opDefineSlot:
+			stack[top] = fr.defineSlot(int(in.a), stack[top])
+			continue
+		case opSetSlot:
+			r = s.setSlot(fr, int(in.a), stack[top])
+			stack = stack[:top]
+		case opCompoundSlot:
+			k := int(in.a)
+			cur := slots[k]
+			if cur == nil {
+				cur = s.evalIdentifier(c.nodes[in.node].(*ast.InfixExpression).Left.(*ast.Identifier))
+			}
+			compounded := s.evalInfixExpression(token.Type(in.c), cur, stack[top])
+			stack = stack[:top]
+			r = s.setSlot(fr, k, compounded)
+			if compounded.Type() == object.ERROR {
+				r = compounded
+			}
+		case opPostfixSlot:
+			r = s.postfixSlot(fr, int(in.a), c.nodes[in.node].(*ast.PostfixExpression))
+		case opSetIndexSlot:
+			r = s.setIndexSlot(fr, int(in.a), token.Type(in.c), stack[top], stack[top-1])
+			stack = stack[:top-1]
+		case opIdent:
+			r = s.evalIdentifier(c.nodes[in.node].(*ast.Identifier))
+			if ref, ok := r.(object.Reference); ok && in.c == 0 {
+				r = ref.ObjValue()
+			}
+		case opEval:
+			if in.c != 0 {
+				r = s.evalInternal(c.nodes[in.node])
+			} else {
+				r = s.Eval(c.nodes[in.node])
+			}
+			fr.reload()
+		case opAssign:
+			r = s.evalAssignment(stack[top], c.nodes[in.node].(*ast.InfixExpression))
+			stack = stack[:top]
+			fr.reload()
+		case opPrefix:
+			r = s.evalPrefixExpression(token.Type(in.c), stack[top])
+			stack = stack[:top]
+		case opInfix:
+			r = s.evalInfixExpression(token.Type(in.c), stack[top-1], stack[top])
+			stack = stack[:top-1]
+		case opJump:
+			pc = int(in.a)
+			continue
+		case opJumpIf:
+			if stack[top] == c.consts[in.b] {
+				pc = int(in.a)
+			}
+			continue
+		case opIf:
+			condition := object.Value(stack[top])
+			stack = stack[:top]
+			switch condition {
+			case object.TRUE:
+				continue
+			case object.FALSE, object.NULL:
+				pc = int(in.a)
+				continue
+			default:
+				if condition.Type() == object.ERROR { // same as the interpreter, keep its kind.
+					r = condition
+					break
+				}
+				r = s.NewError("condition is not a boolean: " + condition.Inspect())
+			}
+		case opTry:
+			handlers = append(handlers, handler{pc: int(in.a), sp: len(stack)})
+			continue
+		case opEndTry:
+			handlers = handlers[:len(handlers)-1]
+			continue
+		case opReturn:
+			res := stack[top]
+			if ref, ok := res.(object.Reference); ok {
+				res = ref.ObjValue()
+			}
+			return res
+		case opCall:
+			base := len(stack) - int(in.a) - 1
+			f := stack[base]
+			args := object.MakeObjectSlice(int(in.a))
+			for _, arg := range stack[base+1:] {
+				args = append(args, object.CopyRegister(arg))
+			}
+			stack = stack[:base]
+			if f.Type() == object.EXTENSION {
+				r = s.applyExtension(f.(object.Extension), args)
+			} else if in.c != 0 && s.selfCall(f) {
+				return tailCall{name: c.strs[in.b], args: args, node: c.nodes[in.node]}
+			} else {
+				r = s.applyFunction(c.strs[in.b], f, args)
+			}
+			fr.reload()
+		case opBuiltin:
+			r = s.builtinValue(token.Type(in.c), stack[top], c.nodes[in.node])
+			stack = stack[:top]
+		case opPrint:
+			base := len(stack) - int(in.a)
+			r = s.printLogError(c.nodes[in.node].Value().Type(), stack[base:])
+			stack = stack[:base]
+		case opLogCheck:
+			if log.GetLogLevel() >= log.Error {
+				stack[top] = object.NULL
+				pc = int(in.a)
+			}
+			continue
+		case opArray:
+			base := len(stack) - int(in.a)
+			elements := object.MakeObjectSlice(int(in.a))
+			for _, e := range stack[base:] {
+				elements = append(elements, object.CopyRegister(e))
+			}
+			stack = stack[:base]
+			r = s.allocated(object.NewArray(elements))
+		case opInterp:
+			base := len(stack) - int(in.a)
+			r = s.allocated(interpolate(stack[base:]))
+			stack = stack[:base]
+		case opCheckKey:
+			key := stack[top]
+			if object.Equals(key, key) {
+				continue
+			}
+			log.Warnf("key %s is not hashable", key.Inspect())
+			r = s.NewError("key " + key.Inspect() + " is not hashable")
+		case opMap:
+			base := len(stack) - 2*int(in.a)
+			result := object.NewMapSize(int(in.a))
+			for i := base; i < len(stack); i += 2 {
+				result = result.Set(object.Value(stack[i]), object.Value(stack[i+1]))
+			}
+			stack = stack[:base]
+			r = s.allocated(result)
+		case opDotExt:
+			if ext, ok := s.Extensions[c.strs[in.b]]; ok {
+				stack = append(stack, ext)
+				pc = int(in.a)
+			}
+			continue
+		case opIndex:
+			if in.c != 0 {
+				r = s.evalIndexExpression(stack[top], c.nodes[in.node].(*ast.IndexExpression))
+				stack = stack[:top]
+				fr.reload()
+			} else {
+				r = s.evalIndexExpressionIdx(stack[top-1], stack[top])
+				stack = stack[:top-1]
+			}
+		case opCheckInt:
+			v := object.Value(stack[top])
+			if _, ok := Int64Value(v); ok {
+				stack[top] = v
+				continue
+			}
+			stack = stack[:top]
+			r = s.NewError(c.strs[in.a] + v.Inspect())
+		case opLoopInit:
+			lp := &c.loops[in.a]
+			fr.ints[lp.ints+loopMode] = modeCond
+			slots[lp.last] = object.NULL
+			continue
+		case opLoopStart:
+			lp := &c.loops[in.a]
+			v := object.Value(stack[top])
+			stack = stack[:top]
+			slots[lp.last] = object.NULL
+			switch v.Type() { //nolint:exhaustive // other types use the condition loop.
+			case object.INTEGER:
+				r = s.startCounting(fr, lp, modeInt, 0, v.(object.Integer).Value, 1)
+				if r == nil {
+					pc = lp.next
+					continue
+				}
+			case object.ERROR:
+				r = v
+			case object.ARRAY, object.MAP, object.STRING:
+				fr.ints[lp.ints+loopMode] = modeList
+				slots[lp.list] = v
+				pc = lp.next
+				continue
+			default:
+				fr.ints[lp.ints+loopMode] = modeCond
+				pc = lp.cond
+				continue
+			}
+		case opLoopRange:
+			lp := &c.loops[in.a]
+			base := len(stack) - int(in.c)
+			start, _ := Int64Value(stack[base])
+			end, _ := Int64Value(stack[base+1])
+			incr := int64(1)
+			if in.c == 3 {
+				incr, _ = Int64Value(stack[base+2])
+			}
+			stack = stack[:base]
+			r = s.startCounting(fr, lp, modeInt, start, end, incr)
+			if r == nil {
+				pc = lp.next
+				continue
+			}
+		case opLoopCond:
+			lp := &c.loops[in.a]
+			condition := object.Value(stack[top])
+			stack = stack[:top]
+			switch condition {
+			case object.TRUE:
+				continue
+			case object.FALSE, object.NULL:
+				pc = lp.exit
+				continue
+			}
+			switch condition.Type() { //nolint:exhaustive // errors for the rest.
+			case object.ERROR:
+				r = condition
+			case object.INTEGER:
+				r = s.startCounting(fr, lp, modeCount, 0, condition.(object.Integer).Value, 1)
+				if r == nil {
+					pc = lp.next
+					continue
+				}
+			default:
+				r = s.NewError("for condition is not a boolean nor integer nor assignment: " + condition.Inspect())
+			}
+		case opLoopBody:
+			lp := &c.loops[in.a]
+			slots[lp.last] = stack[top]
+			stack = stack[:top]
+			pc = lp.next
+			continue
+		case opLoopNext:
+			if s.Context != nil && s.Context.Err() != nil {
+				r = s.Error(s.Context.Err())
+				break
+			}
+			if oerr := s.checkSteps(); oerr != nil {
+				r = *oerr
+				break
+			}
+			lp := &c.loops[in.a]
+			st := fr.ints[lp.ints : lp.ints+loopInts]
+			switch st[loopMode] {
+			case modeCond:
+				pc = lp.cond
+				continue
+			case modeList:
+				list := slots[lp.list]
+				if object.Len(list) == 0 {
+					pc = lp.exit
+					continue
+				}
+				v := object.First(list)
+				slots[lp.list] = object.Rest(list)
+				if v == nil {
+					r = s.NewError("for list element is nil")
+					break
+				}
+				fr.defineSlot(lp.varSlot, v)
+				pc = lp.body
+				continue
+			default:
+				if st[loopStarted] != 0 {
+					st[loopCur] += st[loopIncr]
+				} else {
+					st[loopStarted] = 1
+				}
+				if (st[loopIncr] > 0 && st[loopCur] >= st[loopEnd]) || (st[loopIncr] < 0 && st[loopCur] <= st[loopEnd]) {
+					pc = lp.exit
+					continue
+				}
+				if c.noReg && st[loopMode] == modeInt {
+					s.setSlot(fr, lp.varSlot, object.Integer{Value: st[loopCur]})
+				}
+				pc = lp.body
+				continue
+			}
+		case opLoopVar:
+			lp := &c.loops[in.a]
+			if fr.ints[lp.ints+loopMode] == modeInt {
+				r = object.Integer{Value: fr.ints[lp.ints+loopCur]}
+			} else {
+				r = s.getSlot(fr, int(in.b), in.node, in.c != 0)
+			}
+		case opLoopEnd:
+			stack = append(stack, slots[c.loops[in.a].last])
+			continue
+		}
+		if r.Type() != object.ERROR {
+			stack = append(stack, r)
+			continue
+		}
+		oerr := r.(object.Error)
+		if in.node >= 0 {
+			oerr = s.locateError(oerr, c.nodes[in.node])
+		}
+		if len(handlers) == 0 {
+			return oerr
+		}
+		h := handlers[len(handlers)-1]
+		handlers = handlers[:len(handlers)-1]
+		stack = append(stack[:h.sp], oerr)
+		pc = h.pc
+	}
+}
@@ -0,0 +1,117 @@
+package eval_test
+
+import (
+	"strings"
+	"testing"
+
+	"grol.io/grol/eval"
+	"grol.io/grol/object"
+)
+
+// runBoth evaluates the input with the tree walking interpreter and with the VM
+// and returns the results (with output and error if any) of each.
+func runBoth(t *testing.T, input string) (string, string) {
+	t.Helper()
+	results := [2]string{}
+	for i, vm := range []bool{false, true} {
+		s := eval.NewState()
+		s.VM = vm
+		out := strings.Builder{}
+		s.Out = &out
+		s.LogOut = &out
+		res, err := eval.EvalString(s, input, false)
+		results[i] = out.String() + res.Inspect()
+		if err != nil {
+			results[i] += " error: " + err.Error()
+		}
+	}
+	return results[0], results[1]
+}
+
+func TestVMMatchesTreeWalker(t *testing.T) {
+	tests := []string{
+		`fact = func(n) {if (n<2) {return 1} n*fact(n-1)}; fact(20)`,
+		`func fib(n) {if n <= 1 {return n}; fib(n-1) + fib(n-2)}; fib(20)`,
+		`func(n) {if n <= 1 {return 1}; n * self(n - 1)}(10)`,
+		`func f(a, b) {x := a*2; y := x + b; x++; [x, y]}; f(3, 4)`,
+		`func sum(n) {s := 0; for i := n {s = s + i}; s}; sum(100)`,
+		`func sum(n) {s := 0; for i = 1:n+1 {if i == 5 {continue}; if i > 8 {break}; s += i}; s}; sum(20)`,
+		`func f(l) {r := []; for x := l {r = r + [x*x]}; r}; f([1,2,3,4])`,
+		`func f(m) {r := 0; for kv := m {r += kv.value}; r}; f({"a":1, "b":2, "c":3})`,
+		`func f(n) {i := 0; for i < n {i++}; i}; f(7)`,
+		`func f(n) {i := 0; for {i++; if i >= n {break}}; i}; f(12)`,
+		`func f() {n := 0; for 3 {n++}; n}; f()`,
+		`func adder(n) {func(x) {x + n}}; a := adder(3); a(4)`,
+		`func counter() {c := 0; func() {c++; c}}; c := counter(); c(); c(); c()`,
+		`func f(n) {g := func() {n + 1}; n = 10; g()}; f(1)`,
+		`func f(a) {a[1] = 42; a}; f([1, 2, 3])`,
+		`func f() {m := {"a": 1}; m.b = 2; m["c"] = 3; [m.a, m.b, m.c, len(m)]}; f()`,
+		`func f(x) {x > 3 && x < 10 || x == 42}; [f(1), f(5), f(12), f(42)]`,
+		`func f(x) {if x {"yes"} else {"no"}}; [f(true), f(false)]`,
+		`func f(x) {catch(x / 0)}; f(1)`,
+		`func f(x) {catch(x + 1)}; f(1)`,
+		`func f(x) {x + y}; f(1)`,
+		`func f(x) {if x {1}}; f(3)`,
+		`func f(n) {println("n is", n); print(n, "\n"); n}; f(3)`,
+		`func f(n) {log("n is", n); n}; f(3)`,
+		`func f(l) {[len(l), first(l), rest(l)]}; f([1, 2, 3])`,
+		`func f(n) {for i := n {if i == 3 {return i * 10}}; -1}; f(10)`,
+		`func f(n) {x := 0; for i := n {x += i; i = i + 1}; x}; f(10)`,
+		`func f(n) {for i := n {func() {i}}}; f(3)`,
+		`func f(s) {r := ""; for c := s {r = c + r}; r}; f("hello")`,
+		`func f(n) {x := n; del(x); x}; f(2)`,
+		`func f(n) {if n == 0 {return 0}; f(n-1) + 1}; f(2000)`,
+		`func f(n) {m := {n: n*2, "k": [n, n+1]}; m[n] + m.k[1]}; f(4)`,
+		`func f(x) {v := x; v = v * 2; v = v + 1; v}; f(20)`,
+		`func f(x) {x++; x--; ++x; x}; f(1)`,
+		`func f(a, ..) {[a, len(..)]}; f(1, 2, 3)`,
+		`func f(x) {eval("x+1")}; f(1)`,
+		`func f(x) {s := 0; for i = 0:x {for j = 0:i {s += j}}; s}; f(10)`,
+		`func f() {a := [1, 2]; a[0] = 3; a[5]}; f()`,
+	}
+	for _, input := range tests {
+		tw, vm := runBoth(t, input)
+		if tw != vm {
+			t.Errorf("%s:\ntree walker: %s\nvm:          %s", input, tw, vm)
+		}
+	}
+}
+
+func TestVMMaxDepth(t *testing.T) {
+	s := eval.NewState()
+	s.VM = true
+	s.MaxDepth = 100
+	res, _ := eval.EvalString(s, `func f(n) {1+f(n+1)}; f(1)`, false)
+	if oerr, ok := res.(object.Error); !ok || oerr.Kind != object.DepthError || oerr.Value != "max depth 100 reached" {
+		t.Errorf("expected max depth error, got %s", res.Inspect())
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`func f(n, acc) {if n == 0 {return acc}; f(n-1, acc+1)}; f(10000, 0)`, "10000"},
+		{`func(n) {if n <= 0 {return "done"}; return self(n-1)}(10000)`, `"done"`},
+		{`l = []; for i = 1:1001 {l = l + [i]}; func f(l, acc) {if len(l) == 0 {acc} else {f(rest(l), acc+first(l))}}; f(l, 0)`, "500500"},
+		{`func f(n) {match n {0 {"zero"} _ {f(n-1)}}}; f(10000)`, `"zero"`},
+		{`func f(n, ..) {if n == 0 {return len(..)}; f(n-1, ..)}; f(10000, 1, 2, 3)`, "3"},
+		{`func f(n) {if n == 2 {x = 1}; if n == 1 {return x}; f(n-1)}; f(10000)`, "1"},
+		{`func f(n) {if n == 0 {return 0}; f(n-1, 2)}; f(10)`, "<err: wrong number of arguments for f. got=2, want=1>"},
+		{`forRec = func(n, f) {l = func(i, f) {r = f(i); if i >= n {return r}; l(i+1, f)}; l(1, f)}; forRec(10000, x => x*2)`, "20000"},
+		// Closures made in earlier iterations keep their own n (not a tail call then).
+		{`func f(n, acc) {if n == 0 {return acc}; f(n-1, acc+[func() {n}])}; fs := f(3, []); [fs[0](), fs[1](), fs[2]()]`, "[3,2,1]"},
+	}
+	for _, tt := range tests {
+		for _, vm := range []bool{false, true} {
+			s := eval.NewState()
+			s.VM = vm
+			s.MaxDepth = 100 // way less than the number of calls.
+			res, _ := eval.EvalString(s, tt.input, false)
+			if actual := res.Inspect(); actual != tt.expected {
+				t.Errorf("%s (vm %t): got %s, expected %s", tt.input, vm, actual, tt.expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package extensions
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"time"
+
+	"grol.io/grol/eval"
+	"grol.io/grol/object"
+)
+
+var (
+	objectType = reflect.TypeFor[object.Object]()
+	errorType  = reflect.TypeFor[error]()
+	stateType  = reflect.TypeFor[*eval.State]()
+	bigIntType = reflect.TypeFor[*big.Int]()
+	timeType   = reflect.TypeFor[time.Time]()
+)
+
+// Bind creates the grol function name calling the Go function fn, see [NewBinding] for the
+// supported signatures. For instance
+//
+//	extensions.Bind("repeat", strings.Repeat, "repeats the string n times")
+func Bind(name string, fn any, help string) error {
+	ext, err := NewBinding(name, fn, help)
+	if err != nil {
+		return err
+	}
+	return object.CreateFunction(ext)
+}
+
+// NewBinding returns the extension calling the Go function fn, with the number and types of
+// arguments derived from its signature, for callers that want to adjust it (Category, DontCache,...)
+// before creating it. Parameters and results are converted using object.ToGo and object.FromGo
+// (integers, floats, strings, bools, slices, maps, structs, big.Int, time.Time, pointers to
+// these, or object.Object passed as is); a first *eval.State parameter receives the interpreter state. Variadic functions
+// are variadic in grol too. A last error result, when not nil, becomes the grol error.
+func NewBinding(name string, fn any, help string) (object.Extension, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return object.Extension{}, fmt.Errorf("%s: not a function: %T", name, fn)
+	}
+	t := v.Type()
+	withState := t.NumIn() > 0 && t.In(0) == stateType
+	first := 0
+	if withState {
+		first = 1
+	}
+	numOut := t.NumOut()
+	returnsError := numOut > 0 && t.Out(numOut-1) == errorType
+	if returnsError {
+		numOut--
+	}
+	if numOut > 1 {
+		return object.Extension{}, fmt.Errorf("%s: too many results: %s", name, t)
+	}
+	ext := object.Extension{
+		Name:    name,
+		MinArgs: t.NumIn() - first,
+		MaxArgs: t.NumIn() - first,
+		Help:    help,
+	}
+	if t.IsVariadic() {
+		ext.MinArgs--
+		ext.MaxArgs = -1
+	}
+	for i := first; i < t.NumIn(); i++ {
+		in := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			in = in.Elem()
+		}
+		argType, err := grolType(in)
+		if err != nil {
+			return object.Extension{}, fmt.Errorf("%s: argument %d: %w", name, i-first+1, err)
+		}
+		ext.ArgTypes = append(ext.ArgTypes, argType)
+	}
+	ext.Callback = func(env any, _ string, args []object.Object) object.Object {
+		s := env.(*eval.State)
+		in := make([]reflect.Value, 0, first+len(args))
+		if withState {
+			in = append(in, reflect.ValueOf(s))
+		}
+		for i, arg := range args {
+			argType := t.In(min(first+i, t.NumIn()-1))
+			if t.IsVariadic() && first+i >= t.NumIn()-1 {
+				argType = argType.Elem()
+			}
+			goArg, err := object.ToGoValue(arg, argType)
+			if err != nil {
+				return s.Errorf("argument %d: %v", i+1, err)
+			}
+			in = append(in, goArg)
+		}
+		out := v.Call(in)
+		if returnsError && !out[len(out)-1].IsNil() {
+			return s.Error(out[len(out)-1].Interface().(error))
+		}
+		if numOut == 0 {
+			return object.NULL
+		}
+		res, err := object.FromGo(out[0].Interface())
+		if err != nil {
+			return s.Errorf("result: %v", err)
+		}
+		return res
+	}
+	return ext, nil
+}
+
+// grolType is the grol type of the Go type, used to check the arguments before calling.
+func grolType(t reflect.Type) (object.Type, error) {
+	switch {
+	case t == bigIntType || t == bigIntType.Elem():
+		return object.ANY, nil // integers too.
+	case t == timeType:
+		return object.ANY, nil // numbers or strings.
+	case t.Kind() == reflect.Interface:
+		if t.NumMethod() != 0 && t != objectType {
+			return object.UNKNOWN, fmt.Errorf("unsupported interface type %s", t)
+		}
+		return object.ANY, nil
+	}
+	switch t.Kind() { //nolint:exhaustive // others aren't supported.
+	case reflect.Bool:
+		return object.BOOLEAN, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object.INTEGER, nil
+	case reflect.Float32, reflect.Float64:
+		return object.FLOAT, nil
+	case reflect.String:
+		return object.STRING, nil
+	case reflect.Slice, reflect.Array:
+		return object.ARRAY, nil
+	case reflect.Map, reflect.Struct:
+		return object.MAP, nil
+	case reflect.Pointer:
+		return grolType(t.Elem())
+	}
+	return object.UNKNOWN, fmt.Errorf("unsupported type %s", t)
+}
@@ -0,0 +1,100 @@
+package extensions_test
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"grol.io/grol/eval"
+	"grol.io/grol/extensions"
+	"grol.io/grol/object"
+)
+
+type point struct {
+	X, Y   int
+	Label  string
+	hidden bool
+}
+
+func TestBind(t *testing.T) {
+	if err := extensions.Init(nil); err != nil {
+		t.Fatalf("extensions.Init: %v", err)
+	}
+	bindings := []struct {
+		name string
+		fn   any
+	}{
+		{"test.repeat", strings.Repeat},
+		{"test.sum", func(values ...float64) float64 {
+			total := 0.
+			for _, v := range values {
+				total += v
+			}
+			return total
+		}},
+		{"test.div", func(a, b int) (int, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		}},
+		{"test.move", func(p point, dx int) *point {
+			p.X += dx
+			return &p
+		}},
+		{"test.count", func(words []string) map[string]int {
+			res := make(map[string]int)
+			for _, w := range words {
+				res[w]++
+			}
+			return res
+		}},
+		{"test.big", func(b *big.Int) *big.Int { return new(big.Int).Mul(b, b) }},
+		{"test.depth", func(s *eval.State, o object.Object) string { return o.Type().String() + " " + s.CurrentFile }},
+		{"test.nothing", func(bool) {}},
+		{"test.year", func(t time.Time) int { return t.UTC().Year() }},
+	}
+	for _, b := range bindings {
+		if err := extensions.Bind(b.name, b.fn, "test binding"); err != nil {
+			t.Fatalf("Bind(%s): %v", b.name, err)
+		}
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`test.repeat("ab", 3)`, `"ababab"`},
+		{`test.repeat`, `test.repeat(string, integer) // test binding`},
+		{`test.sum(1, 2.5, 3)`, "6.5"},
+		{`test.sum()`, "0"},
+		{`test.div(7, 2)`, "3"},
+		{`test.div(7, 0)`, "<err: test.gr:1:9: division by zero>"},
+		{`test.div(7, "a")`, "<err: test.gr:1:9: wrong type of argument got=STRING, want " +
+			"test.div(integer, integer) // test binding>"},
+		{`test.move({"X": 1, "Y": 2, "Label": "p", "other": 0}, 10)`, `{"Label":"p","X":11,"Y":2}`},
+		{`test.move({"X": "a"}, 1)`, `<err: test.gr:1:10: argument 1: X: expected an integer, got STRING>`},
+		{`test.count(["a", "b", "a"])`, `{"a":2,"b":1}`},
+		{`test.big(99999999999999999999)`, "9999999999999999999800000000000000000001"},
+		{`test.depth([1])`, `"ARRAY test.gr"`},
+		{`test.nothing(true)`, "nil"},
+		{`test.year(0)`, "1970"},
+		{`test.year("2024-05-06T07:08:09Z")`, "2024"},
+	}
+	s := eval.NewState()
+	s.CurrentFile = "test.gr"
+	for _, tt := range tests {
+		res, _ := eval.EvalString(s, tt.input, false)
+		if actual := res.Inspect(); actual != tt.expected {
+			t.Errorf("%s: got %s, expected %s", tt.input, actual, tt.expected)
+		}
+	}
+	if err := extensions.Bind("test.bad", 42, ""); err == nil || err.Error() != "test.bad: not a function: int" {
+		t.Errorf("expected error for non function, got %v", err)
+	}
+	if err := extensions.Bind("test.bad", func(chan int) {}, ""); err == nil ||
+		err.Error() != "test.bad: argument 1: unsupported type chan int" {
+		t.Errorf("expected error for unsupported type, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package extensions
+
+import (
+	"slices"
+	"strings"
+
+	"grol.io/grol/eval"
+	"grol.io/grol/object"
+)
+
+// Builder creates interpreter states with their own set of extensions and configuration, so
+// different ones can be used in the same process, e.g. a sandboxed state without IOs next to a
+// trusted one with them:
+//
+//	sandbox := extensions.NewBuilder(nil).Without("read", "eof").WithoutCategory(object.CategoryImage)
+//	trusted := extensions.NewBuilder(&extensions.Config{HasLoad: true, HasSave: true, UnrestrictedIOs: true})
+//	s := sandbox.NewState()
+//
+// The extensions common to all configurations are the ones registered globally (by [Init], with
+// default values if it wasn't called, and [Bind] or object.CreateFunction).
+type Builder struct {
+	config     Config
+	without    []string // names, or namespaces ending with ".".
+	categories []string
+	added      object.ExtensionMap
+	caps       object.Capability
+}
+
+// NewBuilder returns a builder for states using the configuration, default (safe) values if c is nil.
+func NewBuilder(c *Config) *Builder {
+	b := &Builder{added: make(object.ExtensionMap), caps: object.CapAll}
+	if c != nil {
+		b.config = *c
+	}
+	return b
+}
+
+// Without removes the named extensions, or the whole namespace for names ending with a dot
+// (e.g. "image.").
+func (b *Builder) Without(names ...string) *Builder {
+	b.without = append(b.without, names...)
+	return b
+}
+
+// WithoutCategory removes the extensions of the categories (e.g. object.CategoryIO).
+func (b *Builder) WithoutCategory(categories ...string) *Builder {
+	b.categories = append(b.categories, categories...)
+	return b
+}
+
+// WithCapabilities sets the capabilities granted to the states (all by default), e.g.
+// object.CapAll &^ object.CapProcess. Extensions needing others stay listed (see info.disabled)
+// but calling them is an error.
+func (b *Builder) WithCapabilities(caps object.Capability) *Builder {
+	b.caps = caps
+	return b
+}
+
+// Add adds an extension only present in the states of this builder.
+func (b *Builder) Add(ext object.Extension) error {
+	return b.added.Add(ext)
+}
+
+// Extensions returns a new set of the extensions for this builder's configuration.
+func (b *Builder) Extensions() object.ExtensionMap {
+	_ = Init(nil) // no-op if already initialized, error would be from Init() itself.
+	res := object.ExtraFunctions()
+	// Replace the globally configured load, save, etc... by this configuration's.
+	all := Config{HasLoad: true, HasSave: true, HasImport: true, UnrestrictedIOs: true}
+	for _, ext := range all.functions() {
+		delete(res, ext.Name)
+	}
+	for _, ext := range b.config.functions() {
+		_ = res.Add(ext) // can't fail, they were just removed.
+	}
+	for name, ext := range b.added {
+		res[name] = ext
+	}
+	for name, ext := range res {
+		if b.excluded(name, ext.Category) {
+			delete(res, name)
+		}
+	}
+	return res
+}
+
+func (b *Builder) excluded(name, category string) bool {
+	if slices.Contains(b.categories, category) {
+		return true
+	}
+	for _, w := range b.without {
+		if name == w || (strings.HasSuffix(w, ".") && strings.HasPrefix(name, w)) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewState returns a new state using the builder's extensions, with its own images.
+func (b *Builder) NewState() *eval.State {
+	s := eval.NewState()
+	s.Extensions = b.Extensions()
+	s.Capabilities = b.caps
+	SetImages(s, make(ImageMap))
+	return s
+}
@@ -0,0 +1,71 @@
+package extensions_test
+
+import (
+	"strings"
+	"testing"
+
+	"grol.io/grol/eval"
+	"grol.io/grol/extensions"
+	"grol.io/grol/object"
+)
+
+func TestBuilder(t *testing.T) {
+	if err := extensions.Init(nil); err != nil {
+		t.Fatalf("extensions.Init: %v", err)
+	}
+	sandbox := extensions.NewBuilder(&extensions.Config{HasSave: true, LoadSaveEmptyOnly: true}).
+		Without("eof", "image.").WithoutCategory(object.CategoryTime)
+	err := sandbox.Add(object.Extension{
+		Name: "sandboxed", MinArgs: 0, MaxArgs: 0,
+		Callback: func(_ any, _ string, _ []object.Object) object.Object { return object.TRUE },
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	trusted := extensions.NewBuilder(&extensions.Config{HasLoad: true, HasImport: true, UnrestrictedIOs: true})
+	restricted := extensions.NewBuilder(&extensions.Config{HasLoad: true, UnrestrictedIOs: true}).
+		WithCapabilities(object.CapAll &^ (object.CapProcess | object.CapFSRead | object.CapTime))
+	tests := []struct {
+		builder  *extensions.Builder
+		input    string
+		expected string
+	}{
+		{sandbox, `sandboxed()`, "true"},
+		{sandbox, `round(2.6)`, "3"},
+		{sandbox, `eof()`, "<err: identifier not found: eof>"},
+		{sandbox, `time.now()`, "<err: identifier not found: time>"},
+		{sandbox, `image.new("a", 1, 1)`, "<err: identifier not found: image>"},
+		{sandbox, `exec("ls")`, "<err: identifier not found: exec>"},
+		{sandbox, `load()`, "<err: identifier not found: load>"},
+		{sandbox, `save("foo")`, `<err: empty only mode, filename must be empty or no arguments, got: "foo">`},
+		{trusted, `sandboxed()`, "<err: identifier not found: sandboxed>"},
+		{trusted, `image.new("a", 1, 1); image.size("a")`, `{"height":1,"width":1}`},
+		{trusted, `image.size("a")`, "nil"}, // each state has its own images.
+		{trusted, `save()`, "<err: identifier not found: save>"},
+		{trusted, `type(exec) + " " + type(import)`, `"EXTENSION EXTENSION"`},
+		{restricted, `exec("ls")`, "<err: exec: process capability not granted>"},
+		{restricted, `load()`, "<err: load: fs-read capability not granted>"},
+		{restricted, `catch(time.now()).kind`, `"capability"`},
+		{restricted, `round(rand(1))`, "0"},
+		{restricted, `info.capabilities`, `["fs-write","random","stdin"]`},
+		{restricted, `info.disabled`, `["deadline","exec","load","run","sleep","time.now","time.parse"]`},
+		{trusted, `info.disabled`, "[]"},
+	}
+	for _, tt := range tests {
+		s := tt.builder.NewState()
+		res, _ := eval.EvalString(s, tt.input, false)
+		if actual := res.Inspect(); actual != tt.expected {
+			t.Errorf("%s: got %s, expected %s", tt.input, actual, tt.expected)
+		}
+	}
+	for _, b := range []*extensions.Builder{sandbox, trusted} {
+		res, _ := eval.EvalString(b.NewState(), "info.gofuncs", false)
+		if listed, hasExec := strings.Contains(res.Inspect(), `"exec"`), b == trusted; listed != hasExec {
+			t.Errorf("info.gofuncs should list the state's extensions, got %s", res.Inspect())
+		}
+	}
+	// The default states are unaffected.
+	if _, found := eval.NewState().Extensions["sandboxed"]; found {
+		t.Errorf("builder extension leaked into the global ones")
+	}
+}
@@ -0,0 +1,147 @@
+package extensions
+
+import (
+	"context"
+
+	"grol.io/grol/eval"
+	"grol.io/grol/object"
+)
+
+// MaxChannelSize is the maximum number of values a channel can buffer.
+const MaxChannelSize = 1 << 20
+
+// stateContext returns the state's context, for blocking operations to stop when it's canceled.
+func stateContext(s *eval.State) context.Context {
+	if s.Context == nil {
+		return context.Background()
+	}
+	return s.Context
+}
+
+func createConcurrencyFunctions() { //nolint:funlen // this is a group of related functions.
+	fn := object.Extension{
+		Name:     "go",
+		MinArgs:  1,
+		MaxArgs:  -1,
+		ArgTypes: []object.Type{object.ANY},
+		Help: "calls the function with the remaining arguments in a new goroutine, with a copy of the globals," +
+			" returns the task to wait() for",
+		Category: object.CategoryConcurrency,
+		Callback: func(st any, _ string, args []object.Object) object.Object {
+			return st.(*eval.State).Go(args[0], args[1:]...)
+		},
+		DontCache: true,
+	}
+	MustCreate(fn)
+	fn.Name = "wait"
+	fn.MinArgs = 1
+	fn.MaxArgs = 1
+	fn.Help = "waits for the task or array of tasks to be done and returns their results (or the first error)"
+	fn.Callback = func(st any, _ string, args []object.Object) object.Object {
+		s := st.(*eval.State)
+		arg := object.Value(args[0])
+		if task, ok := arg.(object.Task); ok {
+			return wait(s, task)
+		}
+		if arg.Type() != object.ARRAY {
+			return s.KindErrorf(object.TypeError, "wait: expected a task or an array of tasks, got %s", arg.Type())
+		}
+		tasks := object.Elements(arg)
+		results := object.MakeObjectSlice(len(tasks))
+		for i, t := range tasks {
+			task, ok := t.(object.Task)
+			if !ok {
+				return s.KindErrorf(object.TypeError, "wait: element %d is a %s, not a task", i, t.Type())
+			}
+			results = append(results, wait(s, task))
+		}
+		for _, res := range results {
+			if res.Type() == object.ERROR {
+				return res
+			}
+		}
+		return object.NewArray(results)
+	}
+	MustCreate(fn)
+	fn.Name = "chan"
+	fn.MinArgs = 0
+	fn.MaxArgs = 1
+	fn.ArgTypes = []object.Type{object.INTEGER}
+	fn.Help = "returns a new channel, buffering up to the optional number of values (0, the default, for unbuffered)"
+	fn.Callback = func(st any, _ string, args []object.Object) object.Object {
+		size := int64(0)
+		if len(args) == 1 {
+			size = args[0].(object.Integer).Value
+		}
+		if size < 0 || size > MaxChannelSize {
+			return st.(*eval.State).Errorf("chan: size %d not between 0 and %d", size, MaxChannelSize)
+		}
+		return object.NewChannel(int(size))
+	}
+	MustCreate(fn)
+	fn.Name = "send"
+	fn.MinArgs = 2
+	fn.MaxArgs = 2
+	fn.ArgTypes = []object.Type{object.CHANNEL, object.ANY}
+	fn.Help = "sends the (non nil) value to the channel, waiting for room in it (or a receiver if unbuffered)"
+	fn.Callback = func(st any, _ string, args []object.Object) object.Object {
+		s := st.(*eval.State)
+		if object.Value(args[1]) == object.NULL {
+			return s.Errorf("send: can't send nil, recv() returns it for closed channels")
+		}
+		return s.Error(args[0].(object.Channel).Send(stateContext(s), object.Isolate(args[1])))
+	}
+	MustCreate(fn)
+	fn.Name = "recv"
+	fn.MinArgs = 1
+	fn.MaxArgs = 1
+	fn.Help = "receives the next value from the channel, waiting for one, nil once it's closed and empty"
+	fn.Callback = func(st any, _ string, args []object.Object) object.Object {
+		s := st.(*eval.State)
+		v, _, err := args[0].(object.Channel).Recv(stateContext(s))
+		if err != nil {
+			return s.Error(err)
+		}
+		return v
+	}
+	MustCreate(fn)
+	fn.Name = "close"
+	fn.Help = "closes the channel, returns false if it already was"
+	fn.Callback = func(_ any, _ string, args []object.Object) object.Object {
+		return object.NativeBoolToBooleanObject(args[0].(object.Channel).Close())
+	}
+	MustCreate(fn)
+	fn.Name = "recv_any"
+	fn.ArgTypes = []object.Type{object.ARRAY}
+	fn.Help = "receives from the first of the array of channels with a value (or closed and empty)," +
+		" returns [index, value]"
+	fn.Callback = func(st any, _ string, args []object.Object) object.Object {
+		s := st.(*eval.State)
+		elements := object.Elements(args[0])
+		if len(elements) == 0 {
+			return s.Errorf("recv_any: no channels")
+		}
+		channels := make([]object.Channel, 0, len(elements))
+		for i, e := range elements {
+			c, ok := e.(object.Channel)
+			if !ok {
+				return s.KindErrorf(object.TypeError, "recv_any: element %d is a %s, not a channel", i, e.Type())
+			}
+			channels = append(channels, c)
+		}
+		i, v, _, err := object.RecvAny(stateContext(s), channels)
+		if err != nil {
+			return s.Error(err)
+		}
+		return object.NewArray([]object.Object{object.Integer{Value: int64(i)}, v})
+	}
+	MustCreate(fn)
+}
+
+func wait(s *eval.State, task object.Task) object.Object {
+	res, err := task.Wait(stateContext(s))
+	if err != nil {
+		return s.Error(err)
+	}
+	return res
+}
@@ -0,0 +1,204 @@
+package extensions_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"grol.io/grol/eval"
+	"grol.io/grol/extensions"
+	"grol.io/grol/object"
+)
+
+func TestConcurrency(t *testing.T) {
+	if err := extensions.Init(nil); err != nil {
+		t.Fatalf("extensions.Init: %v", err)
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// producer/consumer
+		{`func producer(c, n) {for i := n {send(c, i*i)}; close(c); "done"}
+		  c = chan(2); t = go(producer, c, 100)
+		  sum = 0; v = recv(c)
+		  for v != nil {sum = sum + v; v = recv(c)}
+		  [sum, wait(t)]`, `[328350,"done"]`},
+		// unbuffered, consumer in the goroutine.
+		{`func consumer(c) {sum = 0; v = recv(c); for v != nil {sum = sum + v; v = recv(c)}; sum}
+		  c = chan(); t = go(consumer, c)
+		  for i := 10 {send(c, i)}
+		  close(c); wait(t)`, `45`},
+		// fan-out, results in the order of the tasks.
+		{`func work(id, x) {id * 100 + x}
+		  tasks = []; for i := 5 {tasks = tasks + go(work, i, 7)}
+		  wait(tasks)`, `[7,107,207,307,407]`},
+		// fan-out/fan-in through a channel.
+		{`func square(c, x) {send(c, x*x)}
+		  c = chan(10); for i := 10 {go(square, c, i)}
+		  sum = 0; for 10 {sum = sum + recv(c)}; sum`, `285`},
+		{`a = chan(1); b = chan(1); send(b, "b"); recv_any([a, b])`, `[1,"b"]`},
+		{`a = chan(1); b = chan(1); close(a); recv_any([a, b])`, `[0,nil]`},
+		{`c = chan(3); send(c, 1); send(c, 2); close(c); [close(c), recv(c), recv(c), recv(c)]`, `[false,1,2,nil]`},
+		{`c = chan(1); close(c); send(c, 1)`, `<err: test.gr:1:28: send on closed channel>`},
+		{`send(chan(1), nil)`, `<err: test.gr:1:5: send: can't send nil, recv() returns it for closed channels>`},
+		{`chan(-1)`, `<err: test.gr:1:5: chan: size -1 not between 0 and 1048576>`},
+		{`recv_any([chan(), 1])`, `<err: test.gr:1:9: recv_any: element 1 is a INTEGER, not a channel>`},
+		{`wait(go(func() {error("oops")}))`, `<err: test.gr:1:17: oops>`},
+		{`wait([go(func() {1}), 2])`, `<err: test.gr:1:5: wait: element 1 is a INTEGER, not a task>`},
+		{`go(42)`, `<err: test.gr:1:3: not a function: INTEGER:42>`},
+		{`wait(go(max, 3, 7))`, `7`}, // extensions too.
+		// globals are copied: changes in the goroutine are its own.
+		{`x = 1; f = func() {x = x + 1; x}; [wait(go(f)), wait(go(f)), x]`, `[2,2,1]`},
+		// values passed are too (index assignment changes arrays in place).
+		{`a = [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]; f = func(arr) {arr[0] = 42; arr[0]}; [wait(go(f, [a])), a[0]]`, `[42,1]`},
+		{`make = func(n) {func(m) {n * m}}; wait(go(make(21), 2))`, `42`},
+		{`fact = func(n) {if n <= 1 {return 1}; n * fact(n - 1)}; wait(go(fact, 20))`, `2432902008176640000`},
+		// so are the environments of closures reachable from them (checked by make race-tests).
+		{`counter = func() {n = 0; () => {n++; n}}; inc := counter()
+		  tasks = []; for 8 {tasks = tasks + go(() => {for 100 {inc()}; inc()})}
+		  sum = 0; for r = wait(tasks) {sum = sum + r}; [sum, inc()]`, `[808,1]`},
+		{`counter = func() {n = 0; () => {n++; n}}; c = chan(8)
+		  tasks = []; for 8 {tasks = tasks + go(() => {inc := recv(c); for 100 {inc()}; inc()})}
+		  inc := counter(); for 8 {send(c, inc)}
+		  sum = 0; for r = wait(tasks) {sum = sum + r}; [sum, inc()]`, `[808,1]`},
+		// images are shared, the image functions are serialized.
+		{`func draw(i) {name := sprintf("img%d", i); image.new(name, 8, 8); for x := 8 {image.set(name, x, i, [255, 0, 0])}}
+		  image.new("all", 8, 8); func line(i) {for x := 8 {image.set("all", x, i, [0, 255, 0])}}
+		  tasks = []; for i := 8 {tasks = tasks + go(draw, i) + go(line, i)}; wait(tasks)
+		  [image.size("img7").width, image.size("all").height]`, `[8,8]`},
+	}
+	for _, vm := range []bool{false, true} {
+		for _, tt := range tests {
+			s := eval.NewState()
+			s.VM = vm
+			s.CurrentFile = "test.gr"
+			res, _ := eval.EvalString(s, tt.input, false)
+			if actual := res.Inspect(); actual != tt.expected {
+				t.Errorf("vm %t: %s: got %s, expected %s", vm, tt.input, actual, tt.expected)
+			}
+		}
+	}
+}
+
+func TestConcurrencyOutput(t *testing.T) {
+	if err := extensions.Init(nil); err != nil {
+		t.Fatalf("extensions.Init: %v", err)
+	}
+	s := eval.NewState()
+	out := &strings.Builder{}
+	s.Out = out
+	// the goroutines' outputs, and the main one's, both at the top level and in functions.
+	_, err := eval.EvalString(s, `func hello(i) {println("hello", i)}
+		tasks = []; for i := 5 {tasks = tasks + go(hello, i)}
+		tasks = tasks + go(() => println("direct"))
+		println("main"); wait(tasks); hello(5)`, false)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	slices.Sort(lines)
+	expected := []string{"direct", "hello 0", "hello 1", "hello 2", "hello 3", "hello 4", "hello 5", "main"}
+	if !slices.Equal(lines, expected) {
+		t.Errorf("got %q, expected %q", lines, expected)
+	}
+}
+
+func TestConcurrencyCancel(t *testing.T) {
+	if err := extensions.Init(nil); err != nil {
+		t.Fatalf("extensions.Init: %v", err)
+	}
+	s := eval.NewState()
+	defer s.SetContext(context.Background(), 100*time.Millisecond)()
+	start := time.Now()
+	res, _ := eval.EvalString(s, `c = chan(); func spin() {for true {1}}; t = go(spin); recv_any([c])`, false)
+	if res.Inspect() != "<err: context deadline exceeded>" {
+		t.Errorf("expected a timeout error, got %s", res.Inspect())
+	}
+	res, _ = eval.EvalString(s, `wait(t)`, false)
+	if res.Inspect() != "<err: context deadline exceeded>" {
+		t.Errorf("expected a timeout error for the goroutine, got %s", res.Inspect())
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %v to time out", elapsed)
+	}
+}
+
+func TestConcurrencyQuotas(t *testing.T) {
+	if err := extensions.Init(nil); err != nil {
+		t.Fatalf("extensions.Init: %v", err)
+	}
+	tests := []struct {
+		input     string
+		configure func(s *eval.State)
+		kind      string
+	}{
+		// each goroutine is well within the quota, not all of them together.
+		{
+			`func work(n) {for i = n {x := i}; 1}
+			 tasks = []; for i := 10 {tasks = tasks + go(work, 1000)}; wait(tasks)`,
+			func(s *eval.State) { s.MaxSteps = 10_000 }, object.StepsQuotaError,
+		},
+		{
+			`func work(n) {len("0123456789" * n)}
+			 tasks = []; for i := 20 {tasks = tasks + go(work, 1000)}; wait(tasks)`,
+			func(s *eval.State) { s.MaxAlloc = 100_000 }, object.AllocQuotaError,
+		},
+		{
+			`func work(n) {print("0123456789" * n)}
+			 tasks = []; for i := 20 {tasks = tasks + go(work, 10)}; wait(tasks)`,
+			func(s *eval.State) { s.MaxOutput = 1000 }, object.OutputQuotaError,
+		},
+	}
+	for _, vm := range []bool{false, true} {
+		for _, tt := range tests {
+			s := eval.NewState()
+			s.VM = vm
+			out := &strings.Builder{}
+			s.Out = out
+			tt.configure(s)
+			res, _ := eval.EvalString(s, tt.input, false)
+			if oerr, ok := res.(object.Error); !ok || oerr.Kind != tt.kind {
+				t.Errorf("vm %t: %s: expected %s error, got %s", vm, tt.input, tt.kind, res.Inspect())
+			}
+			u := s.Usage()
+			if u.Output > 1000 || out.Len() > 1000 {
+				t.Errorf("vm %t: %s: output %d/%d exceeds the quota", vm, tt.input, u.Output, out.Len())
+			}
+			if s.MaxSteps > 0 && u.Steps > s.MaxSteps+100 {
+				t.Errorf("vm %t: %s: %d steps exceeds the quota", vm, tt.input, u.Steps)
+			}
+		}
+	}
+}
+
+func TestConcurrencySaveGlobals(t *testing.T) {
+	if err := extensions.Init(nil); err != nil {
+		t.Fatalf("extensions.Init: %v", err)
+	}
+	s := eval.NewState()
+	_, err := eval.EvalString(s, `func f() {1}; c = chan(1); t = go(f); hs = [go(f), go(f)]; m = {"c": c, "x": 1}
+		nested = [1, [2, {"t": t}]]; keep = [1, {"a": [2]}]; wait(hs)`, false)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	buf := &strings.Builder{}
+	if _, err = s.SaveGlobals(buf); err != nil {
+		t.Fatalf("SaveGlobals: %v", err)
+	}
+	saved := strings.Split(buf.String(), "\n")
+	for _, line := range saved {
+		name, _, _ := strings.Cut(line, "=")
+		if slices.Contains([]string{"c", "t", "hs", "m", "nested"}, name) {
+			t.Errorf("saved %q which contains a task or channel", line)
+		}
+	}
+	if !slices.Contains(saved, `keep=[1,{"a":[2]}]`) || !slices.Contains(saved, "func f(){1}") {
+		t.Errorf("missing saved values in %q", buf.String())
+	}
+	if _, err = eval.EvalString(eval.NewState(), buf.String(), false); err != nil {
+		t.Errorf("saved globals don't load: %v", err)
+	}
+}
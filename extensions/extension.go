@@ -12,10 +12,12 @@ import (
 	"math/big"
 	"math/rand/v2"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
@@ -30,27 +32,31 @@ import (
 )
 
 var (
+	initMutex sync.Mutex
 	initDone  = false
 	errInInit error
-	// These are a bit ugly as globals, maybe lambda capture and/or receivers on config instead.
-	unrestrictedIOs = false
-	emptyOnly       = false
 )
 
 const GrolFileExtension = ".gr" // Also the default filename for LoadSaveEmptyOnly.
 
 // Config contains configuration for restrictions and features.
-// Currently about IOs of load and save functions.
+// Currently about IOs of load, save and import functions.
 type Config struct {
-	HasLoad           bool // load() only present if this is true.
-	HasSave           bool // save() only present if this is true.
-	LoadSaveEmptyOnly bool // Restrict load/save to a single .gr file inside the current directory.
-	UnrestrictedIOs   bool // Dangerous when true: can overwrite files, read any readable file etc...
+	HasLoad           bool     // load() only present if this is true.
+	HasSave           bool     // save() only present if this is true.
+	HasImport         bool     // import() only present if this is true.
+	ImportPath        []string // Directories import() searches, in order, for relative paths. Current directory if empty.
+	LoadSaveEmptyOnly bool     // Restrict load/save to a single .gr file inside the current directory.
+	UnrestrictedIOs   bool     // Dangerous when true: can overwrite files, read any readable file etc...
 }
 
 // Init initializes the extensions, can be called multiple time safely but should really be called only once
 // before using GROL repl/eval. If the passed [Config] pointer is nil, default (safe) values are used.
+// It is safe to call concurrently, the first call's configuration is the one used (use [NewBuilder]
+// for states with other configurations).
 func Init(c *Config) error {
+	initMutex.Lock()
+	defer initMutex.Unlock()
 	if initDone {
 		return errInInit
 	}
@@ -72,9 +78,6 @@ func MustCreate(ext object.Extension) {
 type OneFloatInOutFunc func(float64) float64
 
 func initInternal(c *Config) error {
-	unrestrictedIOs = c.UnrestrictedIOs
-	emptyOnly = c.LoadSaveEmptyOnly
-
 	// -- These AddEvalResult should probably be like for discord bot,
 	// a separate grol library file embedded in the binary and read/saved in state instead.
 
@@ -135,19 +138,64 @@ func initInternal(c *Config) error {
 		Category: object.CategoryString,
 	})
 	createMathFunctions()
-	createJSONAndEvalFunctions(c)
+	createJSONAndEvalFunctions()
 	createStrFunctions()
 	createMisc()
+	createMemoFunctions()
 	createConversionFunctions()
 	createTimeFunctions()
 	createImageFunctions()
-	if c.UnrestrictedIOs {
-		createShellFunctions()
-	}
 	createIOFunctions()
+	createConcurrencyFunctions()
+	for _, ext := range c.functions() {
+		MustCreate(ext)
+	}
 	return nil
 }
 
+// functions returns the extensions whose presence and behavior depend on the configuration:
+// load, save, import and, with unrestricted IOs, exec and run.
+func (c *Config) functions() []object.Extension {
+	cfg := *c // callbacks use their own copy.
+	c = &cfg
+	var res []object.Extension
+	loadSaveFn := object.Extension{
+		MinArgs:  0, // empty only case - ie ".gr" save file.
+		MaxArgs:  1,
+		ArgTypes: []object.Type{object.STRING},
+		Help:     "filename (.gr)",
+		Category: object.CategoryIO,
+	}
+	if c.HasSave {
+		loadSaveFn.Name = "save"
+		loadSaveFn.Callback = c.saveFunc // save to file.
+		loadSaveFn.Capabilities = object.CapFSWrite
+		res = append(res, loadSaveFn)
+	}
+	if c.HasLoad {
+		loadSaveFn.Name = "load"
+		loadSaveFn.Callback = c.loadFunc // eval a file.
+		loadSaveFn.Capabilities = object.CapFSRead
+		res = append(res, loadSaveFn)
+	}
+	if c.HasImport {
+		res = append(res, object.Extension{
+			Name:         "import",
+			MinArgs:      1,
+			MaxArgs:      1,
+			ArgTypes:     []object.Type{object.STRING},
+			Callback:     c.importFunc,
+			Help:         "evaluates a .gr file (once) in its own environment and returns its definitions as a map, e.g lib.fn(x)",
+			Category:     object.CategoryIO,
+			Capabilities: object.CapFSRead,
+		})
+	}
+	if c.UnrestrictedIOs {
+		res = append(res, shellFunctions()...)
+	}
+	return res
+}
+
 func createMathFunctions() {
 	oneFloat := object.Extension{
 		MinArgs:  1,
@@ -227,13 +275,14 @@ func createMathFunctions() {
 			}
 			return object.Integer{Value: rand.Int64N(n)} //nolint:gosec // no need for crypto/rand here.
 		},
-		DontCache: true,
-		Help:      "returns a random number between 0 and 1, or between 0 and n-1 if n is provided",
-		Category:  object.CategoryMath,
+		DontCache:    true,
+		Help:         "returns a random number between 0 and 1, or between 0 and n-1 if n is provided",
+		Category:     object.CategoryMath,
+		Capabilities: object.CapRandom,
 	})
 }
 
-func createJSONAndEvalFunctions(c *Config) {
+func createJSONAndEvalFunctions() {
 	MustCreate(object.Extension{
 		Name:     "json_go",
 		MinArgs:  1,
@@ -328,23 +377,6 @@ func createJSONAndEvalFunctions(c *Config) {
 		return o
 	}
 	MustCreate(jsonFn)
-	loadSaveFn := object.Extension{
-		MinArgs:  0, // empty only case - ie ".gr" save file.
-		MaxArgs:  1,
-		ArgTypes: []object.Type{object.STRING},
-		Help:     "filename (.gr)",
-		Category: object.CategoryIO,
-	}
-	if c.HasSave {
-		loadSaveFn.Name = "save"
-		loadSaveFn.Callback = saveFunc // save to file.
-		MustCreate(loadSaveFn)
-	}
-	if c.HasLoad {
-		loadSaveFn.Name = "load"
-		loadSaveFn.Callback = loadFunc // eval a file.
-		MustCreate(loadSaveFn)
-	}
 }
 
 const DefaultTrimSet = " \r\n\t"
@@ -647,12 +679,53 @@ func createMisc() {
 			})
 			return object.NewArray(shuffled)
 		},
-		Help:      "randomly reorders elements in an array",
-		Category:  object.CategoryMath,
-		DontCache: true, // Since it's random, we don't want to cache the result
+		Help:         "randomly reorders elements in an array",
+		Category:     object.CategoryMath,
+		DontCache:    true, // Since it's random, we don't want to cache the result
+		Capabilities: object.CapRandom,
 	})
 }
 
+func createMemoFunctions() {
+	memoFn := object.Extension{
+		Name:     "memo.off",
+		MinArgs:  1,
+		MaxArgs:  1,
+		ArgTypes: []object.Type{object.FUNC},
+		Help:     "turns off memoization of the function (e.g. for one with side effects), clearing the memoized results",
+		Category: object.CategoryIntrospection,
+		Callback: func(st any, _ string, args []object.Object) object.Object {
+			s := st.(*eval.State)
+			s.Cache().SetMemoize(args[0].(object.Function).CacheKey, false)
+			return object.NULL
+		},
+		DontCache: true,
+	}
+	MustCreate(memoFn)
+	memoFn.Name = "memo.on"
+	memoFn.Help = "turns memoization of the function back on"
+	memoFn.Callback = func(st any, _ string, args []object.Object) object.Object {
+		s := st.(*eval.State)
+		s.Cache().SetMemoize(args[0].(object.Function).CacheKey, true)
+		return object.NULL
+	}
+	MustCreate(memoFn)
+	memoFn.Name = "memo.clear"
+	memoFn.MinArgs = 0
+	memoFn.Help = "clears the memoized results of the function, or all of them (and the statistics)" +
+		" without argument, returns the number of entries removed"
+	memoFn.Callback = func(st any, _ string, args []object.Object) object.Object {
+		s := st.(*eval.State)
+		if len(args) == 0 {
+			n := s.Cache().Stats().Entries
+			s.ResetCache()
+			return object.Integer{Value: int64(n)}
+		}
+		return object.Integer{Value: int64(s.Cache().Clear(args[0].(object.Function).CacheKey))}
+	}
+	MustCreate(memoFn)
+}
+
 func createConversionFunctions() { //nolint:gocyclo,gocognit,funlen // just a bunch of functions created.
 	intFn := object.Extension{
 		Name:     "int",
@@ -825,15 +898,17 @@ func createTimeFunctions() {
 		Callback: object.ShortCallback(func(_ []object.Object) object.Object {
 			return object.Float{Value: float64(time.Now().UnixMicro()) / 1e6}
 		}),
-		DontCache: true,
+		DontCache:    true,
+		Capabilities: object.CapTime,
 	})
 	MustCreate(object.Extension{
-		Name:     "sleep",
-		MinArgs:  1,
-		MaxArgs:  1,
-		ArgTypes: []object.Type{object.FLOAT},
-		Help:     "sleeps for the specified number of seconds",
-		Category: object.CategoryTime,
+		Name:         "sleep",
+		MinArgs:      1,
+		MaxArgs:      1,
+		ArgTypes:     []object.Type{object.FLOAT},
+		Help:         "sleeps for the specified number of seconds",
+		Category:     object.CategoryTime,
+		Capabilities: object.CapTime,
 		Callback: func(st any, _ string, args []object.Object) object.Object {
 			s := st.(*eval.State)
 			durSec := args[0].(object.Float).Value
@@ -845,6 +920,45 @@ func createTimeFunctions() {
 			return s.Error(terminal.SleepWithContext(s.Context, durDur))
 		},
 	})
+	MustCreate(object.Extension{
+		Name:     "with_timeout",
+		MinArgs:  2,
+		MaxArgs:  -1,
+		ArgTypes: []object.Type{object.FLOAT, object.ANY},
+		Help: "calls the function with the remaining arguments, returning a timeout error" +
+			" if it takes longer than the specified number of seconds",
+		Category: object.CategoryTime,
+		Callback: func(st any, _ string, args []object.Object) object.Object {
+			s := st.(*eval.State)
+			durSec := args[0].(object.Float).Value
+			if durSec <= 0 {
+				return s.NewError("timeout must be positive")
+			}
+			res, _ := s.CallWithTimeout(time.Duration(durSec*1e9), args[1], args[2:]...)
+			return res
+		},
+		DontCache: true,
+	})
+	MustCreate(object.Extension{
+		Name:     "deadline",
+		MinArgs:  0,
+		MaxArgs:  0,
+		Help:     "returns the number of seconds left before the current evaluation times out, nil if it doesn't",
+		Category: object.CategoryTime,
+		Callback: func(st any, _ string, _ []object.Object) object.Object {
+			s := st.(*eval.State)
+			if s.Context == nil {
+				return object.NULL
+			}
+			deadline, ok := s.Context.Deadline()
+			if !ok {
+				return object.NULL
+			}
+			return object.Float{Value: time.Until(deadline).Seconds()}
+		},
+		DontCache:    true,
+		Capabilities: object.CapTime,
+	})
 	MustCreate(object.Extension{
 		Name:     "time.info",
 		MinArgs:  1,
@@ -913,7 +1027,8 @@ func createTimeFunctions() {
 			}
 			return object.Float{Value: float64(t.UnixMicro()) / 1e6}
 		},
-		DontCache: true,
+		DontCache:    true,
+		Capabilities: object.CapTime, // relative times are from now.
 	})
 	MustCreate(object.Extension{
 		Name:     "time.duration",
@@ -1035,15 +1150,15 @@ func evalFunc(env any, name string, args []object.Object) object.Object {
 }
 
 // Normalizes to alphanum.gr.
-func sanitizeFileName(args []object.Object) (string, error) {
+func (c *Config) sanitizeFileName(args []object.Object) (string, error) {
 	if len(args) == 0 {
 		return GrolFileExtension, nil
 	}
 	file := args[0].(object.String).Value
-	if emptyOnly && file != "" {
+	if c.LoadSaveEmptyOnly && file != "" {
 		return "", fmt.Errorf("empty only mode, filename must be empty or no arguments, got: %q", file)
 	}
-	if unrestrictedIOs {
+	if c.UnrestrictedIOs {
 		log.Infof("Unrestricted IOs, not sanitizing filename: %s", file)
 		return file, nil
 	}
@@ -1057,9 +1172,9 @@ func sanitizeFileName(args []object.Object) (string, error) {
 	return f + GrolFileExtension, nil
 }
 
-func saveFunc(env any, _ string, args []object.Object) object.Object {
+func (c *Config) saveFunc(env any, _ string, args []object.Object) object.Object {
 	s := env.(*eval.State)
-	file, err := sanitizeFileName(args)
+	file, err := c.sanitizeFileName(args)
 	if err != nil {
 		return s.Error(err)
 	}
@@ -1079,8 +1194,8 @@ func saveFunc(env any, _ string, args []object.Object) object.Object {
 		object.String{Value: "filename"}, object.String{Value: file})
 }
 
-func loadFunc(env any, _ string, args []object.Object) object.Object {
-	file, err := sanitizeFileName(args)
+func (c *Config) loadFunc(env any, _ string, args []object.Object) object.Object {
+	file, err := c.sanitizeFileName(args)
 	s := env.(*eval.State)
 	if err != nil {
 		return s.Error(err)
@@ -1096,6 +1211,7 @@ func loadFunc(env any, _ string, args []object.Object) object.Object {
 	}
 	what := string(all)
 	what = DropStartingShebang(what)
+	defer s.SetSource(file, what)()
 	// Eval the content.
 	res, err := eval.EvalString(env, what, false)
 	if err != nil {
@@ -1105,14 +1221,62 @@ func loadFunc(env any, _ string, args []object.Object) object.Object {
 	return res
 }
 
+func (c *Config) importFunc(env any, _ string, args []object.Object) object.Object {
+	s := env.(*eval.State)
+	path, err := c.resolveImport(args[0].(object.String).Value)
+	if err != nil {
+		return s.Error(err)
+	}
+	return s.Import(path, func(path string) (string, error) {
+		all, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		log.Infof("Importing: %s", path)
+		return DropStartingShebang(string(all)), nil
+	})
+}
+
+// resolveImport finds the file to import, adding the .gr extension if missing and searching
+// the import path for relative names. Unless IOs are unrestricted, names must be local (no
+// absolute path or `..`). The returned path is absolute so each file is imported only once.
+func (c *Config) resolveImport(name string) (string, error) {
+	if filepath.Ext(name) != GrolFileExtension {
+		name += GrolFileExtension
+	}
+	if !c.UnrestrictedIOs && !filepath.IsLocal(name) {
+		return "", fmt.Errorf("import %q: only local paths are allowed", name)
+	}
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	importPath := c.ImportPath
+	if len(importPath) == 0 {
+		importPath = []string{"."}
+	}
+	for _, dir := range importPath {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return filepath.Abs(path)
+		}
+	}
+	return "", fmt.Errorf("import %q: not found in %v", name, importPath)
+}
+
+// DropStartingShebang removes the #! first line if present, keeping the newline so
+// line numbers in errors still match the file.
 func DropStartingShebang(what string) string {
-	if strings.HasPrefix(what, "#!") {
-		return what[strings.IndexByte(what, '\n')+1:]
+	if !strings.HasPrefix(what, "#!") {
+		return what
+	}
+	idx := strings.IndexByte(what, '\n')
+	if idx < 0 {
+		return ""
 	}
-	return what
+	return what[idx:]
 }
 
-// MapToStruct converts a grol map to a go struct (via json).
+// MapToStruct converts a grol map to a go struct (via json, see object.ToGo for a direct conversion).
 func MapToStruct[T any](inp object.Map, out *T) error {
 	w := bytes.Buffer{}
 	err := inp.JSON(&w)
@@ -7,6 +7,7 @@ import (
 	"image/png"
 	"math"
 	"os"
+	"sync"
 
 	"fortio.org/log"
 	"fortio.org/terminal/ansipixels"
@@ -30,12 +31,40 @@ type GrolImage struct {
 
 type ImageMap map[object.Object]GrolImage
 
+// imageStore is the ClientData of the image functions. Its images are shared by the goroutines
+// of the states using it (see eval.State.Go), so the functions hold its lock while running.
+type imageStore struct {
+	mu     sync.Mutex
+	images ImageMap
+}
+
+// lockImages returns the images of the image functions' ClientData, locked until unlock is called.
+func lockImages(cdata any) (images ImageMap, unlock func()) {
+	store := cdata.(*imageStore)
+	store.mu.Lock()
+	return store.images, store.mu.Unlock
+}
+
+// SetImages makes the image functions of the state use the given images map instead of the
+// default one shared by all states (e.g. a new one for each state when running them concurrently).
+func SetImages(s *eval.State, images ImageMap) {
+	store := &imageStore{images: images}
+	for name, ext := range s.Extensions {
+		if _, ok := ext.ClientData.(*imageStore); ok {
+			ext.ClientData = store
+			s.Extensions[name] = ext
+		}
+	}
+}
+
 // MaxImageDimension is the max image dimension currently allowed, in pixels.
 // TODO: make this configurable and use the slice check as well as some sort of LRU.
 const MaxImageDimension = 1024 // in pixels.
 
-// FontCache stores parsed fonts and font faces.
+// FontCache stores parsed fonts and font faces. Faces can't be used concurrently so they are only
+// accessed with the mutex held, through withFace.
 type FontCache struct {
+	mu    sync.Mutex
 	faces map[string]map[float64]font.Face // variant -> size -> face
 }
 
@@ -43,7 +72,19 @@ var fontCache = &FontCache{
 	faces: make(map[string]map[float64]font.Face),
 }
 
-// getFace returns a cached font face or creates a new one.
+// withFace calls f with the cached (or newly created) font face for the variant and size.
+func (fc *FontCache) withFace(variant string, size float64, f func(face font.Face)) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	face, err := fc.getFace(variant, size)
+	if err != nil {
+		return err
+	}
+	f(face)
+	return nil
+}
+
+// getFace returns a cached font face or creates a new one, must be called with the mutex held.
 func (fc *FontCache) getFace(variant string, size float64) (font.Face, error) {
 	// Check if we have a cached face
 	if sizes, ok := fc.faces[variant]; ok {
@@ -62,7 +103,7 @@ func (fc *FontCache) getFace(variant string, size float64) (font.Face, error) {
 	case "regular":
 		fontData = goregular.TTF
 	default:
-		return nil, object.Errorf("unknown font variant: %s", variant)
+		return nil, object.Errorfp("unknown font variant: %s", variant)
 	}
 
 	// Parse the font
@@ -200,7 +241,7 @@ func getVariant(args []object.Object, variantArgIndex int) string {
 
 func createImageFunctions() { //nolint:funlen,maintidx // this is a group of related functions.
 	// All the functions consistently use args[0] as the image name/reference into the ClientData map.
-	cdata := make(ImageMap)
+	cdata := &imageStore{images: make(ImageMap)}
 	imgFn := object.Extension{
 		Name:       "image.new",
 		MinArgs:    3,
@@ -209,7 +250,8 @@ func createImageFunctions() { //nolint:funlen,maintidx // this is a group of rel
 		ArgTypes:   []object.Type{object.STRING, object.INTEGER, object.INTEGER},
 		ClientData: cdata,
 		Callback: func(cdata any, _ string, args []object.Object) object.Object {
-			images := cdata.(ImageMap)
+			images, unlock := lockImages(cdata)
+			defer unlock()
 			x := int(args[1].(object.Integer).Value)
 			y := int(args[2].(object.Integer).Value)
 			if x > MaxImageDimension || y > MaxImageDimension {
@@ -233,7 +275,8 @@ func createImageFunctions() { //nolint:funlen,maintidx // this is a group of rel
 	imgFn.MaxArgs = 4
 	imgFn.ArgTypes = []object.Type{object.STRING, object.INTEGER, object.INTEGER, object.ARRAY}
 	imgFn.Callback = func(cdata any, name string, args []object.Object) object.Object {
-		images := cdata.(ImageMap)
+		images, unlock := lockImages(cdata)
+		defer unlock()
 		x := int(args[1].(object.Integer).Value)
 		y := int(args[2].(object.Integer).Value)
 		img, ok := images[args[0]]
@@ -270,11 +313,13 @@ func createImageFunctions() { //nolint:funlen,maintidx // this is a group of rel
 	MustCreate(imgFn)
 	imgFn.Name = "image.save"
 	imgFn.Help = "save the named image grol.png"
+	imgFn.Capabilities = object.CapFSWrite
 	imgFn.MinArgs = 1
 	imgFn.MaxArgs = 1
 	imgFn.ArgTypes = []object.Type{object.STRING}
 	imgFn.Callback = func(cdata any, _ string, args []object.Object) object.Object {
-		images := cdata.(ImageMap)
+		images, unlock := lockImages(cdata)
+		defer unlock()
 		img, ok := images[args[0]]
 		if !ok {
 			return object.Errorf("image not found")
@@ -292,13 +337,15 @@ func createImageFunctions() { //nolint:funlen,maintidx // this is a group of rel
 		return args[0]
 	}
 	MustCreate(imgFn)
+	imgFn.Capabilities = object.CapNone
 	imgFn.Name = "image.png"
 	imgFn.Help = "returns the png data of the named image, suitable for base64"
 	imgFn.MinArgs = 1
 	imgFn.MaxArgs = 1
 	imgFn.ArgTypes = []object.Type{object.STRING}
 	imgFn.Callback = func(cdata any, _ string, args []object.Object) object.Object {
-		images := cdata.(ImageMap)
+		images, unlock := lockImages(cdata)
+		defer unlock()
 		img, ok := images[args[0]]
 		if !ok {
 			return object.Errorf("image not found")
@@ -318,7 +365,8 @@ func createImageFunctions() { //nolint:funlen,maintidx // this is a group of rel
 	imgFn.MaxArgs = 7
 	imgFn.ArgTypes = []object.Type{object.STRING, object.FLOAT, object.FLOAT, object.FLOAT, object.STRING, object.ARRAY, object.STRING}
 	imgFn.Callback = func(cdata any, _ string, args []object.Object) object.Object {
-		images := cdata.(ImageMap)
+		images, unlock := lockImages(cdata)
+		defer unlock()
 		img, ok := images[args[0]]
 		if !ok {
 			return object.Errorf("image %q not found", args[0].(object.String).Value)
@@ -346,21 +394,20 @@ func createImageFunctions() { //nolint:funlen,maintidx // this is a group of rel
 		// Get font variant using helper
 		fontVariant := getVariant(args, 6)
 
-		// Get cached font face
-		face, err := fontCache.getFace(fontVariant, size)
+		// Draw the text with the cached font face
+		err := fontCache.withFace(fontVariant, size, func(face font.Face) {
+			d := &font.Drawer{
+				Dst:  img.Image,
+				Src:  image.NewUniform(textColor),
+				Face: face,
+				Dot:  fixed.Point26_6{X: fixed.I(int(x)), Y: fixed.I(int(y))},
+			}
+			d.DrawString(text)
+		})
 		if err != nil {
 			return object.Errorf("error getting font face: %v", err)
 		}
 
-		// Draw the text
-		d := &font.Drawer{
-			Dst:  img.Image,
-			Src:  image.NewUniform(textColor),
-			Face: face,
-			Dot:  fixed.Point26_6{X: fixed.I(int(x)), Y: fixed.I(int(y))},
-		}
-		d.DrawString(text)
-
 		return args[0]
 	}
 	MustCreate(imgFn)
@@ -370,7 +417,8 @@ func createImageFunctions() { //nolint:funlen,maintidx // this is a group of rel
 	imgFn.MaxArgs = 1
 	imgFn.ArgTypes = []object.Type{object.STRING}
 	imgFn.Callback = func(cdata any, _ string, args []object.Object) object.Object {
-		images := cdata.(ImageMap)
+		images, unlock := lockImages(cdata)
+		defer unlock()
 		img, ok := images[args[0]]
 		if !ok {
 			return object.NULL
@@ -387,7 +435,8 @@ func createImageFunctions() { //nolint:funlen,maintidx // this is a group of rel
 	imgFn.MaxArgs = 1
 	imgFn.ArgTypes = []object.Type{object.STRING}
 	imgFn.Callback = func(cdata any, _ string, args []object.Object) object.Object {
-		images := cdata.(ImageMap)
+		images, unlock := lockImages(cdata)
+		defer unlock()
 		delete(images, args[0])
 		return object.NULL
 	}
@@ -408,14 +457,14 @@ func createImageFunctions() { //nolint:funlen,maintidx // this is a group of rel
 		// Get font variant using helper
 		fontVariant := getVariant(args, 2)
 
-		// Get cached font face
-		face, err := fontCache.getFace(fontVariant, size)
+		// Calculate bounds with the cached font face
+		var bounds fixed.Rectangle26_6
+		err := fontCache.withFace(fontVariant, size, func(face font.Face) {
+			bounds, _ = font.BoundString(face, text)
+		})
 		if err != nil {
 			return object.Errorf("error getting font face: %v", err)
 		}
-
-		// Calculate bounds
-		bounds, _ := font.BoundString(face, text)
 		width := float64(bounds.Max.X-bounds.Min.X) / 64  // Convert from 26.6 fixed point
 		height := float64(bounds.Max.Y-bounds.Min.Y) / 64 // Convert from 26.6 fixed point
 		log.Debugf("text %q bounds %#v", text, bounds)
@@ -435,7 +484,7 @@ func createImageFunctions() { //nolint:funlen,maintidx // this is a group of rel
 	createVectorImageFunctions(cdata)
 }
 
-func createVectorImageFunctions(cdata ImageMap) { //nolint:funlen // this is a group of related functions.
+func createVectorImageFunctions(cdata *imageStore) { //nolint:funlen // this is a group of related functions.
 	imgFn := object.Extension{
 		Name:       "image.move_to",
 		MinArgs:    3,
@@ -444,7 +493,8 @@ func createVectorImageFunctions(cdata ImageMap) { //nolint:funlen // this is a g
 		ArgTypes:   []object.Type{object.STRING, object.FLOAT, object.FLOAT},
 		ClientData: cdata,
 		Callback: func(cdata any, _ string, args []object.Object) object.Object {
-			images := cdata.(ImageMap)
+			images, unlock := lockImages(cdata)
+			defer unlock()
 			img, ok := images[args[0]]
 			if !ok {
 				return object.Errorf("image %q not found", args[0].(object.String).Value)
@@ -460,7 +510,8 @@ func createVectorImageFunctions(cdata ImageMap) { //nolint:funlen // this is a g
 	imgFn.Name = "image.line_to"
 	imgFn.Help = "adds a line segment"
 	imgFn.Callback = func(cdata any, _ string, args []object.Object) object.Object {
-		images := cdata.(ImageMap)
+		images, unlock := lockImages(cdata)
+		defer unlock()
 		img, ok := images[args[0]]
 		if !ok {
 			return object.Errorf("image %q not found", args[0].(object.String).Value)
@@ -476,7 +527,8 @@ func createVectorImageFunctions(cdata ImageMap) { //nolint:funlen // this is a g
 	imgFn.MinArgs = 1
 	imgFn.MaxArgs = 1
 	imgFn.Callback = func(cdata any, _ string, args []object.Object) object.Object {
-		images := cdata.(ImageMap)
+		images, unlock := lockImages(cdata)
+		defer unlock()
 		img, ok := images[args[0]]
 		if !ok {
 			return object.Errorf("image %q not found", args[0].(object.String).Value)
@@ -491,7 +543,8 @@ func createVectorImageFunctions(cdata ImageMap) { //nolint:funlen // this is a g
 	imgFn.MaxArgs = 2
 	imgFn.ArgTypes = []object.Type{object.STRING, object.ARRAY}
 	imgFn.Callback = func(cdata any, name string, args []object.Object) object.Object {
-		images := cdata.(ImageMap)
+		images, unlock := lockImages(cdata)
+		defer unlock()
 		img, ok := images[args[0]]
 		if !ok {
 			return object.Errorf("image %q not found", args[0].(object.String).Value)
@@ -529,7 +582,8 @@ func createVectorImageFunctions(cdata ImageMap) { //nolint:funlen // this is a g
 	imgFn.Help = "merges the 2nd image into the first one, additively with white clipping"
 	imgFn.ArgTypes = []object.Type{object.STRING, object.STRING}
 	imgFn.Callback = func(cdata any, _ string, args []object.Object) object.Object {
-		images := cdata.(ImageMap)
+		images, unlock := lockImages(cdata)
+		defer unlock()
 		img1, ok := images[args[0]]
 		if !ok {
 			return object.Errorf("image %q not found", args[0].(object.String).Value)
@@ -548,7 +602,8 @@ func createVectorImageFunctions(cdata ImageMap) { //nolint:funlen // this is a g
 	imgFn.MaxArgs = 7
 	imgFn.ArgTypes = []object.Type{object.STRING, object.FLOAT, object.FLOAT, object.FLOAT, object.FLOAT, object.FLOAT, object.FLOAT}
 	imgFn.Callback = func(cdata any, _ string, args []object.Object) object.Object {
-		images := cdata.(ImageMap)
+		images, unlock := lockImages(cdata)
+		defer unlock()
 		img, ok := images[args[0]]
 		if !ok {
 			return object.Errorf("image %q not found", args[0].(object.String).Value)
@@ -569,7 +624,8 @@ func createVectorImageFunctions(cdata ImageMap) { //nolint:funlen // this is a g
 	imgFn.MaxArgs = 5
 	imgFn.ArgTypes = []object.Type{object.STRING, object.FLOAT, object.FLOAT, object.FLOAT, object.FLOAT}
 	imgFn.Callback = func(cdata any, _ string, args []object.Object) object.Object {
-		images := cdata.(ImageMap)
+		images, unlock := lockImages(cdata)
+		defer unlock()
 		img, ok := images[args[0]]
 		if !ok {
 			return object.Errorf("image %q not found", args[0].(object.String).Value)
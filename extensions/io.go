@@ -1,17 +1,84 @@
 package extensions
 
 import (
+	"context"
 	"errors"
 	"io"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"fortio.org/log"
 	"grol.io/grol/eval"
 	"grol.io/grol/object"
 )
 
-var seenEOF = object.FALSE
+// seenEOF is for the process stdin, shared by all states.
+var seenEOF atomic.Bool
+
+// pendingReads are the reads still in progress when the context of the read() that started them
+// got done, per reader, so the next read() from the same reader gets their data instead of it
+// being lost.
+type pendingReads struct {
+	mu    sync.Mutex
+	reads map[io.Reader]chan readResult
+}
+
+var pending = pendingReads{reads: make(map[io.Reader]chan readResult)}
+
+// take returns and removes the pending read of from, nil if there is none.
+func (p *pendingReads) take(from io.Reader) chan readResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch := p.reads[from]
+	delete(p.reads, from)
+	return ch
+}
+
+func (p *pendingReads) put(from io.Reader, ch chan readResult) {
+	p.mu.Lock()
+	p.reads[from] = ch
+	p.mu.Unlock()
+}
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+// readContext is from.Read(b) but returning the context's error as soon as it's done (e.g. for
+// with_timeout), the blocked read continuing in the background for the next call. Without a
+// deadline (e.g. the REPL, whose context is only canceled once the evaluation is over) it reads
+// directly, so no read is left running to compete with what reads from after the script.
+func readContext(ctx context.Context, from io.Reader, b []byte) (int, error) {
+	ch := pending.take(from)
+	if _, hasDeadline := ctx.Deadline(); ch == nil && !hasDeadline {
+		return from.Read(b)
+	}
+	if ch == nil {
+		ch = make(chan readResult, 1)
+		buf := make([]byte, len(b))
+		go func() {
+			n, err := from.Read(buf)
+			ch <- readResult{buf[:n], err}
+		}()
+	}
+	select {
+	case res := <-ch:
+		n := copy(b, res.data)
+		if n < len(res.data) { // pending read was bigger than this one, keep the rest.
+			rest := make(chan readResult, 1)
+			rest <- readResult{res.data[n:], res.err}
+			pending.put(from, rest)
+			return n, nil
+		}
+		return n, res.err
+	case <-ctx.Done():
+		pending.put(from, ch)
+		return 0, ctx.Err()
+	}
+}
 
 func createIOFunctions() { //nolint:gocognit // we have multiple functions in here.
 	// This can hang so not to be used in wasm/discord/...
@@ -57,9 +124,12 @@ func createIOFunctions() { //nolint:gocognit // we have multiple functions in he
 				}
 				var n int
 				var err error
-				if nonBlocking {
+				switch {
+				case nonBlocking:
 					n, err = s.Term.IntrReader.ReadNonBlocking(b)
-				} else {
+				case s.Context != nil:
+					n, err = readContext(s.Context, from, b)
+				default:
 					n, err = from.Read(b)
 				}
 				if lineMode && n > 0 {
@@ -74,17 +144,20 @@ func createIOFunctions() { //nolint:gocognit // we have multiple functions in he
 					linebuf.Write(b[:n])
 				}
 				if errors.Is(err, io.EOF) {
-					seenEOF = object.TRUE
+					seenEOF.Store(true)
 					break
 				}
 				if err != nil {
-					log.Errf("Error reading stdin: %v", err)
+					if s.Context == nil || s.Context.Err() == nil {
+						log.Errf("Error reading stdin: %v", err)
+					}
 					return s.Error(err)
 				}
 			}
 			return object.String{Value: linebuf.String()}
 		},
-		DontCache: true,
+		DontCache:    true,
+		Capabilities: object.CapStdin,
 	}
 	MustCreate(ioFn)
 	ioFn.Name = "eof"
@@ -94,11 +167,10 @@ func createIOFunctions() { //nolint:gocognit // we have multiple functions in he
 	ioFn.MinArgs = 0
 	ioFn.MaxArgs = 0
 	ioFn.Callback = func(_ any, _ string, _ []object.Object) object.Object {
-		r := seenEOF
-		seenEOF = object.FALSE
-		return r
+		return object.NativeBoolToBooleanObject(seenEOF.Swap(false))
 	}
 	MustCreate(ioFn)
+	ioFn.Capabilities = object.CapNone // flush and term.size don't read stdin.
 	ioFn.Name = "flush"
 	ioFn.Help = "flushes output and disable caching/memoization"
 	ioFn.Category = object.CategoryIO
@@ -0,0 +1,42 @@
+package extensions
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestReadContextPerReader(t *testing.T) {
+	ra, wa := io.Pipe()
+	rb, wb := io.Pipe()
+	b := make([]byte, 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := readContext(ctx, ra, b); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	// The read of a left pending doesn't get b's data.
+	go func() { _, _ = wb.Write([]byte("bbb")) }()
+	n, err := readContext(context.Background(), rb, b)
+	if err != nil || string(b[:n]) != "bbb" {
+		t.Errorf("read from b got %q, %v", b[:n], err)
+	}
+	go func() { _, _ = wa.Write([]byte("aaa")) }()
+	n, err = readContext(context.Background(), ra, b)
+	if err != nil || string(b[:n]) != "aaa" {
+		t.Errorf("pending read from a got %q, %v", b[:n], err)
+	}
+	// Without a deadline, nothing is left pending.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _, _ = wa.Write([]byte("ccc")) }()
+	n, err = readContext(ctx, ra, b)
+	if err != nil || string(b[:n]) != "ccc" {
+		t.Errorf("direct read got %q, %v", b[:n], err)
+	}
+	if ch := pending.take(ra); ch != nil {
+		t.Errorf("unexpected pending read without a deadline")
+	}
+}
@@ -28,7 +28,8 @@ var (
 	stderr = object.String{Value: "stderr"}
 )
 
-func createShellFunctions() {
+// shellFunctions are the exec and run functions, only present with [Config.UnrestrictedIOs].
+func shellFunctions() []object.Extension {
 	shellFn := object.Extension{
 		Name:     "exec",
 		MinArgs:  1,
@@ -50,6 +51,9 @@ func createShellFunctions() {
 				cmd.Stdin = bytes.NewReader(obj)
 			}
 			err := cmd.Run()
+			if ctxErr := s.Context.Err(); ctxErr != nil {
+				return s.Error(ctxErr) // killed because of (with_)timeout or cancellation.
+			}
 			// keys must be sorted. stdErr before stdOut.
 			res := object.MakeQuad(stderr, object.String{Value: serr.String()},
 				stdout, object.String{Value: sout.String()})
@@ -60,9 +64,10 @@ func createShellFunctions() {
 			}
 			return res
 		},
-		DontCache: true,
+		DontCache:    true,
+		Capabilities: object.CapProcess,
 	}
-	MustCreate(shellFn)
+	res := []object.Extension{shellFn}
 	shellFn.Name = "run"
 	shellFn.Help = "runs a command interactively"
 	shellFn.Callback = func(env any, _ string, args []object.Object) object.Object {
@@ -91,5 +96,5 @@ func createShellFunctions() {
 		}
 		return object.NULL
 	}
-	MustCreate(shellFn)
+	return append(res, shellFn)
 }
@@ -1,23 +1,24 @@
-// Grol2go (will eventually) transpile grol scripts to Go code.
-// Doesn't yet transpile, it just makes a go binary that runs the grol code.
+// Grol2go transpiles grol scripts to Go code: the functions it can handle are converted
+// to Go (see transpile.go) and the rest of the script is embedded and interpreted.
 package main
 
 import (
 	"flag"
-	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 
 	"fortio.org/cli"
 	"fortio.org/log"
+	"grol.io/grol/extensions"
+	"grol.io/grol/object"
 )
 
 func main() {
 	os.Exit(Main())
 }
 
-// mainCode is the current generated main.go file content.
+// mainCode is the start of the generated main.go file content.
 const mainCode = `package main
 
 import (
@@ -27,7 +28,9 @@ import (
 
 	"grol.io/grol/eval"
 	"grol.io/grol/extensions"
+	"grol.io/grol/object"
 	"grol.io/grol/repl"
+	"grol.io/grol/token"
 )
 
 func errorAndExit(msg string, args ...any) {
@@ -44,27 +47,55 @@ func main() {
 	if err != nil {
 		errorAndExit("Error initializing extensions: %v", err)
 	}
+	registerFunctions()
 	s := eval.NewState()
-	o := repl.Options{}
+	initIdentifiers(s)
+	s.SetSource(grolFile, grolCode) // for the location of errors.
+	o := repl.Options{ShowEval: true}
 	_, _, errs, _ := repl.EvalOne(context.Background(), s, grolCode, os.Stdout, o)
 	if len(errs) > 0 {
 		errorAndExit("Errors during execution: %v", errs)
 	}
 }
-
-const grolCode = ` + "`"
+`
 
 // Main is the primary entry point for grol2go. It reads grol source files,
-// generates a Go module with embedded grol code, and runs go mod tidy.
+// generates a Go module with the transpiled functions and the rest of the grol code
+// embedded, runs go mod tidy and builds it.
 // Returns 0 on success, or a non-zero error code on failure.
 func Main() int {
 	cli.MinArgs = 1
 	cli.MaxArgs = -1 // unlimited
 	cli.ArgsHelp = "file1.gr [file2.gr ...]"
 	destFlag := flag.String("dest", ".", "destination directory for generated Go files and package")
+	embedOnly := flag.Bool("embed-only", false, "don't transpile functions, only embed the grol code")
+	replaceFlag := flag.String("replace", "",
+		"use the grol.io/grol module from this local `directory` instead of the published one (for development)")
 	cli.Main()
 	dest := *destFlag
 	files := flag.Args()
+	// Same extensions as the generated code will have.
+	if err := extensions.Init(&extensions.Config{UnrestrictedIOs: true}); err != nil {
+		return log.FErrf("Error initializing extensions: %v", err)
+	}
+	t := newTranspiler(object.ExtraFunctions())
+	for _, f := range files {
+		code, err := os.ReadFile(f)
+		if err != nil {
+			return log.FErrf("Error reading %q: %v", f, err)
+		}
+		if err = t.addSource(filepath.Base(f), string(code)); err != nil {
+			return log.FErrf("Error transpiling %q: %v", f, err)
+		}
+	}
+	if !*embedOnly {
+		t.analyze()
+		t.transpile()
+	}
+	generated, err := t.Generate()
+	if err != nil {
+		return log.FErrf("Error generating Go code: %v", err)
+	}
 	// Check that there is no go.mod nor main.go already in dest
 	if _, err := os.Stat(filepath.Join(dest, "go.mod")); err == nil {
 		return log.FErrf("Destination directory %q already contains go.mod", dest)
@@ -78,35 +109,29 @@ func Main() int {
 	}
 	// go mod init in dest:
 	moduleName := deriveModuleName(files[0])
-	log.Infof("Compiling %d grol %s to Go in %q using module name %q",
+	log.Infof("Compiling %d grol %s to Go in %q using module name %q (%d %s transpiled)",
 		len(files),
 		cli.Plural(len(files), "file"),
 		dest,
-		moduleName)
+		moduleName,
+		len(t.Transpiled()),
+		cli.Plural(len(t.Transpiled()), "function"))
 	if err := runCommand(dest, "go", "mod", "init", moduleName); err != nil {
 		return log.FErrf("Failed to initialize go module: %v", err)
 	}
-	// Create main.go in dest
-	mainFilePath := filepath.Join(dest, "main.go")
-	mainFile, err := os.Create(mainFilePath)
-	if err != nil {
-		return log.FErrf("Failed to create main.go in %q: %v", dest, err)
-	}
-	defer mainFile.Close()
-	_, err = mainFile.WriteString(mainCode)
-	if err != nil {
-		return log.FErrf("Failed to write to main.go in %q: %v", dest, err)
-	}
-	for _, f := range files {
-		err = transpileFileToGo(f, mainFile)
+	if *replaceFlag != "" {
+		grolDir, err := filepath.Abs(*replaceFlag)
 		if err != nil {
-			return log.FErrf("Error transpiling %q: %v", f, err)
+			return log.FErrf("Invalid replace directory %q: %v", *replaceFlag, err)
+		}
+		if err := runCommand(dest, "go", "mod", "edit", "-replace", "grol.io/grol="+grolDir); err != nil {
+			return log.FErrf("Failed to add replace directive: %v", err)
 		}
 	}
-	// close the backtick
-	_, err = mainFile.WriteString("`\n")
-	if err != nil {
-		return log.FErrf("Failed to write to main.go in %q: %v", dest, err)
+	// Create main.go in dest
+	mainFilePath := filepath.Join(dest, "main.go")
+	if err := os.WriteFile(mainFilePath, generated, 0o644); err != nil { //nolint:gosec // regular source file.
+		return log.FErrf("Failed to write main.go in %q: %v", dest, err)
 	}
 	// Run go mod tidy in dest
 	log.Infof("Running 'go mod tidy' in %q", dest)
@@ -117,28 +142,10 @@ func Main() int {
 	if err := runCommand(dest, "go", "build", "."); err != nil {
 		return log.FErrf("Failed to run 'go build': %v", err)
 	}
-	log.Infof("Code embedding completed successfully. Run with:\n%s/%s", dest, moduleName)
+	log.Infof("Transpilation completed successfully. Run with:\n%s/%s", dest, moduleName)
 	return 0
 }
 
-func transpileFileToGo(srcFile string, mainFile *os.File) error {
-	f, err := os.Open(srcFile)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	// Implementation of the transpilation logic goes here (eventually).
-	_, err = io.Copy(mainFile, f)
-	if err != nil {
-		return err
-	}
-	_, err = mainFile.WriteString("\n")
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 // deriveModuleName derives a Go module name from a grol source file path.
 func deriveModuleName(srcFile string) string {
 	// Use the filename without extension as module name
@@ -0,0 +1,294 @@
+package main
+
+// runtimeCode is the support code for the transpiled functions, included in the generated main.go.
+const runtimeCode = `
+// Runtime support for the transpiled functions.
+
+// cache memoizes the transpiled functions that have no side effects, like the interpreter does.
+var cache = eval.NewCache()
+
+// grolError is the panic value used to unwind errors up to the extension boundary (see catchError),
+// with the transpiled functions it went through, innermost first (see inFunction).
+type grolError struct {
+	err    object.Object
+	frames []string
+}
+
+// funcInfo describes a transpiled function for the errors raised in it: as the failing expression
+// isn't known, their location is the function's definition.
+type funcInfo struct {
+	inspect string // the function as shown in the interpreter's stacks.
+	file    string
+	pos     token.Position
+	source  string // line at pos.
+}
+
+// check returns o unless it's an error, which is then propagated.
+func check(o object.Object) object.Object {
+	if o.Type() == object.ERROR {
+		panic(grolError{err: o})
+	}
+	return o
+}
+
+func raise(err object.Error) {
+	panic(grolError{err: err})
+}
+
+// enter is called when entering a transpiled function, to stop on max depth, timeouts and
+// cancellation like the interpreter.
+func enter(s *eval.State) {
+	if err := s.EnterCall(); err != nil {
+		panic(grolError{err: err})
+	}
+}
+
+// tick is called at each iteration of the transpiled loops, to stop on timeouts and cancellation.
+func tick(s *eval.State) {
+	if s.Context != nil && s.Context.Err() != nil {
+		panic(grolError{err: s.Error(s.Context.Err())})
+	}
+}
+
+// inFunction is deferred by the transpiled functions after enter: it undoes it and records the
+// function in the error being propagated, if any, like the interpreter's stack and location.
+func inFunction(s *eval.State, fi *funcInfo) {
+	s.LeaveCall()
+	r := recover()
+	if r == nil {
+		return
+	}
+	e, ok := r.(grolError)
+	if !ok {
+		panic(r)
+	}
+	if oerr, ok := e.err.(object.Error); ok && len(e.frames) == 0 && !oerr.Pos.IsValid() {
+		oerr.File, oerr.Pos, oerr.Source = fi.file, fi.pos, fi.source
+		e.err = oerr
+	}
+	e.frames = append(e.frames, fi.inspect)
+	panic(e)
+}
+
+// catchError turns the error being propagated back into a result, its stack starting with the
+// transpiled functions it went through.
+func catchError(res *object.Object) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	e, ok := r.(grolError)
+	if !ok {
+		panic(r)
+	}
+	if oerr, ok := e.err.(object.Error); ok && len(e.frames) > 0 {
+		oerr.Stack = eval.LimitStack(append(e.frames, oerr.Stack...), 10)
+		e.err = oerr
+	}
+	*res = e.err
+}
+
+func register(ext object.Extension) {
+	if err := object.CreateFunction(ext); err != nil {
+		errorAndExit("Error registering %s: %v", ext.Name, err)
+	}
+}
+
+func anyArgs(n int) []object.Type {
+	res := make([]object.Type, n)
+	for i := range res {
+		res[i] = object.ANY
+	}
+	return res
+}
+
+func identifier(s *eval.State, name string) object.Object {
+	v, err := eval.EvalString(s, name, false)
+	if err != nil {
+		errorAndExit("Error getting %s: %v", name, err)
+	}
+	return v
+}
+
+// local checks the local variable has been set.
+func local(s *eval.State, v object.Object, name string) object.Object {
+	if v == nil {
+		raise(s.NewError("identifier not found: " + name))
+	}
+	return v
+}
+
+func cond(s *eval.State, c object.Object) bool {
+	switch c {
+	case object.TRUE:
+		return true
+	case object.FALSE, object.NULL:
+		return false
+	}
+	raise(s.NewError("condition is not a boolean: " + c.Inspect()))
+	return false
+}
+
+// infix is s.Infix with fast paths for the common integer and float cases.
+func infix(s *eval.State, op token.Type, left, right object.Object) object.Object {
+	switch l := left.(type) {
+	case object.Integer:
+		r, ok := right.(object.Integer)
+		if !ok {
+			break
+		}
+		switch op { //nolint:exhaustive // others go through s.Infix.
+		case token.PLUS:
+			if res := l.Value + r.Value; (res > l.Value) == (r.Value > 0) {
+				return object.Integer{Value: res}
+			}
+		case token.MINUS:
+			if res := l.Value - r.Value; (res < l.Value) == (r.Value > 0) {
+				return object.Integer{Value: res}
+			}
+		case token.LT:
+			return object.NativeBoolToBooleanObject(l.Value < r.Value)
+		case token.GT:
+			return object.NativeBoolToBooleanObject(l.Value > r.Value)
+		case token.LTEQ:
+			return object.NativeBoolToBooleanObject(l.Value <= r.Value)
+		case token.GTEQ:
+			return object.NativeBoolToBooleanObject(l.Value >= r.Value)
+		case token.EQ:
+			return object.NativeBoolToBooleanObject(l.Value == r.Value)
+		case token.NOTEQ:
+			return object.NativeBoolToBooleanObject(l.Value != r.Value)
+		}
+	case object.Float:
+		r, ok := right.(object.Float)
+		if !ok {
+			break
+		}
+		switch op { //nolint:exhaustive // others go through s.Infix.
+		case token.PLUS:
+			return object.Float{Value: l.Value + r.Value}
+		case token.MINUS:
+			return object.Float{Value: l.Value - r.Value}
+		case token.ASTERISK:
+			return object.Float{Value: l.Value * r.Value}
+		case token.SLASH:
+			return object.Float{Value: l.Value / r.Value}
+		}
+	}
+	return check(s.Infix(op, left, right))
+}
+
+func builtinLen(s *eval.State, v object.Object) object.Object {
+	l := object.Len(v)
+	if l == -1 {
+		raise(s.NewError("len: not supported on " + v.Type().String()))
+	}
+	return object.Integer{Value: int64(l)}
+}
+
+// newMap creates a map from key, value pairs.
+func newMap(s *eval.State, kv ...object.Object) object.Object {
+	m := object.NewMapSize(len(kv) / 2)
+	for i := 0; i < len(kv); i += 2 {
+		if !object.Equals(kv[i], kv[i]) {
+			raise(s.NewError("key " + kv[i].Inspect() + " is not hashable"))
+		}
+		m = m.Set(kv[i], kv[i+1])
+	}
+	return m
+}
+
+func intValue(s *eval.State, v object.Object, msg string) int64 {
+	i, ok := eval.Int64Value(v)
+	if !ok {
+		raise(s.NewError(msg + v.Inspect()))
+	}
+	return i
+}
+
+func checkRange(s *eval.State, start, end, incr int64) {
+	switch {
+	case incr == 0:
+		raise(s.Errorf("for loop with zero increment"))
+	case incr > 0 && end < start:
+		raise(s.Errorf("for loop with negative count [%d,%d[ incr %d", start, end, incr))
+	case incr < 0 && end > start:
+		raise(s.Errorf("for loop with positive range but negative increment [%d,%d[ incr %d", start, end, incr))
+	}
+}
+
+func conditionError(s *eval.State, c object.Object) {
+	raise(s.NewError("for condition is not a boolean nor integer nor assignment: " + c.Inspect()))
+}
+
+// loopCond evaluates the condition of a for loop: returns whether to run the body, switching
+// to counting (count set) when the condition is an integer.
+func loopCond(s *eval.State, c object.Object, count *int64) bool {
+	switch c {
+	case object.TRUE:
+		return true
+	case object.FALSE, object.NULL:
+		return false
+	}
+	n, ok := c.(object.Integer)
+	if !ok {
+		conditionError(s, c)
+	}
+	checkRange(s, 0, n.Value, 1)
+	*count = n.Value
+	return true
+}
+
+// iterator for the ` + "`for var = value`" + ` loops: counting for integers, elements of arrays,
+// maps and strings.
+type iterator struct {
+	s       *eval.State
+	list    object.Object
+	i, n    int64
+	forever bool
+	val     object.Object
+}
+
+func loopOver(s *eval.State, v object.Object) *iterator {
+	it := &iterator{s: s}
+	switch v.Type() { //nolint:exhaustive // others are conditions.
+	case object.INTEGER:
+		it.n = v.(object.Integer).Value
+		checkRange(s, 0, it.n, 1)
+	case object.ARRAY, object.MAP, object.STRING:
+		it.list = v
+	default:
+		switch v {
+		case object.TRUE:
+			it.forever = true
+			it.val = v
+		case object.FALSE, object.NULL:
+		default:
+			conditionError(s, v)
+		}
+	}
+	return it
+}
+
+func (it *iterator) next() bool {
+	switch {
+	case it.forever:
+		return true
+	case it.list != nil:
+		if object.Len(it.list) == 0 {
+			return false
+		}
+		it.val = object.First(it.list)
+		it.list = object.Rest(it.list)
+		if it.val == nil {
+			raise(it.s.NewError("for list element is nil"))
+		}
+		return true
+	case it.i < it.n:
+		it.val = object.Integer{Value: it.i}
+		it.i++
+		return true
+	}
+	return false
+}
+`
@@ -0,0 +1,1029 @@
+package main
+
+// Transpilation of grol functions to Go.
+//
+// Top level named functions (`func name(..) {..}` and `name = func(..) {..}`) whose body only
+// uses the supported subset (parameters and local variables, literals, arithmetic, if, for,
+// arrays, maps, builtins like len/first/rest/print and calls to extensions or to other
+// transpiled functions) are turned into Go functions operating on object.Object values.
+// They are registered as extensions so the rest of the script, which keeps being interpreted
+// from the embedded source, can call them. Anything dynamic (closures, macros, eval(), access
+// to global variables,...) makes the function stay interpreted.
+
+import (
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+
+	"fortio.org/log"
+	"grol.io/grol/ast"
+	"grol.io/grol/eval"
+	"grol.io/grol/extensions"
+	"grol.io/grol/lexer"
+	"grol.io/grol/object"
+	"grol.io/grol/parser"
+	"grol.io/grol/token"
+)
+
+// unsupported is the panic value used to abandon the transpilation of a function.
+type unsupported string
+
+type source struct {
+	name       string
+	code       string
+	statements []ast.Node
+}
+
+type function struct {
+	name      string
+	lit       *ast.FunctionLiteral
+	src       *source
+	stmt      int // index of the defining statement in src.statements.
+	calls     map[string]bool
+	cacheable bool
+	reason    string   // why it's not transpiled, empty when it is.
+	params    []string // Go names of the parameters.
+	body      string   // Go code of the function body.
+}
+
+type transpiler struct {
+	sources  []*source
+	funcs    map[string]*function
+	order    []string        // function names in definition order.
+	globals  map[string]bool // names assigned at the top level.
+	exts     object.ExtensionMap
+	idents   map[string]bool // extra identifiers (PI, nil,...) referred to by transpiled code.
+	consts   []string        // Go expressions of the literals, hoisted as package variables.
+	constIdx map[string]int
+}
+
+func newTranspiler(exts object.ExtensionMap) *transpiler {
+	return &transpiler{
+		funcs:    make(map[string]*function),
+		globals:  make(map[string]bool),
+		exts:     exts,
+		idents:   make(map[string]bool),
+		constIdx: make(map[string]int),
+	}
+}
+
+// addSource parses the grol code of one file.
+func (t *transpiler) addSource(name, code string) error {
+	code = extensions.DropStartingShebang(code) // same as repl.EvalAll, keeps the line numbers.
+	p := parser.New(lexer.New(code))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return fmt.Errorf("parsing errors: %v", errs)
+	}
+	t.sources = append(t.sources, &source{name: name, code: code, statements: program.Statements})
+	return nil
+}
+
+// analyze finds the candidate functions and the global names.
+func (t *transpiler) analyze() {
+	definitions := make(map[string]int)
+	for _, src := range t.sources {
+		for i, stmt := range src.statements {
+			if name, lit := functionDefinition(stmt); lit != nil {
+				if _, ok := t.funcs[name]; !ok {
+					t.funcs[name] = &function{name: name, lit: lit, src: src, stmt: i}
+					t.order = append(t.order, name)
+				}
+			}
+			// Globals: what's assigned outside of function bodies.
+			ast.Walk(stmt, func(n ast.Node) bool {
+				if name := assignedName(n); name != "" {
+					t.globals[name] = true
+				}
+				_, isFunc := n.(*ast.FunctionLiteral)
+				return !isFunc
+			})
+			// Any other assignment of a function name, anywhere, means it's not a constant function.
+			ast.Walk(stmt, func(n ast.Node) bool {
+				if name := assignedName(n); name != "" {
+					definitions[name]++
+				}
+				return true
+			})
+		}
+	}
+	for name, fn := range t.funcs {
+		switch {
+		case definitions[name] != 1:
+			fn.reason = "defined or assigned more than once"
+		case fn.lit.Variadic:
+			fn.reason = "variadic"
+		case !goName(name):
+			fn.reason = "name"
+		case t.isExternal(name):
+			fn.reason = "name conflicts with an extension or identifier"
+		}
+	}
+}
+
+// functionDefinition returns the name and function literal of top level function definitions.
+func functionDefinition(stmt ast.Node) (string, *ast.FunctionLiteral) {
+	switch n := stmt.(type) {
+	case *ast.FunctionLiteral:
+		if n.Name != nil {
+			return n.Name.Literal(), n
+		}
+	case *ast.InfixExpression:
+		if n.Type() != token.ASSIGN && n.Type() != token.DEFINE {
+			return "", nil
+		}
+		id, ok := n.Left.(*ast.Identifier)
+		lit, isFunc := n.Right.(*ast.FunctionLiteral)
+		if ok && isFunc && lit.Name == nil {
+			return id.Literal(), lit
+		}
+	}
+	return "", nil
+}
+
+// assignedName returns the variable (not index) a node assigns or defines, if any.
+func assignedName(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.FunctionLiteral:
+		if n.Name != nil {
+			return n.Name.Literal()
+		}
+	case *ast.InfixExpression:
+		if isAssignment(n.Type()) {
+			if id, ok := n.Left.(*ast.Identifier); ok {
+				return id.Literal()
+			}
+		}
+	case *ast.PostfixExpression:
+		return n.Prev.Literal()
+	case *ast.PrefixExpression:
+		if n.Type() == token.INCR || n.Type() == token.DECR {
+			if id, ok := n.Right.(*ast.Identifier); ok {
+				return id.Literal()
+			}
+		}
+	}
+	return ""
+}
+
+func isAssignment(t token.Type) bool {
+	_, compound := isCompound(t)
+	return t == token.ASSIGN || t == token.DEFINE || compound
+}
+
+func isCompound(t token.Type) (token.Type, bool) {
+	return t - (token.SUMASSIGN - token.PLUS), t >= token.SUMASSIGN && t <= token.XORASSIGN
+}
+
+// goName checks the grol identifier can be used as part of a Go identifier.
+func goName(name string) bool {
+	for _, b := range []byte(name) {
+		if !lexer.IsAlphaNum(b) || b >= 0x80 {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// isExternal returns true for names resolved outside of the script: extensions,
+// extra identifiers and the special ones.
+func (t *transpiler) isExternal(name string) bool {
+	if _, ok := t.exts[name]; ok {
+		return true
+	}
+	switch name {
+	case "self", "info", "eval", "load", "args", "..":
+		return true
+	}
+	return extraIdentifier(name) != nil
+}
+
+// extraIdentifier returns the value of predefined identifiers like PI or nil, nil otherwise.
+func extraIdentifier(name string) object.Object {
+	if !goName(name) {
+		return nil
+	}
+	v, err := eval.EvalString(eval.NewState(), name, false)
+	if err != nil || v.Type() == object.FUNC {
+		return nil
+	}
+	return v
+}
+
+// transpile generates the Go code for the candidate functions, leaving the ones that
+// can't be transpiled (or call ones that can't) to the interpreter.
+func (t *transpiler) transpile() {
+	for _, name := range t.order {
+		fn := t.funcs[name]
+		if fn.reason == "" {
+			t.compile(fn)
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, name := range t.order {
+			fn := t.funcs[name]
+			if fn.reason != "" {
+				continue
+			}
+			for callee := range fn.calls {
+				if c := t.funcs[callee]; c.reason != "" {
+					fn.reason = "calls interpreted function " + callee
+					changed = true
+					break
+				}
+				if !t.funcs[callee].cacheable && fn.cacheable {
+					fn.cacheable = false
+					changed = true
+				}
+			}
+		}
+	}
+	for _, name := range t.order {
+		fn := t.funcs[name]
+		if fn.reason != "" {
+			log.Infof("%s: %s stays interpreted: %s", fn.src.name, name, fn.reason)
+		} else {
+			log.Infof("%s: %s transpiled to Go", fn.src.name, name)
+		}
+	}
+}
+
+// Transpiled returns the names of the transpiled functions.
+func (t *transpiler) Transpiled() []string {
+	var res []string
+	for _, name := range t.order {
+		if t.funcs[name].reason == "" {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// constant returns the package variable holding the given Go expression.
+func (t *transpiler) constant(expr string) string {
+	idx, ok := t.constIdx[expr]
+	if !ok {
+		idx = len(t.consts)
+		t.consts = append(t.consts, expr)
+		t.constIdx[expr] = idx
+	}
+	return "lit" + strconv.Itoa(idx)
+}
+
+func (t *transpiler) compile(fn *function) {
+	fc := &funcCompiler{
+		t:      t,
+		fn:     fn,
+		params: make(map[string]bool),
+		locals: make(map[string]bool),
+	}
+	fn.calls = make(map[string]bool)
+	fn.cacheable = true
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		reason, ok := r.(unsupported)
+		if !ok {
+			panic(r)
+		}
+		fn.reason = string(reason)
+	}()
+	fc.function()
+}
+
+type funcCompiler struct {
+	t        *transpiler
+	fn       *function
+	params   map[string]bool
+	locals   map[string]bool
+	loopVars []string // integer and list loop variables in scope, can't be modified.
+	loops    int
+	tmp      int
+	buf      strings.Builder
+}
+
+func fail(format string, args ...any) {
+	panic(unsupported(fmt.Sprintf(format, args...)))
+}
+
+func (fc *funcCompiler) emit(format string, args ...any) {
+	fmt.Fprintf(&fc.buf, format, args...)
+	fc.buf.WriteByte('\n')
+}
+
+func (fc *funcCompiler) newTmp() string {
+	fc.tmp++
+	return "t" + strconv.Itoa(fc.tmp)
+}
+
+// function compiles the function's parameters and body.
+func (fc *funcCompiler) function() {
+	fn := fc.fn
+	for _, p := range fn.lit.Parameters {
+		name := p.Value().Literal()
+		if !goName(name) || fc.t.isExternal(name) || object.Constant(name) || name == fn.name {
+			fail("parameter name %s", name)
+		}
+		fc.params[name] = true
+		fn.params = append(fn.params, "v_"+name)
+	}
+	fc.findLocals()
+	fn.body = fc.body()
+}
+
+// goCode returns the Go code for the function: a memoizing wrapper when cacheable and the body.
+func (fn *function) goCode() string {
+	paramList := ""
+	if len(fn.params) > 0 {
+		paramList = ", " + strings.Join(fn.params, ", ") + " object.Object"
+	}
+	args := strings.Join(fn.params, ", ")
+	out := strings.Builder{}
+	pos := fn.lit.Position()
+	fmt.Fprintf(&out, "var %s = funcInfo{\ninspect: %q,\nfile: %q,\n", goInfo(fn.name), fn.inspect(), fn.src.name)
+	fmt.Fprintf(&out, "pos: token.Position{Line: %d, Column: %d},\nsource: %q,\n}\n\n",
+		pos.Line, pos.Column, fn.src.line(pos.Line))
+	fmt.Fprintf(&out, "// %s is transpiled from %s:%s.\n", goFunc(fn.name), fn.src.name, pos)
+	if fn.cacheable {
+		fmt.Fprintf(&out, "func %s(s *eval.State%s) object.Object {\n", goFunc(fn.name), paramList)
+		fmt.Fprintf(&out, "args := []object.Object{%s}\n", args)
+		fmt.Fprintf(&out, "if v, _, ok := cache.Get(%q, args); ok {\nreturn v\n}\n", fn.name)
+		fmt.Fprintf(&out, "res := %s(s%s)\n", goBody(fn.name), prefixComma(args))
+		fmt.Fprintf(&out, "cache.Set(%q, args, res, nil)\nreturn res\n}\n\n", fn.name)
+		fmt.Fprintf(&out, "func %s(s *eval.State%s) object.Object {\n", goBody(fn.name), paramList)
+	} else {
+		fmt.Fprintf(&out, "func %s(s *eval.State%s) object.Object {\n", goFunc(fn.name), paramList)
+	}
+	fmt.Fprintf(&out, "enter(s)\ndefer inFunction(s, &%s)\n", goInfo(fn.name))
+	out.WriteString(fn.body)
+	out.WriteString("}\n")
+	return out.String()
+}
+
+// body compiles the function body, after which cacheability is known.
+func (fc *funcCompiler) body() string {
+	locals := make([]string, 0, len(fc.locals))
+	for name := range fc.locals {
+		locals = append(locals, "v_"+name)
+	}
+	sort.Strings(locals)
+	if len(locals) > 0 {
+		fc.emit("var %s object.Object", strings.Join(locals, ", "))
+	}
+	fc.emit("var res object.Object")
+	if len(locals) > 0 {
+		// Go rejects locals that are only ever assigned.
+		fc.emit("_ = []object.Object{%s}", strings.Join(locals, ", "))
+	}
+	fc.block(fc.fn.lit.Body, "res")
+	fc.emit("return res")
+	return fc.buf.String()
+}
+
+// inspect returns the function as the interpreter shows it, e.g. in error stacks.
+func (fn *function) inspect() string {
+	f := object.Function{
+		Parameters: fn.lit.Parameters,
+		Name:       fn.lit.Name,
+		Body:       fn.lit.Body,
+		Variadic:   fn.lit.Variadic,
+		Lambda:     fn.lit.IsLambda || fn.lit.Name == nil,
+	}
+	object.SetCacheKey(&f)
+	return f.Inspect()
+}
+
+// line returns the source line number n (starting at 1).
+func (src *source) line(n int) string {
+	lines := strings.Split(src.code, "\n")
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return lines[n-1]
+}
+
+func goFunc(name string) string { return "fn_" + name }
+func goBody(name string) string { return "body_" + name }
+func goInfo(name string) string { return "info_" + name }
+
+func prefixComma(s string) string {
+	if s == "" {
+		return ""
+	}
+	return ", " + s
+}
+
+// findLocals collects the variables assigned in the function, which must not be globals
+// unless they're defined locally with := before any use.
+func (fc *funcCompiler) findLocals() {
+	t := fc.t
+	defined := make(map[string]bool)
+	ast.Walk(fc.fn.lit.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral, *ast.MacroLiteral:
+			fail("nested function")
+		case *ast.Identifier:
+			name := n.Literal()
+			switch name {
+			case "eval", "info", "load":
+				fail("uses %s", name)
+			}
+			if t.globals[name] && t.funcs[name] == nil && !defined[name] && !fc.params[name] {
+				fail("uses global %s", name)
+			}
+		case *ast.InfixExpression:
+			if id, ok := n.Left.(*ast.Identifier); ok && n.Type() == token.DEFINE {
+				fc.define(id.Literal(), n.Right, defined)
+			}
+		case *ast.ForExpression:
+			if name, ok := forVar(n); ok && n.Condition.Value().Type() == token.DEFINE {
+				fc.define(name, n.Condition.(*ast.InfixExpression).Right, defined)
+			}
+		}
+		if name := assignedName(n); name != "" {
+			fc.addLocal(name, defined)
+		}
+		return true
+	})
+}
+
+// define records the := of a global name, which then is a local (as long as it's not
+// used in its own definition).
+func (fc *funcCompiler) define(name string, value ast.Node, defined map[string]bool) {
+	if !fc.t.globals[name] || defined[name] {
+		return
+	}
+	ast.Walk(value, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Identifier); ok && id.Literal() == name {
+			fail("uses global %s", name)
+		}
+		return true
+	})
+	defined[name] = true
+}
+
+func (fc *funcCompiler) addLocal(name string, defined map[string]bool) {
+	if fc.params[name] {
+		return
+	}
+	t := fc.t
+	if !goName(name) || t.isExternal(name) || object.Constant(name) || t.funcs[name] != nil ||
+		(t.globals[name] && !defined[name]) {
+		fail("assigns non local %s", name)
+	}
+	fc.locals[name] = true
+}
+
+// forVar returns the variable name of the `for var = ...` special forms.
+func forVar(fe *ast.ForExpression) (string, bool) {
+	ie, ok := fe.Condition.(*ast.InfixExpression)
+	if !ok || (ie.Type() != token.ASSIGN && ie.Type() != token.DEFINE) {
+		return "", false
+	}
+	id, ok := ie.Left.(*ast.Identifier)
+	if !ok {
+		return "", false
+	}
+	return id.Literal(), true
+}
+
+func isComment(n ast.Node) bool {
+	_, ok := n.(*ast.Comment)
+	return ok
+}
+
+// block compiles statements, the value of the last one is assigned to dest unless empty.
+func (fc *funcCompiler) block(stmts *ast.Statements, dest string) {
+	var nodes []ast.Node
+	if stmts != nil {
+		for _, n := range stmts.Statements {
+			if !isComment(n) {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	if len(nodes) == 0 {
+		if dest != "" {
+			fc.emit("%s = object.NULL", dest)
+		}
+		return
+	}
+	for i, n := range nodes {
+		if i == len(nodes)-1 {
+			fc.statement(n, dest)
+		} else {
+			fc.statement(n, "")
+		}
+	}
+}
+
+func (fc *funcCompiler) statement(node ast.Node, dest string) {
+	switch n := node.(type) {
+	case *ast.ReturnStatement:
+		if n.ReturnValue == nil {
+			fc.emit("return object.NULL")
+			return
+		}
+		fc.emit("return %s", fc.value(n.ReturnValue))
+	case *ast.ControlExpression:
+		if fc.loops == 0 {
+			fail("%s outside of loop", n.Literal())
+		}
+		fc.emit("%s", n.Literal())
+	case *ast.IfExpression:
+		fc.ifExpression(n, dest)
+	case *ast.ForExpression:
+		fc.forExpression(n, dest)
+	case *ast.InfixExpression:
+		if isAssignment(n.Type()) {
+			v := fc.assignment(n)
+			if dest != "" {
+				fc.emit("%s = %s", dest, v)
+			}
+			return
+		}
+		fc.expressionStatement(n, dest)
+	case *ast.PostfixExpression:
+		v := fc.local(n.Prev.Literal(), true)
+		if dest != "" {
+			fc.emit("%s = %s", dest, v)
+		}
+		fc.emit("%s = check(s.Postfix(token.%s, %s))", v, n.Type(), v)
+	default:
+		fc.expressionStatement(n, dest)
+	}
+}
+
+func (fc *funcCompiler) expressionStatement(n ast.Node, dest string) {
+	e := fc.expression(n)
+	if dest != "" {
+		fc.emit("%s = %s", dest, e)
+	} else {
+		fc.emit("_ = %s", e)
+	}
+}
+
+// value returns a Go expression for the value of the node, which can be an if, for or assignment
+// in which case the corresponding statements are emitted first.
+func (fc *funcCompiler) value(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.IfExpression, *ast.ForExpression:
+		tmp := fc.newTmp()
+		fc.emit("var %s object.Object", tmp)
+		fc.statement(n, tmp)
+		return tmp
+	case *ast.InfixExpression:
+		if isAssignment(n.Type()) {
+			return fc.assignment(n)
+		}
+	}
+	return fc.expression(node)
+}
+
+func (fc *funcCompiler) ifExpression(n *ast.IfExpression, dest string) {
+	fc.emit("if cond(s, %s) {", fc.expression(n.Condition))
+	fc.block(n.Consequence, dest)
+	if n.Alternative != nil || dest != "" {
+		fc.emit("} else {")
+		fc.block(n.Alternative, dest)
+	}
+	fc.emit("}")
+}
+
+// local returns the Go variable for a parameter or local, checking it's not a loop variable
+// when it's modified.
+func (fc *funcCompiler) local(name string, modified bool) string {
+	if !fc.params[name] && !fc.locals[name] {
+		fail("non local %s", name)
+	}
+	if modified {
+		for _, v := range fc.loopVars {
+			if v == name {
+				fail("loop variable %s modified", name)
+			}
+		}
+	}
+	return "v_" + name
+}
+
+// assignment emits the assignment statement and returns the assigned value.
+func (fc *funcCompiler) assignment(n *ast.InfixExpression) string {
+	op := n.Type()
+	switch target := n.Left.(type) {
+	case *ast.Identifier:
+		v := fc.local(target.Literal(), true)
+		value := fc.value(n.Right)
+		if compound, ok := isCompound(op); ok {
+			fc.emit("%s = check(s.Infix(token.%s, %s, %s))", v, compound, v, value)
+		} else {
+			fc.emit("%s = %s", v, value)
+		}
+		return v
+	case *ast.IndexExpression:
+		id, ok := target.Left.(*ast.Identifier)
+		if !ok {
+			fail("nested index assignment")
+		}
+		v := fc.local(id.Literal(), true)
+		value := fc.newTmp()
+		fc.emit("%s := %s", value, fc.value(n.Right))
+		index := fc.index(target)
+		if compound, ok := isCompound(op); ok {
+			idx := fc.newTmp()
+			fc.emit("%s := %s", idx, index)
+			fc.emit("%s = check(s.Infix(token.%s, check(s.Index(%s, %s)), %s))", value, compound, v, idx, value)
+			index = idx
+		}
+		fc.emit("%s = check(s.SetIndex(%s, %s, %s))", v, v, index, value)
+		return value
+	}
+	fail("assignment to %T", n.Left)
+	return ""
+}
+
+// index returns the Go expression of the index part of a (non range) index expression.
+func (fc *funcCompiler) index(n *ast.IndexExpression) string {
+	if n.Type() == token.DOT {
+		key := n.Index.Value()
+		if key.Type() != token.STRING && key.Type() != token.IDENT {
+			fail("dot index")
+		}
+		return fc.t.constant(fmt.Sprintf("object.String{Value: %s}", strconv.Quote(key.Literal())))
+	}
+	if n.Index.Value().Type() == token.COLON {
+		fail("range index")
+	}
+	return fc.expression(n.Index)
+}
+
+// expression returns the Go expression for grol expressions that don't need statements.
+func (fc *funcCompiler) expression(node ast.Node) string { //nolint:gocyclo,funlen // lots of node types.
+	t := fc.t
+	switch n := node.(type) {
+	case *ast.IntegerLiteral:
+		return t.constant(fmt.Sprintf("object.Integer{Value: %d}", n.Val))
+	case *ast.FloatLiteral:
+		return t.constant(fmt.Sprintf("object.Float{Value: %s}", strconv.FormatFloat(n.Val, 'g', -1, 64)))
+	case *ast.StringLiteral:
+		return t.constant(fmt.Sprintf("object.String{Value: %s}", strconv.Quote(n.Literal())))
+	case *ast.InterpolatedString:
+		return fmt.Sprintf("s.Interpolate(%s)", fc.list(n.Parts))
+	case *ast.Boolean:
+		if n.Val {
+			return "object.TRUE"
+		}
+		return "object.FALSE"
+	case *ast.Identifier:
+		name := n.Literal()
+		if fc.params[name] || fc.locals[name] {
+			if fc.params[name] {
+				return "v_" + name
+			}
+			return fmt.Sprintf("local(s, v_%s, %q)", name, name)
+		}
+		if t.globals[name] || t.funcs[name] != nil || extraIdentifier(name) == nil {
+			fail("identifier %s", name)
+		}
+		t.idents[name] = true
+		return "id_" + name
+	case *ast.PrefixExpression:
+		switch n.Type() {
+		case token.MINUS, token.BANG, token.BITNOT, token.BITXOR, token.PLUS:
+			return fmt.Sprintf("check(s.Prefix(token.%s, %s))", n.Type(), fc.expression(n.Right))
+		}
+		fail("prefix %s", n.Literal())
+	case *ast.PostfixExpression:
+		v := fc.local(n.Prev.Literal(), true)
+		return fmt.Sprintf("func() object.Object { old := %s; %s = check(s.Postfix(token.%s, old)); return old }()",
+			v, v, n.Type())
+	case *ast.InfixExpression:
+		return fc.infix(n)
+	case *ast.IndexExpression:
+		if n.Type() == token.DOT {
+			if _, isExt := t.exts[n.Left.Value().Literal()+"."+n.Index.Value().Literal()]; isExt {
+				fail("extension as value")
+			}
+		}
+		left := fc.expression(n.Left)
+		return fmt.Sprintf("check(s.Index(%s, %s))", left, fc.index(n))
+	case *ast.CallExpression:
+		return fc.call(n)
+	case *ast.Builtin:
+		return fc.builtin(n)
+	case *ast.ArrayLiteral:
+		return fmt.Sprintf("object.NewArray([]object.Object{%s})", fc.list(n.Elements))
+	case *ast.MapLiteral:
+		var kv []ast.Node
+		for _, k := range n.Order {
+			kv = append(kv, k, n.Pairs[k])
+		}
+		return fmt.Sprintf("newMap(s, %s)", fc.list(kv))
+	}
+	fail("%T", node)
+	return ""
+}
+
+func (fc *funcCompiler) list(nodes []ast.Node) string {
+	res := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		res = append(res, fc.expression(n))
+	}
+	return strings.Join(res, ", ")
+}
+
+func (fc *funcCompiler) infix(n *ast.InfixExpression) string {
+	op := n.Type()
+	switch op {
+	case token.AND, token.OR:
+		shortCircuit := "object.FALSE"
+		if op == token.OR {
+			shortCircuit = "object.TRUE"
+		}
+		return fmt.Sprintf("func() object.Object { if l := %s; l != %s { return check(s.Infix(token.%s, l, %s)) }; return %s }()",
+			fc.expression(n.Left), shortCircuit, op, fc.expression(n.Right), shortCircuit)
+	case token.BITOR:
+		if n.Right.Value().Type() == token.LPAREN {
+			fail("pipe")
+		}
+	case token.PLUS, token.MINUS, token.ASTERISK, token.SLASH, token.BITAND, token.BITXOR, token.PERCENT,
+		token.LT, token.GT, token.LTEQ, token.GTEQ, token.EQ, token.NOTEQ, token.LEFTSHIFT, token.RIGHTSHIFT:
+	default:
+		fail("operator %s", n.Literal())
+	}
+	return fmt.Sprintf("infix(s, token.%s, %s, %s)", op, fc.expression(n.Left), fc.expression(n.Right))
+}
+
+func (fc *funcCompiler) call(n *ast.CallExpression) string {
+	t := fc.t
+	args := fc.list(n.Arguments)
+	var name string
+	switch f := n.Function.(type) {
+	case *ast.Identifier:
+		name = f.Literal()
+	case *ast.IndexExpression:
+		if f.Type() == token.DOT {
+			name = f.Left.Value().Literal() + "." + f.Index.Value().Literal()
+		}
+	}
+	if name == "self" {
+		name = fc.fn.name
+	}
+	if ext, isExt := t.exts[name]; isExt && !fc.params[name] && !fc.locals[name] {
+		if ext.DontCache {
+			fc.fn.cacheable = false
+		}
+		return fmt.Sprintf("check(s.CallExtension(%q%s))", name, prefixComma(args))
+	}
+	callee, ok := t.funcs[name]
+	if !ok || fc.params[name] || fc.locals[name] {
+		fail("call to %s", n.Function.Value().Literal())
+	}
+	if len(n.Arguments) != len(callee.lit.Parameters) {
+		fail("wrong number of arguments calling %s", name)
+	}
+	if callee != fc.fn {
+		fc.fn.calls[name] = true
+	}
+	return fmt.Sprintf("%s(s%s)", goFunc(name), prefixComma(args))
+}
+
+func (fc *funcCompiler) builtin(n *ast.Builtin) string {
+	t := n.Type()
+	switch t {
+	case token.LEN, token.FIRST, token.REST:
+		if len(n.Parameters) != 1 {
+			fail("%s arguments", n.Literal())
+		}
+		arg := fc.expression(n.Parameters[0])
+		switch t { //nolint:exhaustive // only these 3.
+		case token.LEN:
+			return fmt.Sprintf("builtinLen(s, %s)", arg)
+		case token.FIRST:
+			return fmt.Sprintf("object.First(%s)", arg)
+		default:
+			return fmt.Sprintf("object.Rest(%s)", arg)
+		}
+	case token.PRINT, token.PRINTLN, token.ERROR:
+		if t != token.PRINTLN && len(n.Parameters) == 0 {
+			fail("%s arguments", n.Literal())
+		}
+		if t != token.ERROR {
+			fc.fn.cacheable = false
+		}
+		return fmt.Sprintf("check(s.Print(token.%s%s))", t, prefixComma(fc.list(n.Parameters)))
+	}
+	fail("builtin %s", n.Literal())
+	return ""
+}
+
+func (fc *funcCompiler) forExpression(n *ast.ForExpression, dest string) {
+	if dest != "" {
+		fc.emit("%s = object.NULL", dest)
+	}
+	name, special := forVar(n)
+	if !special {
+		// Condition loop, switching to counting when the condition is an integer.
+		count := fc.newTmp()
+		fc.emit("%s := int64(-1)", count)
+		fc.emit("for {")
+		fc.emit("if %s < 0 && !loopCond(s, %s, &%s) {\nbreak\n}", count, fc.expression(n.Condition), count)
+		fc.emit("if %s == 0 {\nbreak\n}\nif %s > 0 {\n%s--\n}", count, count, count)
+		fc.loopBody(n.Body, dest, "")
+		fc.emit("}")
+		return
+	}
+	v := fc.local(name, true)
+	ie := n.Condition.(*ast.InfixExpression)
+	rangeExpr, isRange := ie.Right.(*ast.InfixExpression)
+	if !isRange || rangeExpr.Type() != token.COLON {
+		// integer count or list iteration, depending on the value.
+		it := fc.newTmp()
+		fc.emit("for %s := loopOver(s, %s); %s.next(); {", it, fc.expression(ie.Right), it)
+		fc.emit("%s = %s.val", v, it)
+		fc.loopBody(n.Body, dest, name)
+		fc.emit("}")
+		return
+	}
+	start, end, incr := fc.newTmp(), fc.newTmp(), fc.newTmp()
+	parts := "n:m"
+	if rangeExpr.Left.Value().Type() == token.COLON {
+		parts = "n:m:i"
+		inner := rangeExpr.Left.(*ast.InfixExpression)
+		fc.emit("%s := intValue(s, %s, \"for var = n:m:i n not an integer: \")", start, fc.expression(inner.Left))
+		fc.emit("%s := intValue(s, %s, \"for var = n:m:i m not an integer: \")", end, fc.expression(inner.Right))
+		fc.emit("%s := intValue(s, %s, \"for var = n:m:i i not an integer: \")", incr, fc.expression(rangeExpr.Right))
+	} else {
+		fc.emit("%s := intValue(s, %s, \"for var = %s n not an integer: \")", start, fc.expression(rangeExpr.Left), parts)
+		fc.emit("%s := intValue(s, %s, \"for var = %s m not an integer: \")", end, fc.expression(rangeExpr.Right), parts)
+		fc.emit("%s := int64(1)", incr)
+	}
+	i := fc.newTmp()
+	fc.emit("checkRange(s, %s, %s, %s)", start, end, incr)
+	fc.emit("for %s := %s; (%s > 0 && %s < %s) || (%s < 0 && %s > %s); %s += %s {",
+		i, start, incr, i, end, incr, i, end, i, incr)
+	fc.emit("%s = object.Integer{Value: %s}", v, i)
+	fc.loopBody(n.Body, dest, name)
+	fc.emit("}")
+}
+
+func (fc *funcCompiler) loopBody(body *ast.Statements, dest, loopVar string) {
+	fc.emit("tick(s)")
+	fc.loops++
+	if loopVar != "" {
+		fc.loopVars = append(fc.loopVars, loopVar)
+	}
+	fc.block(body, dest)
+	if loopVar != "" {
+		fc.loopVars = fc.loopVars[:len(fc.loopVars)-1]
+	}
+	fc.loops--
+}
+
+// Generate returns the formatted Go code of the main.go of the generated program.
+func (t *transpiler) Generate() ([]byte, error) {
+	out := strings.Builder{}
+	names := make([]string, 0, len(t.sources))
+	for _, src := range t.sources {
+		names = append(names, src.name)
+	}
+	fmt.Fprintf(&out, "// Code generated by grol2go from %s. DO NOT EDIT.\n\n", strings.Join(names, ", "))
+	out.WriteString(mainCode)
+	out.WriteString(runtimeCode)
+	if len(t.consts) > 0 {
+		out.WriteString("\nvar (\n")
+		for i, c := range t.consts {
+			fmt.Fprintf(&out, "lit%d object.Object = %s\n", i, c)
+		}
+		out.WriteString(")\n")
+	}
+	idents := make([]string, 0, len(t.idents))
+	for name := range t.idents {
+		idents = append(idents, name)
+	}
+	sort.Strings(idents)
+	if len(idents) > 0 {
+		fmt.Fprintf(&out, "\nvar id_%s object.Object\n", strings.Join(idents, ", id_"))
+	}
+	out.WriteString("\n// initIdentifiers sets the predefined identifiers used by the transpiled functions.\n")
+	out.WriteString("func initIdentifiers(s *eval.State) {\n")
+	for _, name := range idents {
+		fmt.Fprintf(&out, "id_%s = identifier(s, %q)\n", name, name)
+	}
+	out.WriteString("}\n\n")
+	out.WriteString("// registerFunctions makes the transpiled functions available to the interpreted code.\n")
+	out.WriteString("func registerFunctions() {\n")
+	for _, name := range t.Transpiled() {
+		fn := t.funcs[name]
+		n := len(fn.lit.Parameters)
+		var args []string
+		for i := range n {
+			args = append(args, fmt.Sprintf("object.Value(args[%d])", i))
+		}
+		fmt.Fprintf(&out, "register(object.Extension{\nName: %q,\nMinArgs: %d,\nMaxArgs: %d,\n", name, n, n)
+		fmt.Fprintf(&out, "ArgTypes: anyArgs(%d),\nHelp: %q,\nCategory: \"grol2go\",\nDontCache: %t,\n",
+			n, "transpiled from "+fn.src.name, !fn.cacheable)
+		fmt.Fprintf(&out, "Callback: func(st any, _ string, args []object.Object) (res object.Object) {\n")
+		fmt.Fprintf(&out, "defer catchError(&res)\nreturn %s(st.(*eval.State)%s)\n},\n})\n",
+			goFunc(name), prefixComma(strings.Join(args, ", ")))
+	}
+	out.WriteString("}\n")
+	for _, name := range t.Transpiled() {
+		out.WriteString("\n")
+		out.WriteString(t.funcs[name].goCode())
+	}
+	file := ""
+	if len(t.sources) == 1 { // line numbers only match the first file's otherwise.
+		file = t.sources[0].name
+	}
+	fmt.Fprintf(&out, "\nconst grolFile = %q\n", file)
+	out.WriteString("\nconst grolCode = ")
+	out.WriteString(goString(t.Interpreted()))
+	out.WriteString("\n")
+	res, err := format.Source([]byte(out.String()))
+	if err != nil {
+		return []byte(out.String()), fmt.Errorf("formatting generated code: %w", err)
+	}
+	return res, nil
+}
+
+// goString returns a Go string literal, raw when possible for readability.
+func goString(s string) string {
+	if strings.Contains(s, "`") || strings.Contains(s, "\r") {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
+
+// Interpreted returns the grol code left to the interpreter: the sources with the transpiled
+// functions definitions blanked out (so line numbers in errors still match the original files).
+func (t *transpiler) Interpreted() string {
+	out := strings.Builder{}
+	for _, src := range t.sources {
+		code := []byte(src.code)
+		offsets := lineOffsets(src.code)
+		for _, name := range t.Transpiled() {
+			fn := t.funcs[name]
+			if fn.src != src {
+				continue
+			}
+			start := offset(offsets, startPosition(src.statements[fn.stmt]))
+			end := len(code)
+			if fn.stmt+1 < len(src.statements) {
+				end = offset(offsets, startPosition(src.statements[fn.stmt+1]))
+			}
+			for i := start; i < end && i < len(code); i++ {
+				if code[i] != '\n' {
+					code[i] = ' '
+				}
+			}
+		}
+		// Lines that became blank are emptied.
+		lines := strings.Split(string(code), "\n")
+		original := strings.Split(src.code, "\n")
+		for i, l := range lines {
+			if l != original[i] && strings.TrimSpace(l) == "" {
+				lines[i] = ""
+			}
+		}
+		out.WriteString(strings.Join(lines, "\n"))
+		if !strings.HasSuffix(src.code, "\n") {
+			out.WriteString("\n")
+		}
+	}
+	return out.String()
+}
+
+// startPosition returns the earliest position within the node.
+func startPosition(node ast.Node) token.Position {
+	var res token.Position
+	ast.Walk(node, func(n ast.Node) bool {
+		p := n.Position()
+		if p.IsValid() && (!res.IsValid() || p.Line < res.Line || (p.Line == res.Line && p.Column < res.Column)) {
+			res = p
+		}
+		return true
+	})
+	return res
+}
+
+func lineOffsets(code string) []int {
+	offsets := []int{0}
+	for i := range len(code) {
+		if code[i] == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+func offset(lines []int, p token.Position) int {
+	if !p.IsValid() || p.Line > len(lines) {
+		return 0
+	}
+	return lines[p.Line-1] + p.Column - 1
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"grol.io/grol/eval"
+	"grol.io/grol/extensions"
+	"grol.io/grol/object"
+)
+
+const testCode = `#!/usr/bin/env grol
+func fact(n) {
+	if n <= 1 {
+		return 1
+	}
+	n * fact(n - 1)
+}
+func noisy(x) {
+	println("x is", x)
+	fact(x)
+}
+g := 3
+func useGlobal(x) {
+	x + g
+}
+func viaGlobal(x) {
+	useGlobal(x) + 1
+}
+func sum(l) {
+	s := 0
+	for v := l {
+		s = s + v
+	}
+	s
+}
+println(fact(10), sum([1, 2, 3]))
+`
+
+func newTestTranspiler(t *testing.T) *transpiler {
+	t.Helper()
+	err := extensions.Init(nil)
+	if err != nil {
+		t.Fatalf("extensions.Init: %v", err)
+	}
+	tr := newTranspiler(object.ExtraFunctions())
+	if err = tr.addSource("test.gr", testCode); err != nil {
+		t.Fatalf("addSource: %v", err)
+	}
+	tr.analyze()
+	tr.transpile()
+	return tr
+}
+
+func TestTranspiled(t *testing.T) {
+	tr := newTestTranspiler(t)
+	expected := []string{"fact", "noisy", "sum"}
+	if got := tr.Transpiled(); !slices.Equal(got, expected) {
+		t.Errorf("transpiled functions: got %v, expected %v", got, expected)
+	}
+	for name, reason := range map[string]string{
+		"useGlobal": "uses global g",
+		"viaGlobal": "calls interpreted function useGlobal",
+	} {
+		if got := tr.funcs[name].reason; got != reason {
+			t.Errorf("%s: got reason %q, expected %q", name, got, reason)
+		}
+	}
+	if !tr.funcs["fact"].cacheable || tr.funcs["noisy"].cacheable {
+		t.Errorf("fact should be cacheable, noisy shouldn't")
+	}
+}
+
+func TestInterpretedKeepsLines(t *testing.T) {
+	tr := newTestTranspiler(t)
+	code := tr.Interpreted()
+	if n, expected := strings.Count(code, "\n"), strings.Count(testCode, "\n"); n != expected {
+		t.Errorf("interpreted code has %d lines, expected %d:\n%s", n, expected, code)
+	}
+	if strings.Contains(code, "func fact") || !strings.Contains(code, "func useGlobal") {
+		t.Errorf("unexpected interpreted code:\n%s", code)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tr := newTestTranspiler(t)
+	code, err := tr.Generate()
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	for _, s := range []string{
+		"func fn_fact(", "func body_sum(", "defer inFunction(s, &info_sum)", "tick(s)", `const grolFile = "test.gr"`, "const grolCode = ",
+	} {
+		if !strings.Contains(string(code), s) {
+			t.Errorf("generated code is missing %q", s)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	tr := newTestTranspiler(t)
+	s := eval.NewState()
+	s.Out = &strings.Builder{}
+	if _, err := eval.EvalString(s, extensions.DropStartingShebang(testCode), false); err != nil {
+		t.Fatalf("EvalString: %v", err)
+	}
+	for _, name := range tr.Transpiled() {
+		v, err := eval.EvalString(s, name, false)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// same as in the interpreter's error stacks.
+		if got := tr.funcs[name].inspect(); got != v.Inspect() {
+			t.Errorf("%s: got %q, expected %q", name, got, v.Inspect())
+		}
+	}
+}
+
+// runCode exercises the transpiled functions' results, errors, max depth and timeouts.
+const runCode = `func fact(n) {
+	if n <= 1 {
+		return 1
+	}
+	n * fact(n - 1)
+}
+func down(n) {
+	if n == 0 {
+		return 0
+	}
+	1 + down(n - 1)
+}
+func spin() {
+	for true {
+		x := 1
+	}
+}
+func divide(a, b) {
+	a / b
+}
+func count(l) {
+	n := 0
+	for v := l {
+		if v > 2 {
+			n++
+		}
+	}
+	n
+}
+println(fact(20), count([1, 2, 3, 4]), divide(7, 2))
+r := catch(divide(1, 0))
+println(r.kind, r.value)
+r = catch(divide("a", "b"))
+println(r.kind, r.value)
+println(catch(down(100000000)).kind)
+println(catch(with_timeout(0.05, spin)).kind)
+println(down(1000))
+`
+
+func TestRunTranspiled(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs a Go program")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	if err := extensions.Init(&extensions.Config{UnrestrictedIOs: true}); err != nil {
+		t.Fatalf("extensions.Init: %v", err)
+	}
+	tr := newTranspiler(object.ExtraFunctions())
+	if err := tr.addSource("run.gr", runCode); err != nil {
+		t.Fatalf("addSource: %v", err)
+	}
+	tr.analyze()
+	tr.transpile()
+	if got, expected := tr.Transpiled(), []string{"fact", "down", "spin", "divide", "count"}; !slices.Equal(got, expected) {
+		t.Fatalf("transpiled functions: got %v, expected %v", got, expected)
+	}
+	code, err := tr.Generate()
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	// Within this module, so it builds against this version of grol.
+	dir, err := os.MkdirTemp(".", "_run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = os.WriteFile(filepath.Join(dir, "main.go"), code, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("go", "run", "./"+dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run: %v\n%s", err, out)
+	}
+	s := eval.NewState()
+	expected := &strings.Builder{}
+	s.Out = expected
+	if _, err = eval.EvalString(s, runCode, false); err != nil {
+		t.Fatalf("EvalString: %v", err)
+	}
+	if string(out) != expected.String() {
+		t.Errorf("transpiled output:\n%s\ninterpreter output:\n%s", out, expected)
+	}
+}
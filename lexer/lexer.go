@@ -17,6 +17,8 @@ type Lexer struct {
 	hadNewline    bool // newline was seen before current token
 	lastNewLine   int  // position just after most recent newline
 	lineNumber    int
+	tokenPos      token.Position // line and column of the start of the most recent token
+	tokenStart    int            // byte offset of the start of the most recent token
 }
 
 // New creates a lexer in mode with string input expected to be complete (multiline/file).
@@ -49,6 +51,24 @@ func (l *Lexer) LastNewLine() int {
 	return l.lastNewLine
 }
 
+// TokenPosition returns the line and column (1 based) where the token most recently
+// returned by NextToken started.
+func (l *Lexer) TokenPosition() token.Position {
+	return l.tokenPos
+}
+
+// TokenOffset returns the byte offset in the input where the token most recently
+// returned by NextToken started.
+func (l *Lexer) TokenOffset() int {
+	return l.tokenStart
+}
+
+// Sub returns a lexer for the input[start:end] part of the input, which is at position pos,
+// so tokens positions and error lines refer to the original input (e.g. for `${expr}` in strings).
+func (l *Lexer) Sub(start, end int, pos token.Position) *Lexer {
+	return &Lexer{input: l.input[:end], pos: start, lineNumber: pos.Line, lastNewLine: start - pos.Column + 1}
+}
+
 // CurrentLine returns the current line as a string, the position within that line,
 // and the current line number. Useful for error handling. This operation may be somewhat expensive.
 func (l *Lexer) CurrentLine() (string, int, int) {
@@ -63,6 +83,8 @@ func (l *Lexer) CurrentLine() (string, int, int) {
 //nolint:gocyclo,funlen // yes it's getting quite involved.
 func (l *Lexer) NextToken() *token.Token {
 	l.skipWhitespace()
+	l.tokenPos = token.Position{Line: l.lineNumber, Column: l.pos - l.lastNewLine + 1}
+	l.tokenStart = l.pos
 	ch := l.readChar()
 	nextChar := l.peekChar()
 	switch ch { // Maybe benchmark and do our own lookup table?
@@ -124,10 +146,14 @@ func (l *Lexer) NextToken() *token.Token {
 		}
 		return token.ConstantTokenChar(ch)
 	case '"', '`':
-		str, ok := l.readString(ch)
+		start := l.pos
+		str, interp, ok := l.readString(ch)
 		if !ok {
 			return l.EOLEOF()
 		}
+		if interp {
+			return token.Intern(token.INTERP, string(l.input[start:l.pos-1]))
+		}
 		return token.Intern(token.STRING, str)
 	case '\'':
 		r, ok := l.readRune()
@@ -193,6 +219,10 @@ func (l *Lexer) skipWhitespace() {
 func (l *Lexer) readChar() byte {
 	ch := l.peekChar()
 	l.pos++
+	if ch == '\n' { // multi line strings and block comments.
+		l.lastNewLine = l.pos
+		l.lineNumber++
+	}
 	return ch
 }
 
@@ -237,7 +267,7 @@ func (l *Lexer) processEscape(escapeChar byte) (byte, bool) {
 		return '\n', true
 	case 't':
 		return '\t', true
-	case '\'', '"', '\\':
+	case '\'', '"', '\\', '$':
 		return escapeChar, true
 	case 'x':
 		return l.readHex(), true
@@ -246,8 +276,11 @@ func (l *Lexer) processEscape(escapeChar byte) (byte, bool) {
 	}
 }
 
-func (l *Lexer) readString(sep byte) (string, bool) {
+// readString reads until the closing sep and returns the unescaped string. For double quoted
+// strings containing `${expr}`, interp is true, and the caller should use the raw source instead.
+func (l *Lexer) readString(sep byte) (string, bool, bool) {
 	doubleQuotes := (sep == '"')
+	interp := false
 	buf := strings.Builder{}
 	for {
 		ch := l.readChar()
@@ -265,18 +298,109 @@ func (l *Lexer) readString(sep byte) (string, bool) {
 				var ok bool
 				ch, ok = l.processEscape(escapeChar)
 				if !ok {
-					return buf.String(), false
+					return buf.String(), interp, false
 				}
 			}
+		case doubleQuotes && ch == '$' && l.peekChar() == '{':
+			l.pos++
+			if _, ok := l.readInterpolation(); !ok {
+				return buf.String(), interp, false
+			}
+			interp = true
+			continue
 		case ch == sep:
-			return buf.String(), true
+			return buf.String(), interp, true
 		case ch == 0:
-			return buf.String(), false
+			return buf.String(), interp, false
 		}
 		buf.WriteByte(ch)
 	}
 }
 
+// readInterpolation reads the expression of a `${expr}`, after the `${`, up to the matching `}`,
+// skipping over nested braces, strings and characters. Returns the expression source.
+func (l *Lexer) readInterpolation() (string, bool) {
+	start := l.pos
+	depth := 0
+	for {
+		ch := l.readChar()
+		switch ch {
+		case 0:
+			return "", false
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return string(l.input[start : l.pos-1]), true
+			}
+			depth--
+		case '"', '`':
+			if _, _, ok := l.readString(ch); !ok {
+				return "", false
+			}
+		case '\'':
+			if _, ok := l.readRune(); !ok {
+				return "", false
+			}
+		}
+	}
+}
+
+// InterpolationPart is a piece of an interpolated string: either unescaped text
+// or, when IsExpr is true, the source of an expression. Offset is where it starts in the raw string.
+type InterpolationPart struct {
+	Text   string
+	IsExpr bool
+	Offset int
+}
+
+// SplitInterpolation splits the raw content (between the quotes) of an INTERP token into its parts.
+func SplitInterpolation(raw string) ([]InterpolationPart, bool) {
+	l := New(raw)
+	var parts []InterpolationPart
+	buf := strings.Builder{}
+	textStart := 0
+	for {
+		ch := l.readChar()
+		switch {
+		case ch == 0:
+			if buf.Len() > 0 {
+				parts = append(parts, InterpolationPart{Text: buf.String(), Offset: textStart})
+			}
+			return parts, true
+		case ch == '\\':
+			escapeChar := l.readChar()
+			switch escapeChar {
+			case 'u':
+				buf.WriteRune(l.readUnicode16())
+			case 'U':
+				buf.WriteRune(l.readUnicode32())
+			default:
+				b, ok := l.processEscape(escapeChar)
+				if !ok {
+					return nil, false
+				}
+				buf.WriteByte(b)
+			}
+		case ch == '$' && l.peekChar() == '{':
+			if buf.Len() > 0 {
+				parts = append(parts, InterpolationPart{Text: buf.String(), Offset: textStart})
+				buf.Reset()
+			}
+			l.pos++
+			offset := l.pos
+			expr, ok := l.readInterpolation()
+			if !ok {
+				return nil, false
+			}
+			parts = append(parts, InterpolationPart{Text: expr, IsExpr: true, Offset: offset})
+			textStart = l.pos
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+}
+
 func (l *Lexer) readRune() (rune, bool) {
 	startPos := l.pos
 	ch := l.readChar()
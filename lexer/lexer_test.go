@@ -415,3 +415,69 @@ func TestReadCharLiteral(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPosition(t *testing.T) {
+	input := "x = 1\n\tfoo(`a\nb`, /* c\n */ y)"
+	tests := []struct {
+		literal string
+		line    int
+		column  int
+	}{
+		{"x", 1, 1},
+		{"=", 1, 3},
+		{"1", 1, 5},
+		{"foo", 2, 2},
+		{"(", 2, 5},
+		{"a\nb", 2, 6},
+		{",", 3, 3},
+		{"/* c\n */", 3, 5},
+		{"y", 4, 5},
+		{")", 4, 6},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal() != tt.literal {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.literal, tok.Literal())
+		}
+		pos := l.TokenPosition()
+		if pos.Line != tt.line || pos.Column != tt.column {
+			t.Errorf("tests[%d] - %q position wrong. expected=%d:%d, got=%s", i, tt.literal, tt.line, tt.column, pos)
+		}
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	input := "\"a ${b + \"}${c}\"} \\${d} {e}\" `${f}`"
+	l := New(input)
+	tok := l.NextToken()
+	raw := `a ${b + "}${c}"} \${d} {e}`
+	if tok.Type() != token.INTERP || tok.Literal() != raw {
+		t.Fatalf("expected INTERP %q, got %s", raw, tok.DebugString())
+	}
+	if tok = l.NextToken(); tok.Type() != token.STRING || tok.Literal() != "${f}" {
+		t.Errorf("expected raw string to not be interpolated, got %s", tok.DebugString())
+	}
+	parts, ok := SplitInterpolation(raw)
+	if !ok {
+		t.Fatalf("unexpected split failure for %q", raw)
+	}
+	expected := []InterpolationPart{
+		{Text: "a ", Offset: 0},
+		{Text: `b + "}${c}"`, IsExpr: true, Offset: 4},
+		{Text: " ${d} {e}", Offset: 16},
+	}
+	if len(parts) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, parts)
+	}
+	for i, p := range parts {
+		if p != expected[i] {
+			t.Errorf("part %d: expected %+v, got %+v", i, expected[i], p)
+		}
+	}
+	for _, incomplete := range []string{`"a ${b`, `"a ${"b}"`, `"a ${b}`} {
+		if tok := New(incomplete).NextToken(); tok.Type() != token.EOF {
+			t.Errorf("expected EOF for incomplete %q, got %s", incomplete, tok.DebugString())
+		}
+	}
+}
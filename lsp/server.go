@@ -0,0 +1,406 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"fortio.org/log"
+	"grol.io/grol/ast"
+	"grol.io/grol/extensions"
+	"grol.io/grol/lexer"
+	"grol.io/grol/object"
+	"grol.io/grol/parser"
+	"grol.io/grol/token"
+	"grol.io/grol/trie"
+)
+
+// Server is a (single client, sequential) language server.
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*document
+	words    map[string]CompletionItem // keywords, builtins and extensions.
+	shutdown bool
+}
+
+// document is an open .gr file and the result of parsing it.
+type document struct {
+	text    string
+	lines   []string
+	program *ast.Statements
+	errors  []parser.Diagnostic
+	funcs   map[string]*definition // top level functions.
+	words   *trie.Trie             // completion candidates, language words and funcs.
+}
+
+type definition struct {
+	pos token.Position // of the function name.
+	lit *ast.FunctionLiteral
+}
+
+// NewServer creates a server reading requests from in and writing responses to out.
+// Extensions must have been initialized (extensions.Init) first.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	s := &Server{
+		in:    bufio.NewReader(in),
+		out:   out,
+		docs:  make(map[string]*document),
+		words: make(map[string]CompletionItem),
+	}
+	info := token.Info()
+	for k := range info.Keywords {
+		s.words[k] = CompletionItem{Label: k, Kind: KindKeyword, Detail: "keyword"}
+	}
+	for b := range info.Builtins {
+		s.words[b] = CompletionItem{Label: b, Kind: KindFunction, Detail: "builtin"}
+	}
+	for name, ext := range object.ExtraFunctions() {
+		s.words[name] = CompletionItem{Label: name, Kind: KindFunction, Detail: ext.Inspect()}
+	}
+	return s
+}
+
+// Run processes messages until exit (or end of input) and returns the exit code:
+// 0 if shutdown was requested first, 1 otherwise.
+func (s *Server) Run() int {
+	for {
+		msg, err := ReadMessage(s.in)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Errf("lsp: error reading message: %v", err)
+			}
+			return 1
+		}
+		var req request
+		if err = json.Unmarshal(msg, &req); err != nil {
+			log.Errf("lsp: invalid message: %v", err)
+			continue
+		}
+		if req.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+			return 1
+		}
+		result, rerr := s.handle(req)
+		if len(req.ID) == 0 {
+			continue // notification, no response.
+		}
+		if rerr != nil {
+			err = WriteMessage(s.out, errorResponse{JSONRPC: "2.0", ID: req.ID, Error: *rerr})
+		} else {
+			err = WriteMessage(s.out, response{JSONRPC: "2.0", ID: req.ID, Result: result})
+		}
+		if err != nil {
+			log.Errf("lsp: error writing response: %v", err)
+			return 1
+		}
+	}
+}
+
+func (s *Server) handle(req request) (any, *responseError) { //nolint:gocognit // one case per method.
+	log.LogVf("lsp: %s", req.Method)
+	switch req.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":           1, // full.
+				"documentFormattingProvider": true,
+				"completionProvider":         map[string]any{"triggerCharacters": []string{"."}},
+				"hoverProvider":              true,
+				"definitionProvider":         true,
+			},
+			"serverInfo": map[string]any{"name": "grol"},
+		}, nil
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, paramsError(err)
+		}
+		s.update(p.TextDocument.URI, p.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, paramsError(err)
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			s.update(p.TextDocument.URI, p.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, paramsError(err)
+		}
+		delete(s.docs, p.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+		return nil, nil
+	case "textDocument/formatting":
+		var p DocumentFormattingParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, paramsError(err)
+		}
+		if doc := s.docs[p.TextDocument.URI]; doc != nil {
+			return doc.format(), nil
+		}
+		return nil, nil
+	case "textDocument/completion", "textDocument/hover", "textDocument/definition":
+		var p TextDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, paramsError(err)
+		}
+		doc := s.docs[p.TextDocument.URI]
+		if doc == nil {
+			return nil, nil
+		}
+		switch req.Method {
+		case "textDocument/completion":
+			return s.completion(doc, p.Position), nil
+		case "textDocument/hover":
+			return s.hover(doc, p.Position), nil
+		default:
+			return doc.definition(p.TextDocument.URI, p.Position), nil
+		}
+	}
+	if len(req.ID) == 0 {
+		return nil, nil // ignore unknown notifications.
+	}
+	return nil, &responseError{Code: methodNotFound, Message: "method not supported: " + req.Method}
+}
+
+func paramsError(err error) *responseError {
+	return &responseError{Code: invalidParams, Message: err.Error()}
+}
+
+func (s *Server) notify(method string, params any) {
+	err := WriteMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		log.Errf("lsp: error writing %s: %v", method, err)
+	}
+}
+
+// update (re)parses the document and publishes its diagnostics.
+func (s *Server) update(uri, text string) {
+	doc := s.parse(text)
+	s.docs[uri] = doc
+	diags := make([]Diagnostic, 0, len(doc.errors))
+	for _, e := range doc.errors {
+		start := doc.position(e.Pos)
+		end := start
+		if line := doc.line(start.Line); start.Character < utf16Len(line) {
+			end.Character++
+		}
+		diags = append(diags, Diagnostic{
+			Range:    Range{Start: start, End: end},
+			Severity: SeverityError,
+			Source:   "grol",
+			Message:  e.Message,
+		})
+	}
+	s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: diags})
+}
+
+func (s *Server) parse(text string) *document {
+	doc := &document{
+		text:  text,
+		lines: strings.Split(text, "\n"),
+		funcs: make(map[string]*definition),
+		words: trie.NewTrie(),
+	}
+	// Same as when running the file, the #! line is ignored (line numbers are kept).
+	p := parser.New(lexer.New(extensions.DropStartingShebang(text)))
+	doc.program = p.ParseProgram()
+	doc.errors = p.Diagnostics()
+	for _, stmt := range doc.program.Statements {
+		if name, def := functionDefinition(stmt); def != nil {
+			if _, found := doc.funcs[name]; !found {
+				doc.funcs[name] = def
+			}
+		}
+	}
+	for w := range s.words {
+		doc.words.Insert(w)
+	}
+	for name := range doc.funcs {
+		doc.words.Insert(name)
+	}
+	return doc
+}
+
+// functionDefinition returns the name and definition of top level `func name(...)`
+// and `name = func(...)` statements.
+func functionDefinition(stmt ast.Node) (string, *definition) {
+	switch n := stmt.(type) {
+	case *ast.FunctionLiteral:
+		if n.Name != nil {
+			return n.Name.Literal(), &definition{pos: n.Name.Position(), lit: n}
+		}
+	case *ast.InfixExpression:
+		if n.Type() != token.ASSIGN && n.Type() != token.DEFINE {
+			return "", nil
+		}
+		id, ok := n.Left.(*ast.Identifier)
+		lit, isFunc := n.Right.(*ast.FunctionLiteral)
+		if ok && isFunc {
+			return id.Literal(), &definition{pos: id.Position(), lit: lit}
+		}
+	}
+	return "", nil
+}
+
+// signature returns `func name(params)`.
+func (d *definition) signature(name string) string {
+	ps := ast.NewPrintState()
+	ps.PatternList(d.lit.Parameters)
+	return "func " + name + "(" + ps.String() + ")"
+}
+
+func (s *Server) completion(doc *document, pos Position) []CompletionItem {
+	prefix, _, _ := doc.word(pos, false)
+	_, words := doc.words.PrefixAll(prefix)
+	items := make([]CompletionItem, 0, len(words))
+	for _, w := range words {
+		if def, ok := doc.funcs[w]; ok {
+			items = append(items, CompletionItem{Label: w, Kind: KindFunction, Detail: def.signature(w)})
+			continue
+		}
+		items = append(items, s.words[w])
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+func (s *Server) hover(doc *document, pos Position) *Hover {
+	w, start, end := doc.word(pos, true)
+	if w == "" {
+		return nil
+	}
+	var text string
+	if def, ok := doc.funcs[w]; ok {
+		text = "```grol\n" + def.signature(w) + "\n```"
+	} else if ext, isExt := object.ExtraFunctions()[w]; isExt {
+		usage := strings.Builder{}
+		ext.Usage(&usage)
+		text = "```grol\n" + w + "(" + usage.String() + ")\n```"
+		if ext.Help != "" {
+			text += "\n[" + ext.Category + "] " + ext.Help
+		}
+	} else if item, found := s.words[w]; found {
+		text = item.Detail + " `" + w + "`"
+	} else {
+		return nil
+	}
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: text},
+		Range:    &Range{Start: start, End: end},
+	}
+}
+
+func (doc *document) definition(uri string, pos Position) *Location {
+	w, _, _ := doc.word(pos, true)
+	def, ok := doc.funcs[w]
+	if !ok {
+		return nil
+	}
+	start := doc.position(def.pos)
+	end := start
+	end.Character += utf16Len(w)
+	return &Location{URI: uri, Range: Range{Start: start, End: end}}
+}
+
+// format returns the edit replacing the whole document with its formatted version,
+// nothing if the document doesn't parse.
+func (doc *document) format() []TextEdit {
+	if len(doc.errors) > 0 {
+		return nil
+	}
+	formatted := doc.program.PrettyPrint(ast.NewPrintState()).String()
+	if strings.HasPrefix(doc.text, "#!") {
+		formatted = doc.lines[0] + "\n" + formatted
+	}
+	if formatted == doc.text {
+		return []TextEdit{}
+	}
+	last := len(doc.lines) - 1
+	end := Position{Line: last, Character: utf16Len(doc.lines[last])}
+	return []TextEdit{{Range: Range{End: end}, NewText: formatted}}
+}
+
+func (doc *document) line(n int) string {
+	if n < 0 || n >= len(doc.lines) {
+		return ""
+	}
+	return doc.lines[n]
+}
+
+// position converts a grol (1 based, byte column) position to a LSP one.
+func (doc *document) position(p token.Position) Position {
+	if !p.IsValid() {
+		return Position{}
+	}
+	n := min(p.Line-1, len(doc.lines)-1)
+	line := doc.lines[n]
+	col := max(0, min(p.Column-1, len(line)))
+	return Position{Line: n, Character: utf16Len(line[:col])}
+}
+
+// byteOffset converts a UTF-16 character offset within a line to a byte offset.
+func byteOffset(line string, character int) int {
+	units := 0
+	for i, r := range line {
+		if units >= character {
+			return i
+		}
+		units += utf16RuneLen(r)
+	}
+	return len(line)
+}
+
+func utf16RuneLen(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16RuneLen(r)
+	}
+	return n
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '.' || c >= utf8.RuneSelf ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// word returns the identifier (including `.`, e.g. math.sqrt) ending at pos or, if whole is true,
+// the one surrounding pos; along with its range.
+func (doc *document) word(pos Position, whole bool) (string, Position, Position) {
+	line := doc.line(pos.Line)
+	idx := byteOffset(line, pos.Character)
+	start, end := idx, idx
+	for start > 0 && isWordByte(line[start-1]) {
+		start--
+	}
+	if whole {
+		for end < len(line) && isWordByte(line[end]) {
+			end++
+		}
+	}
+	w := line[start:end]
+	return w, Position{Line: pos.Line, Character: utf16Len(line[:start])}, Position{Line: pos.Line, Character: utf16Len(line[:end])}
+}
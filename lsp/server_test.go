@@ -0,0 +1,192 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"grol.io/grol/extensions"
+)
+
+const testURI = "file:///tmp/test.gr"
+
+// session runs the server on the given messages followed by shutdown and exit.
+// Returns the results (or whole error responses) by id and the notifications.
+func session(t *testing.T, msgs ...request) (map[int]json.RawMessage, []notification) {
+	t.Helper()
+	if err := extensions.Init(nil); err != nil {
+		t.Fatalf("extensions.Init: %v", err)
+	}
+	in := bytes.Buffer{}
+	msgs = append(msgs, request{Method: "shutdown", ID: json.RawMessage("0")}, request{Method: "exit"})
+	for _, m := range msgs {
+		m.JSONRPC = "2.0"
+		if err := WriteMessage(&in, m); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+	}
+	out := bytes.Buffer{}
+	if code := NewServer(&in, &out).Run(); code != 0 {
+		t.Errorf("exit code %d", code)
+	}
+	responses := make(map[int]json.RawMessage)
+	var notifications []notification
+	r := bufio.NewReader(&out)
+	for {
+		msg, err := ReadMessage(r)
+		if err != nil {
+			break
+		}
+		var resp struct {
+			ID     *int
+			Method string
+			Params json.RawMessage
+			Result json.RawMessage
+			Error  *responseError
+		}
+		if err = json.Unmarshal(msg, &resp); err != nil {
+			t.Fatalf("invalid response %s: %v", msg, err)
+		}
+		switch {
+		case resp.ID == nil:
+			notifications = append(notifications, notification{Method: resp.Method, Params: resp.Params})
+		case resp.Error != nil:
+			responses[*resp.ID] = msg
+		default:
+			responses[*resp.ID] = resp.Result
+		}
+	}
+	return responses, notifications
+}
+
+func call(id int, method string, params any) request {
+	b, _ := json.Marshal(params)
+	return request{ID: json.RawMessage(strconv.Itoa(id)), Method: method, Params: b}
+}
+
+func notify(method string, params any) request {
+	b, _ := json.Marshal(params)
+	return request{Method: method, Params: b}
+}
+
+func open(text string) request {
+	return notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: testURI, LanguageID: "grol", Version: 1, Text: text},
+	})
+}
+
+func at(line, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: testURI},
+		Position:     Position{Line: line, Character: character},
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	_, notifications := session(t, open("x = 3 +* 4\ny = 1\nz = )"))
+	if len(notifications) != 1 || notifications[0].Method != "textDocument/publishDiagnostics" {
+		t.Fatalf("expected 1 publishDiagnostics, got %v", notifications)
+	}
+	var p PublishDiagnosticsParams
+	if err := json.Unmarshal(notifications[0].Params.(json.RawMessage), &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %+v", p.Diagnostics)
+	}
+	d := p.Diagnostics[0]
+	if d.Range.Start != (Position{Line: 0, Character: 7}) || d.Message != "no prefix parse function for `*` found" {
+		t.Errorf("unexpected first diagnostic %+v", d)
+	}
+	if d = p.Diagnostics[1]; d.Range.Start.Line != 2 {
+		t.Errorf("unexpected second diagnostic %+v", d)
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	responses, _ := session(t,
+		open("#!/usr/bin/env grol\nfunc f(x){x+1}\n"),
+		call(1, "textDocument/formatting", DocumentFormattingParams{TextDocument: TextDocumentIdentifier{URI: testURI}}))
+	var edits []TextEdit
+	if err := json.Unmarshal(responses[1], &edits); err != nil || len(edits) != 1 {
+		t.Fatalf("unexpected formatting response %s: %v", responses[1], err)
+	}
+	expected := "#!/usr/bin/env grol\nfunc f(x) {\n\tx + 1\n}\n"
+	if edits[0].NewText != expected {
+		t.Errorf("got %q, expected %q", edits[0].NewText, expected)
+	}
+	if edits[0].Range.End != (Position{Line: 2}) {
+		t.Errorf("unexpected range %+v", edits[0].Range)
+	}
+}
+
+func TestCompletionHoverDefinition(t *testing.T) {
+	code := "func square(x) {x*x}\nsquare(3)\nimage.ne\n"
+	responses, _ := session(t,
+		open(code),
+		call(1, "textDocument/completion", at(1, 3)),
+		call(2, "textDocument/completion", at(2, 8)),
+		call(3, "textDocument/hover", at(1, 2)),
+		call(4, "textDocument/hover", at(2, 1)),
+		call(5, "textDocument/definition", at(1, 4)),
+		call(6, "textDocument/unknownMethod", at(0, 0)))
+	if !strings.Contains(string(responses[6]), `"code":-32601`) {
+		t.Errorf("expected method not found error, got %s", responses[6])
+	}
+	var items []CompletionItem
+	if err := json.Unmarshal(responses[1], &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Label != "square" || items[0].Detail != "func square(x)" {
+		t.Errorf("unexpected completion %+v", items)
+	}
+	if err := json.Unmarshal(responses[2], &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Label != "image.new" || !strings.HasPrefix(items[0].Detail, "image.new(string, integer, integer)") {
+		t.Errorf("unexpected completion %+v", items)
+	}
+	var hover Hover
+	if err := json.Unmarshal(responses[3], &hover); err != nil {
+		t.Fatal(err)
+	}
+	if hover.Contents.Value != "```grol\nfunc square(x)\n```" {
+		t.Errorf("unexpected hover %+v", hover)
+	}
+	if string(responses[4]) != "null" {
+		t.Errorf("unexpected hover on partial word %s", responses[4])
+	}
+	var loc Location
+	if err := json.Unmarshal(responses[5], &loc); err != nil {
+		t.Fatal(err)
+	}
+	expected := Range{Start: Position{Line: 0, Character: 5}, End: Position{Line: 0, Character: 11}}
+	if loc.URI != testURI || loc.Range != expected {
+		t.Errorf("unexpected definition %+v", loc)
+	}
+}
+
+func TestHoverExtension(t *testing.T) {
+	responses, _ := session(t, open("sqrt(2)\n"), call(1, "textDocument/hover", at(0, 2)))
+	var hover Hover
+	if err := json.Unmarshal(responses[1], &hover); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hover.Contents.Value, "```grol\nsqrt(float)\n```\n[math] ") {
+		t.Errorf("unexpected hover %+v", hover)
+	}
+}
+
+func TestUTF16Positions(t *testing.T) {
+	doc := (&Server{words: map[string]CompletionItem{}}).parse("s = \"😀é\"; foo")
+	if n := utf16Len("😀é"); n != 3 {
+		t.Errorf("utf16Len: %d", n)
+	}
+	w, start, end := doc.word(Position{Line: 0, Character: 12}, true)
+	if w != "foo" || start.Character != 11 || end.Character != 14 {
+		t.Errorf("got %q %+v %+v", w, start, end)
+	}
+}
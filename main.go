@@ -20,8 +20,11 @@ import (
 	"fortio.org/progressbar"
 	"fortio.org/struct2env"
 	"fortio.org/terminal"
+	"grol.io/grol/debugger"
 	"grol.io/grol/eval"
 	"grol.io/grol/extensions" // register extensions
+	"grol.io/grol/lsp"
+	"grol.io/grol/object"
 	"grol.io/grol/repl"
 )
 
@@ -66,7 +69,9 @@ func Main() (retcode int) { //nolint:funlen // we do have quite a lot of flags a
 	maxHistory := flag.Int("max-history", terminal.DefaultHistoryCapacity, "max history `size`, use 0 to disable.")
 	disableLoadSave := flag.Bool("no-load-save", false, "disable load/save of history")
 	restrictIOs := flag.Bool("restrict-io", false, "restrict IOs (safe mode)")
-	emptyOnly := flag.Bool("empty-only", false, "only allow load()/save() to ./.gr")
+	emptyOnly := flag.Bool("empty-only", false, "only allow load()/save() to ./.gr (and no import())")
+	importPath := flag.String("import-path", ".", "`directories` (separated by "+string(filepath.ListSeparator)+
+		") searched by import() for relative paths")
 	noAuto := flag.Bool("no-auto", false, "don't auto load/save the state to ./.gr")
 	maxDepth := flag.Int("max-depth", eval.DefaultMaxDepth-1, "Maximum interpreter depth")
 	maxLen := flag.Int("max-save-len", 4000, "Maximum len of saved identifiers, use 0 for unlimited")
@@ -75,11 +80,25 @@ func Main() (retcode int) { //nolint:funlen // we do have quite a lot of flags a
 	maxDuration := duration.Flag("max-duration", 0, "Maximum duration for a script to run. 0 for unlimited.")
 	shebangMode := flag.Bool("s", false, "#! script mode: next argument is a script file to run, rest are args to the script")
 	noRegister := flag.Bool("no-register", false, "Don't use registers")
+	useVM := flag.Bool("vm", false, "Run functions through the bytecode compiler and virtual machine")
+	noOptimize := flag.Bool("no-optimize", false, "Don't fold constants and simplify the parsed program before evaluating it")
+	debugMode := flag.Bool("debug", false, "Run the script file(s) in the interactive debugger (type help at the prompt)")
 	noProgress := flag.Bool("no-progress", false, "Don't show progress bar even when processing multiple files")
+	maxSteps := flag.Int64("max-steps", 0, "Maximum number of evaluation steps, 0 for unlimited")
+	maxAlloc := flag.Int64("max-alloc", 0, "Maximum approximate `bytes` allocated for strings, arrays and maps, 0 for unlimited")
+	maxOutput := flag.Int64("max-output", 0, "Maximum `bytes` of output, 0 for unlimited")
+	maxCacheEntries := flag.Int("max-cache-entries", 0, "Maximum number of memoized function results, 0 for unlimited")
+	maxCacheBytes := flag.Int64("max-cache-bytes", 0, "Maximum approximate `bytes` of memoized function results, 0 for unlimited")
+	deny := flag.String("deny", "", "comma separated `capabilities` to deny to extensions: "+object.CapAll.String())
 
-	cli.ArgsHelp = "*.gr files to interpret or `-` for stdin without prompt or no arguments for stdin repl..."
+	cli.ArgsHelp = "*.gr files to interpret or `-` for stdin without prompt or `lsp` for the language server" +
+		" or `dap` for the debug adapter or no arguments for stdin repl..."
 	cli.MaxArgs = -1
 	cli.Main()
+	denied, err := object.ParseCapabilities(*deny)
+	if err != nil {
+		return log.FErrf("Invalid -deny: %v", err)
+	}
 	if cmd, ok := strings.CutPrefix(*commandFlag, "exec "); ok && !*restrictIOs {
 		return repl.ShellExec(cmd)
 	}
@@ -114,8 +133,17 @@ func Main() (retcode int) { //nolint:funlen // we do have quite a lot of flags a
 		PanicOk:     *panicOk,
 		AllParens:   *allParens,
 		MaxDuration: *maxDuration,
+		MaxSteps:    *maxSteps,
+		MaxAlloc:    *maxAlloc,
+		MaxOutput:   *maxOutput,
 		ShebangMode: *shebangMode,
 		NoReg:       *noRegister,
+		VM:          *useVM,
+		NoOptimize:  *noOptimize,
+
+		MaxCacheEntries:    *maxCacheEntries,
+		MaxCacheBytes:      *maxCacheBytes,
+		DeniedCapabilities: denied,
 	}
 	if hookBefore != nil {
 		retcode = hookBefore()
@@ -132,13 +160,23 @@ func Main() (retcode int) { //nolint:funlen // we do have quite a lot of flags a
 	c := extensions.Config{
 		HasLoad:           !*disableLoadSave,
 		HasSave:           !*disableLoadSave,
+		HasImport:         !*emptyOnly,
+		ImportPath:        filepath.SplitList(*importPath),
 		UnrestrictedIOs:   !*restrictIOs,
 		LoadSaveEmptyOnly: *emptyOnly,
 	}
-	err := extensions.Init(&c)
+	err = extensions.Init(&c)
 	if err != nil {
 		return log.FErrf("Error initializing extensions: %v", err)
 	}
+	if flag.NArg() == 1 && flag.Arg(0) == "lsp" {
+		log.Infof("Starting language server on stdio")
+		return lsp.NewServer(os.Stdin, os.Stdout).Run()
+	}
+	if flag.NArg() == 1 && flag.Arg(0) == "dap" {
+		log.Infof("Starting debug adapter on stdio")
+		return debugger.NewDAPServer(os.Stdin, os.Stdout).Run()
+	}
 	if *commandFlag != "" {
 		res, errs, _ := repl.EvalStringWithOption(context.Background(), options, *commandFlag)
 		// Only parsing errors are already logged, eval errors aren't, we (re)log everything:
@@ -155,6 +193,10 @@ func Main() (retcode int) { //nolint:funlen // we do have quite a lot of flags a
 	options.All = true
 	s := eval.NewState()
 	s.NoReg = *noRegister
+	s.VM = *useVM
+	s.MaxSteps, s.MaxAlloc, s.MaxOutput = *maxSteps, *maxAlloc, *maxOutput
+	s.Cache().MaxEntries, s.Cache().MaxBytes = *maxCacheEntries, *maxCacheBytes
+	s.Capabilities &^= denied
 	if options.ShebangMode {
 		script := flag.Arg(0)
 		// remaining := flag.Args()[1:] // actually let's also pass the name of the script as arg[0]
@@ -170,6 +212,11 @@ func Main() (retcode int) { //nolint:funlen // we do have quite a lot of flags a
 	}
 	files := flag.Args()
 	numFiles := len(files)
+	var dbg *debugger.CLI
+	if *debugMode {
+		dbg = debugger.NewCLI(os.Stdin, os.Stdout)
+		dbg.Attach(s)
+	}
 	// Only use the progress bar if we have more than 1 file as input. eg. in `make grol-tests`
 	// and not disabled and stderr is a tty.
 	// progress bar also breaks check_tests_double_format.sh so we disable it for formatting.
@@ -203,7 +250,13 @@ func Main() (retcode int) { //nolint:funlen // we do have quite a lot of flags a
 			ns := eval.NewState()
 			ns.Out = s.Out
 			ns.LogOut = s.LogOut
+			ns.MaxSteps, ns.MaxAlloc, ns.MaxOutput = s.MaxSteps, s.MaxAlloc, s.MaxOutput
+			ns.Cache().MaxEntries, ns.Cache().MaxBytes = s.Cache().MaxEntries, s.Cache().MaxBytes
+			ns.Capabilities = s.Capabilities
 			s = ns
+			if dbg != nil {
+				dbg.Attach(s)
+			}
 		}
 	}
 	if usePbar {
@@ -0,0 +1,169 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Objects for running functions concurrently: channels to pass values between goroutines and
+// the tasks returned by go() to wait for their results.
+
+var lastID atomic.Int64
+
+// Channel is a grol channel (chan(n)) of values between goroutines, copies refer to the same
+// channel. Unlike Go's, sending to a closed channel is an error (and not a panic), closing it
+// again does nothing and receiving from a closed one returns nil once it's drained.
+type Channel struct {
+	*channel
+}
+
+type channel struct {
+	id     int64
+	values chan Object
+	closed chan struct{}
+	close  sync.Once
+}
+
+// ErrClosedChannel is the error of sending to a closed channel.
+var ErrClosedChannel = errors.New("send on closed channel")
+
+func NewChannel(size int) Channel {
+	return Channel{&channel{id: lastID.Add(1), values: make(chan Object, size), closed: make(chan struct{})}}
+}
+
+func (c Channel) Type() Type { return CHANNEL }
+func (c Channel) Inspect() string {
+	return fmt.Sprintf("<chan#%d %d/%d>", c.id, len(c.values), cap(c.values))
+}
+func (c Channel) Unwrap(_ bool) any { return c }
+func (c Channel) JSON(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%q", c.Inspect())
+	return err
+}
+
+// Send blocks until the value is queued (or received for unbuffered channels), the channel is
+// closed or the context is done.
+func (c Channel) Send(ctx context.Context, value Object) error {
+	select { // checked first as select picks randomly among the ready cases.
+	case <-c.closed:
+		return ErrClosedChannel
+	default:
+	}
+	select {
+	case c.values <- value:
+		return nil
+	case <-c.closed:
+		return ErrClosedChannel
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Recv blocks until a value is received or the context is done. ok is false when the channel is
+// closed and has no more values.
+func (c Channel) Recv(ctx context.Context) (value Object, ok bool, err error) {
+	select {
+	case v := <-c.values:
+		return v, true, nil
+	case <-c.closed:
+		v, ok := c.drain()
+		return v, ok, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// drain returns one of the values left in the closed channel, if any.
+func (c Channel) drain() (Object, bool) {
+	select {
+	case v := <-c.values:
+		return v, true
+	default:
+		return NULL, false
+	}
+}
+
+// Close closes the channel, returning false if it was already closed.
+func (c Channel) Close() bool {
+	closed := false
+	c.close.Do(func() {
+		close(c.closed)
+		closed = true
+	})
+	return closed
+}
+
+// RecvAny blocks until one of the channels has a value, or is closed and drained, or the context
+// is done and returns the index of that channel, like a select statement.
+func RecvAny(ctx context.Context, channels []Channel) (index int, value Object, ok bool, err error) {
+	cases := make([]reflect.SelectCase, 0, 2*len(channels)+1)
+	for _, c := range channels {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.values)})
+	}
+	for _, c := range channels {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.closed)})
+	}
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+	chosen, v, _ := reflect.Select(cases)
+	switch {
+	case chosen < len(channels):
+		return chosen, v.Interface().(Object), true, nil
+	case chosen < 2*len(channels):
+		index = chosen - len(channels)
+		value, ok = channels[index].drain()
+		return index, value, ok, nil
+	default:
+		return -1, nil, false, ctx.Err()
+	}
+}
+
+// Task is the handle of a function call running in its own goroutine (see go()), to wait for
+// its result.
+type Task struct {
+	*task
+}
+
+type task struct {
+	id     int64
+	done   chan struct{}
+	result Object
+}
+
+// NewTask returns a new task and the function to call with its result when it's done.
+func NewTask() (Task, func(Object)) {
+	t := Task{&task{id: lastID.Add(1), done: make(chan struct{})}}
+	return t, func(result Object) {
+		t.result = result
+		close(t.done)
+	}
+}
+
+func (t Task) Type() Type { return TASK }
+func (t Task) Inspect() string {
+	select {
+	case <-t.done:
+		return fmt.Sprintf("<task#%d done>", t.id)
+	default:
+		return fmt.Sprintf("<task#%d running>", t.id)
+	}
+}
+func (t Task) Unwrap(_ bool) any { return t }
+func (t Task) JSON(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%q", t.Inspect())
+	return err
+}
+
+// Wait blocks until the task is done, returning its result, or the context is done.
+func (t Task) Wait(ctx context.Context) (Object, error) {
+	select {
+	case <-t.done:
+		return t.result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
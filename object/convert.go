@@ -0,0 +1,400 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Conversions between grol objects and Go values, e.g. for host applications to pass data to
+// scripts and read their results back (and for extensions.Bind).
+
+var (
+	objectType = reflect.TypeFor[Object]()
+	bigIntType = reflect.TypeFor[*big.Int]()
+	timeType   = reflect.TypeFor[time.Time]()
+)
+
+// FromGo converts the Go value to a grol object. Supported are integers (as BigInt when they
+// don't fit in an int64), floats, strings, bools, slices and arrays, maps, structs (as maps of their
+// exported fields, see [ToGo] for the tags), *big.Int and big.Int, time.Time (as float seconds
+// since epoch, like time.now()), pointers to these (nil is nil) and Object (as is). Big integers
+// are copied and cyclic values return an error.
+func FromGo(v any) (Object, error) {
+	return visiting{}.fromGo(reflect.ValueOf(v))
+}
+
+// ToGo converts the grol object to a Go value of type T, the reverse of [FromGo]. Struct fields
+// are set from the map entries of the same name, or the one from a `grol:"name"` tag; `grol:"-"`
+// skips the field (and `grol:"name,omitempty"` omits zero values in FromGo). Floats accept
+// integers, time.Time accepts numbers of seconds since epoch and RFC 3339 strings and interfaces
+// get the Unwrap()ed value. For instance
+//
+//	cfg, err := object.ToGo[Config](res)
+func ToGo[T any](o Object) (T, error) {
+	var res T
+	v, err := ToGoValue(o, reflect.TypeFor[T]())
+	if err != nil {
+		return res, err
+	}
+	reflect.ValueOf(&res).Elem().Set(v)
+	return res, nil
+}
+
+// structField returns the grol map key for the struct field, and whether to omit zero values.
+// The key is empty for fields to skip.
+func structField(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	tag, found := field.Tag.Lookup("grol")
+	if !found {
+		return field.Name, false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		name = field.Name
+	}
+	return name, opts == "omitempty"
+}
+
+// visit is a pointer (and length, for slices) being converted.
+type visit struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+// visiting tracks the pointers on the current conversion path, so that cyclic values return an
+// error instead of recursing forever (values shared without cycles are fine).
+type visiting map[visit]bool
+
+// enter returns an error if v is already being converted, or records it until leave is called.
+func (c visiting) enter(v visit) error {
+	if v.ptr == 0 {
+		return nil
+	}
+	if c[v] {
+		return fmt.Errorf("cyclic value of type %s", v.typ)
+	}
+	c[v] = true
+	return nil
+}
+
+func (c visiting) leave(v visit) {
+	delete(c, v)
+}
+
+// objectVisit is the visit of the grol arrays and maps that can be made cyclic from Go (by
+// changing their Elements() or Set()ting a map in itself), and the zero visit for other objects.
+func objectVisit(o Object) visit {
+	switch v := o.(type) {
+	case BigArray:
+		return visit{reflect.ValueOf(v.elements).Pointer(), len(v.elements), reflect.TypeOf(v)}
+	case *BigMap:
+		return visit{reflect.ValueOf(v).Pointer(), 0, reflect.TypeOf(v)}
+	}
+	return visit{}
+}
+
+// goVisit is the visit of Go pointers, maps and slices, and the zero visit for other values.
+func goVisit(v reflect.Value) visit {
+	switch v.Kind() { //nolint:exhaustive // only these can be cyclic.
+	case reflect.Pointer, reflect.Map:
+		return visit{v.Pointer(), 0, v.Type()}
+	case reflect.Slice:
+		return visit{v.Pointer(), v.Len(), v.Type()}
+	}
+	return visit{}
+}
+
+// ToGoValue converts the grol object to a Go value of type t, see [ToGo].
+func ToGoValue(o Object, t reflect.Type) (reflect.Value, error) {
+	return visiting{}.toGoValue(o, t)
+}
+
+func (c visiting) toGoValue(o Object, t reflect.Type) (reflect.Value, error) { //nolint:gocognit,gocyclo,funlen // one case per kind.
+	o = Value(o)
+	switch {
+	case t == objectType:
+		return reflect.ValueOf(&o).Elem(), nil
+	case t == bigIntType || t == bigIntType.Elem():
+		b, ok := BigIntValue(o)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("expected an integer, got %s", o.Type())
+		}
+		b = new(big.Int).Set(b) // so the Go side can't change the grol value.
+		if t == bigIntType {
+			return reflect.ValueOf(b), nil
+		}
+		return reflect.ValueOf(b).Elem(), nil
+	case t == timeType:
+		return toTime(o)
+	case t.Kind() == reflect.Interface:
+		if o == NULL {
+			return reflect.Zero(t), nil
+		}
+		v := reflect.ValueOf(o.Unwrap(false))
+		if !v.IsValid() {
+			return reflect.Zero(t), nil
+		}
+		if !v.Type().AssignableTo(t) {
+			return reflect.Value{}, fmt.Errorf("%s (%s) isn't a %s", o.Type(), v.Type(), t)
+		}
+		return v, nil
+	}
+	res := reflect.New(t).Elem()
+	if t.Kind() != reflect.Pointer { // converts the same o to t.Elem().
+		ov := objectVisit(o)
+		if err := c.enter(ov); err != nil {
+			return res, err
+		}
+		defer c.leave(ov)
+	}
+	switch t.Kind() { //nolint:exhaustive // others aren't supported.
+	case reflect.Bool:
+		b, ok := o.(Boolean)
+		if !ok {
+			return res, fmt.Errorf("expected a boolean, got %s", o.Type())
+		}
+		res.SetBool(b.Value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := o.(Integer)
+		if !ok {
+			return res, fmt.Errorf("expected an integer, got %s", o.Type())
+		}
+		if res.OverflowInt(i.Value) {
+			return res, fmt.Errorf("%d overflows %s", i.Value, t)
+		}
+		res.SetInt(i.Value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		switch i := o.(type) {
+		case Integer:
+			if i.Value < 0 {
+				return res, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			u = uint64(i.Value)
+		case BigInt: // from FromGo of large uint64s.
+			if !i.Value.IsUint64() {
+				return res, fmt.Errorf("%s overflows %s", i.Value, t)
+			}
+			u = i.Value.Uint64()
+		default:
+			return res, fmt.Errorf("expected an integer, got %s", o.Type())
+		}
+		if res.OverflowUint(u) {
+			return res, fmt.Errorf("%d overflows %s", u, t)
+		}
+		res.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		switch f := o.(type) {
+		case Float:
+			res.SetFloat(f.Value)
+		case Integer:
+			res.SetFloat(float64(f.Value))
+		default:
+			return res, fmt.Errorf("expected a float, got %s", o.Type())
+		}
+	case reflect.String:
+		str, ok := o.(String)
+		if !ok {
+			return res, fmt.Errorf("expected a string, got %s", o.Type())
+		}
+		res.SetString(str.Value)
+	case reflect.Slice, reflect.Array:
+		if o == NULL && t.Kind() == reflect.Slice {
+			return res, nil
+		}
+		if o.Type() != ARRAY {
+			return res, fmt.Errorf("expected an array, got %s", o.Type())
+		}
+		elements := Elements(o)
+		if t.Kind() == reflect.Slice {
+			res = reflect.MakeSlice(t, len(elements), len(elements))
+		} else if len(elements) != t.Len() {
+			return res, fmt.Errorf("expected %d elements, got %d", t.Len(), len(elements))
+		}
+		for i, e := range elements {
+			v, err := c.toGoValue(e, t.Elem())
+			if err != nil {
+				return res, fmt.Errorf("[%d]: %w", i, err)
+			}
+			res.Index(i).Set(v)
+		}
+	case reflect.Map:
+		if o == NULL {
+			return res, nil
+		}
+		m, ok := o.(Map)
+		if !ok {
+			return res, fmt.Errorf("expected a map, got %s", o.Type())
+		}
+		res = reflect.MakeMapWithSize(t, m.Len())
+		for _, key := range Elements(m) {
+			k, err := c.toGoValue(key, t.Key())
+			if err != nil {
+				return res, fmt.Errorf("key %s: %w", key.Inspect(), err)
+			}
+			value, _ := m.Get(key)
+			v, err := c.toGoValue(value, t.Elem())
+			if err != nil {
+				return res, fmt.Errorf("[%s]: %w", key.Inspect(), err)
+			}
+			res.SetMapIndex(k, v)
+		}
+	case reflect.Struct:
+		m, ok := o.(Map)
+		if !ok {
+			return res, fmt.Errorf("expected a map, got %s", o.Type())
+		}
+		for i := range t.NumField() {
+			name, _ := structField(t.Field(i))
+			if name == "" {
+				continue
+			}
+			value, found := m.Get(String{Value: name})
+			if !found {
+				continue
+			}
+			v, err := c.toGoValue(value, t.Field(i).Type)
+			if err != nil {
+				return res, fmt.Errorf("%s: %w", name, err)
+			}
+			res.Field(i).Set(v)
+		}
+	case reflect.Pointer:
+		if o == NULL {
+			return res, nil
+		}
+		v, err := c.toGoValue(o, t.Elem())
+		if err != nil {
+			return res, err
+		}
+		res.Set(reflect.New(t.Elem()))
+		res.Elem().Set(v)
+	default:
+		return res, fmt.Errorf("unsupported type %s", t)
+	}
+	return res, nil
+}
+
+func toTime(o Object) (reflect.Value, error) {
+	var t time.Time
+	switch v := o.(type) {
+	case Float:
+		t = time.UnixMicro(int64(math.Round(v.Value * 1e6)))
+	case Integer:
+		t = time.Unix(v.Value, 0)
+	case String:
+		var err error
+		t, err = time.Parse(time.RFC3339Nano, v.Value)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+	default:
+		return reflect.Value{}, fmt.Errorf("expected a time in seconds or a string, got %s", o.Type())
+	}
+	return reflect.ValueOf(t), nil
+}
+
+func (c visiting) fromGo(v reflect.Value) (Object, error) { //nolint:gocyclo,funlen // one case per kind.
+	if !v.IsValid() {
+		return NULL, nil
+	}
+	switch v.Type() {
+	case bigIntType:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return BigInt{Value: new(big.Int).Set(v.Interface().(*big.Int))}, nil // so the Go side can't change it.
+	case bigIntType.Elem():
+		b := v.Interface().(big.Int)
+		return BigInt{Value: new(big.Int).Set(&b)}, nil
+	case timeType:
+		return Float{Value: float64(v.Interface().(time.Time).UnixMicro()) / 1e6}, nil
+	}
+	gv := goVisit(v)
+	if err := c.enter(gv); err != nil {
+		return nil, err
+	}
+	defer c.leave(gv)
+	switch v.Kind() { //nolint:exhaustive // others aren't supported.
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		if o, ok := v.Interface().(Object); ok {
+			return o, nil
+		}
+		return c.fromGo(v.Elem())
+	case reflect.Bool:
+		return NativeBoolToBooleanObject(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := v.Uint()
+		if u > math.MaxInt64 {
+			return BigInt{Value: new(big.Int).SetUint64(u)}, nil
+		}
+		return Integer{Value: int64(u)}, nil
+	case reflect.Float32, reflect.Float64:
+		return Float{Value: v.Float()}, nil
+	case reflect.String:
+		return String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NULL, nil
+		}
+		elements := MakeObjectSlice(v.Len())
+		for i := range v.Len() {
+			e, err := c.fromGo(v.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			elements = append(elements, e)
+		}
+		return NewArray(elements), nil
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		m := NewMapSize(v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := c.fromGo(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			value, err := c.fromGo(iter.Value())
+			if err != nil {
+				return nil, fmt.Errorf("[%s]: %w", key.Inspect(), err)
+			}
+			m = m.Set(key, value)
+		}
+		return m, nil
+	case reflect.Struct:
+		t := v.Type()
+		m := NewMapSize(t.NumField())
+		for i := range t.NumField() {
+			name, omitEmpty := structField(t.Field(i))
+			if name == "" || (omitEmpty && v.Field(i).IsZero()) {
+				continue
+			}
+			value, err := c.fromGo(v.Field(i))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			m = m.Set(String{Value: name}, value)
+		}
+		return m, nil
+	}
+	return nil, errors.New("unsupported type " + v.Type().String())
+}
@@ -5,21 +5,31 @@ import (
 	"fmt"
 	"maps"
 	"strings"
+	"sync"
 
 	"grol.io/grol/lexer"
 )
 
 type ExtensionMap map[string]Extension
 
+// The registries of extended functions and identifiers, shared by all the interpreter states
+// which can run concurrently, hence the mutex.
 var (
 	extraFunctions   ExtensionMap
 	extraIdentifiers map[string]Object
 	initDone         bool
+	registryMutex    sync.RWMutex
 )
 
 // Init resets the table of extended functions to empty.
 // Optional, will be called on demand the first time through CreateFunction.
 func Init() {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	initLocked()
+}
+
+func initLocked() {
 	extraFunctions = make(ExtensionMap)
 	extraIdentifiers = make(map[string]Object)
 	initDone = true
@@ -39,9 +49,16 @@ func ValidIdentifier(name string) bool {
 
 // CreateFunction adds a new function to the table of extended functions.
 func CreateFunction(cmd Extension) error {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
 	if !initDone {
-		Init()
+		initLocked()
 	}
+	return extraFunctions.Add(cmd)
+}
+
+// Add checks and adds the extension to the map, e.g. for a set of extensions specific to a state.
+func (m ExtensionMap) Add(cmd Extension) error {
 	if cmd.Name == "" {
 		return errors.New("empty command name")
 	}
@@ -64,24 +81,30 @@ func CreateFunction(cmd Extension) error {
 	if len(cmd.ArgTypes) < cmd.MinArgs {
 		return errors.New(cmd.Name + ": arg types < min args")
 	}
-	if _, ok := extraFunctions[cmd.Name]; ok {
+	if _, ok := m[cmd.Name]; ok {
 		return errors.New(cmd.Name + ": already defined")
 	}
 	cmd.Variadic = (cmd.MaxArgs == -1) || (cmd.MaxArgs > cmd.MinArgs)
 	// If namespaced, put both at top level (for sake of baseinfo and command completion) and
 	// in namespace map (for access/ref by eval). We decided to not even have namespaces map
 	// after all.
-	extraFunctions[cmd.Name] = cmd
+	m[cmd.Name] = cmd
 	return nil
 }
 
-// ExtraFunctions returns the table of extended functions to seed the state of an eval.
+// ExtraFunctions returns a copy of the table of extended functions to seed the state of an eval
+// (so it can be changed by the state and while that state runs).
 func ExtraFunctions() ExtensionMap {
-	// no need to make a copy as each value need to be set to be changed (map of structs, not pointers).
-	return extraFunctions
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	copied := make(ExtensionMap, len(extraFunctions))
+	maps.Copy(copied, extraFunctions)
+	return copied
 }
 
 func IsExtraFunction(name string) bool {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
 	_, ok := extraFunctions[name]
 	return ok
 }
@@ -89,8 +112,10 @@ func IsExtraFunction(name string) bool {
 // AddIdentifier adds values to top level environment, e.g "pi" -> 3.14159...
 // or "printf(){print(sprintf(%s, args...))}".
 func AddIdentifier(name string, value Object) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
 	if !initDone {
-		Init()
+		initLocked()
 	}
 	extraIdentifiers[name] = value
 }
@@ -99,6 +124,12 @@ func isConstantAndExtraIdentifier(name string) bool {
 	if !Constant(name) {
 		return false
 	}
+	return isExtraIdentifier(name)
+}
+
+func isExtraIdentifier(name string) bool {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
 	_, ok := extraIdentifiers[name]
 	return ok
 }
@@ -106,9 +137,8 @@ func isConstantAndExtraIdentifier(name string) bool {
 // This makes a copy of the extraIdentifiers map to serve as initial Environment without mutating the original.
 // use to setup the root environment for the interpreter state.
 func initialIdentifiersCopy() map[string]Object {
-	if !initDone {
-		Init()
-	}
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
 	// we'd use maps.Clone except for tinygo not having it.
 	// https://github.com/tinygo-org/tinygo/issues/4382
 	copied := make(map[string]Object, len(extraIdentifiers))
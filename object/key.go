@@ -0,0 +1,74 @@
+package object
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// AppendKey appends to b an encoding of the value that is the same for equal values and different
+// otherwise: structural for arrays and maps (of any size and nesting) and by code for functions.
+// It's used for memoization keys of values that aren't Hashable. ok is false for values that
+// can't be part of such keys, like closures (functions not defined at the top level, whose
+// result can depend on the values they captured), errors or macros.
+func AppendKey(b []byte, o Object) ([]byte, bool) {
+	o = Value(o)
+	switch v := o.(type) {
+	case Integer:
+		return binary.AppendVarint(append(b, 'i'), v.Value), true
+	case Float:
+		return binary.LittleEndian.AppendUint64(append(b, 'f'), math.Float64bits(v.Value)), true
+	case BigInt:
+		return appendString(append(b, 'b'), v.Value.Text(62)), true
+	case Boolean:
+		if v.Value {
+			return append(b, 'T'), true
+		}
+		return append(b, 'F'), true
+	case Null:
+		return append(b, 'n'), true
+	case String:
+		return appendString(append(b, 's'), v.Value), true
+	case Extension:
+		return appendString(append(b, 'e'), v.Name), true
+	case Function:
+		if v.CacheKey == "" || v.Env == nil || v.Env.depth != 0 {
+			return b, false
+		}
+		return appendString(append(b, 'g'), v.CacheKey), true
+	case SmallMap:
+		return appendPairs(b, v.smallKV[:v.len])
+	case *BigMap:
+		return appendPairs(b, v.kv)
+	}
+	if o.Type() != ARRAY {
+		return b, false
+	}
+	elements := Elements(o)
+	b = binary.AppendUvarint(append(b, 'a'), uint64(len(elements)))
+	ok := true
+	for _, e := range elements {
+		if b, ok = AppendKey(b, e); !ok {
+			return b, false
+		}
+	}
+	return b, true
+}
+
+// appendPairs appends the map's sorted key value pairs.
+func appendPairs(b []byte, kvs []keyValuePair) ([]byte, bool) {
+	b = binary.AppendUvarint(append(b, 'm'), uint64(len(kvs)))
+	ok := true
+	for _, kv := range kvs {
+		if b, ok = AppendKey(b, kv.Key); !ok {
+			return b, false
+		}
+		if b, ok = AppendKey(b, kv.Value); !ok {
+			return b, false
+		}
+	}
+	return b, true
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+}
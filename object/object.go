@@ -44,7 +44,9 @@ const (
 	EXTENSION
 	REFERENCE
 	REGISTER
-	ANY // A marker, for extensions, not a real type.
+	CHANNEL // Channel between goroutines, see chan().
+	TASK    // Function running in its own goroutine, see go().
+	ANY     // A marker, for extensions, not a real type.
 )
 
 // Extension categories.
@@ -55,6 +57,7 @@ const (
 	CategoryTime          = "time"
 	CategoryIO            = "io"
 	CategoryImage         = "image"
+	CategoryConcurrency   = "concurrency"
 )
 
 //go:generate stringer -type=Type
@@ -272,6 +275,10 @@ func Cmp(ei, ej Object) int { //nolint:gocognit,gocyclo,funlen // big switch...
 		return -1
 	case STRING:
 		return cmp.Compare(ei.(String).Value, ej.(String).Value)
+	case CHANNEL:
+		return cmp.Compare(ei.(Channel).id, ej.(Channel).id)
+	case TASK:
+		return cmp.Compare(ei.(Task).id, ej.(Task).id)
 
 	// RETURN, QUOTE, MACRO, ANY aren't expected to be compared.
 	case RETURN, QUOTE, MACRO, UNKNOWN, ANY:
@@ -637,9 +644,41 @@ func (n Null) Unwrap(_ bool) any { return nil }
 func (n Null) Type() Type        { return NIL }
 func (n Null) Inspect() string   { return "nil" }
 
+// Kinds of errors, so scripts (through catch()) and Go code can handle specific failures without
+// matching messages.
+const (
+	ParseError       = "parse"   // invalid code given to eval(), load(), import...
+	TypeError        = "type"    // wrong type or number of arguments, operator not supported by the types...
+	IndexError       = "index"   // invalid index or range.
+	TimeoutError     = "timeout" // the evaluation was canceled or took more than its maximum duration.
+	IOError          = "io"      // file not found, permission denied...
+	UserError        = "user"    // error() from the script.
+	StepsQuotaError  = "steps_quota"
+	AllocQuotaError  = "alloc_quota"
+	OutputQuotaError = "output_quota"
+	CapabilityError  = "capability" // extension requiring a capability the state doesn't have.
+	DepthError       = "depth"      // recursion deeper than the state's MaxDepth.
+)
+
 type Error struct {
-	Value string // message
-	Stack []string
+	Value  string // message
+	Kind   string // kind of error (e.g. TypeError) or empty when it isn't one of the above.
+	Data   Object // optional payload, e.g. the map given to error().
+	Cause  *Error // wrapped error, e.g. the one in the code given to eval().
+	Stack  []string
+	File   string         // file where the error occurred, if known.
+	Pos    token.Position // line and column of the innermost node that produced the error, if known.
+	Source string         // source line at Pos, if known.
+}
+
+// Error implements the Go error interface, so errors.As can retrieve the *object.Error from the
+// errors returned by eval functions: the message prefixed by the location if known. It's on the
+// pointer as the value's Unwrap(bool) is the Object one, not the errors package one.
+func (e *Error) Error() string {
+	if loc := e.Location(); loc != "" {
+		return loc + ": " + e.Value
+	}
+	return e.Value
 }
 
 // Errorf creates an error object with a formatted message. Use eval's Errorf() instead whenever possible to get the stack.
@@ -664,22 +703,51 @@ func (e Error) Unwrap(forceStringKeys bool) any {
 	}
 	return errors.New(e.Value)
 }
-func (e Error) Error() string { return e.Value }
-func (e Error) Type() Type    { return ERROR }
-func (e Error) Inspect() string {
-	if len(e.Stack) == 0 {
-		return fmt.Sprintf("<err: %s>", e.Value)
-	}
-	if len(e.Stack) == 1 {
-		return fmt.Sprintf("<err: %s in %s>", e.Value, e.Stack[0])
+func (e Error) Type() Type { return ERROR }
+
+// Location returns file:line:col of the error or an empty string if not known.
+func (e Error) Location() string {
+	if e.File == "" || !e.Pos.IsValid() {
+		return ""
 	}
+	return e.File + ":" + e.Pos.String()
+}
+
+func (e Error) Inspect() string {
 	out := strings.Builder{}
 	out.WriteString("<err: ")
+	if loc := e.Location(); loc != "" {
+		out.WriteString(loc)
+		out.WriteString(": ")
+	}
 	out.WriteString(e.Value)
-	out.WriteString(", stack below:>")
-	for _, s := range e.Stack {
+	switch len(e.Stack) {
+	case 0:
+		out.WriteString(">")
+	case 1:
+		out.WriteString(" in ")
+		out.WriteString(e.Stack[0])
+		out.WriteString(">")
+	default:
+		out.WriteString(", stack below:>")
+		for _, s := range e.Stack {
+			out.WriteByte('\n')
+			out.WriteString(s)
+		}
+	}
+	if e.Source != "" && e.Location() != "" {
+		out.WriteByte('\n')
+		out.WriteString(e.Source)
 		out.WriteByte('\n')
-		out.WriteString(s)
+		// Keep tabs so the ^ lines up with the source line.
+		for i := 0; i < e.Pos.Column-1 && i < len(e.Source); i++ {
+			if e.Source[i] == '\t' {
+				out.WriteByte('\t')
+			} else {
+				out.WriteByte(' ')
+			}
+		}
+		out.WriteByte('^')
 	}
 	return out.String()
 }
@@ -769,7 +837,7 @@ func (f Function) finishFuncOutput(out *strings.Builder, compact bool) string {
 		out.WriteString("(")
 	}
 	ps := &ast.PrintState{Out: out, Compact: compact}
-	ps.ComaList(f.Parameters)
+	ps.PatternList(f.Parameters)
 	if f.Lambda {
 		return f.lambdaPrint(ps, out)
 	}
@@ -1321,6 +1389,65 @@ type Extension struct {
 	ClientData any         // Opaque data that will be passed as first argument of Callback if set (state is, if nil).
 	Variadic   bool        // MaxArgs > MinArgs (or MaxArg == -1)
 	DontCache  bool        // If true, the result of this function should not be cached (has side effects).
+	// Capabilities the state must have been granted for the function to be called (e.g. CapProcess).
+	Capabilities Capability
+}
+
+// Capability is a set of permissions that extensions can require and states grant.
+type Capability uint8
+
+const (
+	CapFSRead  Capability = 1 << iota // reading files (load, import...).
+	CapFSWrite                        // writing files (save, image.save...).
+	CapProcess                        // running other processes (exec, run).
+	CapStdin                          // reading the standard input (read, eof).
+	CapTime                           // the current time and sleeping.
+	CapRandom                         // random numbers.
+)
+
+const (
+	numCapabilities = len(capabilityNames)
+
+	CapNone Capability = 0
+	CapAll  Capability = 1<<numCapabilities - 1 // all of the above, the default for NewState().
+)
+
+var capabilityNames = [...]string{"fs-read", "fs-write", "process", "stdin", "time", "random"}
+
+// Names returns the names of the capabilities in the set.
+func (c Capability) Names() []string {
+	var names []string
+	for i, name := range capabilityNames {
+		if c&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (c Capability) String() string {
+	return strings.Join(c.Names(), ",")
+}
+
+// ParseCapabilities returns the set of comma separated capability names, "all" or "none".
+func ParseCapabilities(list string) (Capability, error) {
+	res := CapNone
+	for name := range strings.SplitSeq(list, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "", "none":
+			continue
+		case "all":
+			res = CapAll
+			continue
+		}
+		i := slices.Index(capabilityNames[:], name)
+		if i < 0 {
+			return res, fmt.Errorf("unknown capability %q, valid ones are %s, all or none", name, CapAll)
+		}
+		res |= 1 << i
+	}
+	return res, nil
 }
 
 // ShortCallback adapts functions that only need the arguments.
@@ -1387,9 +1514,12 @@ func (e Extension) Inspect() string {
 	e.Usage(&out)
 	out.WriteString(")")
 	if e.Help != "" {
-		out.WriteString(" // [")
-		out.WriteString(e.Category)
-		out.WriteString("] ")
+		out.WriteString(" // ")
+		if e.Category != "" {
+			out.WriteString("[")
+			out.WriteString(e.Category)
+			out.WriteString("] ")
+		}
 		out.WriteString(e.Help)
 	}
 	return out.String()
@@ -1,7 +1,10 @@
 package object_test
 
 import (
+	"math/big"
+	"strconv"
 	"testing"
+	"time"
 
 	"grol.io/grol/object"
 )
@@ -77,3 +80,199 @@ func TestIsConstantIdentifier(t *testing.T) {
 		})
 	}
 }
+
+func TestParseCapabilities(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected object.Capability
+		err      bool
+	}{
+		{"", object.CapNone, false},
+		{"none", object.CapNone, false},
+		{"all", object.CapAll, false},
+		{"process", object.CapProcess, false},
+		{"fs-read, fs-write", object.CapFSRead | object.CapFSWrite, false},
+		{"time,foo", object.CapTime, true},
+	}
+	for _, tt := range tests {
+		actual, err := object.ParseCapabilities(tt.input)
+		if (err != nil) != tt.err || actual != tt.expected {
+			t.Errorf("ParseCapabilities(%q) got %v, %v expected %v (error %t)", tt.input, actual, err, tt.expected, tt.err)
+		}
+	}
+	if s := object.CapAll.String(); s != "fs-read,fs-write,process,stdin,time,random" {
+		t.Errorf("CapAll.String() got %q", s)
+	}
+}
+
+type inner struct {
+	Tags []string `grol:"tags"`
+}
+
+type record struct {
+	Name    string         `grol:"name"`
+	Count   uint64         `grol:"count"`
+	Ratio   float64        `grol:"ratio,omitempty"`
+	When    time.Time      `grol:"when"`
+	Big     *big.Int       `grol:"big"`
+	Inner   *inner         `grol:"inner"`
+	Extra   map[string]int `grol:"extra"`
+	Secret  string         `grol:"-"`
+	Any     any            `grol:"any"`
+	Raw     object.Object  `grol:"raw"`
+	Default bool
+	hidden  int
+}
+
+func TestFromGoToGo(t *testing.T) {
+	in := record{
+		Name:    "test",
+		Count:   1<<63 + 1,
+		When:    time.Date(2024, 5, 6, 7, 8, 9, 500000000, time.UTC),
+		Big:     big.NewInt(-42),
+		Inner:   &inner{Tags: []string{"a", "b"}},
+		Extra:   map[string]int{"x": 1},
+		Secret:  "not exported",
+		Any:     "anything",
+		Raw:     object.NULL,
+		Default: true,
+		hidden:  3,
+	}
+	o, err := object.FromGo(in)
+	if err != nil {
+		t.Fatalf("FromGo: %v", err)
+	}
+	expected := `{"Default":true,"any":"anything","big":-42,"count":9223372036854775809,"extra":{"x":1},` +
+		`"inner":{"tags":["a","b"]},"name":"test","raw":nil,"when":1714979289.5}`
+	if actual := o.Inspect(); actual != expected {
+		t.Errorf("FromGo got %s, expected %s", actual, expected)
+	}
+	out, err := object.ToGo[record](o)
+	if err != nil {
+		t.Fatalf("ToGo: %v", err)
+	}
+	in.Secret, in.hidden = "", 0
+	if out.Name != in.Name || out.Count != in.Count || !out.When.Equal(in.When) || out.Big.Cmp(in.Big) != 0 ||
+		len(out.Inner.Tags) != 2 || out.Extra["x"] != 1 || out.Secret != "" || out.Any != "anything" ||
+		out.Raw != object.NULL || !out.Default {
+		t.Errorf("ToGo got %+v, expected %+v", out, in)
+	}
+	if _, err := object.ToGo[record](object.String{Value: "x"}); err == nil || err.Error() != "expected a map, got STRING" {
+		t.Errorf("expected error for non map, got %v", err)
+	}
+	m := object.NewMap().Set(object.String{Value: "inner"}, object.NewMap().Set(object.String{Value: "tags"},
+		object.NewArray([]object.Object{object.Integer{Value: 1}})))
+	if _, err := object.ToGo[record](m); err == nil || err.Error() != "inner: tags: [0]: expected a string, got INTEGER" {
+		t.Errorf("expected error for nested wrong type, got %v", err)
+	}
+	when, err := object.ToGo[time.Time](object.String{Value: "2024-05-06T07:08:09Z"})
+	if err != nil || when.Unix() != 1714979289 {
+		t.Errorf("ToGo time from string got %v, %v", when, err)
+	}
+	if _, err := object.FromGo(make(chan int)); err == nil {
+		t.Errorf("expected error for unsupported type")
+	}
+}
+
+type node struct {
+	Name string
+	Next *node
+}
+
+func TestFromGoToGoCycles(t *testing.T) {
+	n := &node{Name: "a"}
+	n.Next = &node{Name: "b", Next: n}
+	if _, err := object.FromGo(n); err == nil || err.Error() != "Next: Next: cyclic value of type *object_test.node" {
+		t.Errorf("expected cycle error for pointers, got %v", err)
+	}
+	l := []any{1, nil}
+	l[1] = l
+	if _, err := object.FromGo(l); err == nil || err.Error() != "[1]: cyclic value of type []interface {}" {
+		t.Errorf("expected cycle error for slices, got %v", err)
+	}
+	m := map[string]any{}
+	m["self"] = m
+	if _, err := object.FromGo(m); err == nil {
+		t.Errorf("expected cycle error for maps")
+	}
+	// Shared without cycles is fine.
+	shared := &node{Name: "s"}
+	o, err := object.FromGo([]*node{shared, shared})
+	if err != nil || o.Inspect() != `[{"Name":"s","Next":nil},{"Name":"s","Next":nil}]` {
+		t.Errorf("unexpected %v, %v", o, err)
+	}
+	bm := object.NewMapSize(object.MaxSmallMap + 1)
+	bm.Set(object.String{Value: "self"}, bm)
+	if _, err := object.ToGo[map[string]map[string]int](bm); err == nil {
+		t.Errorf("expected cycle error for grol maps")
+	}
+	p, err := object.ToGo[*[]int](object.NewArray([]object.Object{object.Integer{Value: 1}}))
+	if err != nil || len(*p) != 1 {
+		t.Errorf("pointers to slices should convert, got %v, %v", p, err)
+	}
+}
+
+func TestFromGoBigIntCopy(t *testing.T) {
+	b := big.NewInt(42)
+	o, err := object.FromGo(b)
+	if err != nil {
+		t.Fatalf("FromGo: %v", err)
+	}
+	b.SetInt64(7)
+	if o.Inspect() != "42" {
+		t.Errorf("changing the Go big.Int changed the grol value to %s", o.Inspect())
+	}
+}
+
+func TestAppendKey(t *testing.T) {
+	array := func(n int) object.Object {
+		elements := object.MakeObjectSlice(n)
+		for i := range n {
+			elements = append(elements, object.Integer{Value: int64(i)})
+		}
+		return object.NewArray(elements)
+	}
+	mkMap := func(last int) object.Object { // BigMap.Set changes the map in place.
+		m := object.NewMap()
+		for i := range 10 {
+			m = m.Set(object.String{Value: strconv.Itoa(i)}, array(i))
+		}
+		return m.Set(object.String{Value: "3"}, array(last))
+	}
+	m := mkMap(3)
+	key := func(values ...object.Object) string {
+		var b []byte
+		var ok bool
+		for _, v := range values {
+			if b, ok = object.AppendKey(b, v); !ok {
+				return "not ok"
+			}
+		}
+		return string(b)
+	}
+	same := [][2]object.Object{
+		{array(20), array(20)},
+		{m, mkMap(3)},
+		{object.NewArray([]object.Object{m, object.NULL}), object.NewArray([]object.Object{m, object.NULL})},
+	}
+	for _, tt := range same {
+		if key(tt[0]) != key(tt[1]) {
+			t.Errorf("expected same keys for %s and %s", tt[0].Inspect(), tt[1].Inspect())
+		}
+	}
+	different := [][2][]object.Object{
+		{{array(20)}, {array(21)}},
+		{{object.Integer{Value: 1}}, {object.Float{Value: 1}}},
+		{{object.String{Value: "ab"}}, {object.String{Value: "a"}, object.String{Value: "b"}}},
+		{{array(2), array(0)}, {array(1), array(1)}},
+		{{m}, {mkMap(4)}},
+	}
+	for _, tt := range different {
+		if key(tt[0]...) == key(tt[1]...) {
+			t.Errorf("expected different keys for %v and %v", tt[0], tt[1])
+		}
+	}
+	if _, ok := object.AppendKey(nil, object.Error{Value: "x"}); ok {
+		t.Errorf("errors shouldn't be usable as keys")
+	}
+}
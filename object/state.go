@@ -6,6 +6,7 @@ import (
 	"io"
 	"slices"
 	"sort"
+	"sync"
 
 	"fortio.org/cli"
 	"fortio.org/log"
@@ -27,7 +28,9 @@ type Environment struct {
 	numSet    int64
 	getMiss   int64
 	cantCache bool
+	updates   int64 // changes made through references (from other environments) and deletions.
 	function  *Function
+	block     bool // only holds some bindings, see NewBlockEnvironment.
 	registers [NumRegisters]int64
 	numReg    int
 	// Output buffering state
@@ -54,12 +57,23 @@ func (e *Environment) Len() int {
 	return len(e.store)
 }
 
-var baseInfo BigMap
+var (
+	baseInfo      BigMap
+	baseInfoMutex sync.Mutex
+)
 
+// BaseInfo returns a copy of the static part of info (which is computed once), for the caller
+// to add the globals and stack to.
 func (e *Environment) BaseInfo() *BigMap {
-	if baseInfo.kv != nil {
-		return &baseInfo
+	baseInfoMutex.Lock()
+	defer baseInfoMutex.Unlock()
+	if baseInfo.kv == nil {
+		initBaseInfo()
 	}
+	return &BigMap{kv: slices.Clone(baseInfo.kv)}
+}
+
+func initBaseInfo() {
 	baseInfo.kv = make([]keyValuePair, 0, 8) // 6 here + globals + stack
 	tokInfo := token.Info()
 	keys := make([]Object, 0, len(tokInfo.Keywords))
@@ -88,7 +102,6 @@ func (e *Environment) BaseInfo() *BigMap {
 	baseInfo.Set(String{"gofuncs"}, arr)                             // 4
 	baseInfo.Set(String{"version"}, String{Value: cli.ShortVersion}) // 5
 	baseInfo.Set(String{"platform"}, String{Value: cli.LongVersion}) // 6
-	return &baseInfo
 }
 
 func (e *Environment) Info() Object {
@@ -144,6 +157,28 @@ func (e *Environment) RegisterTrie(t *trie.Trie) {
 	t.Insert("info ") // magic extra identifier (need the space).
 }
 
+// runtimeOnly returns whether the value is, or contains (e.g. an array of tasks), a task or a channel.
+func runtimeOnly(v Object) bool {
+	v = Value(v)
+	switch v.Type() { //nolint:exhaustive // only these can contain tasks or channels.
+	case CHANNEL, TASK:
+		return true
+	case ARRAY:
+		return slices.ContainsFunc(Elements(v), runtimeOnly)
+	case MAP:
+		m := v.(Map)
+		for _, k := range Elements(m) {
+			if runtimeOnly(k) {
+				return true
+			}
+			if val, _ := m.Get(k); runtimeOnly(val) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // SaveGlobals saves and returns the number of ids written. maxValueLen <= 0 means no limit.
 func (e *Environment) SaveGlobals(to io.Writer, maxValueLen int) (int, error) {
 	for e.outer != nil {
@@ -161,6 +196,9 @@ func (e *Environment) SaveGlobals(to io.Writer, maxValueLen int) (int, error) {
 			continue
 		}
 		v := e.store[k]
+		if runtimeOnly(v) {
+			continue // only meaningful while running (and can't be loaded back).
+		}
 		if v.Type() == FUNC {
 			f := v.(Function)
 			if f.Name != nil {
@@ -205,6 +243,12 @@ func (e *Environment) MakeRegister(originalName string, v int64) Register {
 	return r
 }
 
+// ReleaseRegisters releases all the registers, e.g. to bind new values to the parameters of a
+// function environment that is reused for a tail call.
+func (e *Environment) ReleaseRegisters() {
+	e.numReg = 0
+}
+
 func (e *Environment) ReleaseRegister(register Register) {
 	if register.Idx != e.numReg-1 {
 		panic(fmt.Sprintf("Releasing non last register %s %d != %d", register.Literal(), register.Idx, e.numReg-1))
@@ -236,6 +280,12 @@ func (e *Environment) makeRef(name string) (*Reference, bool) {
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
+	if e.block {
+		if obj, ok := e.store[name]; ok {
+			return obj, true
+		}
+		return e.outer.Get(name)
+	}
 	if name == "info" {
 		e.TriggerNoCache()
 		return e.Info(), true
@@ -277,6 +327,7 @@ func (e *Environment) Delete(name string) Object {
 	}
 	if _, ok := e.store[name]; ok {
 		delete(e.store, name)
+		e.updates++
 		log.Debugf("Delete(%s) found at %d %v", name, e.depth, e.cacheKey)
 		return TRUE
 	}
@@ -289,6 +340,10 @@ func (e *Environment) Delete(name string) Object {
 // TriggerNoCache is used prevent this call stack from caching.
 // Meant to be used by extensions that for instance return random numbers or change state.
 func (e *Environment) TriggerNoCache() {
+	if e.block {
+		e.outer.TriggerNoCache()
+		return
+	}
 	log.Debugf("TriggerNoCache() GETMISS called at %d %v", e.depth, e.cacheKey)
 	e.cantCache = true
 	e.getMiss++
@@ -299,6 +354,22 @@ func (e *Environment) GetMisses() int64 {
 	return e.getMiss
 }
 
+// Updates returns how many times values in this environment were changed through references
+// from other environments (or deleted). The VM uses it to know when to reload its local slots.
+func (e *Environment) Updates() int64 {
+	return e.updates
+}
+
+// GetLocal returns the value stored in this environment itself, without looking up the outer ones
+// (but the enclosing one for block environments).
+func (e *Environment) GetLocal(name string) (Object, bool) {
+	obj, ok := e.store[name]
+	if !ok && e.block {
+		return e.outer.GetLocal(name)
+	}
+	return obj, ok
+}
+
 // CantCache returns true if a non-cacheable extension (like rand()) was called.
 func (e *Environment) CantCache() bool {
 	return e.cantCache
@@ -354,6 +425,7 @@ func (e *Environment) update(name string, found, val Object) Object {
 		log.Debugf("SetNoChecks(%s) updating ref %s in %d", name, rr.Name, rr.RefEnv.depth)
 		e = rr.RefEnv
 		name = rr.Name
+		e.updates++
 	}
 	e.store[name] = val
 	if e.depth == 0 {
@@ -373,11 +445,15 @@ func (e *Environment) SetNoChecks(name string, val Object, create bool) Object {
 	if ok {
 		return e.update(name, r, val)
 	}
+	if e.block {
+		return e.outer.SetNoChecks(name, val, false)
+	}
 	// New name... let's see if it's really new or making it a ref.
 	if ref, ok := e.makeRef(name); ok {
 		log.Debugf("SetNoChecks(%s) created ref %s in %d", name, ref.Name, ref.RefEnv.depth)
 		val = Value(val) // Dereference to store actual value, not a register/reference pointer.
 		ref.RefEnv.store[ref.Name] = val
+		ref.RefEnv.updates++
 		return val
 	}
 	log.Debugf("SetNoChecks(%s) brand new to %d and above", name, e.depth)
@@ -409,6 +485,24 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 	return env
 }
 
+// NewBlockEnvironment creates an environment for bindings only visible in a block (e.g. the
+// variables of a match arm's pattern), within the current one: other names are read and set in
+// the current environment itself, including their accounting for caching, so the block is
+// transparent to everything else (the function being applied, self, output buffering,...).
+func NewBlockEnvironment(current *Environment) *Environment {
+	return &Environment{
+		store:    make(map[string]Object),
+		outer:    current,
+		stack:    current,
+		depth:    current.depth + 1,
+		cacheKey: current.cacheKey,
+		block:    true,
+		// same output buffering (e.g. for FlushOutput).
+		OutputBuffer: current.OutputBuffer,
+		PrevOut:      current.PrevOut,
+	}
+}
+
 // NewFunctionEnvironment creates a new environment either based on original function definitions' environment
 // or the current one if the function is the same, that allows a function to set some values
 // visible through recursion to itself.
@@ -443,8 +537,143 @@ func (e *Environment) Name() string {
 	return e.function.Inspect()
 }
 
+// FunctionName returns the short name of the frame: the function's name, "func" for anonymous
+// functions and "" for non function environments (e.g. the top level).
+func (e *Environment) FunctionName() string {
+	if e.function == nil {
+		return ""
+	}
+	if e.function.Name == nil {
+		return "func"
+	}
+	return e.function.Name.Literal()
+}
+
+// Function returns the function this environment is the frame of, nil for non function
+// environments (e.g. the top level).
+func (e *Environment) Function() *Function {
+	if e.block {
+		return e.outer.Function()
+	}
+	return e.function
+}
+
+// Names returns the sorted names defined in this environment (not including the outer ones, but
+// including the enclosing one's for block environments), skipping, for the top level, the
+// predefined identifiers (PI, printf, etc...).
+func (e *Environment) Names() []string {
+	keys := make([]string, 0, len(e.store))
+	if e.block {
+		keys = append(keys, e.outer.Names()...)
+	}
+	for k := range e.store {
+		if e.outer == nil && e.function == nil {
+			if isExtraIdentifier(k) {
+				continue
+			}
+		}
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// IsBlock returns true for block environments (see NewBlockEnvironment), which are part of the
+// frame of the function they're in.
+func (e *Environment) IsBlock() bool {
+	return e.block
+}
+
+// Frame returns the environment of the function (or top level) frame e is part of: e itself
+// unless it's a block environment.
+func (e *Environment) Frame() *Environment {
+	for e.block {
+		e = e.outer
+	}
+	return e
+}
+
+// Outer returns the enclosing environment (where the function was defined), nil for the top level.
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
 // StackParent allows eval and others to walk up the stack of envs themselves
 // (using Name() to produce a stack trace for instance).
 func (e *Environment) StackParent() *Environment {
 	return e.stack
 }
+
+// Snapshot copies the environment and its outer ones, for a goroutine to use while this one keeps
+// changing. The returned function copies values, e.g. the arguments of the goroutine's function,
+// the same way the ones of the environments are: see [Isolate], and functions (and references)
+// of the copied environments use the copies instead.
+func (e *Environment) Snapshot() (*Environment, func(Object) Object) {
+	envs := make(snapshot)
+	return envs.env(e), envs.copy
+}
+
+// Isolate returns a copy of the value that can be used by another goroutine: arrays and maps,
+// which index assignments change in place, are copied (recursively), and so are the environments
+// of functions (closures), once each.
+func Isolate(o Object) Object {
+	return make(snapshot).copy(Value(o))
+}
+
+// snapshot maps the environments copied by Snapshot and Isolate to their copies.
+type snapshot map[*Environment]*Environment
+
+// env returns the copy of orig, making it (and the ones of its outer environments and of the
+// functions it holds) the first time.
+func (envs snapshot) env(orig *Environment) *Environment {
+	if orig == nil {
+		return nil
+	}
+	if env, found := envs[orig]; found {
+		return env
+	}
+	env := &Environment{
+		store:    make(map[string]Object, len(orig.store)),
+		depth:    orig.depth,
+		cacheKey: orig.cacheKey,
+		block:    orig.block,
+	}
+	envs[orig] = env // before copying the values, which can refer back to it.
+	env.outer = envs.env(orig.outer)
+	if orig.function != nil {
+		fn := envs.copy(*orig.function).(Function)
+		env.function = &fn
+	}
+	for name, v := range orig.store {
+		env.store[name] = envs.copy(v)
+	}
+	return env
+}
+
+func (envs snapshot) copy(o Object) Object {
+	if r, ok := o.(Reference); ok {
+		return Reference{Name: r.Name, RefEnv: envs.env(r.RefEnv)}
+	}
+	o = Value(o)
+	switch v := o.(type) {
+	case Function:
+		v.Env = envs.env(v.Env)
+		return v
+	case SmallMap, *BigMap:
+		m := NewMapSize(Len(v))
+		for _, k := range Elements(v) {
+			value, _ := v.(Map).Get(k)
+			m = m.Set(envs.copy(k), envs.copy(value))
+		}
+		return m
+	}
+	if o.Type() != ARRAY || Len(o) == 0 {
+		return o
+	}
+	elements := Elements(o)
+	res := MakeObjectSlice(len(elements))
+	for _, e := range elements {
+		res = append(res, envs.copy(e))
+	}
+	return NewArray(res)
+}
@@ -25,12 +25,14 @@ func _() {
 	_ = x[EXTENSION-14]
 	_ = x[REFERENCE-15]
 	_ = x[REGISTER-16]
-	_ = x[ANY-17]
+	_ = x[CHANNEL-17]
+	_ = x[TASK-18]
+	_ = x[ANY-19]
 }
 
-const _Type_name = "UNKNOWNINTEGERFLOATBIGINTBOOLEANNILERRORRETURNFUNCSTRINGARRAYMAPQUOTEMACROEXTENSIONREFERENCEREGISTERANY"
+const _Type_name = "UNKNOWNINTEGERFLOATBIGINTBOOLEANNILERRORRETURNFUNCSTRINGARRAYMAPQUOTEMACROEXTENSIONREFERENCEREGISTERCHANNELTASKANY"
 
-var _Type_index = [...]uint8{0, 7, 14, 19, 25, 32, 35, 40, 46, 50, 56, 61, 64, 69, 74, 83, 92, 100, 103}
+var _Type_index = [...]uint8{0, 7, 14, 19, 25, 32, 35, 40, 46, 50, 56, 61, 64, 69, 74, 83, 92, 100, 107, 111, 114}
 
 func (i Type) String() string {
 	idx := int(i) - 0
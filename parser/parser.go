@@ -24,13 +24,18 @@ type Parser struct {
 	prevToken *token.Token
 	curToken  *token.Token
 	peekToken *token.Token
+	curPos    token.Position
+	peekPos   token.Position
+	curStart  int // byte offsets of the current and peek tokens.
+	peekStart int
 
 	prevNewline        bool
 	nextNewline        bool
 	continuationNeeded bool
 	prevPos            int
 
-	errors []string
+	errors      []string
+	diagnostics []Diagnostic
 
 	prefixParseFns  map[token.Type]prefixParseFn
 	infixParseFns   map[token.Type]infixParseFn
@@ -75,12 +80,15 @@ func New(l *lexer.Lexer) *Parser { //nolint:funlen // yes we have a lot to regis
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.FOR, p.parseForExpression)
 	p.registerPrefix(token.BREAK, p.parseControlExpression)
 	p.registerPrefix(token.CONTINUE, p.parseControlExpression)
 	p.registerPrefix(token.RETURN, p.parseReturnStatement)
 	p.registerPrefix(token.FUNC, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.INTERP, p.parseStringLiteral)
 	p.registerPrefix(token.LEN, p.parseBuiltin)
 	p.registerPrefix(token.FIRST, p.parseBuiltin)
 	p.registerPrefix(token.REST, p.parseBuiltin)
@@ -145,28 +153,66 @@ func New(l *lexer.Lexer) *Parser { //nolint:funlen // yes we have a lot to regis
 	return p
 }
 
+// Diagnostic is a structured parsing error.
+type Diagnostic struct {
+	Pos      token.Position // where the error was detected.
+	Message  string         // short message, without position nor source line.
+	Expected []token.Type   // token(s) that were expected instead, if applicable.
+}
+
+func (d Diagnostic) String() string {
+	return d.Pos.String() + ": " + d.Message
+}
+
+// Errors returns the errors as formatted strings (line number, message and source line with a marker).
 func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// Diagnostics returns the same errors as Errors() but as structured diagnostics.
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
+}
+
+// addError records both the formatted error and its structured diagnostic.
+func (p *Parser) addError(formatted string, pos token.Position, msg string, expected ...token.Type) {
+	p.errors = append(p.errors, formatted)
+	p.diagnostics = append(p.diagnostics, Diagnostic{Pos: pos, Message: msg, Expected: expected})
+}
+
 func (p *Parser) nextToken() {
 	p.prevToken = p.curToken
 	p.curToken = p.peekToken
+	p.curPos = p.peekPos
+	p.curStart = p.peekStart
 	p.prevPos = p.l.Pos()
 	p.peekToken = p.l.NextToken()
+	p.peekPos = p.l.TokenPosition()
+	p.peekStart = p.l.TokenOffset()
 	p.prevNewline = p.nextNewline
 	p.nextNewline = p.l.HadNewline()
 }
 
+// curBase returns the ast.Base for the current token and its position.
+func (p *Parser) curBase() ast.Base {
+	return ast.Base{Token: p.curToken, Pos: p.curPos}
+}
+
 func (p *Parser) ParseProgram() *ast.Statements {
 	program := &ast.Statements{}
 	program.Statements = []ast.Node{}
 
 	for p.curToken.Type() != token.EOF && p.curToken.Type() != token.EOL {
+		numErrors := len(p.errors)
 		stmt := p.parseStatement()
-		if stmt == nil {
+		if stmt == nil && (p.continuationNeeded || len(p.errors) == numErrors) {
 			return program
 		}
+		if len(p.errors) != numErrors {
+			// Skip the (partial) statement in error and keep going to report all the errors in the file.
+			p.synchronize()
+			continue
+		}
 		program.Statements = append(program.Statements, stmt)
 		p.nextToken()
 	}
@@ -174,9 +220,38 @@ func (p *Parser) ParseProgram() *ast.Statements {
 	return program
 }
 
+func isClosing(t *token.Token) bool {
+	switch t.Type() { //nolint:exhaustive // only closing ones matter.
+	case token.RPAREN, token.RBRACKET, token.RBRACE:
+		return true
+	default:
+		return false
+	}
+}
+
+// synchronize skips tokens, after an error, until the start of what looks like the next
+// statement: a token following a newline or a `;` outside of (), [] and {}.
+func (p *Parser) synchronize() {
+	depth := 0
+	for !p.curTokenIs(token.EOF) && !p.curTokenIs(token.EOL) {
+		switch p.curToken.Type() { //nolint:exhaustive // only grouping ones matter.
+		case token.LPAREN, token.LBRACKET, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACKET, token.RBRACE:
+			depth = max(0, depth-1)
+		}
+		boundary := depth == 0 && (p.curTokenIs(token.SEMICOLON) || p.nextNewline)
+		p.nextToken()
+		// closing tokens left over from the statement in error don't start a new one.
+		if boundary && !isClosing(p.curToken) {
+			return
+		}
+	}
+}
+
 func (p *Parser) parseArrayLiteral() ast.Node {
 	array := &ast.ArrayLiteral{}
-	array.Token = p.curToken
+	array.Base = p.curBase()
 
 	array.Elements = p.parseExpressionList(token.RBRACKET)
 
@@ -184,14 +259,84 @@ func (p *Parser) parseArrayLiteral() ast.Node {
 }
 
 func (p *Parser) parseStringLiteral() ast.Node {
+	if p.curToken.Type() == token.INTERP {
+		return p.parseInterpolatedString()
+	}
 	r := &ast.StringLiteral{}
-	r.Token = p.curToken
+	r.Base = p.curBase()
+	return r
+}
+
+// parseInterpolatedString parses the `${expr}` parts of the string with sub parsers reading
+// the same input, so positions (and errors) are the ones in the source.
+func (p *Parser) parseInterpolatedString() ast.Node {
+	r := &ast.InterpolatedString{}
+	r.Base = p.curBase()
+	raw := p.curToken.Literal()
+	parts, ok := lexer.SplitInterpolation(raw)
+	if !ok {
+		p.errorAt(p.curPos, "invalid string interpolation")
+		return nil
+	}
+	for _, part := range parts {
+		pos := interpolationPosition(p.curPos, raw, part.Offset)
+		if !part.IsExpr {
+			r.Parts = append(r.Parts, &ast.StringLiteral{Base: ast.Base{Token: token.Intern(token.STRING, part.Text), Pos: pos}})
+			continue
+		}
+		start := p.curStart + 1 + part.Offset
+		sub := New(p.l.Sub(start, start+len(part.Text), pos))
+		program := sub.ParseProgram()
+		if len(sub.errors) > 0 {
+			p.errors = append(p.errors, sub.errors...)
+			p.diagnostics = append(p.diagnostics, sub.diagnostics...)
+			return nil
+		}
+		var expr ast.Node
+		for _, st := range program.Statements {
+			if _, isComment := st.(*ast.Comment); isComment {
+				continue
+			}
+			if expr != nil {
+				expr = nil
+				break
+			}
+			expr = st
+		}
+		if _, isReturn := expr.(*ast.ReturnStatement); expr == nil || isReturn {
+			p.errorAt(pos, "expected a single expression in `${}` of string")
+			return nil
+		}
+		r.Parts = append(r.Parts, expr)
+	}
 	return r
 }
 
+// interpolationPosition returns the position of offset within the raw content of the string at pos.
+func interpolationPosition(pos token.Position, raw string, offset int) token.Position {
+	before := raw[:offset]
+	nl := strings.LastIndexByte(before, '\n')
+	if nl == -1 {
+		return token.Position{Line: pos.Line, Column: pos.Column + 1 + offset}
+	}
+	return token.Position{Line: pos.Line + strings.Count(before, "\n"), Column: offset - nl}
+}
+
+// errorAt adds an error, at pos, for the current token.
+func (p *Parser) errorAt(pos token.Position, msg string) {
+	errLine, lineNum := p.ErrorLine(true)
+	p.addError(fmt.Sprintf("%d: %s:\n%s", lineNum, msg, errLine), pos, msg)
+}
+
+// keywordError reports the current token, a keyword, used where a variable name is expected
+// (e.g. `[a, ..rest] = arr`).
+func (p *Parser) keywordError() {
+	p.errorAt(p.curPos, fmt.Sprintf("`%s` is a keyword, it can't be used as a variable name", p.curToken.Literal()))
+}
+
 func (p *Parser) parseComment() ast.Node {
 	r := &ast.Comment{}
-	r.Token = p.curToken
+	r.Base = p.curBase()
 	r.SameLineAsPrevious = !p.prevNewline
 	r.SameLineAsNext = !p.nextNewline
 	isBlockComment := (p.curToken.Type() == token.BLOCKCOMMENT)
@@ -223,7 +368,7 @@ func (p *Parser) parseStatement() ast.Node {
 
 func (p *Parser) parseReturnStatement() ast.Node {
 	stmt := &ast.ReturnStatement{}
-	stmt.Token = p.curToken
+	stmt.Base = p.curBase()
 
 	// hacky for empty expressions like plain `return`.
 	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF) || p.peekTokenIs(token.EOL) {
@@ -290,16 +435,15 @@ func (p *Parser) ErrorLine(forPreviousToken bool) (string, int) {
 func (p *Parser) peekError(t token.Type) {
 	log.Debugf("peekError: %s", t)
 	errLine, lineNum := p.ErrorLine(false)
-	msg := fmt.Sprintf("%d: expected next token to be `%s`, got `%s` instead:\n%s",
-		lineNum, token.ByType(t).Literal(), p.peekToken.Literal(), errLine)
-	p.errors = append(p.errors, msg)
+	msg := fmt.Sprintf("expected next token to be `%s`, got `%s` instead", token.ByType(t).Literal(), p.peekToken.Literal())
+	p.addError(fmt.Sprintf("%d: %s:\n%s", lineNum, msg, errLine), p.peekPos, msg, t)
 }
 
 func (p *Parser) noPrefixParseFnError(t *token.Token) {
 	log.Debugf("Adding noPrefixParseFnError: %s", t.DebugString())
 	errLine, lineNum := p.ErrorLine(true)
-	msg := fmt.Sprintf("%d: no prefix parse function for `%s` found:\n%s", lineNum, t.Literal(), errLine)
-	p.errors = append(p.errors, msg)
+	msg := fmt.Sprintf("no prefix parse function for `%s` found", t.Literal())
+	p.addError(fmt.Sprintf("%d: %s:\n%s", lineNum, msg, errLine), p.curPos, msg)
 }
 
 func (p *Parser) parseExpression(precedence ast.Priority) ast.Node {
@@ -359,8 +503,20 @@ func (p *Parser) parseIdentifier() ast.Node {
 		p.nextToken()
 		return postfix()
 	}
+	if p.curTokenIs(token.DOTDOT) && p.peekToken.Type().IsKeyword() {
+		p.nextToken()
+		p.keywordError()
+		return nil
+	}
+	if p.curTokenIs(token.DOTDOT) && p.peekTokenIs(token.IDENT) {
+		// `..name` rest of array destructuring, evaluating it outside of one is an error.
+		prefix := &ast.PrefixExpression{Base: p.curBase()}
+		p.nextToken()
+		prefix.Right = &ast.Identifier{Base: p.curBase()}
+		return prefix
+	}
 	i := &ast.Identifier{}
-	i.Token = p.curToken
+	i.Base = p.curBase()
 	return i
 }
 
@@ -370,7 +526,7 @@ func (p *Parser) parseIntegerLiteral() ast.Node {
 		return p.parseBigIntLiteral()
 	}
 	lit := &ast.IntegerLiteral{}
-	lit.Token = p.curToken
+	lit.Base = p.curBase()
 	lit.Val = value
 	return lit
 }
@@ -383,7 +539,7 @@ func (p *Parser) parseBigIntLiteral() ast.Node {
 		return p.parseFloatLiteral()
 	}
 	lit := &ast.BigIntLiteral{}
-	lit.Token = p.curToken
+	lit.Base = p.curBase()
 	lit.Val = v
 	return lit
 }
@@ -392,19 +548,19 @@ func (p *Parser) parseFloatLiteral() ast.Node {
 	value, err := strconv.ParseFloat(p.curToken.Literal(), 64)
 	if err != nil {
 		errLine, lineNum := p.ErrorLine(false)
-		msg := fmt.Sprintf("%d: could not parse %q as float:\n%s", lineNum, p.curToken.Literal(), errLine)
-		p.errors = append(p.errors, msg)
+		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal())
+		p.addError(fmt.Sprintf("%d: %s:\n%s", lineNum, msg, errLine), p.curPos, msg)
 		return nil
 	}
 	lit := &ast.FloatLiteral{}
-	lit.Token = p.curToken
+	lit.Base = p.curBase()
 	lit.Val = value
 	return lit
 }
 
 func (p *Parser) parseBoolean() ast.Node {
 	b := &ast.Boolean{Val: p.curTokenIs(token.TRUE)}
-	b.Token = p.curToken
+	b.Base = p.curBase()
 	return b
 }
 
@@ -435,7 +591,7 @@ func (p *Parser) parseGroupedExpression() ast.Node {
 
 func (p *Parser) parsePrefixExpression() ast.Node {
 	expression := &ast.PrefixExpression{}
-	expression.Token = p.curToken
+	expression.Base = p.curBase()
 
 	p.nextToken()
 
@@ -446,7 +602,7 @@ func (p *Parser) parsePrefixExpression() ast.Node {
 
 func (p *Parser) parsePostfixExpression() ast.Node {
 	expression := &ast.PostfixExpression{}
-	expression.Token = p.curToken
+	expression.Base = p.curBase()
 	expression.Prev = p.prevToken
 	return expression
 }
@@ -487,7 +643,7 @@ func okParamList(nodes []ast.Node) (*token.Token, bool) {
 
 func (p *Parser) parseLambdaMulti(left ast.Node, more ...ast.Node) ast.Node {
 	lambda := &ast.FunctionLiteral{IsLambda: true}
-	lambda.Token = p.curToken
+	lambda.Base = p.curBase()
 	if left == nil {
 		lambda.Parameters = more
 	} else {
@@ -496,8 +652,8 @@ func (p *Parser) parseLambdaMulti(left ast.Node, more ...ast.Node) ast.Node {
 	t, ok := okParamList(lambda.Parameters)
 	if !ok {
 		errLine, lineNum := p.ErrorLine(false)
-		p.errors = append(p.errors, fmt.Sprintf("%d: lambda parameters must be identifiers, not %s\n%s",
-			lineNum, t.Literal(), errLine))
+		msg := "lambda parameters must be identifiers, not " + t.Literal()
+		p.addError(fmt.Sprintf("%d: %s\n%s", lineNum, msg, errLine), p.curPos, msg)
 		return nil
 	}
 	if t != nil {
@@ -511,12 +667,14 @@ func (p *Parser) parseLambdaMulti(left ast.Node, more ...ast.Node) ast.Node {
 			return nil
 		}
 		log.Debugf("parseLambdaMulti: body: %#v", lambda.Body)
+		ast.MarkTailCalls(lambda.Body)
 		return lambda
 	}
 	precedence := p.curPrecedence()
 	p.nextToken()
 	body := p.parseExpression(precedence)
 	lambda.Body = &ast.Statements{Statements: []ast.Node{body}}
+	ast.MarkTailCalls(lambda.Body)
 	return lambda
 }
 
@@ -524,7 +682,7 @@ func (p *Parser) parseInfixExpression(left ast.Node) ast.Node {
 	expression := &ast.InfixExpression{
 		Left: left,
 	}
-	expression.Token = p.curToken
+	expression.Base = p.curBase()
 
 	precedence := p.curPrecedence()
 	// handle [n:] case
@@ -539,13 +697,13 @@ func (p *Parser) parseInfixExpression(left ast.Node) ast.Node {
 
 func (p *Parser) parseControlExpression() ast.Node {
 	expression := &ast.ControlExpression{}
-	expression.Token = p.curToken
+	expression.Base = p.curBase()
 	return expression
 }
 
 func (p *Parser) parseForExpression() ast.Node {
 	expression := &ast.ForExpression{}
-	expression.Token = p.curToken
+	expression.Base = p.curBase()
 	p.nextToken()
 	expression.Condition = p.parseExpression(ast.LOWEST)
 
@@ -563,7 +721,7 @@ func (p *Parser) parseForExpression() ast.Node {
 
 func (p *Parser) parseIfExpression() ast.Node {
 	expression := &ast.IfExpression{}
-	expression.Token = p.curToken
+	expression.Base = p.curBase()
 
 	p.nextToken()
 	expression.Condition = p.parseExpression(ast.LOWEST)
@@ -599,6 +757,235 @@ func (p *Parser) parseIfExpression() ast.Node {
 	return expression
 }
 
+// parseTryExpression parses `try { body } catch name { handler } finally { cleanup }`.
+func (p *Parser) parseTryExpression() ast.Node {
+	expression := &ast.TryExpression{}
+	expression.Base = p.curBase()
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Body = p.parseBlockStatement()
+	if p.continuationNeeded {
+		return nil
+	}
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if p.peekTokenIs(token.IDENT) {
+			p.nextToken()
+			expression.Name = &ast.Identifier{Base: p.curBase()}
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = p.parseBlockStatement()
+		if p.continuationNeeded {
+			return nil
+		}
+	}
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = p.parseBlockStatement()
+		if p.continuationNeeded {
+			return nil
+		}
+	}
+	if expression.Catch == nil && expression.Finally == nil {
+		p.errorAt(p.peekPos, "expected `catch` or `finally` after `try` block")
+		return nil
+	}
+	return expression
+}
+
+func (p *Parser) parseMatchExpression() ast.Node {
+	expression := &ast.MatchExpression{}
+	expression.Base = p.curBase()
+	p.nextToken()
+	expression.Subject = p.parseExpression(ast.LOWEST)
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.nextToken()
+	for !p.curTokenIs(token.RBRACE) {
+		switch {
+		case p.curTokenIs(token.EOL):
+			p.continuationNeeded = true
+			return nil
+		case p.curTokenIs(token.EOF):
+			p.errorAt(p.curPos, "unterminated match, expected `}`")
+			return nil
+		case p.isComment():
+			p.nextToken()
+			continue
+		}
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		expression.Arms = append(expression.Arms, arm)
+		p.nextToken()
+	}
+	return expression
+}
+
+// parseMatchArm parses `pattern, pattern if guard { body }`.
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	arm := &ast.MatchArm{}
+	arm.Base = p.curBase()
+	for {
+		pattern := p.parsePattern()
+		if pattern == nil {
+			return nil
+		}
+		arm.Patterns = append(arm.Patterns, pattern)
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+		p.nextToken()
+	}
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(ast.LOWEST)
+		if arm.Guard == nil {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	arm.Body = p.parseBlockStatement()
+	if p.continuationNeeded {
+		return nil
+	}
+	return arm
+}
+
+// parsePattern parses a match pattern: a literal, an identifier (type name, constant, `_` or
+// binding), or an array or map of patterns.
+func (p *Parser) parsePattern() ast.Node {
+	switch p.curToken.Type() { //nolint:exhaustive // other tokens aren't patterns.
+	case token.IDENT, token.FUNC:
+		i := &ast.Identifier{}
+		i.Base = p.curBase()
+		return i
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+	case token.LBRACE:
+		return p.parseMapPattern()
+	case token.EOL:
+		p.continuationNeeded = true
+		return nil
+	case token.TRUE, token.FALSE:
+	default:
+		if p.curToken.Type().IsKeyword() {
+			p.keywordError()
+			return nil
+		}
+	}
+	return p.parseLiteralPattern()
+}
+
+// parseLiteralPattern parses the literals (including negative numbers) that can be used as patterns or map pattern keys.
+func (p *Parser) parseLiteralPattern() ast.Node {
+	switch p.curToken.Type() { //nolint:exhaustive // other tokens aren't literals.
+	case token.INT, token.FLOAT, token.STRING, token.INTERP, token.TRUE, token.FALSE, token.IDENT:
+		return p.prefixParseFns[p.curToken.Type()]()
+	case token.MINUS:
+		if p.peekTokenIs(token.INT) || p.peekTokenIs(token.FLOAT) {
+			r := &ast.PrefixExpression{}
+			r.Base = p.curBase()
+			p.nextToken()
+			r.Right = p.prefixParseFns[p.curToken.Type()]()
+			return r
+		}
+	case token.EOL:
+		p.continuationNeeded = true
+		return nil
+	}
+	p.errorAt(p.curPos, fmt.Sprintf("invalid pattern `%s`", p.curToken.Literal()))
+	return nil
+}
+
+// parseArrayPattern parses `[pattern, ..., ..tail]`, the optional `..tail` or `..` being last.
+func (p *Parser) parseArrayPattern() ast.Node {
+	array := &ast.ArrayLiteral{}
+	array.Base = p.curBase()
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		if p.curTokenIs(token.DOTDOT) {
+			var rest ast.Node = &ast.Identifier{Base: p.curBase()}
+			if p.peekToken.Type().IsKeyword() {
+				p.nextToken()
+				p.keywordError()
+				return nil
+			}
+			if p.peekTokenIs(token.IDENT) {
+				prefix := &ast.PrefixExpression{Base: p.curBase()}
+				p.nextToken()
+				prefix.Right = &ast.Identifier{Base: p.curBase()}
+				rest = prefix
+			}
+			array.Elements = append(array.Elements, rest)
+			break
+		}
+		element := p.parsePattern()
+		if element == nil {
+			return nil
+		}
+		array.Elements = append(array.Elements, element)
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	return array
+}
+
+// parseMapPattern parses `{key: pattern, ...}`, `name` being short for `"name": name`.
+func (p *Parser) parseMapPattern() ast.Node {
+	mapRes := &ast.MapLiteral{}
+	mapRes.Base = p.curBase()
+	mapRes.Pairs = make(map[ast.Node]ast.Node)
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		var key, value ast.Node
+		if p.curTokenIs(token.IDENT) && !p.peekTokenIs(token.COLON) {
+			key = &ast.StringLiteral{Base: ast.Base{Token: token.Intern(token.STRING, p.curToken.Literal()), Pos: p.curPos}}
+			value = &ast.Identifier{Base: p.curBase()}
+		} else if p.curToken.Type().IsKeyword() && !p.peekTokenIs(token.COLON) {
+			p.keywordError()
+			return nil
+		} else {
+			key = p.parseLiteralPattern()
+			if key == nil || !p.expectPeek(token.COLON) {
+				return nil
+			}
+			p.nextToken()
+			value = p.parsePattern()
+			if value == nil {
+				return nil
+			}
+		}
+		mapRes.Pairs[key] = value
+		mapRes.Order = append(mapRes.Order, key)
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	return mapRes
+}
+
 func (p *Parser) parseBlockStatement() *ast.Statements {
 	block := &ast.Statements{}
 	// block.Token = p.curToken
@@ -620,12 +1007,12 @@ func (p *Parser) parseBlockStatement() *ast.Statements {
 
 func (p *Parser) parseFunctionLiteral() ast.Node {
 	lit := &ast.FunctionLiteral{}
-	lit.Token = p.curToken
+	lit.Base = p.curBase()
 	// Optional name/identifier
 	if p.peekTokenIs(token.IDENT) {
 		p.nextToken()
 		name := &ast.Identifier{}
-		name.Token = p.curToken
+		name.Base = p.curBase()
 		lit.Name = name
 	}
 	if !p.expectPeek(token.LPAREN) {
@@ -642,14 +1029,17 @@ func (p *Parser) parseFunctionLiteral() ast.Node {
 	if p.continuationNeeded {
 		return nil
 	}
-
+	ast.MarkTailCalls(lit.Body)
 	return lit
 }
 
 func (p *Parser) parseBuiltin() ast.Node {
 	bi := &ast.Builtin{}
-	bi.Token = p.curToken
-
+	bi.Base = p.curBase()
+	if !p.peekTokenIs(token.LPAREN) && !p.peekTokenIs(token.EOL) {
+		p.keywordError() // e.g. `[first, b] = arr`, as builtins can only be called.
+		return nil
+	}
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
@@ -663,17 +1053,18 @@ func (p *Parser) parseFunctionParameters() ([]ast.Node, bool) {
 		p.nextToken()
 		return identifiers, false
 	}
-	p.nextToken()
-	ident := &ast.Identifier{}
-	ident.Token = p.curToken
-	identifiers = append(identifiers, ident)
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
+	for {
 		p.nextToken()
-		ident := &ast.Identifier{}
-		ident.Token = p.curToken
-		identifiers = append(identifiers, ident)
+		param := p.parseParameter()
+		if param == nil {
+			return nil, false
+		}
+		identifiers = append(identifiers, param)
 		p.skipPeekComments()
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
 	}
 	p.skipPeekComments()
 	if !p.expectPeek(token.RPAREN) {
@@ -683,9 +1074,26 @@ func (p *Parser) parseFunctionParameters() ([]ast.Node, bool) {
 	return identifiers, (p.prevToken.Type() == token.DOTDOT)
 }
 
+// parseParameter parses a function parameter: an identifier or an array or map destructuring pattern.
+func (p *Parser) parseParameter() ast.Node {
+	switch p.curToken.Type() { //nolint:exhaustive // other tokens are identifiers.
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+	case token.LBRACE:
+		return p.parseMapPattern()
+	}
+	if p.curToken.Type().IsKeyword() {
+		p.keywordError()
+		return nil
+	}
+	ident := &ast.Identifier{}
+	ident.Base = p.curBase()
+	return ident
+}
+
 func (p *Parser) parseCallExpression(function ast.Node) ast.Node {
 	exp := &ast.CallExpression{Function: function}
-	exp.Token = p.curToken
+	exp.Base = p.curBase()
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
 	return exp
 }
@@ -716,7 +1124,7 @@ func (p *Parser) parseExpressionList(end token.Type) []ast.Node {
 
 func (p *Parser) parseIndexExpression(left ast.Node) ast.Node {
 	exp := &ast.IndexExpression{Left: left}
-	exp.Token = p.curToken
+	exp.Base = p.curBase()
 	isDot := p.curToken.Type() == token.DOT
 
 	p.nextToken()
@@ -738,7 +1146,7 @@ func (p *Parser) parseIndexExpression(left ast.Node) ast.Node {
 
 func (p *Parser) parseMapLiteral() ast.Node {
 	mapRes := &ast.MapLiteral{}
-	mapRes.Token = p.curToken
+	mapRes.Base = p.curBase()
 	mapRes.Pairs = make(map[ast.Node]ast.Node)
 
 	for !p.peekTokenIs(token.RBRACE) {
@@ -752,6 +1160,14 @@ func (p *Parser) parseMapLiteral() ast.Node {
 			return mapRes
 		}
 		kv := p.parseExpression(ast.LOWEST)
+		if id, isIdent := kv.(*ast.Identifier); isIdent && (p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.RBRACE)) {
+			// `{x, y}` short for `{"x": x, "y": y}`.
+			kv = &ast.InfixExpression{
+				Base:  ast.Base{Token: token.Intern(token.COLON, ":"), Pos: id.Pos},
+				Left:  &ast.StringLiteral{Base: ast.Base{Token: token.Intern(token.STRING, id.Literal()), Pos: id.Pos}},
+				Right: id,
+			}
+		}
 		ex, ok := kv.(*ast.InfixExpression)
 		if !ok || ex.Token.Type() != token.COLON {
 			if p.peekTokenIs(token.EOL) {
@@ -781,11 +1197,17 @@ func (p *Parser) parseMapLiteral() ast.Node {
 
 func (p *Parser) parseMacroLiteral() ast.Node {
 	lit := &ast.MacroLiteral{}
-	lit.Token = p.curToken
+	lit.Base = p.curBase()
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
 	lit.Parameters, _ = p.parseFunctionParameters() // TODO variadic macros?
+	for _, param := range lit.Parameters {
+		if _, ok := param.(*ast.Identifier); !ok {
+			p.errorAt(param.Position(), "macro parameters can't be destructuring patterns")
+			return nil
+		}
+	}
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
@@ -1,11 +1,13 @@
 package parser_test
 
 import (
+	"strings"
 	"testing"
 
 	"grol.io/grol/ast"
 	"grol.io/grol/lexer"
 	"grol.io/grol/parser"
+	"grol.io/grol/token"
 )
 
 func Test_LetStatements(t *testing.T) {
@@ -382,6 +384,26 @@ e = "f"`,
 			"a % (b * c)",
 			"a%(b*c)",
 		},
+		{
+			"match v { 1, -2 { a } [x, ..r] if x>1 {x} {\"k\": 1, y} {y} _ {} }",
+			"match v {\n\t1, -2 {\n\t\ta\n\t}\n\t[x, ..r] if x > 1 {\n\t\tx\n\t}\n\t{\"k\":1, y} {\n\t\ty\n\t}\n\t_ {\n\t}\n}",
+			"match v{1,-2{a}[x,..r] if x>1{x}{\"k\":1,y}{y}_{}}",
+		},
+		{
+			"[a,b, ..t] = arr; {x, \"k\": [y]} := m; func f([a, b], {c}) {a}; p := {x, y}",
+			"[a, b, ..t] = arr\n{x, \"k\":[y]} := m\nfunc f([a, b], {c}) {\n\ta\n}\np := {\"x\":x, \"y\":y}",
+			"[a,b,..t]=arr {x,\"k\":[y]}:=m func f([a,b],{c}){a}p:={\"x\":x,\"y\":y}",
+		},
+		{
+			`s = "total: ${a + b}\t${ f(1,"x${y}") }" + ` + "`${raw}`" + `+"\${lit}"`,
+			`s = "total: ${a+b}\t${f(1,"x${y}")}" + "\${raw}" + "\${lit}"`,
+			`s="total: ${a+b}\t${f(1,"x${y}")}"+"\${raw}"+"\${lit}"`,
+		},
+		{
+			"x := try { f() } catch e { e.value } finally { close() }; try {a} finally {b}",
+			"x := try {\n\tf()\n} catch e {\n\te.value\n} finally {\n\tclose()\n}\ntry {\n\ta\n} finally {\n\tb\n}",
+			"x:=try{f()}catch e{e.value}finally{close()}try{a}finally{b}",
+		},
 	}
 	for i, tt := range tests {
 		l := lexer.New(tt.input)
@@ -475,3 +497,166 @@ func TestOddPanic(t *testing.T) {
 		t.Errorf("expected %q got %q", expected, actual)
 	}
 }
+
+func TestNodePositions(t *testing.T) {
+	inp := "a = 1\n  f(a,\n    b + 2)"
+	l := lexer.New(inp)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, inp, p)
+	if len(program.Statements) != 2 {
+		t.Fatalf("expecting 2 statements, got %d", len(program.Statements))
+	}
+	assign := program.Statements[0].(*ast.InfixExpression)
+	call := program.Statements[1].(*ast.CallExpression)
+	plus := call.Arguments[1].(*ast.InfixExpression)
+	tests := []struct {
+		node     ast.Node
+		expected string
+	}{
+		{assign, "1:3"},
+		{assign.Left, "1:1"},
+		{assign.Right, "1:5"},
+		{call, "2:4"},
+		{call.Function, "2:3"},
+		{call.Arguments[0], "2:5"},
+		{plus, "3:7"},
+		{plus.Left, "3:5"},
+		{plus.Right, "3:9"},
+	}
+	for _, tt := range tests {
+		if actual := tt.node.Position().String(); actual != tt.expected {
+			t.Errorf("position of %s: got %s, expected %s", ast.DebugString(tt.node), actual, tt.expected)
+		}
+	}
+}
+
+func TestDiagnosticsRecovery(t *testing.T) {
+	inp := `a = (1
+b = 2
+f(x, @)
+c = [3
+d = 4`
+	l := lexer.New(inp)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	diags := p.Diagnostics()
+	if len(diags) != 3 || len(p.Errors()) != 3 {
+		t.Fatalf("expecting 3 diagnostics and errors, got %v / %v", diags, p.Errors())
+	}
+	expected := []string{
+		"2:1: expected next token to be `)`, got `b` instead",
+		"3:6: no prefix parse function for `@` found",
+		"5:1: expected next token to be `]`, got `d` instead",
+	}
+	for i, d := range diags {
+		if d.String() != expected[i] {
+			t.Errorf("diagnostic %d: got %q expected %q", i, d.String(), expected[i])
+		}
+	}
+	if len(diags[0].Expected) != 1 || diags[0].Expected[0] != token.RPAREN {
+		t.Errorf("expected tokens for first diagnostic: got %v", diags[0].Expected)
+	}
+	if len(diags[1].Expected) != 0 {
+		t.Errorf("unexpected expected tokens for second diagnostic: %v", diags[1].Expected)
+	}
+	// Statements in between errors are still parsed.
+	out := ast.NewPrintState()
+	out.Compact = true
+	program.PrettyPrint(out)
+	if !strings.Contains(out.String(), "b=2") || !strings.Contains(out.String(), "d=4") {
+		t.Errorf("expected statements after errors to be parsed, got %q", out.String())
+	}
+}
+
+func TestInterpolatedString(t *testing.T) {
+	inp := "x = 1\ns = \"a\n${x +\n y}b${x}\""
+	l := lexer.New(inp)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, inp, p)
+	is := program.Statements[1].(*ast.InfixExpression).Right.(*ast.InterpolatedString)
+	if len(is.Parts) != 4 {
+		t.Fatalf("expecting 4 parts, got %d", len(is.Parts))
+	}
+	plus := is.Parts[1].(*ast.InfixExpression)
+	tests := []struct {
+		node     ast.Node
+		expected string
+	}{
+		{is, "2:5"},
+		{is.Parts[0], "2:6"},
+		{plus.Left, "3:3"},
+		{plus, "3:5"},
+		{plus.Right, "4:2"},
+		{is.Parts[2], "4:4"},
+		{is.Parts[3], "4:7"},
+	}
+	for _, tt := range tests {
+		if actual := tt.node.Position().String(); actual != tt.expected {
+			t.Errorf("position of %s: got %s, expected %s", ast.DebugString(tt.node), actual, tt.expected)
+		}
+	}
+	inp = "a = 1\nb = \"x ${a @ 2} ${}\""
+	p = parser.New(lexer.New(inp))
+	_ = p.ParseProgram()
+	diags := p.Diagnostics()
+	if len(diags) == 0 || diags[0].Pos.String() != "2:12" {
+		t.Errorf("expected error at 2:12, got %v", diags)
+	}
+	p = parser.New(lexer.New(`"${}"`))
+	_ = p.ParseProgram()
+	if diags = p.Diagnostics(); len(diags) != 1 || diags[0].String() != "1:4: expected a single expression in `${}` of string" {
+		t.Errorf("unexpected diagnostics for empty interpolation: %v", diags)
+	}
+}
+
+func TestMatchErrors(t *testing.T) {
+	p := parser.New(lexer.New("match x {\n\t1 {a}\n\t+ {b}\n}"))
+	_ = p.ParseProgram()
+	if diags := p.Diagnostics(); len(diags) == 0 || diags[0].String() != "3:2: invalid pattern `+`" {
+		t.Errorf("unexpected diagnostics %v", diags)
+	}
+	p = parser.New(lexer.NewLineMode("match x {\n\t[a, b] {a}\n"))
+	_ = p.ParseProgram()
+	if !p.ContinuationNeeded() {
+		t.Errorf("expecting continuation needed for incomplete match")
+	}
+}
+
+func TestTryErrors(t *testing.T) {
+	p := parser.New(lexer.New("try {\n\ta\n}\nb"))
+	_ = p.ParseProgram()
+	if diags := p.Diagnostics(); len(diags) == 0 || diags[0].String() != "4:1: expected `catch` or `finally` after `try` block" {
+		t.Errorf("unexpected diagnostics %v", diags)
+	}
+}
+
+func TestKeywordBindingErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[a, b, ..rest] = arr", "1:10: `rest` is a keyword, it can't be used as a variable name"},
+		{"[first, b] = arr", "1:2: `first` is a keyword, it can't be used as a variable name"},
+		{"{x, len} := m", "1:5: `len` is a keyword, it can't be used as a variable name"},
+		{"func f(a, [b, print]) {a}", "1:15: `print` is a keyword, it can't be used as a variable name"},
+		{"func f(rest) {1}", "1:8: `rest` is a keyword, it can't be used as a variable name"},
+		{"match v {[x, ..rest] {x}}", "1:16: `rest` is a keyword, it can't be used as a variable name"},
+		{"match v {if {1}}", "1:10: `if` is a keyword, it can't be used as a variable name"},
+	}
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		_ = p.ParseProgram()
+		if diags := p.Diagnostics(); len(diags) == 0 || diags[0].String() != tt.expected {
+			t.Errorf("%s: got diagnostics %v, expected %q first", tt.input, diags, tt.expected)
+		}
+	}
+	for _, ok := range []string{"[a, b, ..tail] = arr", "match v {true {1} [x, ..] {x}}", "rest([1, 2])"} {
+		p := parser.New(lexer.New(ok))
+		_ = p.ParseProgram()
+		if errs := p.Errors(); len(errs) != 0 {
+			t.Errorf("%s: unexpected errors %v", ok, errs)
+		}
+	}
+}
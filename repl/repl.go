@@ -67,8 +67,20 @@ type Options struct {
 	PreInput    func(*eval.State)
 	AllParens   bool // Show all parens in parse tree (default is to simplify using precedence).
 	MaxDuration time.Duration
-	ShebangMode bool // Whether to run in #! script mode (not making a difference here, used in main.go).
-	NoReg       bool // Disable registers.
+	MaxSteps    int64 // Quota of evaluation steps, 0 for unlimited (see eval.State for these 3 quotas).
+	MaxAlloc    int64 // Quota of approximate bytes allocated for strings, arrays and maps, 0 for unlimited.
+	MaxOutput   int64 // Quota of bytes printed, 0 for unlimited.
+	ShebangMode bool  // Whether to run in #! script mode (not making a difference here, used in main.go).
+	NoReg       bool  // Disable registers.
+	VM          bool  // Use the bytecode compiler and virtual machine for functions.
+	// Creates the state with its own extensions and configuration when set (instead of the ones from extensions.Init).
+	Builder *extensions.Builder
+	// Capabilities (e.g. object.CapProcess) removed from the state's, none by default.
+	DeniedCapabilities object.Capability
+	// Limits of the functions results cache (see eval.Cache), 0 for unlimited.
+	MaxCacheEntries int
+	MaxCacheBytes   int64
+	NoOptimize      bool // Evaluate the program as parsed, without the constant folding etc of eval.State.Optimize.
 }
 
 func AutoLoad(s *eval.State, options Options) error {
@@ -156,6 +168,7 @@ func EvalAll(s *eval.State, in io.Reader, out io.Writer, options Options) []stri
 	}
 	what := string(b)
 	what = extensions.DropStartingShebang(what)
+	s.SetSource(s.CurrentFile, what)
 	if options.PreInput != nil {
 		options.PreInput(s)
 	}
@@ -185,8 +198,9 @@ func EvalString(what string) (string, []string, string) {
 // additionally control the behavior:
 // AutoLoad, AutoSave, Compact.
 func EvalStringWithOption(ctx context.Context, o Options, what string) (res string, errs []string, formatted string) {
-	s := eval.NewState()
+	s := newState(o)
 	s.NoReg = o.NoReg
+	s.VM = o.VM
 	if o.MaxDepth > 0 {
 		s.MaxDepth = o.MaxDepth
 	}
@@ -212,6 +226,19 @@ func EvalStringWithOption(ctx context.Context, o Options, what string) (res stri
 	return res, errs, formatted
 }
 
+func newState(o Options) *eval.State {
+	var s *eval.State
+	if o.Builder != nil {
+		s = o.Builder.NewState()
+	} else {
+		s = eval.NewState()
+	}
+	s.MaxSteps, s.MaxAlloc, s.MaxOutput = o.MaxSteps, o.MaxAlloc, o.MaxOutput
+	s.Cache().MaxEntries, s.Cache().MaxBytes = o.MaxCacheEntries, o.MaxCacheBytes
+	s.Capabilities &^= o.DeniedCapabilities
+	return s
+}
+
 func extractHistoryNumber(input string) (int, bool) {
 	if len(input) > 1 && input[0] == '!' {
 		numberPart := input[1:]
@@ -239,8 +266,9 @@ func AddTerm(s *eval.State) *terminal.Terminal {
 
 func Interactive(options Options) int { //nolint:funlen // we do have quite a few cases.
 	options.NilAndErr = true
-	s := eval.NewState()
+	s := newState(options)
 	s.NoReg = options.NoReg
+	s.VM = options.VM
 	if options.MaxDepth > 0 {
 		s.MaxDepth = options.MaxDepth
 	}
@@ -253,7 +281,7 @@ func Interactive(options Options) int { //nolint:funlen // we do have quite a fe
 	for v := range tokInfo.Builtins {
 		autoComplete.Trie.Insert(v + "(")
 	}
-	for k := range object.ExtraFunctions() {
+	for k := range s.Extensions {
 		autoComplete.Trie.Insert(k + "(")
 	}
 	autoComplete.Trie.Insert("history") // add this one as it's not in the language but handled here.
@@ -349,9 +377,10 @@ func Interactive(options Options) int { //nolint:funlen // we do have quite a fe
 
 // Grol provides an alternate API for benchmarking and simplicity.
 type Grol struct {
-	State     *eval.State
-	PrintEval bool
-	program   ast.Node
+	State      *eval.State
+	PrintEval  bool
+	NoOptimize bool // See Options.NoOptimize.
+	program    ast.Node
 }
 
 // New initializes Grol with new empty state.
@@ -369,11 +398,13 @@ func (g *Grol) Parse(inp []byte) error {
 	}
 	g.State.DefineMacros(g.program)
 	numMacros := g.State.NumMacros()
-	if numMacros == 0 {
-		return nil
+	if numMacros > 0 {
+		log.LogVf("Expanding, %d macros defined", numMacros)
+		g.program = g.State.ExpandMacros(g.program)
+	}
+	if !g.NoOptimize {
+		g.program = g.State.Optimize(g.program)
 	}
-	log.LogVf("Expanding, %d macros defined", numMacros)
-	g.program = g.State.ExpandMacros(g.program)
 	return nil
 }
 
@@ -473,6 +504,15 @@ func evalOne(s *eval.State, what string, out io.Writer, options Options) (bool,
 	} else {
 		log.LogVf("Skipping macro expansion as none are defined")
 	}
+	if !options.NoOptimize {
+		optimized := s.Optimize(program)
+		// Only shown when it changed something.
+		if options.ShowParse && ast.DebugString(optimized) != ast.DebugString(program) {
+			fmt.Fprint(out, "== Optimized ==> ")
+			optimized.PrettyPrint(&ast.PrintState{Out: out})
+		}
+		program = optimized
+	}
 	if options.ShowParse && options.ShowEval {
 		fmt.Fprint(out, "== Eval  ==> ")
 	}
@@ -3,9 +3,12 @@ package repl_test
 import (
 	"context"
 	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 
 	"grol.io/grol/eval"
+	"grol.io/grol/extensions"
 	"grol.io/grol/object"
 	"grol.io/grol/repl"
 )
@@ -186,3 +189,53 @@ func TestLikeDiscordBot(t *testing.T) {
 		t.Errorf("EvalString() got %v\n---\n%s\n---want---\n%s\n---", errs, evalres, expected)
 	}
 }
+
+// Examples whose output depends on time, random numbers or stdin (not checked), and the slow
+// ones (only checked without -short, e.g. not by make race-tests).
+var (
+	nonDeterministicExamples = []string{
+		"bezier_plot.gr", "blackjack.gr", "image.gr", "mandelbrot.gr", "nonblockingread.gr", "pi2.gr", "random.gr",
+	}
+	slowExamples = []string{"advent_2024_day11.gr", "cards.gr", "loop.gr", "pi_perf.gr", "prime_sieve_iter_compact.gr"}
+)
+
+func TestOptimizeExamples(t *testing.T) {
+	if err := extensions.Init(nil); err != nil {
+		t.Fatalf("extensions.Init: %v", err)
+	}
+	dir, err := filepath.Abs("../examples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.gr"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no examples found: %v", err)
+	}
+	t.Chdir(t.TempDir()) // examples saving files do it there.
+	for _, file := range files {
+		name := filepath.Base(file)
+		if slices.Contains(nonDeterministicExamples, name) || (testing.Short() && slices.Contains(slowExamples, name)) {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("reading %s: %v", file, err)
+		}
+		opts := repl.EvalStringOptions()
+		opts.All = true
+		// Denied so a new example needing them fails below instead of being silently skipped.
+		opts.DeniedCapabilities = object.CapStdin | object.CapProcess | object.CapTime | object.CapRandom
+		code := extensions.DropStartingShebang(string(content))
+		res, errs, _ := repl.EvalStringWithOption(context.Background(), opts, code)
+		opts.NoOptimize = true
+		expected, expectedErrs, _ := repl.EvalStringWithOption(context.Background(), opts, code)
+		if len(expectedErrs) > 0 {
+			t.Errorf("%s: unexpected errors %v, add it to nonDeterministicExamples if it needs time, random or stdin",
+				name, expectedErrs)
+			continue
+		}
+		if res != expected || !slices.Equal(errs, expectedErrs) {
+			t.Errorf("%s: optimized got %v\n%s\n---want---\n%v\n%s", name, errs, res, expectedErrs, expected)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package token
+
+import "strconv"
+
+// Position is a line and column (both 1 based) within the source. The zero value
+// means the position is unknown (e.g. nodes synthesized during macro expansion).
+// It is kept outside of Token so tokens can still be interned.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// IsValid returns true if the position is known.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns "line:column" or "-" for unknown positions.
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+}
@@ -1,13 +1,15 @@
 // Package token defines 2 types of Token, constant ones (with no "value") and the ones with attached
-// value that is variable (e.g. IDENT, INT, FLOAT, STRING, *COMMENT).
+// value that is variable (e.g. IDENT, INT, FLOAT, STRING, INTERP, *COMMENT).
 // We might have used the upcoming unique https://tip.golang.org/doc/go1.23#new-unique-package
-// but we want this to run on 1.22 and earlier and rolled our own, not multi threaded.
+// but we want this to run on 1.22 and earlier and rolled our own, safe for concurrent use by
+// independent interpreters (e.g. in goroutines of a server).
 package token
 
 import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 
 	"fortio.org/sets"
 )
@@ -29,16 +31,27 @@ type Token struct {
 	literal   string
 }
 
-// Single threaded (famous last words), no need for sync.Map.
-var interning map[Token]*Token
+// Interned tokens, shared by concurrent lexers so protected by internMutex (a sync.Map would copy the
+// noCopy tokens as keys).
+var (
+	interning   map[Token]*Token
+	internMutex sync.RWMutex
+)
 
 // InternToken looks up a unique pointer to a token of same values, if it exists,
 // otherwise store the passed in one for future lookups.
 func InternToken(t *Token) *Token {
+	internMutex.RLock()
 	ptr, ok := interning[*t]
+	internMutex.RUnlock()
 	if ok {
 		return ptr
 	}
+	internMutex.Lock()
+	defer internMutex.Unlock()
+	if ptr, ok = interning[*t]; ok { // interned by another goroutine in between.
+		return ptr
+	}
 	interning[*t] = t
 	return t
 }
@@ -48,7 +61,9 @@ func Intern(t Type, literal string) *Token {
 }
 
 func ResetInterning() {
+	internMutex.Lock()
 	interning = make(map[Token]*Token)
+	internMutex.Unlock()
 }
 
 const (
@@ -63,6 +78,7 @@ const (
 	INT    // 1343456
 	FLOAT  // .5, 3.14159,...
 	STRING // "foo bar" or `foo bar`
+	INTERP // "foo ${bar}" (literal is the source between the quotes)
 	LINECOMMENT
 	BLOCKCOMMENT
 	REGISTER // not used for parsing, only to tag object.Register as ast node of unique type.
@@ -147,6 +163,9 @@ const (
 	FOR
 	BREAK
 	CONTINUE
+	MATCH
+	TRY
+	FINALLY
 	// Macro magic.
 
 	MACRO
@@ -327,6 +346,12 @@ func Init() { //nolint:funlen // we have a lot of file associations
 //go:generate stringer -type=Type
 var _ = EOF.String() // force compile error if go generate is missing.
 
+// IsKeyword returns true for the keywords and builtins (e.g. `if`, `rest`), which can't be used
+// as variable names.
+func (t Type) IsKeyword() bool {
+	return t > startIdentityTokens && t < endIdentityTokens
+}
+
 func LookupIdent(ident string) *Token {
 	// constant/identity ones:
 	if t, ok := keywords[ident]; ok {
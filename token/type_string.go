@@ -15,86 +15,90 @@ func _() {
 	_ = x[INT-4]
 	_ = x[FLOAT-5]
 	_ = x[STRING-6]
-	_ = x[LINECOMMENT-7]
-	_ = x[BLOCKCOMMENT-8]
-	_ = x[REGISTER-9]
-	_ = x[endValueTokens-10]
-	_ = x[startSingleCharTokens-11]
-	_ = x[ASSIGN-12]
-	_ = x[PLUS-13]
-	_ = x[MINUS-14]
-	_ = x[ASTERISK-15]
-	_ = x[SLASH-16]
-	_ = x[BITAND-17]
-	_ = x[BITOR-18]
-	_ = x[BITXOR-19]
-	_ = x[BITNOT-20]
-	_ = x[BANG-21]
-	_ = x[PERCENT-22]
-	_ = x[LT-23]
-	_ = x[GT-24]
-	_ = x[COMMA-25]
-	_ = x[SEMICOLON-26]
-	_ = x[LPAREN-27]
-	_ = x[RPAREN-28]
-	_ = x[LBRACE-29]
-	_ = x[RBRACE-30]
-	_ = x[LBRACKET-31]
-	_ = x[RBRACKET-32]
-	_ = x[COLON-33]
-	_ = x[DOT-34]
-	_ = x[endSingleCharTokens-35]
-	_ = x[startMultiCharTokens-36]
-	_ = x[LTEQ-37]
-	_ = x[GTEQ-38]
-	_ = x[EQ-39]
-	_ = x[NOTEQ-40]
-	_ = x[INCR-41]
-	_ = x[DECR-42]
-	_ = x[DOTDOT-43]
-	_ = x[OR-44]
-	_ = x[AND-45]
-	_ = x[LEFTSHIFT-46]
-	_ = x[RIGHTSHIFT-47]
-	_ = x[LAMBDA-48]
-	_ = x[DEFINE-49]
-	_ = x[SUMASSIGN-50]
-	_ = x[SUBASSIGN-51]
-	_ = x[PRODASSIGN-52]
-	_ = x[DIVASSIGN-53]
-	_ = x[ANDASSIGN-54]
-	_ = x[ORASSIGN-55]
-	_ = x[XORASSIGN-56]
-	_ = x[endMultiCharTokens-57]
-	_ = x[startIdentityTokens-58]
-	_ = x[FUNC-59]
-	_ = x[TRUE-60]
-	_ = x[FALSE-61]
-	_ = x[IF-62]
-	_ = x[ELSE-63]
-	_ = x[RETURN-64]
-	_ = x[FOR-65]
-	_ = x[BREAK-66]
-	_ = x[CONTINUE-67]
-	_ = x[MACRO-68]
-	_ = x[QUOTE-69]
-	_ = x[UNQUOTE-70]
-	_ = x[LEN-71]
-	_ = x[FIRST-72]
-	_ = x[REST-73]
-	_ = x[PRINT-74]
-	_ = x[PRINTLN-75]
-	_ = x[LOG-76]
-	_ = x[ERROR-77]
-	_ = x[CATCH-78]
-	_ = x[DEL-79]
-	_ = x[endIdentityTokens-80]
-	_ = x[EOF-81]
+	_ = x[INTERP-7]
+	_ = x[LINECOMMENT-8]
+	_ = x[BLOCKCOMMENT-9]
+	_ = x[REGISTER-10]
+	_ = x[endValueTokens-11]
+	_ = x[startSingleCharTokens-12]
+	_ = x[ASSIGN-13]
+	_ = x[PLUS-14]
+	_ = x[MINUS-15]
+	_ = x[ASTERISK-16]
+	_ = x[SLASH-17]
+	_ = x[BITAND-18]
+	_ = x[BITOR-19]
+	_ = x[BITXOR-20]
+	_ = x[BITNOT-21]
+	_ = x[BANG-22]
+	_ = x[PERCENT-23]
+	_ = x[LT-24]
+	_ = x[GT-25]
+	_ = x[COMMA-26]
+	_ = x[SEMICOLON-27]
+	_ = x[LPAREN-28]
+	_ = x[RPAREN-29]
+	_ = x[LBRACE-30]
+	_ = x[RBRACE-31]
+	_ = x[LBRACKET-32]
+	_ = x[RBRACKET-33]
+	_ = x[COLON-34]
+	_ = x[DOT-35]
+	_ = x[endSingleCharTokens-36]
+	_ = x[startMultiCharTokens-37]
+	_ = x[LTEQ-38]
+	_ = x[GTEQ-39]
+	_ = x[EQ-40]
+	_ = x[NOTEQ-41]
+	_ = x[INCR-42]
+	_ = x[DECR-43]
+	_ = x[DOTDOT-44]
+	_ = x[OR-45]
+	_ = x[AND-46]
+	_ = x[LEFTSHIFT-47]
+	_ = x[RIGHTSHIFT-48]
+	_ = x[LAMBDA-49]
+	_ = x[DEFINE-50]
+	_ = x[SUMASSIGN-51]
+	_ = x[SUBASSIGN-52]
+	_ = x[PRODASSIGN-53]
+	_ = x[DIVASSIGN-54]
+	_ = x[ANDASSIGN-55]
+	_ = x[ORASSIGN-56]
+	_ = x[XORASSIGN-57]
+	_ = x[endMultiCharTokens-58]
+	_ = x[startIdentityTokens-59]
+	_ = x[FUNC-60]
+	_ = x[TRUE-61]
+	_ = x[FALSE-62]
+	_ = x[IF-63]
+	_ = x[ELSE-64]
+	_ = x[RETURN-65]
+	_ = x[FOR-66]
+	_ = x[BREAK-67]
+	_ = x[CONTINUE-68]
+	_ = x[MATCH-69]
+	_ = x[TRY-70]
+	_ = x[FINALLY-71]
+	_ = x[MACRO-72]
+	_ = x[QUOTE-73]
+	_ = x[UNQUOTE-74]
+	_ = x[LEN-75]
+	_ = x[FIRST-76]
+	_ = x[REST-77]
+	_ = x[PRINT-78]
+	_ = x[PRINTLN-79]
+	_ = x[LOG-80]
+	_ = x[ERROR-81]
+	_ = x[CATCH-82]
+	_ = x[DEL-83]
+	_ = x[endIdentityTokens-84]
+	_ = x[EOF-85]
 }
 
-const _Type_name = "ILLEGALEOLstartValueTokensIDENTINTFLOATSTRINGLINECOMMENTBLOCKCOMMENTREGISTERendValueTokensstartSingleCharTokensASSIGNPLUSMINUSASTERISKSLASHBITANDBITORBITXORBITNOTBANGPERCENTLTGTCOMMASEMICOLONLPARENRPARENLBRACERBRACELBRACKETRBRACKETCOLONDOTendSingleCharTokensstartMultiCharTokensLTEQGTEQEQNOTEQINCRDECRDOTDOTORANDLEFTSHIFTRIGHTSHIFTLAMBDADEFINESUMASSIGNSUBASSIGNPRODASSIGNDIVASSIGNANDASSIGNORASSIGNXORASSIGNendMultiCharTokensstartIdentityTokensFUNCTRUEFALSEIFELSERETURNFORBREAKCONTINUEMACROQUOTEUNQUOTELENFIRSTRESTPRINTPRINTLNLOGERRORCATCHDELendIdentityTokensEOF"
+const _Type_name = "ILLEGALEOLstartValueTokensIDENTINTFLOATSTRINGINTERPLINECOMMENTBLOCKCOMMENTREGISTERendValueTokensstartSingleCharTokensASSIGNPLUSMINUSASTERISKSLASHBITANDBITORBITXORBITNOTBANGPERCENTLTGTCOMMASEMICOLONLPARENRPARENLBRACERBRACELBRACKETRBRACKETCOLONDOTendSingleCharTokensstartMultiCharTokensLTEQGTEQEQNOTEQINCRDECRDOTDOTORANDLEFTSHIFTRIGHTSHIFTLAMBDADEFINESUMASSIGNSUBASSIGNPRODASSIGNDIVASSIGNANDASSIGNORASSIGNXORASSIGNendMultiCharTokensstartIdentityTokensFUNCTRUEFALSEIFELSERETURNFORBREAKCONTINUEMATCHTRYFINALLYMACROQUOTEUNQUOTELENFIRSTRESTPRINTPRINTLNLOGERRORCATCHDELendIdentityTokensEOF"
 
-var _Type_index = [...]uint16{0, 7, 10, 26, 31, 34, 39, 45, 56, 68, 76, 90, 111, 117, 121, 126, 134, 139, 145, 150, 156, 162, 166, 173, 175, 177, 182, 191, 197, 203, 209, 215, 223, 231, 236, 239, 258, 278, 282, 286, 288, 293, 297, 301, 307, 309, 312, 321, 331, 337, 343, 352, 361, 371, 380, 389, 397, 406, 424, 443, 447, 451, 456, 458, 462, 468, 471, 476, 484, 489, 494, 501, 504, 509, 513, 518, 525, 528, 533, 538, 541, 558, 561}
+var _Type_index = [...]uint16{0, 7, 10, 26, 31, 34, 39, 45, 51, 62, 74, 82, 96, 117, 123, 127, 132, 140, 145, 151, 156, 162, 168, 172, 179, 181, 183, 188, 197, 203, 209, 215, 221, 229, 237, 242, 245, 264, 284, 288, 292, 294, 299, 303, 307, 313, 315, 318, 327, 337, 343, 349, 358, 367, 377, 386, 395, 403, 412, 430, 449, 453, 457, 462, 464, 468, 474, 477, 482, 490, 495, 498, 505, 510, 515, 522, 525, 530, 534, 539, 546, 549, 554, 559, 562, 579, 582}
 
 func (i Type) String() string {
 	idx := int(i) - 0